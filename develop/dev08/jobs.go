@@ -0,0 +1,577 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// terminal is a descriptor of controlling terminal of interactive shell, -1 if stdin isn't a terminal
+var terminal = -1
+
+// shellPgid is a process group of the shell which gets terminal back when foreground job exits or stops
+var shellPgid int
+
+// initJobControl prepares the shell to give its terminal to foreground jobs
+func initJobControl() {
+	fd := int(os.Stdin.Fd())
+	if _, err := tcgetpgrp(fd); err != nil {
+		return
+	}
+	terminal = fd
+	shellPgid = syscall.Getpgrp()
+	// the shell takes terminal back being in background, ignored SIGTTOU is inherited by commands
+	// but it only matters for terminals with tostop mode
+	signal.Ignore(syscall.SIGTTOU)
+	// Ctrl+Z at the prompt must not stop the shell, handled signals are reset to default in commands
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGTSTP, syscall.SIGTTIN)
+}
+
+func tcgetpgrp(fd int) (int, error) {
+	var pgid int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgid))); errno != 0 {
+		return 0, errno
+	}
+	return int(pgid), nil
+}
+
+func tcsetpgrp(fd, pgid int) error {
+	id := int32(pgid)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&id))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// stage is a command of job, builtins run in the shell have no pid, they set status and close done when they finish
+type stage struct {
+	pid      int
+	done     chan struct{}
+	exited   bool
+	stopped  bool
+	status   int
+	signal   syscall.Signal
+	coreDump bool
+}
+
+// wait waits for change of state of stage, with syscall.WNOHANG it returns immediately if state didn't change
+func (s *stage) wait(options int) {
+	if s.pid == 0 {
+		if options&syscall.WNOHANG == 0 {
+			<-s.done
+			s.exited = true
+			return
+		}
+		select {
+		case <-s.done:
+			s.exited = true
+		default:
+		}
+		return
+	}
+	var ws syscall.WaitStatus
+	pid, err := syscall.Wait4(s.pid, &ws, options, nil)
+	for err == syscall.EINTR {
+		pid, err = syscall.Wait4(s.pid, &ws, options, nil)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		s.exited, s.status = true, 1
+		return
+	}
+	if pid != s.pid {
+		return
+	}
+	switch {
+	case ws.Exited():
+		s.exited, s.stopped, s.status = true, false, ws.ExitStatus()
+	case ws.Signaled():
+		s.exited, s.stopped, s.status, s.signal = true, false, 128+int(ws.Signal()), ws.Signal()
+		s.coreDump = ws.CoreDump()
+	case ws.Stopped():
+		s.stopped = true
+	case ws.Continued():
+		s.stopped = false
+	}
+}
+
+// job is a pipeline run in its own process group, id is a number of job in table of jobs
+type job struct {
+	id     int
+	pgid   int
+	text   string
+	stages []*stage
+	// reported is true when stop of the job was reported to user
+	reported bool
+}
+
+// startJob starts commands of pipeline connected with pipes in a new process group,
+// foreground job gets terminal of interactive shell. Background jobs of non-interactive shell read /dev/null.
+func startJob(pl *pipeline, text string, foreground bool) *job {
+	j := &job{text: text}
+	in := jobInput(foreground)
+	for i, c := range pl.commands {
+		out, next := os.Stdout, (*os.File)(nil)
+		if i < len(pl.commands)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				closeFile(in)
+				j.stages = append(j.stages, &stage{exited: true, status: 1})
+				break
+			}
+			out, next = w, r
+		}
+		j.stages = append(j.stages, j.start(c, in, out, foreground, foreground && len(pl.commands) == 1))
+		in = next
+	}
+	return j
+}
+
+// jobInput returns standard input of job, it's /dev/null for background jobs of non-interactive shell
+func jobInput(foreground bool) *os.File {
+	if !foreground && terminal < 0 {
+		if null, err := os.Open(os.DevNull); err == nil {
+			return null
+		}
+	}
+	return os.Stdin
+}
+
+// startSubshell starts subshell running and-or list of item as a background job
+func startSubshell(item *listItem) *job {
+	j := &job{text: item.text}
+	in := jobInput(false)
+	args, err := subshellArgs(item.source(), nil)
+	if err != nil {
+		closeFile(in)
+		fmt.Fprintln(os.Stderr, err)
+		j.stages = append(j.stages, &stage{exited: true, status: 1})
+		return j
+	}
+	j.stages = append(j.stages, j.exec(args, []*os.File{in, os.Stdout, os.Stderr}, false))
+	closeFile(in)
+	return j
+}
+
+// start starts command of job reading in and writing out, which are closed when command doesn't need them anymore.
+// Builtins run in the shell only if inShell is set, otherwise they run in subshell and can't change the shell.
+func (j *job) start(c *command, in, out *os.File, foreground, inShell bool) *stage {
+	files, opened, err := redirectFiles([]*os.File{in, out, os.Stderr}, c.redirects)
+	release := func() {
+		closeFile(in)
+		closeFile(out)
+		closeFiles(opened)
+	}
+	if err != nil {
+		release()
+		fmt.Fprintln(os.Stderr, err)
+		return &stage{exited: true, status: 1}
+	}
+	args := expandArgs(c.args)
+	if len(args) == 0 {
+		// command of only redirects creates files
+		release()
+		return &stage{exited: true}
+	}
+	f, builtin := funcMap[args[0]]
+	if builtin && inShell {
+		s := &stage{done: make(chan struct{})}
+		go func() {
+			defer close(s.done)
+			s.status = f(args, &stdio{in: files[0], out: files[1], err: files[2]})
+			release()
+		}()
+		return s
+	}
+	if builtin {
+		if args, err = subshellArgs("", args); err != nil {
+			release()
+			fmt.Fprintln(os.Stderr, err)
+			return &stage{exited: true, status: 1}
+		}
+	}
+	s := j.exec(args, files, foreground)
+	release()
+	return s
+}
+
+// exec starts process of job with given files, the first process of job becomes leader of its process group.
+// Commands of subshell stay in its process group, so signals sent to the group of its job reach them.
+func (j *job) exec(args []string, files []*os.File, foreground bool) *stage {
+	attr := &syscall.SysProcAttr{}
+	if !inSubshell {
+		attr.Setpgid, attr.Pgid = true, j.pgid
+		if foreground && terminal >= 0 && j.pgid == 0 {
+			attr.Foreground = true
+			attr.Ctty = terminal
+		}
+	}
+	p, err := forkExec(args, files, attr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return &stage{exited: true, status: 127}
+	}
+	if attr.Setpgid && j.pgid == 0 {
+		j.pgid = p.Pid
+	}
+	// process is waited by pid to see its stops
+	pid := p.Pid
+	p.Release()
+	return &stage{pid: pid}
+}
+
+// done reports whether all commands of job exited
+func (j *job) done() bool {
+	for _, s := range j.stages {
+		if !s.exited {
+			return false
+		}
+	}
+	return true
+}
+
+// stopped reports whether job is stopped by a signal
+func (j *job) stopped() bool {
+	for _, s := range j.stages {
+		if s.stopped && !s.exited {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns exit status of pipeline of job
+func (j *job) status() int {
+	statuses := make([]int, len(j.stages))
+	for i, s := range j.stages {
+		statuses[i] = s.status
+	}
+	return pipelineStatus(statuses)
+}
+
+// describe returns state of finished job: Done, Exit with status or name of signal which killed it
+func (j *job) describe() string {
+	last := j.stages[len(j.stages)-1]
+	switch {
+	case last.signal != 0:
+		name := last.signal.String()
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if last.coreDump {
+			name += " (core dumped)"
+		}
+		return name
+	case j.status() != 0:
+		return fmt.Sprintf("Exit %d", j.status())
+	}
+	return "Done"
+}
+
+// lastPid returns pid of the last process of job
+func (j *job) lastPid() int {
+	for i := len(j.stages) - 1; i >= 0; i-- {
+		if j.stages[i].pid != 0 {
+			return j.stages[i].pid
+		}
+	}
+	return 0
+}
+
+// wait waits until all commands of job exit or job is stopped
+func (j *job) wait() {
+	for _, s := range j.stages {
+		for s.pid != 0 && !s.exited && !s.stopped {
+			s.wait(syscall.WUNTRACED)
+		}
+	}
+	if j.stopped() {
+		// builtins writing to stopped commands would block
+		return
+	}
+	for _, s := range j.stages {
+		if !s.exited {
+			s.wait(0)
+		}
+	}
+}
+
+// poll updates states of commands of job without blocking
+func (j *job) poll() {
+	for _, s := range j.stages {
+		if !s.exited {
+			s.wait(syscall.WNOHANG | syscall.WUNTRACED | syscall.WCONTINUED)
+		}
+	}
+}
+
+// resume continues stopped commands of job
+func (j *job) resume() error {
+	j.reported = false
+	for _, s := range j.stages {
+		s.stopped = false
+	}
+	if j.pgid == 0 {
+		return nil
+	}
+	return syscall.Kill(-j.pgid, syscall.SIGCONT)
+}
+
+// foreground waits for job in foreground and gives terminal back to the shell, signals received
+// by the shell meanwhile are forwarded to job. Stopped job is added to table of jobs and finished job is removed from it.
+func foreground(j *job) int {
+	previous := foregroundPgid.Swap(int32(j.pgid))
+	j.wait()
+	foregroundPgid.Store(previous)
+	if terminal >= 0 {
+		if err := tcsetpgrp(terminal, shellPgid); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if j.stopped() {
+		shellJobs.add(j)
+		j.reported = true
+		fmt.Printf("\n%s\n", shellJobs.format(j, "Stopped"))
+		return 128 + int(syscall.SIGTSTP)
+	}
+	shellJobs.remove(j)
+	reportSignal(j)
+	return j.status()
+}
+
+// runPipeline runs pipeline in foreground and returns its exit status, inverted for negated pipeline
+func runPipeline(pl *pipeline, text string) int {
+	status := foreground(startJob(pl, text, true))
+	if pl.negate {
+		if status == 0 {
+			return 1
+		}
+		return 0
+	}
+	return status
+}
+
+// runBackground starts item as a background job and prints its number and pid,
+// and-or list and negated pipeline are run by subshell as a single job
+func runBackground(item *listItem) {
+	var j *job
+	if ao := item.andOr; len(ao.pipelines) > 1 || ao.pipelines[0].negate {
+		j = startSubshell(item)
+	} else {
+		j = startJob(ao.pipelines[0], item.text, false)
+	}
+	shellJobs.add(j)
+	fmt.Printf("[%d] %d\n", j.id, j.lastPid())
+}
+
+// notifyJobs reports jobs which finished or stopped since the previous prompt, finished jobs are removed
+func notifyJobs(w io.Writer) {
+	for _, j := range shellJobs.list() {
+		j.poll()
+		switch {
+		case j.done():
+			fmt.Fprintln(w, shellJobs.format(j, j.describe()))
+			shellJobs.remove(j)
+		case j.stopped() && !j.reported:
+			j.reported = true
+			fmt.Fprintln(w, shellJobs.format(j, "Stopped"))
+		}
+	}
+}
+
+// jobTable contains background and stopped jobs, the last job is the current one
+type jobTable struct {
+	m    sync.Mutex
+	jobs []*job
+}
+
+var shellJobs = &jobTable{}
+
+// add adds job to table or moves it to the end, new job gets number greater than numbers of all jobs
+func (t *jobTable) add(j *job) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.removeLocked(j)
+	if j.id == 0 {
+		j.id = 1
+		for _, other := range t.jobs {
+			if other.id >= j.id {
+				j.id = other.id + 1
+			}
+		}
+	}
+	t.jobs = append(t.jobs, j)
+}
+
+func (t *jobTable) remove(j *job) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.removeLocked(j)
+}
+
+func (t *jobTable) removeLocked(j *job) {
+	for i, other := range t.jobs {
+		if other == j {
+			t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// list returns jobs ordered by number
+func (t *jobTable) list() []*job {
+	t.m.Lock()
+	defer t.m.Unlock()
+	jobs := append([]*job(nil), t.jobs...)
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].id < jobs[k].id })
+	return jobs
+}
+
+// find returns job by its spec: %n, n, %+, %% or empty spec for the current job and %- for the previous one
+func (t *jobTable) find(spec string) (*job, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	switch spec {
+	case "", "%", "%%", "%+":
+		if len(t.jobs) > 0 {
+			return t.jobs[len(t.jobs)-1], nil
+		}
+		return nil, errors.New("no current job")
+	case "%-":
+		if len(t.jobs) > 1 {
+			return t.jobs[len(t.jobs)-2], nil
+		}
+		return nil, errors.New("no previous job")
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err == nil {
+		for _, j := range t.jobs {
+			if j.id == id {
+				return j, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// format returns line of job in list of jobs with state of job
+func (t *jobTable) format(j *job, state string) string {
+	t.m.Lock()
+	marker := ' '
+	if n := len(t.jobs); n > 0 && t.jobs[n-1] == j {
+		marker = '+'
+	} else if n > 1 && t.jobs[n-2] == j {
+		marker = '-'
+	}
+	t.m.Unlock()
+	text := j.text
+	if state == "Running" {
+		text += " &"
+	}
+	return fmt.Sprintf("[%d]%c  %-24s%s", j.id, marker, state, text)
+}
+
+// jobs prints jobs with their states, finished jobs are removed
+func jobs(args []string, std *stdio) int {
+	if len(args) > 1 {
+		fmt.Fprintln(std.err, "jobs: expected 0 arguments; got", len(args)-1)
+		return 1
+	}
+	for _, j := range shellJobs.list() {
+		j.poll()
+		switch {
+		case j.done():
+			fmt.Fprintln(std.out, shellJobs.format(j, j.describe()))
+			shellJobs.remove(j)
+		case j.stopped():
+			j.reported = true
+			fmt.Fprintln(std.out, shellJobs.format(j, "Stopped"))
+		default:
+			fmt.Fprintln(std.out, shellJobs.format(j, "Running"))
+		}
+	}
+	return 0
+}
+
+// jobArg returns job of the only optional argument of builtin
+func jobArg(args []string) (*job, error) {
+	if len(args) > 2 {
+		return nil, fmt.Errorf("%s: too many arguments", args[0])
+	}
+	spec := ""
+	if len(args) == 2 {
+		spec = args[1]
+	}
+	j, err := shellJobs.find(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+	return j, nil
+}
+
+// fg continues job in foreground giving it terminal of the shell and returns its exit status
+func fg(args []string, std *stdio) int {
+	j, err := jobArg(args)
+	if err != nil {
+		fmt.Fprintln(std.err, err)
+		return 1
+	}
+	fmt.Fprintln(std.out, j.text)
+	if terminal >= 0 && j.pgid != 0 {
+		if err := tcsetpgrp(terminal, j.pgid); err != nil {
+			fmt.Fprintln(std.err, err)
+		}
+	}
+	if err := j.resume(); err != nil {
+		fmt.Fprintln(std.err, err)
+	}
+	return foreground(j)
+}
+
+// bg continues stopped job in background
+func bg(args []string, std *stdio) int {
+	j, err := jobArg(args)
+	if err != nil {
+		fmt.Fprintln(std.err, err)
+		return 1
+	}
+	if err := j.resume(); err != nil {
+		fmt.Fprintln(std.err, err)
+		return 1
+	}
+	fmt.Fprintf(std.out, "[%d] %s &\n", j.id, j.text)
+	return 0
+}
+
+// wait waits for the job of argument or for all jobs, stopped jobs are not waited for.
+// It returns status of the job of argument, 127 if there is no such job, or zero without argument.
+func wait(args []string, std *stdio) int {
+	waited := shellJobs.list()
+	if len(args) > 1 {
+		j, err := jobArg(args)
+		if err != nil {
+			fmt.Fprintln(std.err, err)
+			return 127
+		}
+		waited = []*job{j}
+	}
+	for _, j := range waited {
+		if !j.stopped() {
+			j.wait()
+		}
+	}
+	if len(args) > 1 {
+		if waited[0].stopped() {
+			return 128 + int(syscall.SIGTSTP)
+		}
+		return waited[0].status()
+	}
+	return 0
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Grammar of input of the shell:
+
+	program  := separator* (andOr (separator+ andOr)*)?
+	andOr    := pipeline (("&&" | "||") newline* pipeline)*
+	pipeline := ["!"] command ("|" newline* command)*
+	command  := (word | redirect)+
+	redirect := [fd] (">" | ">>" | "<" | ">&" | "&>" | "<<" | "<<-") word
+
+separator is ";", "&" or newline, "&" runs preceding andOr in background. "!" is an unquoted word
+negating exit status of pipeline.
+Bodies of here-documents <<word follow the line of their redirects and end with a line equal to word,
+<<- strips leading tabs from lines of body. Variables are expanded in bodies unless word is quoted.
+Words may contain 'strings', "strings" and escapes \x, "#" at the beginning of word starts a comment
+and \ at the end of line continues input on the next line.
+*/
+
+// position is a line and a column of a character of input counted in characters from 1
+type position struct {
+	line, col int
+}
+
+func (p position) String() string {
+	return fmt.Sprintf("%d:%d", p.line, p.col)
+}
+
+// SyntaxError describes invalid input, Incomplete errors are fixed by reading more lines of input
+type SyntaxError struct {
+	Pos        position
+	Msg        string
+	Incomplete bool
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %s: %s", e.Pos, e.Msg)
+}
+
+// wordPart is a piece of word, literal parts come from single quotes or escapes and are never expanded
+type wordPart struct {
+	text    string
+	literal bool
+}
+
+// word is an argument of command or a target of redirect, quoted words contain quotes or escapes
+type word struct {
+	parts  []wordPart
+	pos    position
+	quoted bool
+}
+
+// String returns text of word without quotes
+func (w word) String() string {
+	var b strings.Builder
+	for _, p := range w.parts {
+		b.WriteString(p.text)
+	}
+	return b.String()
+}
+
+// expand returns text of word with variables of unquoted and double-quoted parts replaced by values of lookup
+func (w word) expand(lookup func(string) string) string {
+	var b strings.Builder
+	for _, p := range w.parts {
+		if p.literal {
+			b.WriteString(p.text)
+		} else {
+			b.WriteString(os.Expand(p.text, lookup))
+		}
+	}
+	return b.String()
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokNewline
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	op   string
+	// fd is a number of file descriptor before redirect operator, -1 if it's omitted
+	fd   int
+	word word
+	pos  position
+	// offset is an index of the first byte of token in input
+	offset int
+}
+
+// describe returns token as it's named in syntax errors
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokNewline:
+		return "`newline'"
+	case tokWord:
+		return "`" + t.word.String() + "'"
+	}
+	return "`" + t.op + "'"
+}
+
+// operators of the shell, longer operators go before their prefixes
+var operators = []string{"<<-", "&&", "||", ">>", "&>", ">&", "<<", "|", "&", ";", ">", "<"}
+
+func isRedirect(op string) bool {
+	switch op {
+	case ">", ">>", "<", ">&", "&>", "<<", "<<-":
+		return true
+	}
+	return false
+}
+
+// lexer splits input into tokens
+type lexer struct {
+	input string
+	i     int
+	pos   position
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input, pos: position{line: 1, col: 1}}
+}
+
+// peek returns character at offset from current one, 0 at the end of input
+func (l *lexer) peek(offset int) byte {
+	if l.i+offset < len(l.input) {
+		return l.input[l.i+offset]
+	}
+	return 0
+}
+
+// advance moves over n bytes of input
+func (l *lexer) advance(n int) {
+	for n > 0 && l.i < len(l.input) {
+		r, size := utf8.DecodeRuneInString(l.input[l.i:])
+		l.i += size
+		n -= size
+		if r == '\n' {
+			l.pos.line++
+			l.pos.col = 1
+		} else {
+			l.pos.col++
+		}
+	}
+}
+
+// skipBlanks skips spaces, comments and line continuations
+func (l *lexer) skipBlanks() error {
+	for l.i < len(l.input) {
+		switch c := l.peek(0); {
+		case c == ' ' || c == '\t' || c == '\r':
+			l.advance(1)
+		case c == '\\' && l.peek(1) == '\n':
+			if err := l.continuation(); err != nil {
+				return err
+			}
+		case c == '#':
+			for l.i < len(l.input) && l.peek(0) != '\n' {
+				l.advance(1)
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// continuation skips backslash and newline, input ending with them continues on the next line
+func (l *lexer) continuation() error {
+	pos := l.pos
+	l.advance(2)
+	if l.i >= len(l.input) {
+		return &SyntaxError{Pos: pos, Msg: "unexpected end of input after \\", Incomplete: true}
+	}
+	return nil
+}
+
+// next returns next token of input
+func (l *lexer) next() (token, error) {
+	if err := l.skipBlanks(); err != nil {
+		return token{}, err
+	}
+	start, offset := l.pos, l.i
+	if l.i >= len(l.input) {
+		return token{kind: tokEOF, pos: start, offset: offset}, nil
+	}
+	if l.peek(0) == '\n' {
+		l.advance(1)
+		return token{kind: tokNewline, pos: start, offset: offset}, nil
+	}
+	// digits right before redirect operator are number of file descriptor
+	digits := 0
+	for c := l.peek(digits); c >= '0' && c <= '9'; c = l.peek(digits) {
+		digits++
+	}
+	if digits > 0 && (l.peek(digits) == '>' || l.peek(digits) == '<') {
+		fd := 0
+		for _, c := range l.input[l.i : l.i+digits] {
+			fd = fd*10 + int(c-'0')
+		}
+		l.advance(digits)
+		return token{kind: tokOp, op: l.operator(), fd: fd, pos: start, offset: offset}, nil
+	}
+	if op := l.operator(); op != "" {
+		return token{kind: tokOp, op: op, fd: -1, pos: start, offset: offset}, nil
+	}
+	w, err := l.word()
+	if err != nil {
+		return token{}, err
+	}
+	return token{kind: tokWord, word: w, pos: start, offset: offset}, nil
+}
+
+// operator consumes operator at current position and returns it, empty string if there is no operator
+func (l *lexer) operator() string {
+	for _, op := range operators {
+		if strings.HasPrefix(l.input[l.i:], op) {
+			l.advance(len(op))
+			return op
+		}
+	}
+	return ""
+}
+
+// word consumes word at current position
+func (l *lexer) word() (word, error) {
+	w := word{pos: l.pos}
+	var text strings.Builder
+	// flush appends unquoted text collected so far as a part of word
+	flush := func() {
+		if text.Len() > 0 {
+			w.parts = append(w.parts, wordPart{text: text.String()})
+			text.Reset()
+		}
+	}
+	for l.i < len(l.input) {
+		c := l.peek(0)
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || strings.IndexByte("|&;<>", c) >= 0:
+			flush()
+			return w, nil
+		case c == '\\':
+			if l.peek(1) == '\n' {
+				if err := l.continuation(); err != nil {
+					return w, err
+				}
+				continue
+			}
+			if l.i+1 >= len(l.input) {
+				return w, &SyntaxError{Pos: l.pos, Msg: "unexpected end of input after \\", Incomplete: true}
+			}
+			flush()
+			w.quoted = true
+			_, size := utf8.DecodeRuneInString(l.input[l.i+1:])
+			w.parts = append(w.parts, wordPart{text: l.input[l.i+1 : l.i+1+size], literal: true})
+			l.advance(1 + size)
+		case c == '\'':
+			flush()
+			w.quoted = true
+			start := l.pos
+			l.advance(1)
+			end := strings.IndexByte(l.input[l.i:], '\'')
+			if end < 0 {
+				return w, &SyntaxError{Pos: start, Msg: "unterminated single quote", Incomplete: true}
+			}
+			w.parts = append(w.parts, wordPart{text: l.input[l.i : l.i+end], literal: true})
+			l.advance(end + 1)
+		case c == '"':
+			flush()
+			w.quoted = true
+			if err := l.doubleQuoted(&w); err != nil {
+				return w, err
+			}
+		default:
+			_, size := utf8.DecodeRuneInString(l.input[l.i:])
+			text.WriteString(l.input[l.i : l.i+size])
+			l.advance(size)
+		}
+	}
+	flush()
+	return w, nil
+}
+
+// doubleQuoted consumes "string" adding its parts to w, backslash escapes only \ " $ ` and newline in it
+func (l *lexer) doubleQuoted(w *word) error {
+	start := l.pos
+	l.advance(1)
+	var text strings.Builder
+	for l.i < len(l.input) {
+		switch c := l.peek(0); {
+		case c == '"':
+			l.advance(1)
+			w.parts = append(w.parts, wordPart{text: text.String()})
+			return nil
+		case c == '\\' && l.peek(1) == '\n':
+			l.advance(2)
+		case c == '\\' && strings.IndexByte("\\\"$`", l.peek(1)) >= 0:
+			if text.Len() > 0 {
+				w.parts = append(w.parts, wordPart{text: text.String()})
+				text.Reset()
+			}
+			w.parts = append(w.parts, wordPart{text: string(l.peek(1)), literal: true})
+			l.advance(2)
+		default:
+			_, size := utf8.DecodeRuneInString(l.input[l.i:])
+			text.WriteString(l.input[l.i : l.i+size])
+			l.advance(size)
+		}
+	}
+	return &SyntaxError{Pos: start, Msg: "unterminated double quote", Incomplete: true}
+}
+
+// hereDoc reads body of here-document ending with line delimiter from the next line of input
+func (l *lexer) hereDoc(delimiter string, stripTabs bool) (string, error) {
+	start := l.pos
+	var body strings.Builder
+	for l.i < len(l.input) {
+		end := strings.IndexByte(l.input[l.i:], '\n')
+		if end < 0 {
+			break
+		}
+		line := l.input[l.i : l.i+end]
+		l.advance(end + 1)
+		if stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == delimiter {
+			return body.String(), nil
+		}
+		body.WriteString(line + "\n")
+	}
+	return "", &SyntaxError{Pos: start, Msg: "here-document delimited by `" + delimiter + "' is not terminated", Incomplete: true}
+}
+
+// redirect changes file descriptor fd of command, op is one of >, >>, <, >&, &>, << and <<-.
+// Target of here-document is its delimiter, body is expanded if expand is true.
+type redirect struct {
+	fd     int
+	op     string
+	target word
+	pos    position
+	body   string
+	expand bool
+}
+
+// command is a simple command with its redirects
+type command struct {
+	args      []word
+	redirects []*redirect
+}
+
+// pipeline is a sequence of commands connected with pipes, negate inverts its exit status
+type pipeline struct {
+	commands []*command
+	negate   bool
+}
+
+// andOr is a pipeline followed by pipelines run depending on status of previous one, ops are && or ||
+type andOr struct {
+	pipelines []*pipeline
+	ops       []string
+	pos       []position
+}
+
+// listItem is an andOr of program run in foreground or in background, text is its source shown in list of jobs.
+// Bodies of here-documents follow the line of text, so hereDocs keeps redirects which bodies aren't in text.
+type listItem struct {
+	andOr      *andOr
+	background bool
+	pos        position
+	text       string
+	hereDocs   []*redirect
+}
+
+// source returns text of item followed by bodies of its here-documents, so it can be parsed again
+func (item *listItem) source() string {
+	if len(item.hereDocs) == 0 {
+		return item.text
+	}
+	var b strings.Builder
+	b.WriteString(item.text + "\n")
+	for _, r := range item.hereDocs {
+		b.WriteString(r.body + r.target.String() + "\n")
+	}
+	return b.String()
+}
+
+// program is a parsed input of the shell
+type program struct {
+	items []*listItem
+}
+
+// parser builds program from tokens of lexer
+type parser struct {
+	lex *lexer
+	tok token
+	// end is an index of byte after the previous token
+	end int
+	// hereDocs are redirects which bodies start after the next newline
+	hereDocs []*redirect
+}
+
+// parse parses input into program, returned *SyntaxError is Incomplete if input ends in the middle of a command
+func parse(input string) (*program, error) {
+	p := &parser{lex: newLexer(input)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.program()
+}
+
+func (p *parser) advance() error {
+	p.end = p.lex.i
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	switch {
+	case t.kind == tokNewline:
+		for _, r := range p.hereDocs {
+			if r.body, err = p.lex.hereDoc(r.target.String(), r.op == "<<-"); err != nil {
+				return err
+			}
+		}
+		p.hereDocs = nil
+	case t.kind == tokEOF && len(p.hereDocs) > 0:
+		return &SyntaxError{Pos: t.pos, Msg: "here-document delimited by `" + p.hereDocs[0].target.String() + "' is not terminated", Incomplete: true}
+	}
+	return nil
+}
+
+// unexpected returns error for current token, end of input is Incomplete
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return &SyntaxError{Pos: p.tok.pos, Msg: "unexpected end of input", Incomplete: true}
+	}
+	return &SyntaxError{Pos: p.tok.pos, Msg: "unexpected token " + p.tok.describe()}
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if p.tok.op == op {
+			return true
+		}
+	}
+	return false
+}
+
+// skipNewlines skips newlines allowed after |, && and ||
+func (p *parser) skipNewlines() error {
+	for p.tok.kind == tokNewline {
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) program() (*program, error) {
+	prog := &program{}
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	for p.tok.kind != tokEOF {
+		pos, offset := p.tok.pos, p.tok.offset
+		ao, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		item := &listItem{andOr: ao, pos: pos, text: p.lex.input[offset:p.end], hereDocs: p.hereDocs}
+		prog.items = append(prog.items, item)
+		switch {
+		case p.tok.kind == tokEOF:
+		case p.tok.kind == tokNewline || p.isOp(";", "&"):
+			item.background = p.isOp("&")
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if err := p.skipNewlines(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	return prog, nil
+}
+
+func (p *parser) andOr() (*andOr, error) {
+	pl, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	ao := &andOr{pipelines: []*pipeline{pl}}
+	for p.isOp("&&", "||") {
+		ao.ops = append(ao.ops, p.tok.op)
+		ao.pos = append(ao.pos, p.tok.pos)
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if pl, err = p.pipeline(); err != nil {
+			return nil, err
+		}
+		ao.pipelines = append(ao.pipelines, pl)
+	}
+	return ao, nil
+}
+
+func (p *parser) pipeline() (*pipeline, error) {
+	negate := p.tok.kind == tokWord && !p.tok.word.quoted && p.tok.word.String() == "!"
+	if negate {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	c, err := p.command()
+	if err != nil {
+		return nil, err
+	}
+	pl := &pipeline{commands: []*command{c}, negate: negate}
+	for p.isOp("|") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if c, err = p.command(); err != nil {
+			return nil, err
+		}
+		pl.commands = append(pl.commands, c)
+	}
+	return pl, nil
+}
+
+func (p *parser) command() (*command, error) {
+	c := &command{}
+	for {
+		switch {
+		case p.tok.kind == tokWord:
+			c.args = append(c.args, p.tok.word)
+		case p.tok.kind == tokOp && isRedirect(p.tok.op):
+			r := &redirect{fd: p.tok.fd, op: p.tok.op, pos: p.tok.pos}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokWord {
+				if p.tok.kind == tokEOF {
+					return nil, &SyntaxError{Pos: p.tok.pos, Msg: "unexpected token `newline'"}
+				}
+				return nil, p.unexpected()
+			}
+			r.target = p.tok.word
+			if r.op == "<<" || r.op == "<<-" {
+				r.expand = !r.target.quoted
+				p.hereDocs = append(p.hereDocs, r)
+			}
+			c.redirects = append(c.redirects, r)
+		default:
+			if len(c.args) == 0 && len(c.redirects) == 0 {
+				return nil, p.unexpected()
+			}
+			return c, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// String returns program in normalized form with arguments in brackets, it's used in tests and debugging
+func (prog *program) String() string {
+	var b strings.Builder
+	for i, item := range prog.items {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		for j, pl := range item.andOr.pipelines {
+			if j > 0 {
+				b.WriteString(" " + item.andOr.ops[j-1] + " ")
+			}
+			if pl.negate {
+				b.WriteString("! ")
+			}
+			for k, c := range pl.commands {
+				if k > 0 {
+					b.WriteString(" | ")
+				}
+				var fields []string
+				for _, arg := range c.args {
+					fields = append(fields, "["+arg.String()+"]")
+				}
+				for _, r := range c.redirects {
+					fd := ""
+					if r.fd >= 0 {
+						fd = fmt.Sprint(r.fd)
+					}
+					fields = append(fields, fd+r.op+"["+r.target.String()+"]")
+				}
+				b.WriteString(strings.Join(fields, " "))
+			}
+		}
+		if item.background {
+			b.WriteString(" &")
+		} else {
+			b.WriteString(";")
+		}
+	}
+	return b.String()
+}
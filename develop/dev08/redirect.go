@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// redirectFiles applies redirects to files of descriptors 0, 1 and 2 of command from left to right.
+// It returns new files of descriptors and opened files which must be closed after command is started.
+func redirectFiles(files []*os.File, redirects []*redirect) ([]*os.File, []*os.File, error) {
+	files = append([]*os.File(nil), files...)
+	var opened []*os.File
+	for _, r := range redirects {
+		fd := r.fd
+		if fd < 0 {
+			fd = 1
+			if r.op == "<" || r.op == "<<" || r.op == "<<-" {
+				fd = 0
+			}
+		}
+		if fd >= len(files) {
+			closeFiles(opened)
+			return nil, nil, fmt.Errorf("%d: bad file descriptor", fd)
+		}
+		target := r.target.expand(lookupVar)
+		var f *os.File
+		var err error
+		switch r.op {
+		case ">", "&>":
+			f, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+		case ">>":
+			f, err = os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+		case "<":
+			f, err = os.Open(target)
+		case "<<", "<<-":
+			f, err = hereDocFile(r)
+		case ">&":
+			// 2>&1 duplicates descriptor, the file stays open until command is started
+			n, convErr := strconv.Atoi(target)
+			if convErr != nil || n < 0 || n >= len(files) {
+				err = fmt.Errorf("%s: ambiguous redirect", target)
+				break
+			}
+			files[fd] = files[n]
+			continue
+		}
+		if err != nil {
+			closeFiles(opened)
+			return nil, nil, err
+		}
+		opened = append(opened, f)
+		files[fd] = f
+		if r.op == "&>" {
+			files[2] = f
+		}
+	}
+	return files, opened, nil
+}
+
+// hereDocFile returns read end of pipe which body of here-document is written to
+func hereDocFile(r *redirect) (*os.File, error) {
+	body := r.body
+	if r.expand {
+		body = expandHereDoc(body)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	// writer fails when command exits without reading the whole body
+	go func() {
+		pw.WriteString(body)
+		pw.Close()
+	}()
+	return pr, nil
+}
+
+// expandHereDoc replaces variables in body of here-document, \$ is a literal dollar sign
+func expandHereDoc(body string) string {
+	parts := strings.Split(body, `\$`)
+	for i, part := range parts {
+		parts[i] = os.Expand(part, lookupVar)
+	}
+	return strings.Join(parts, "$")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// foregroundPgid is a process group of foreground job, 0 while the shell waits for input or runs builtins
+var foregroundPgid atomic.Int32
+
+// reading is true while the shell waits for input, interrupted is set by SIGINT at that time,
+// so partially read input is discarded
+var reading, interrupted atomic.Bool
+
+// initSignals makes the shell survive SIGINT and SIGQUIT. Signals are handled rather than ignored,
+// because ignored signals stay ignored in started commands.
+func initSignals() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGQUIT)
+	go func() {
+		for sig := range sigCh {
+			handleSignal(sig.(syscall.Signal))
+		}
+	}()
+}
+
+// handleSignal forwards signal received by the shell to foreground job. Interactive shell gets them only
+// from kill, terminal sends them to foreground process group itself. At the prompt SIGINT starts a new line.
+func handleSignal(sig syscall.Signal) {
+	if pgid := foregroundPgid.Load(); pgid != 0 {
+		if err := syscall.Kill(-int(pgid), sig); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return
+	}
+	if sig == syscall.SIGINT && terminal >= 0 && reading.Load() {
+		interrupted.Store(true)
+		fmt.Print("\n" + prompt())
+	}
+}
+
+// reportSignal prints how foreground job was killed like the job table does,
+// interrupted jobs only end the line after ^C and broken pipes are usual for pipelines
+func reportSignal(j *job) {
+	switch j.stages[len(j.stages)-1].signal {
+	case 0, syscall.SIGPIPE:
+	case syscall.SIGINT:
+		fmt.Println()
+	default:
+		fmt.Println(j.describe())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// subshellFlag is the first argument of the shell started as a subshell, the second one is its state in JSON
+const subshellFlag = "-subshell"
+
+// subshellState is a state of the shell passed to its copy running a part of program in a separate process,
+// so the part can't change the shell. It's either source of and-or list run in background with its here-documents,
+// or already expanded arguments of builtin run in pipeline or in background.
+type subshellState struct {
+	Status   int      `json:"status"`
+	Pipefail bool     `json:"pipefail,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Args     []string `json:"args,omitempty"`
+}
+
+// inSubshell is true in subshell, it has no job control and its commands stay in its process group
+var inSubshell bool
+
+// subshellArgs returns arguments of a subshell running text or builtin with exit status and options of the shell
+func subshellArgs(text string, args []string) ([]string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(subshellState{Status: lastStatus, Pipefail: pipefail, Text: text, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return []string{self, subshellFlag, string(data)}, nil
+}
+
+// runSubshell runs the shell started as a subshell and returns its exit status, false is returned for the main shell
+func runSubshell() (int, bool) {
+	if len(os.Args) != 3 || os.Args[1] != subshellFlag {
+		return 0, false
+	}
+	inSubshell = true
+	var state subshellState
+	if err := json.Unmarshal([]byte(os.Args[2]), &state); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2, true
+	}
+	lastStatus, pipefail = state.Status, state.Pipefail
+	if len(state.Args) > 0 {
+		f, ok := funcMap[state.Args[0]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: not a builtin\n", state.Args[0])
+			return 127, true
+		}
+		return f(state.Args, &stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr}), true
+	}
+	prog, err := parse(state.Text)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2, true
+	}
+	return runProgram(prog), true
+}
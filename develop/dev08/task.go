@@ -2,10 +2,11 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -34,131 +35,314 @@ import (
 */
 
 func main() {
+	if status, ok := runSubshell(); ok {
+		os.Exit(status)
+	}
+	initJobControl()
+	initSignals()
 	s := bufio.NewScanner(os.Stdin)
 	for {
-		path, err := os.Getwd()
-		if err != nil {
-			fmt.Println(err)
+		prog, ok := readProgram(s)
+		if !ok {
+			fmt.Println("exit")
+			os.Exit(lastStatus)
+		}
+		if prog == nil {
+			// syntax error
+			lastStatus = 2
 			continue
 		}
-		base := filepath.Base(path)
-		fmt.Printf("%s$ ", base)
-		s.Scan()
-		osArgs := strings.Fields(s.Text())
-		if len(osArgs) == 0 {
+		runProgram(prog)
+	}
+}
+
+// prompt returns prompt with base name of current directory
+func prompt() string {
+	path, err := os.Getwd()
+	if err != nil {
+		fmt.Println(err)
+		return "$ "
+	}
+	return filepath.Base(path) + "$ "
+}
+
+// readProgram prints prompt and reads lines until they form a complete program,
+// nil program is returned for invalid input and false at the end of input.
+// Jobs finished since the previous prompt are reported before it.
+func readProgram(s *bufio.Scanner) (*program, bool) {
+	notifyJobs(os.Stdout)
+	fmt.Print(prompt())
+	var input string
+	for {
+		reading.Store(true)
+		ok := s.Scan()
+		reading.Store(false)
+		if interrupted.Swap(false) {
+			input = ""
+		}
+		if !ok {
+			if input != "" {
+				fmt.Fprintln(os.Stderr, "syntax error: unexpected end of file")
+			}
+			return nil, false
+		}
+		input += s.Text() + "\n"
+		prog, err := parse(input)
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) && syntaxErr.Incomplete {
+			fmt.Print("> ")
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, true
+		}
+		return prog, true
+	}
+}
+
+// lastStatus is exit status of the last pipeline, it's a value of $?
+var lastStatus int
+
+// runProgram runs items of program one after another and returns exit status of the last one,
+// items ending with & are started as background jobs
+func runProgram(prog *program) int {
+	for _, item := range prog.items {
+		if item.background {
+			runBackground(item)
+			lastStatus = 0
 			continue
 		}
-		if f, ok := funcMap[osArgs[0]]; !ok {
-			forkExec(osArgs)
-		} else {
-			f(osArgs)
+		runAndOr(item.andOr, item.text)
+	}
+	return lastStatus
+}
+
+// runAndOr runs the first pipeline of ao, each next pipeline runs only if status of the previous one
+// is zero for && or non-zero for ||. Status of the last run pipeline is returned.
+func runAndOr(ao *andOr, text string) int {
+	if len(ao.pipelines) > 1 {
+		// pipelines are shown in job table by themselves
+		text = ""
+	}
+	lastStatus = runPipeline(ao.pipelines[0], text)
+	for i, op := range ao.ops {
+		if (op == "&&") == (lastStatus == 0) {
+			lastStatus = runPipeline(ao.pipelines[i+1], text)
 		}
 	}
+	return lastStatus
+}
+
+// stdio contains standard streams of a builtin command
+type stdio struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
 }
 
-var funcMap = map[string]func(args []string){
+// funcMap contains builtins, they return exit status like external commands
+var funcMap = map[string]func(args []string, std *stdio) int{
 	"cd":   cd,
 	"pwd":  pwd,
 	"echo": echo,
 	"kill": kill,
 	"ps":   ps,
+	"set":  set,
+	"jobs": jobs,
+	"fg":   fg,
+	"bg":   bg,
+	"wait": wait,
 	"exit": exit,
 }
 
-func cd(args []string) {
+// pipefail makes pipeline return status of the last failed stage instead of status of the last stage
+var pipefail bool
+
+// expandArgs returns arguments with expanded variables, unquoted words expanded to empty strings are dropped
+func expandArgs(words []word) []string {
+	args := make([]string, 0, len(words))
+	for _, w := range words {
+		if arg := w.expand(lookupVar); arg != "" || w.quoted {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+// lookupVar returns value of variable of the shell, ? is exit status of the last pipeline
+func lookupVar(name string) string {
+	if name == "?" {
+		return strconv.Itoa(lastStatus)
+	}
+	return os.Getenv(name)
+}
+
+// pipelineStatus returns status of the last stage, or with pipefail status of the last failed stage
+func pipelineStatus(statuses []int) int {
+	if pipefail {
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i] != 0 {
+				return statuses[i]
+			}
+		}
+		return 0
+	}
+	return statuses[len(statuses)-1]
+}
+
+// closeFile closes pipe ends of the shell, standard streams are left open
+func closeFile(f *os.File) {
+	if f != os.Stdin && f != os.Stdout && f != os.Stderr {
+		f.Close()
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func cd(args []string, std *stdio) int {
 	switch len(args) {
 	case 1:
-		if home, err := os.UserHomeDir(); err != nil {
-			fmt.Println(err)
-		} else {
-			if err := os.Chdir(home); err != nil {
-				fmt.Println(err)
-			}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintln(std.err, err)
+			return 1
+		}
+		if err := os.Chdir(home); err != nil {
+			fmt.Fprintln(std.err, err)
+			return 1
 		}
 	case 2:
 		if err := os.Chdir(args[1]); err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(std.err, err)
+			return 1
 		}
 	default:
-		fmt.Println("Too many args for cd command")
+		fmt.Fprintln(std.err, "Too many args for cd command")
+		return 1
 	}
+	return 0
 }
 
-func pwd(args []string) {
+func pwd(args []string, std *stdio) int {
 	if len(args) > 1 {
-		fmt.Println("pwd: expected 0 arguments; got", len(args)-1)
-	} else {
-		if path, err := os.Getwd(); err != nil {
-			fmt.Println(err)
-		} else {
-			fmt.Println(path)
-		}
+		fmt.Fprintln(std.err, "pwd: expected 0 arguments; got", len(args)-1)
+		return 1
 	}
+	path, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(std.err, err)
+		return 1
+	}
+	fmt.Fprintln(std.out, path)
+	return 0
 }
 
-func echo(args []string) {
-	fmt.Println(strings.Join(args[1:], " "))
+func echo(args []string, std *stdio) int {
+	if _, err := fmt.Fprintln(std.out, strings.Join(args[1:], " ")); err != nil {
+		return 1
+	}
+	return 0
 }
 
-func kill(args []string) {
+// kill kills processes by pids, %n kills process group of job n
+func kill(args []string, std *stdio) int {
 	for _, pidSTR := range args[1:] {
+		if strings.HasPrefix(pidSTR, "%") {
+			j, err := shellJobs.find(pidSTR)
+			if err == nil && j.pgid == 0 {
+				err = fmt.Errorf("%s: job has no processes", pidSTR)
+			}
+			if err != nil {
+				fmt.Fprintln(std.err, "kill:", err)
+				return 1
+			}
+			pidSTR = strconv.Itoa(-j.pgid)
+		}
 		pid, err := strconv.Atoi(pidSTR)
 		if err != nil {
-			fmt.Println(err)
-			return
+			fmt.Fprintln(std.err, err)
+			return 1
 		}
 		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
-			fmt.Println(err)
-			return
+			fmt.Fprintln(std.err, err)
+			return 1
 		}
 	}
+	return 0
 }
 
-func ps(args []string) {
+func ps(args []string, std *stdio) int {
 	if len(args) > 1 {
-		fmt.Println("Too many arguments")
-		return
+		fmt.Fprintln(std.err, "Too many arguments")
+		return 1
 	}
 	processes, err := gops.Processes()
 	if err != nil {
-		fmt.Println(err)
-		return
+		fmt.Fprintln(std.err, err)
+		return 1
 	}
-	fmt.Printf("%6s\t%s\n", "PID", "CMD")
+	fmt.Fprintf(std.out, "%6s\t%s\n", "PID", "CMD")
 	for _, process := range processes {
-		fmt.Printf("%6d\t%s\n", process.Pid(), process.Executable())
+		fmt.Fprintf(std.out, "%6d\t%s\n", process.Pid(), process.Executable())
+	}
+	return 0
+}
+
+// set switches options of the shell: set -o pipefail enables pipefail, set +o pipefail disables it,
+// set -o prints states of options
+func set(args []string, std *stdio) int {
+	switch {
+	case len(args) == 2 && args[1] == "-o":
+		state := "off"
+		if pipefail {
+			state = "on"
+		}
+		fmt.Fprintf(std.out, "pipefail\t%s\n", state)
+	case len(args) == 3 && (args[1] == "-o" || args[1] == "+o") && args[2] == "pipefail":
+		pipefail = args[1] == "-o"
+	default:
+		fmt.Fprintln(std.err, "usage: set -o|+o pipefail")
+		return 2
 	}
+	return 0
 }
 
-func forkExec(args []string) {
+// forkExec starts external command with given standard streams and attributes of process
+func forkExec(args []string, files []*os.File, attr *syscall.SysProcAttr) (*os.Process, error) {
 	path, err := exec.LookPath(args[0])
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
 	}
-	args[0] = path
 	var procAttr os.ProcAttr
-	procAttr.Files = []*os.File{os.Stdin, os.Stdout, os.Stderr}
-	p, err := os.StartProcess(args[0], args, &procAttr)
-	if err != nil {
-		fmt.Println(err)
-		return
+	procAttr.Files = files
+	procAttr.Sys = attr
+	return os.StartProcess(path, args, &procAttr)
+}
+
+// exit exits the shell with status of argument or with status of the last pipeline, subshell exits silently
+func exit(args []string, std *stdio) int {
+	if !inSubshell {
+		fmt.Fprintln(std.out, "exit")
 	}
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case <-sigCh:
-		return
-	default:
-		state, err := p.Wait()
+	status := lastStatus
+	switch len(args) {
+	case 1:
+	case 2:
+		n, err := strconv.Atoi(args[1])
 		if err != nil {
-			fmt.Println(err)
-			fmt.Printf("exit(%d)\n", state.ExitCode())
+			fmt.Fprintf(std.err, "exit: %s: numeric argument required\n", args[1])
+			n = 2
 		}
+		status = n & 0xff
+	default:
+		fmt.Fprintln(std.err, "exit: too many arguments")
+		return 1
 	}
-}
-
-func exit(args []string) {
-	fmt.Println("exit")
-	os.Exit(0)
+	os.Exit(status)
+	return status
 }
@@ -1 +1,414 @@
 package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary run as a subshell of the shell
+func TestMain(m *testing.M) {
+	if status, ok := runSubshell(); ok {
+		os.Exit(status)
+	}
+	os.Exit(m.Run())
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		input   string
+		program string
+	}{
+		"empty":             {input: "  \n", program: ""},
+		"comment":           {input: "# nothing to do\n", program: ""},
+		"simple command":    {input: "ls -l /tmp", program: "[ls] [-l] [/tmp];"},
+		"quotes":            {input: `echo "hello world" 'a  b' "" it\'s`, program: "[echo] [hello world] [a  b] [] [it's];"},
+		"adjacent quotes":   {input: `echo a"b c"'d'e`, program: "[echo] [ab cde];"},
+		"double quotes":     {input: `echo "a \"b\" \$x \n"`, program: `[echo] [a "b" $x \n];`},
+		"single quotes":     {input: `echo 'a \ "b"'`, program: `[echo] [a \ "b"];`},
+		"escaped operators": {input: `echo a\|b \; \#c`, program: "[echo] [a|b] [;] [#c];"},
+		"trailing comment":  {input: "echo a#b # comment | wc", program: "[echo] [a#b];"},
+		"continuation":      {input: "echo a \\\nb", program: "[echo] [a] [b];"},
+		"unicode":           {input: "echo 'привет мир'", program: "[echo] [привет мир];"},
+		"pipeline":          {input: "cat a|grep -v b | wc -l", program: "[cat] [a] | [grep] [-v] [b] | [wc] [-l];"},
+		"pipeline on lines": {input: "echo a |\n\n wc", program: "[echo] [a] | [wc];"},
+		"list":              {input: "cd /tmp; pwd\nls;", program: "[cd] [/tmp]; [pwd]; [ls];"},
+		"and or":            {input: "make && echo ok || echo failed", program: "[make] && [echo] [ok] || [echo] [failed];"},
+		"background":        {input: "sleep 10 & echo started", program: "[sleep] [10] & [echo] [started];"},
+		"redirects": {input: "sort <in >out 2>>errors 2>&1 &>all",
+			program: "[sort] <[in] >[out] 2>>[errors] 2>&[1] &>[all];"},
+		"redirect before command": {input: ">out echo a", program: "[echo] [a] >[out];"},
+		"here-documents":          {input: "cat <<A <<-'B'\nx\nA\n\ty\n\tB\necho", program: "[cat] <<[A] <<-[B]; [echo];"},
+		"digits without redirect": {input: "echo 2 >x 2&>y", program: "[echo] [2] [2] >[x] &>[y];"},
+		"negation":                {input: "! grep a | wc && ! false", program: "! [grep] [a] | [wc] && ! [false];"},
+		"quoted exclamation":      {input: "'!' a!b !", program: "[!] [a!b] [!];"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			prog, err := parse(v.input)
+			if err != nil {
+				t.Fatalf("expected: %v, got: %v", nil, err)
+			}
+			if prog.String() != v.program {
+				t.Errorf("expected: %s, got: %s", v.program, prog.String())
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]struct {
+		input      string
+		err        string
+		incomplete bool
+	}{
+		"missing stage":         {input: "echo a | | wc", err: "syntax error at 1:10: unexpected token `|'"},
+		"leading separator":     {input: "; ls", err: "syntax error at 1:1: unexpected token `;'"},
+		"double separator":      {input: "ls ;; pwd", err: "syntax error at 1:5: unexpected token `;'"},
+		"missing target":        {input: "echo a >", err: "syntax error at 1:9: unexpected token `newline'"},
+		"target is operator":    {input: "echo a > | wc", err: "syntax error at 1:10: unexpected token `|'"},
+		"error on second line":  {input: "echo a\n  ls && && pwd", err: "syntax error at 2:9: unexpected token `&&'"},
+		"column in characters":  {input: "echo 'ф' | && x", err: "syntax error at 1:12: unexpected token `&&'"},
+		"trailing pipe":         {input: "echo a |", err: "syntax error at 1:9: unexpected end of input", incomplete: true},
+		"trailing and":          {input: "make &&\n", err: "syntax error at 2:1: unexpected end of input", incomplete: true},
+		"single quote":          {input: "echo 'abc", err: "syntax error at 1:6: unterminated single quote", incomplete: true},
+		"double quote":          {input: "echo a \"b\nc", err: "syntax error at 1:8: unterminated double quote", incomplete: true},
+		"continued line":        {input: "echo a \\\n", err: "syntax error at 1:8: unexpected end of input after \\", incomplete: true},
+		"continued word":        {input: "echo a\\\n", err: "syntax error at 1:7: unexpected end of input after \\", incomplete: true},
+		"here-document":         {input: "cat <<EOF\nabc\n", err: "syntax error at 2:1: here-document delimited by `EOF' is not terminated", incomplete: true},
+		"here-doc without body": {input: "cat <<EOF", err: "syntax error at 1:10: here-document delimited by `EOF' is not terminated", incomplete: true},
+		"trailing backslash":    {input: "echo a\\", err: "syntax error at 1:7: unexpected end of input after \\", incomplete: true},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			_, err := parse(v.input)
+			syntaxErr, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("expected: %s, got: %v", v.err, err)
+			}
+			if syntaxErr.Error() != v.err {
+				t.Errorf("expected: %s, got: %s", v.err, syntaxErr.Error())
+			}
+			if syntaxErr.Incomplete != v.incomplete {
+				t.Errorf("expected: %v, got: %v", v.incomplete, syntaxErr.Incomplete)
+			}
+		})
+	}
+}
+
+func TestItemText(t *testing.T) {
+	prog := mustParse(t, "  sleep 1 | wc &echo 'a  b' >out;\n  ls # comment")
+	expected := []string{"sleep 1 | wc", "echo 'a  b' >out", "ls"}
+	if len(prog.items) != len(expected) {
+		t.Fatalf("expected: %d, got: %d", len(expected), len(prog.items))
+	}
+	for i, item := range prog.items {
+		if item.text != expected[i] {
+			t.Errorf("expected: %q, got: %q", expected[i], item.text)
+		}
+	}
+}
+
+func TestPipelineStatus(t *testing.T) {
+	tests := map[string]struct {
+		statuses []int
+		pipefail bool
+		status   int
+	}{
+		"last succeeded":          {statuses: []int{1, 0}, status: 0},
+		"last failed":             {statuses: []int{0, 2}, status: 2},
+		"pipefail without errors": {statuses: []int{0, 0}, pipefail: true, status: 0},
+		"pipefail":                {statuses: []int{1, 3, 0}, pipefail: true, status: 3},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			pipefail = v.pipefail
+			defer func() { pipefail = false }()
+			if status := pipelineStatus(v.statuses); status != v.status {
+				t.Errorf("expected: %d, got: %d", v.status, status)
+			}
+		})
+	}
+}
+
+func TestRunPipeline(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		pipefail bool
+		status   int
+	}{
+		"external commands":   {input: "true | false", status: 1},
+		"builtin to external": {input: "echo hello | grep -q hello", status: 0},
+		"not found":           {input: "no-such-command-dev08 2>/dev/null | true", pipefail: true, status: 127},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			pipefail = v.pipefail
+			defer func() { pipefail = false }()
+			if status := runPipeline(mustParse(t, v.input).items[0].andOr.pipelines[0], ""); status != v.status {
+				t.Errorf("expected: %d, got: %d", v.status, status)
+			}
+		})
+	}
+}
+
+func TestBackgroundJobs(t *testing.T) {
+	defer func() { shellJobs = &jobTable{} }()
+	for _, input := range []string{"sleep 0.1", "true | sh -c 'exit 3'", "sleep 5"} {
+		item := mustParse(t, input+" &").items[0]
+		shellJobs.add(startJob(item.andOr.pipelines[0], item.text, false))
+	}
+	tests := map[string]struct {
+		spec string
+		text string
+		err  string
+	}{
+		"current":      {spec: "", text: "sleep 5"},
+		"current %%":   {spec: "%%", text: "sleep 5"},
+		"previous":     {spec: "%-", text: "true | sh -c 'exit 3'"},
+		"by number":    {spec: "%1", text: "sleep 0.1"},
+		"without %":    {spec: "2", text: "true | sh -c 'exit 3'"},
+		"missing job":  {spec: "%9", err: "%9: no such job"},
+		"not a number": {spec: "%x", err: "%x: no such job"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			j, err := shellJobs.find(v.spec)
+			if v.err != "" {
+				if err == nil || err.Error() != v.err {
+					t.Errorf("expected: %s, got: %v", v.err, err)
+				}
+				return
+			}
+			if err != nil || j.text != v.text {
+				t.Errorf("expected: %s, got: %v %v", v.text, j, err)
+			}
+		})
+	}
+
+	last, _ := shellJobs.find("%3")
+	syscall.Kill(-last.pgid, syscall.SIGTERM)
+	var out strings.Builder
+	wait([]string{"wait"}, &stdio{out: &out, err: &out})
+	notifyJobs(&out)
+	expected := "[1]   Done                    sleep 0.1\n" +
+		"[2]-  Exit 3                  true | sh -c 'exit 3'\n" +
+		"[3]+  Terminated              sleep 5\n"
+	if out.String() != expected {
+		t.Errorf("expected: %q, got: %q", expected, out.String())
+	}
+	if jobs := shellJobs.list(); len(jobs) != 0 {
+		t.Errorf("expected: %d, got: %d", 0, len(jobs))
+	}
+}
+
+func TestForegroundSignals(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		signal syscall.Signal
+		status int
+	}{
+		"interrupted":      {input: "sleep 5", signal: syscall.SIGINT, status: 130},
+		"quit":             {input: "sleep 5 | sleep 5", signal: syscall.SIGQUIT, status: 131},
+		"killed by itself": {input: "sh -c 'kill -TERM $$'", status: 143},
+		"exit status":      {input: "sh -c 'exit 7'", status: 7},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			pl := mustParse(t, v.input).items[0].andOr.pipelines[0]
+			done := make(chan int)
+			go func() {
+				done <- runPipeline(pl, v.input)
+			}()
+			if v.signal != 0 {
+				for foregroundPgid.Load() == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				handleSignal(v.signal)
+			}
+			if status := <-done; status != v.status {
+				t.Errorf("expected: %d, got: %d", v.status, status)
+			}
+			if pgid := foregroundPgid.Load(); pgid != 0 {
+				t.Errorf("expected: %d, got: %d", 0, pgid)
+			}
+		})
+	}
+}
+
+func TestRunProgram(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	tests := map[string]struct {
+		input  string
+		status int
+		output string
+	}{
+		"status of builtin":   {input: "cd /no-such-dir-dev08 2>/dev/null; echo $? > " + out, output: "1\n"},
+		"status of command":   {input: "sh -c 'exit 5'; echo $? > " + out, output: "5\n"},
+		"last status":         {input: "echo a > " + out + "; false", status: 1, output: "a\n"},
+		"and":                 {input: "true && echo a > " + out, output: "a\n"},
+		"and skipped":         {input: "false && echo a > " + out, status: 1},
+		"or":                  {input: "false || echo $? > " + out, output: "1\n"},
+		"or skipped":          {input: "true || echo a > " + out},
+		"skipped to next":     {input: "false && echo a > " + out + " || echo b > " + out, output: "b\n"},
+		"negation":            {input: "! true || ! false && echo $? > " + out, output: "0\n"},
+		"negated builtin":     {input: "! echo a > " + out, status: 1, output: "a\n"},
+		"background and-or":   {input: "true && echo a > " + out + " & wait", output: "a\n"},
+		"background skipped":  {input: "false && echo a > " + out + " & wait %1", status: 1},
+		"status in subshell":  {input: "sh -c 'exit 4'; echo $? > " + out + " || true & wait", output: "4\n"},
+		"background negation": {input: "! false > " + out + " & wait %1 && echo $? >> " + out, output: "0\n"},
+		"background here-doc": {input: "true && cat > " + out + " <<EOF &\nhi\nEOF\nwait", output: "hi\n"},
+		"background quoted":   {input: "! cat <<'EOF' > " + out + " &\n$HOME\nEOF\nwait %1", status: 1, output: "$HOME\n"},
+		"exit in subshell":    {input: "true && exit 3 & wait %%; echo $? > " + out, output: "3\n"},
+		"status of redirects": {input: "> /no-such-dir-dev08/out 2>/dev/null || echo $? > " + out, output: "1\n"},
+	}
+	defer func() { shellJobs = &jobTable{} }()
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			defer os.Remove(out)
+			defer func() { shellJobs = &jobTable{} }()
+			if status := runProgram(mustParse(t, v.input)); status != v.status {
+				t.Errorf("expected: %d, got: %d", v.status, status)
+			}
+			data, _ := os.ReadFile(out)
+			if string(data) != v.output {
+				t.Errorf("expected: %q, got: %q", v.output, string(data))
+			}
+		})
+	}
+}
+
+func TestBuiltinsInSubshell(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		input  string
+		status int
+		output string
+	}{
+		"exit in pipeline":   {input: "exit 3 | cat > " + out},
+		"status of exit":     {input: "set -o pipefail; exit 3 | true", status: 3},
+		"cd in pipeline":     {input: "cd / | true; pwd > " + out, output: wd + "\n"},
+		"set in pipeline":    {input: "set -o pipefail | true; false | true", status: 0},
+		"exit status":        {input: "false; echo $? | cat > " + out, output: "1\n"},
+		"exit in background": {input: "exit 4 & wait %1", status: 4},
+		"cd in background":   {input: "cd / & wait; pwd > " + out, output: wd + "\n"},
+		"echo in background": {input: "echo a > " + out + " & wait", output: "a\n"},
+	}
+	defer func() { shellJobs = &jobTable{} }()
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			defer os.Remove(out)
+			defer func() { shellJobs, pipefail = &jobTable{}, false }()
+			if status := runProgram(mustParse(t, v.input)); status != v.status {
+				t.Errorf("expected: %d, got: %d", v.status, status)
+			}
+			data, _ := os.ReadFile(out)
+			if string(data) != v.output {
+				t.Errorf("expected: %q, got: %q", v.output, string(data))
+			}
+			if got, _ := os.Getwd(); got != wd {
+				t.Errorf("expected: %s, got: %s", wd, got)
+			}
+		})
+	}
+
+	item := mustParse(t, "echo hi > /dev/null &").items[0]
+	j := startJob(item.andOr.pipelines[0], item.text, false)
+	j.wait()
+	if j.lastPid() == 0 || j.pgid != j.lastPid() {
+		t.Errorf("expected: pid of process group leader, got: %d %d", j.lastPid(), j.pgid)
+	}
+	if j.status() != 0 {
+		t.Errorf("expected: %d, got: %d", 0, j.status())
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DEV08_NAME", "world")
+	if err := os.WriteFile(filepath.Join(dir, "in"), []byte("b\na\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// messages of ls differ between systems, so partial outputs are only contained in files
+	tests := map[string]struct {
+		input   string
+		file    string
+		output  string
+		partial bool
+	}{
+		"builtin to file":     {input: "echo hello > out", file: "out", output: "hello\n"},
+		"append":              {input: "echo a > out; echo b >> out", file: "out", output: "a\nb\n"},
+		"input from file":     {input: "sort < in > out", file: "out", output: "a\nb\n"},
+		"stderr":              {input: "ls no-such-file 2> err", file: "err", output: "no-such-file", partial: true},
+		"stderr to stdout":    {input: "ls no-such-file > out 2>&1", file: "out", output: "no-such-file", partial: true},
+		"order of redirects":  {input: "ls no-such-file 2>&1 > out", file: "out", output: ""},
+		"both streams":        {input: "ls in no-such-file &> out", file: "out", output: "no-such-file", partial: true},
+		"builtin stderr":      {input: "cd a b 2> err", file: "err", output: "Too many args for cd command\n"},
+		"pipeline":            {input: "echo b a | tr ' ' '\\n' | sort > out", file: "out", output: "a\nb\n"},
+		"expanded target":     {input: "echo x > $DEV08_NAME", file: "world", output: "x\n"},
+		"only redirect":       {input: "> empty", file: "empty", output: ""},
+		"here-document":       {input: "cat <<EOF > out\nhello $DEV08_NAME\n\\$HOME\nEOF\n", file: "out", output: "hello world\n$HOME\n"},
+		"quoted here-doc":     {input: "cat <<'EOF' > out\nhello $DEV08_NAME\nEOF\n", file: "out", output: "hello $DEV08_NAME\n"},
+		"tabs of here-doc":    {input: "cat <<-END > out\n\t\tindented\n\tEND\n", file: "out", output: "indented\n"},
+		"two here-documents":  {input: "cat <<A > out; cat <<B >> out\n1\nA\n2\nB\n", file: "out", output: "1\n2\n"},
+		"here-doc to builtin": {input: "pwd <<EOF > out\nignored\nEOF\n", file: "out", output: dir + "\n"},
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			for _, item := range mustParse(t, v.input).items {
+				runPipeline(item.andOr.pipelines[0], item.text)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, v.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(data); got != v.output && !(v.partial && strings.Contains(got, v.output)) {
+				t.Errorf("expected: %q, got: %q", v.output, got)
+			}
+			os.Remove(filepath.Join(dir, v.file))
+		})
+	}
+}
+
+func TestRedirectErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing file":      "cat < /no-such-dir-dev08/file",
+		"ambiguous":         "echo a >& file",
+		"bad descriptor":    "echo a 5> /dev/null",
+		"directory as file": "echo a > /",
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			c := mustParse(t, v).items[0].andOr.pipelines[0].commands[0]
+			if _, _, err := redirectFiles([]*os.File{os.Stdin, os.Stdout, os.Stderr}, c.redirects); err == nil {
+				t.Errorf("expected: error, got: %v", err)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, input string) *program {
+	t.Helper()
+	prog, err := parse(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
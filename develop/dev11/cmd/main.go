@@ -2,35 +2,305 @@ package main
 
 import (
 	"context"
+	"dev11/internal/blob"
+	"dev11/internal/calendar"
+	"dev11/internal/controller/attachment"
 	"dev11/internal/controller/event"
+	"dev11/internal/controller/task"
+	"dev11/internal/controller/webhook"
+	"dev11/internal/digest"
 	httphandler "dev11/internal/handler/http"
+	"dev11/internal/health"
+	"dev11/internal/identity"
+	"dev11/internal/raft"
 	"dev11/internal/repository/memory"
+	"dev11/internal/tenant"
+	"dev11/internal/tlsserver"
+	"encoding/json"
+	"flag"
 	"log"
+	"net"
 	"net/http"
+	"net/smtp"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 )
 
+// config holds values of command line flags
+type config struct {
+	calendars           string
+	country             string
+	timeout             time.Duration
+	cacheSize           int
+	addr                string
+	raftID              uint64
+	raftPeers           string
+	raftAddr            string
+	raftDir             string
+	raftSnapshotEntries int
+	raftSecretFile      string
+	maxStale            time.Duration
+	maxEvents           int
+	maxEventsPerDay     int
+	retentionMonths     int
+	retentionArchive    string
+	retentionInterval   time.Duration
+	digestSubscriptions string
+	digestInterval      time.Duration
+	smtpAddr            string
+	smtpFrom            string
+	smtpUser            string
+	smtpPassword        string
+	attachmentsDir      string
+	maxAttachmentSize   int64
+	maxEventAttachments int64
+	maxAttachments      int
+	tlsCert             string
+	tlsKey              string
+	tlsClientCA         string
+	tlsClientAuth       string
+	tlsIdentities       string
+	tenantsFile         string
+	webhooksFile        string
+	webhook             webhook.Config
+	redirectAddr        string
+	adminAddr           string
+	healthTimeout       time.Duration
+	drainDelay          time.Duration
+	version             bool
+}
+
+// parseFlags parses command line flags into config
+func parseFlags() *config {
+	c := &config{}
+	flag.StringVar(&c.calendars, "calendars", "calendars", "directory with production calendars")
+	flag.StringVar(&c.country, "country", "RU", "default country of production calendar")
+	flag.DurationVar(&c.timeout, "timeout", 5*time.Second, "timeout of request processing")
+	flag.IntVar(&c.cacheSize, "cache", 1024, "number of cached queries, 0 disables cache")
+	flag.StringVar(&c.addr, "addr", ":8080", "address to listen on")
+	flag.Uint64Var(&c.raftID, "raft-id", 0, "id of node in replicated cluster, 0 disables replication")
+	flag.StringVar(&c.raftPeers, "raft-peers", "", "comma separated list of nodes of cluster in id=url form, urls point to their -raft-addr listeners")
+	flag.StringVar(&c.raftAddr, "raft-addr", "", "address of listener serving requests of other nodes of cluster, required with -raft-id")
+	flag.StringVar(&c.raftDir, "raft-dir", "raft", "directory where node saves its term, vote, snapshot and log")
+	flag.IntVar(&c.raftSnapshotEntries, "raft-snapshot-entries", raft.DefaultSnapshotEntries,
+		"number of writes after which node saves snapshot of events and drops log before it")
+	flag.StringVar(&c.raftSecretFile, "raft-secret-file", "", "file with secret shared by nodes of cluster, required with -raft-id")
+	flag.DurationVar(&c.maxStale, "max-stale", 0, "maximal time since contact with leader for reads on follower, 0 means unbounded")
+	flag.IntVar(&c.maxEvents, "max-events", 0, "maximal number of events of user, 0 means unlimited")
+	flag.IntVar(&c.maxEventsPerDay, "max-events-per-day", 0, "maximal number of events of user in a day, 0 means unlimited")
+	flag.IntVar(&c.retentionMonths, "retention-months", 0, "events older than this number of months are purged, 0 keeps events forever")
+	flag.StringVar(&c.retentionArchive, "retention-archive", "", "file to append purged events to as JSON lines, empty means events are discarded")
+	flag.DurationVar(&c.retentionInterval, "retention-interval", time.Hour, "interval between purges of old events")
+	flag.StringVar(&c.digestSubscriptions, "digest-subscriptions", "", "JSON file with subscriptions to agenda digests, empty disables delivery")
+	flag.DurationVar(&c.digestInterval, "digest-interval", time.Minute, "interval between checks of due agenda digests")
+	flag.StringVar(&c.smtpAddr, "smtp-addr", "localhost:25", "address of SMTP server delivering agenda digests")
+	flag.StringVar(&c.smtpFrom, "smtp-from", "calendar@localhost", "sender address of agenda digests")
+	flag.StringVar(&c.smtpUser, "smtp-user", "", "user name for SMTP authentication, empty disables authentication")
+	flag.StringVar(&c.smtpPassword, "smtp-password", "", "password for SMTP authentication")
+	flag.StringVar(&c.attachmentsDir, "attachments-dir", "attachments", "directory with files attached to events")
+	flag.Int64Var(&c.maxAttachmentSize, "max-attachment-size", 10<<20, "maximal size of attached file in bytes, 0 means unlimited")
+	flag.Int64Var(&c.maxEventAttachments, "max-event-attachments-size", 50<<20, "maximal total size of files attached to an event in bytes, 0 means unlimited")
+	flag.IntVar(&c.maxAttachments, "max-attachments", 20, "maximal number of files attached to an event, 0 means unlimited")
+	flag.StringVar(&c.tlsCert, "tls-cert", "", "PEM file with certificate chain of the server, enables HTTPS together with -tls-key")
+	flag.StringVar(&c.tlsKey, "tls-key", "", "PEM file with private key of the server")
+	flag.StringVar(&c.tlsClientCA, "tls-client-ca", "", "PEM file with certificate authorities of client certificates")
+	flag.StringVar(&c.tlsClientAuth, "tls-client-auth", "none", "client certificates policy: none, optional or require")
+	flag.StringVar(&c.tlsIdentities, "tls-identities", "", "JSON file mapping subjects of client certificates to user ids")
+	flag.StringVar(&c.tenantsFile, "tenants", "", "JSON file with organisations, their admins and members, empty means no organisations")
+	flag.StringVar(&c.webhooksFile, "webhooks-file", "webhooks.json", "JSON file with webhooks of users and their pending deliveries")
+	flag.IntVar(&c.webhook.MaxAttempts, "webhook-max-attempts", webhook.DefaultConfig.MaxAttempts, "number of failed attempts after which webhook delivery is dead-lettered")
+	flag.DurationVar(&c.webhook.Backoff, "webhook-backoff", webhook.DefaultConfig.Backoff, "delay before the first retry of webhook delivery, doubled after every failure")
+	flag.DurationVar(&c.webhook.MaxBackoff, "webhook-max-backoff", webhook.DefaultConfig.MaxBackoff, "maximal delay between retries of webhook delivery")
+	flag.DurationVar(&c.webhook.Timeout, "webhook-timeout", webhook.DefaultConfig.Timeout, "timeout of request to webhook")
+	flag.StringVar(&c.redirectAddr, "http-redirect-addr", "", "address of plain HTTP listener redirecting to HTTPS, empty disables it")
+	flag.StringVar(&c.adminAddr, "admin-addr", "", "address of admin listener with probes, runtime stats and pprof, empty disables it")
+	flag.DurationVar(&c.healthTimeout, "health-timeout", 2*time.Second, "timeout of health checks of readiness probe")
+	flag.DurationVar(&c.drainDelay, "drain-delay", 5*time.Second, "time between failing readiness probe and shutdown of listeners")
+	flag.BoolVar(&c.version, "version", false, "print build info and exit")
+	flag.Parse()
+	return c
+}
+
 func main() {
-	repo := memory.New()
+	c := parseFlags()
+	build := health.ReadBuild()
+	if c.version {
+		json.NewEncoder(os.Stdout).Encode(build)
+		return
+	}
+	log.Printf("calendar %s %s, %s", build.Version, build.Revision, build.GoVersion)
+
+	var servers []*http.Server
+	repo, peer, stopRepo, err := newRepository(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopRepo()
+	if peer != nil {
+		servers = append(servers, peer)
+		serve(peer)
+	}
+	// holidays of users are kept with events, so they are replicated the same way
+	cal := calendar.NewRegistry(c.country, repo)
+	if err := cal.LoadDir(c.calendars); err != nil {
+		log.Fatal(err)
+	}
 	ctrl := event.New(repo)
-	h := httphandler.New(ctrl)
-	m := http.NewServeMux()
-	m.Handle("/create_event", h.Post(http.HandlerFunc(h.PostCreateEvent)))
-	m.Handle("/update_event", h.Post(http.HandlerFunc(h.PostUpdateEvent)))
-	m.Handle("/delete_event", h.Post(http.HandlerFunc(h.PostDeleteEvent)))
-	m.Handle("/events_for_day", h.Get(http.HandlerFunc(h.GetEventsForDay)))
-	m.Handle("/events_for_week", h.Get(http.HandlerFunc(h.GetEventsForWeek)))
-	m.Handle("/events_for_month", h.Get(http.HandlerFunc(h.GetEventsForMonth)))
-	s := http.Server{Handler: h.Log(m), Addr: ":8080"}
-	go func() {
-		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	ctrl.SetWorkingDays(cal)
+	ctrl.SetQuota(event.Quota{MaxEvents: c.maxEvents, MaxEventsPerDay: c.maxEventsPerDay})
+	stopJanitor, err := startJanitor(c, ctrl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopJanitor()
+	// tasks are kept by every node separately, they are not replicated
+	tasks := task.New(memory.NewTaskRepository())
+	attachments, err := newAttachments(c, ctrl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhooks, err := webhook.New(ctrl, c.webhooksFile, c.webhook)
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhooks.Start()
+	defer webhooks.Stop()
+	var tenants *tenant.Directory
+	if c.tenantsFile != "" {
+		if tenants, err = tenant.Load(c.tenantsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	h := httphandler.New(ctrl, tasks, attachments, webhooks, cal, tenants)
+	checker := health.New(c.healthTimeout)
+	checker.Add("repository", repo.Health)
+	stopDigest, err := startDigest(c, ctrl, tasks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopDigest()
+	m := routes(h, ctrl, tenants, checker)
+	var api http.Handler = h.Timeout(c.timeout, m)
+	var identities *identity.Registry
+	if c.tlsIdentities != "" {
+		if identities, err = identity.Load(c.tlsIdentities); err != nil {
+			log.Fatal(err)
+		}
+		api = identities.Middleware(h.Timeout(c.timeout, h.Authorize(m)))
+	}
+	s := &http.Server{Handler: h.Log(api), Addr: c.addr}
+	var reloader *tlsserver.Reloader
+	if s.TLSConfig, reloader, err = newTLSConfig(c); err != nil {
+		log.Fatal(err)
+	}
+	servers = append(servers, s)
+	serve(s)
+	if c.redirectAddr != "" {
+		if reloader == nil {
+			log.Fatal("-http-redirect-addr requires -tls-cert and -tls-key")
+		}
+		_, port, err := net.SplitHostPort(c.addr)
+		if err != nil {
 			log.Fatal(err)
 		}
+		redirect := &http.Server{Handler: tlsserver.Redirect(port), Addr: c.redirectAddr, ReadHeaderTimeout: c.timeout}
+		servers = append(servers, redirect)
+		serve(redirect)
+	}
+	if c.adminAddr != "" {
+		admin := &http.Server{Handler: checker.AdminHandler(), Addr: c.adminAddr, ReadHeaderTimeout: c.timeout}
+		servers = append(servers, admin)
+		serve(admin)
+	}
+	sigHup := make(chan os.Signal, 1)
+	signal.Notify(sigHup, syscall.SIGHUP)
+	go func() {
+		for range sigHup {
+			reload(reloader, identities, tenants)
+		}
 	}()
 	sigTerm := make(chan os.Signal, 1)
 	signal.Notify(sigTerm, syscall.SIGINT, syscall.SIGTERM)
 	<-sigTerm
-	s.Shutdown(context.Background())
+	// load balancers see failing readiness and stop sending requests before listeners are closed
+	checker.Drain()
+	log.Printf("draining for %v", c.drainDelay)
+	select {
+	case <-time.After(c.drainDelay):
+	case <-sigTerm:
+	}
+	for _, s := range servers {
+		s.Shutdown(context.Background())
+	}
+}
+
+// startJanitor sets retention policy of ctrl and starts purging old events if -retention-months is set,
+// returned function stops purging and closes archive
+func startJanitor(c *config, ctrl *event.Controller) (func(), error) {
+	if c.retentionMonths <= 0 {
+		return func() {}, nil
+	}
+	retention := event.Retention{Months: c.retentionMonths}
+	closeArchive := func() error { return nil }
+	if c.retentionArchive != "" {
+		f, err := os.OpenFile(c.retentionArchive, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		retention.Archive = event.NewJSONArchive(f)
+		closeArchive = f.Close
+	}
+	ctrl.SetRetention(retention)
+	janitor := event.NewJanitor(ctrl, c.retentionInterval)
+	janitor.Start()
+	return func() {
+		janitor.Stop()
+		closeArchive()
+	}, nil
+}
+
+// newAttachments creates controller of files attached to events and removes blobs left from deleted events
+func newAttachments(c *config, ctrl *event.Controller) (*attachment.Controller, error) {
+	blobs, err := blob.New(c.attachmentsDir)
+	if err != nil {
+		return nil, err
+	}
+	limits := attachment.Limits{MaxFileSize: c.maxAttachmentSize, MaxEventSize: c.maxEventAttachments, MaxFiles: c.maxAttachments}
+	attachments, err := attachment.New(ctrl, blobs, filepath.Join(c.attachmentsDir, "index.json"), limits)
+	if err != nil {
+		return nil, err
+	}
+	// events could be deleted while the server was down
+	if n, err := attachments.Collect(context.Background()); err != nil {
+		log.Printf("collecting attachments: %v", err)
+	} else if n > 0 {
+		log.Printf("removed %d unused attachment blobs", n)
+	}
+	return attachments, nil
+}
+
+// startDigest starts delivery of agenda digests by email if -digest-subscriptions is set,
+// returned function stops delivery
+func startDigest(c *config, ctrl *event.Controller, tasks *task.Controller) (func(), error) {
+	if c.digestSubscriptions == "" {
+		return func() {}, nil
+	}
+	subs, err := digest.LoadSubscriptions(c.digestSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+	sender := digest.SMTPSender{Addr: c.smtpAddr}
+	if c.smtpUser != "" {
+		host, _, _ := net.SplitHostPort(c.smtpAddr)
+		sender.Auth = smtp.PlainAuth("", c.smtpUser, c.smtpPassword, host)
+	}
+	scheduler, err := digest.NewScheduler(digest.NewBuilder(ctrl, tasks), sender, c.smtpFrom, subs, c.digestInterval)
+	if err != nil {
+		return nil, err
+	}
+	scheduler.Start()
+	return scheduler.Stop, nil
 }
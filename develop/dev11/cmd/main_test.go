@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dev11/internal/blob"
+	"dev11/internal/calendar"
+	"dev11/internal/controller/attachment"
+	"dev11/internal/controller/event"
+	"dev11/internal/controller/task"
+	"dev11/internal/controller/webhook"
+	httphandler "dev11/internal/handler/http"
+	"dev11/internal/health"
+	"dev11/internal/identity"
+	"dev11/internal/openapi"
+	"dev11/internal/repository/memory"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const form = "application/x-www-form-urlencoded"
+
+const ics = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\n" +
+	"UID:openapi-test\r\nDTSTAMP:20240502T091500Z\r\nSUMMARY:Standup\r\n" +
+	"DTSTART:20240515T090000\r\nDTEND:20240515T091500\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+// newAPI returns handler of the API built like in main with repositories in memory and organisations of tenants
+func newAPI(t *testing.T, tenants *tenant.Directory) *mux {
+	t.Helper()
+	repo := memory.New()
+	cal := calendar.NewRegistry("RU", repo)
+	if err := cal.LoadDir("../calendars"); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := event.New(repo)
+	ctrl.SetWorkingDays(cal)
+	dir := t.TempDir()
+	blobs, err := blob.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := attachment.New(ctrl, blobs, filepath.Join(dir, "index.json"), attachment.Limits{MaxFileSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := webhook.New(ctrl, filepath.Join(dir, "webhooks.json"), webhook.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := httphandler.New(ctrl, task.New(memory.NewTaskRepository()), attachments, webhooks, cal, tenants)
+	checker := health.New(time.Second)
+	checker.Add("repository", repo.Health)
+	return routes(h, ctrl, tenants, checker)
+}
+
+func multipartBody(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for name, content := range files {
+		part, err := w.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	w.Close()
+	return w.FormDataContentType(), buf.String()
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := openapi.NewValidator(doc)
+	m := newAPI(t, nil)
+	h := httphandler.New(nil, nil, nil, nil, nil, nil)
+	var problems []string
+	api := v.Middleware(h.Timeout(time.Second, m), func(req *http.Request, err error) {
+		problems = append(problems, req.Method+" "+req.URL.String()+": "+err.Error())
+	})
+
+	upload, uploadBody := multipartBody(t, map[string]string{"notes.txt": "hello"})
+	tooLarge, tooLargeBody := multipartBody(t, map[string]string{"large.txt": strings.Repeat("x", 17)})
+	// invalid marks requests violating the document on purpose, their responses are validated anyway
+	tests := []struct {
+		method, target, contentType, body string
+		header                            map[string]string
+		code                              int
+		invalid                           bool
+		// save names id of created object for {name} placeholders of following requests
+		save string
+	}{
+		{method: "POST", target: "/create_webhook", contentType: form, body: "user_id=1&url=https://example.com/hook&events=event.created,event.deleted",
+			code: 201, save: "webhook"},
+		{method: "POST", target: "/create_webhook", contentType: form, body: "user_id=1&url=example.com", code: 400},
+		{method: "POST", target: "/create_webhook", contentType: form, body: "user_id=1&url=https://example.com/hook&events=task.created", code: 400},
+		{method: "POST", target: "/create_event", contentType: form, code: 201,
+			body: "user_id=1&title=Planning&date=2024-05-15&time=10:00&duration=90&category=Work&tags=q3,plan&priority=2&location=Room+4", save: "event"},
+		{method: "POST", target: "/create_event", contentType: form, code: 201,
+			body: "user_id=1&title=Report&working_day=3&month=2024-05&color=%23ff0000", save: "report"},
+		{method: "POST", target: "/create_event", contentType: form, body: "user_id=1&date=2024-05-15", code: 400, invalid: true},
+		{method: "POST", target: "/create_event", contentType: form, body: "user_id=1&title=x&date=2024-05-15&priority=7", code: 400, invalid: true},
+		{method: "GET", target: "/create_event", code: 405, invalid: true},
+		{method: "POST", target: "/update_event", contentType: form, body: "user_id=1&id={event}&title=Planning+Q3&date=2024-05-15", code: 200},
+		{method: "POST", target: "/update_event", contentType: form, body: "user_id=1&id=99&title=x&date=2024-05-15", code: 404},
+		{method: "POST", target: "/quick_add", contentType: form, body: "user_id=1&text=lunch+on+2024-05-16+13:00+for+1h", code: 201},
+		{method: "POST", target: "/quick_add", contentType: form, body: "user_id=1", code: 400, invalid: true},
+		{method: "POST", target: "/create_holiday", contentType: form, body: "user_id=1&date=2024-05-17&name=Vacation", code: 201},
+		{method: "POST", target: "/delete_holiday", contentType: form, body: "user_id=1&date=2024-05-17", code: 200},
+		{method: "POST", target: "/delete_holiday", contentType: form, body: "user_id=1&date=2024-05-17", code: 404},
+		{method: "POST", target: "/create_task", contentType: form, body: "user_id=1&title=Send+report&due=2024-05-10&recurrence=weekly", code: 201, save: "weekly"},
+		{method: "POST", target: "/create_task", contentType: form, body: "user_id=1&title=Book+room&due=2024-05-14", code: 201, save: "task"},
+		{method: "POST", target: "/update_task", contentType: form, body: "user_id=1&id={task}&title=Book+room+4&due=2024-05-14&description=big", code: 200},
+		{method: "POST", target: "/complete_task", contentType: form, body: "user_id=1&id={weekly}", code: 200},
+		{method: "POST", target: "/complete_task", contentType: form, body: "user_id=1&id={task}", code: 200},
+		{method: "POST", target: "/reopen_task", contentType: form, body: "user_id=1&id={task}", code: 200},
+		{method: "POST", target: "/reopen_task", contentType: form, body: "user_id=1&id=99", code: 404},
+		{method: "GET", target: "/tasks/overdue?user_id=1&date=2024-05-20", code: 200},
+		{method: "GET", target: "/events_for_day?user_id=1&date=2024-05-15", code: 200},
+		{method: "GET", target: "/events_for_day?user_id=1&date=15.05.2024", code: 400, invalid: true},
+		{method: "GET", target: "/events_for_day?user_id=1&date=2024-05-15&country=XX", code: 400},
+		{method: "GET", target: "/events_for_day?user_id=2&date=2024-05-15", code: 404},
+		{method: "GET", target: "/events_for_week?user_id=1&date=2024-05-13&tag=q3", code: 200},
+		{method: "GET", target: "/events_for_month?user_id=1&date=2024-05-01&category=work", code: 200},
+		{method: "GET", target: "/events_for_month?user_id=1&date=2024-05-01&page=2", code: 200, invalid: true},
+		{method: "GET", target: "/events/search?user_id=1&q=planning&limit=5", code: 200},
+		{method: "GET", target: "/events/search?user_id=1&q=planning&limit=0", code: 400, invalid: true},
+		{method: "GET", target: "/usage?user_id=1", code: 200},
+		{method: "GET", target: "/agenda?user_id=1&date=2024-05-13&period=weekly&format=markdown", code: 200},
+		{method: "GET", target: "/agenda?user_id=1&date=2024-05-15&format=html&tz=Europe/Moscow", code: 200},
+		{method: "GET", target: "/agenda?user_id=1&period=yearly", code: 400, invalid: true},
+		{method: "POST", target: "/upload_attachments?user_id=1&event_id={event}", contentType: upload, body: uploadBody, code: 201, save: "attachment"},
+		{method: "POST", target: "/upload_attachments?user_id=1&event_id={event}", contentType: tooLarge, body: tooLargeBody, code: 413},
+		{method: "POST", target: "/upload_attachments?user_id=1&event_id=99", contentType: upload, body: uploadBody, code: 404},
+		{method: "GET", target: "/attachments?user_id=1&event_id={event}", code: 200},
+		{method: "GET", target: "/attachments/download?user_id=1&id={attachment}", code: 200},
+		{method: "GET", target: "/attachments/download?user_id=1&id={attachment}", header: map[string]string{"Range": "bytes=0-1"}, code: 206},
+		{method: "GET", target: "/attachments/download?user_id=1&id={attachment}", header: map[string]string{"Range": "bytes=10-"}, code: 416},
+		{method: "GET", target: "/attachments/download?user_id=1&id=99", code: 404},
+		{method: "POST", target: "/delete_attachment", contentType: form, body: "user_id=1&id={attachment}", code: 200},
+		{method: "GET", target: "/webhooks?user_id=1", code: 200},
+		{method: "GET", target: "/webhooks/deliveries?user_id=1&id={webhook}&limit=10", code: 200},
+		{method: "GET", target: "/webhooks/deliveries?user_id=2&id={webhook}", code: 404},
+		{method: "POST", target: "/delete_webhook", contentType: form, body: "user_id=1&id={webhook}", code: 200},
+		{method: "POST", target: "/delete_webhook", contentType: form, body: "user_id=1&id={webhook}", code: 404},
+		{method: "OPTIONS", target: "/caldav/1/", code: 200},
+		{method: "PROPFIND", target: "/caldav/1/", header: map[string]string{"Depth": "0"}, code: 207},
+		{method: "OPTIONS", target: "/caldav/1/events/", code: 200},
+		{method: "GET", target: "/caldav/1/events/", code: 200},
+		{method: "OPTIONS", target: "/caldav/1/events/openapi-test.ics", code: 200},
+		{method: "PUT", target: "/caldav/1/events/openapi-test.ics", contentType: "text/calendar", body: ics, code: 201},
+		{method: "PUT", target: "/caldav/1/events/openapi-test.ics", contentType: "text/calendar", body: ics,
+			header: map[string]string{"If-None-Match": "*"}, code: 412},
+		{method: "PUT", target: "/caldav/1/events/openapi-test.ics", contentType: "text/calendar", body: ics, code: 204},
+		{method: "PUT", target: "/caldav/1/events/other.ics", contentType: "text/calendar", body: ics, code: 400},
+		{method: "GET", target: "/caldav/1/events/openapi-test.ics", code: 200},
+		{method: "HEAD", target: "/caldav/1/events/openapi-test.ics", code: 200},
+		{method: "DELETE", target: "/caldav/1/events/openapi-test.ics", code: 204},
+		{method: "DELETE", target: "/caldav/1/events/openapi-test.ics", code: 404},
+		{method: "GET", target: "/caldav/1/events/openapi-test.ics", code: 404},
+		{method: "POST", target: "/delete_task", contentType: form, body: "user_id=1&id={task}", code: 200},
+		{method: "POST", target: "/delete_task", contentType: form, body: "user_id=1&id={task}", code: 404},
+		{method: "POST", target: "/delete_event", contentType: form, body: "user_id=1&id={report}", code: 200},
+		{method: "POST", target: "/delete_event", contentType: form, body: "user_id=1&id={report}", code: 404},
+		{method: "POST", target: "/delete_event", contentType: form, body: "id={report}", code: 400, invalid: true},
+		{method: "GET", target: "/organisation", code: 404},
+		{method: "GET", target: "/openapi.json", code: 200},
+		{method: "GET", target: "/healthz", code: 200},
+		{method: "GET", target: "/readyz", code: 200},
+		{method: "POST", target: "/readyz", code: 405, invalid: true},
+		{method: "GET", target: "/", code: 302},
+		{method: "GET", target: "/ui/", code: 200},
+		{method: "GET", target: "/ui/app.js", code: 200},
+		{method: "GET", target: "/ui/missing.js", code: 404},
+		{method: "GET", target: "/ui/index.html", code: 301},
+		{method: "GET", target: "/missing", code: 404, invalid: true},
+		// RPCs of cluster are served only by -raft-addr listener
+		{method: "POST", target: "/raft/append_entries", contentType: "application/json", body: "{}", code: 404, invalid: true},
+	}
+	ids := map[string]string{}
+	for _, v := range tests {
+		for key, id := range ids {
+			v.target = strings.ReplaceAll(v.target, "{"+key+"}", id)
+			v.body = strings.ReplaceAll(v.body, "{"+key+"}", id)
+		}
+		name := v.method + " " + v.target
+		problems = nil
+		req := httptest.NewRequest(v.method, v.target, strings.NewReader(v.body))
+		if v.contentType != "" {
+			req.Header.Set("Content-Type", v.contentType)
+		}
+		for key, value := range v.header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		if w.Code != v.code {
+			t.Errorf("%s: expected: %d, got: %d %s", name, v.code, w.Code, w.Body.String())
+		}
+		var requestProblems bool
+		for _, p := range problems {
+			if strings.HasPrefix(p, name+": request:") {
+				requestProblems = true
+			}
+			if !v.invalid || !strings.HasPrefix(p, name+": request:") {
+				t.Errorf("unexpected problem: %s", p)
+			}
+		}
+		if v.invalid && !requestProblems {
+			t.Errorf("%s: expected: request problems, got: none", name)
+		}
+		if v.save != "" {
+			ids[v.save] = savedID(t, w.Body.Bytes())
+		}
+	}
+	if unused := v.Unused(); len(unused) > 0 {
+		t.Errorf("expected: all operations requested, got unused: %v", unused)
+	}
+	for _, pattern := range m.patterns {
+		if !documented(doc, pattern) {
+			t.Errorf("expected: pattern %s documented, got: none", pattern)
+		}
+	}
+}
+
+// documented reports whether the document has a path served by pattern of http.ServeMux,
+// patterns ending with slash serve subtrees
+func documented(doc *openapi.Document, pattern string) bool {
+	for path := range doc.Paths {
+		if path == pattern || pattern != "/" && strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// savedID returns id of object created by request from its response,
+// result is either the id, the created object or an array of created objects
+func savedID(t *testing.T, body []byte) string {
+	t.Helper()
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	var created []model.Attachment
+	if err := json.Unmarshal(resp.Result, &created); err == nil && len(created) > 0 {
+		return strconv.FormatUint(created[0].ID, 10)
+	}
+	var w model.Webhook
+	if err := json.Unmarshal(resp.Result, &w); err == nil && w.ID != 0 {
+		return strconv.FormatUint(w.ID, 10)
+	}
+	return string(resp.Result)
+}
+
+func TestTenants(t *testing.T) {
+	dir := t.TempDir()
+	organisations := `[{"id": 1, "name": "Acme", "admins": [1], "members": [2]}, {"id": 2, "name": "Globex", "members": [1]}]`
+	if err := os.WriteFile(filepath.Join(dir, "tenants.json"), []byte(organisations), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	subjects := `{"CN=alice,O=Acme": {"tenant": 1, "user": 1}, "CN=bob,O=Acme": {"tenant": 1, "user": 2},` +
+		` "CN=carol,O=Globex": {"tenant": 2, "user": 1}, "CN=dave": 1}`
+	if err := os.WriteFile(filepath.Join(dir, "identities.json"), []byte(subjects), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := tenant.Load(filepath.Join(dir, "tenants.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, err := identity.Load(filepath.Join(dir, "identities.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := httphandler.New(nil, nil, nil, nil, nil, tenants)
+	var problems []string
+	api := openapi.NewValidator(doc).Middleware(identities.Middleware(h.Timeout(time.Second, h.Authorize(newAPI(t, tenants)))),
+		func(req *http.Request, err error) {
+			problems = append(problems, req.Method+" "+req.URL.String()+": "+err.Error())
+		})
+
+	alice := &pkix.Name{CommonName: "alice", Organization: []string{"Acme"}}
+	bob := &pkix.Name{CommonName: "bob", Organization: []string{"Acme"}}
+	carol := &pkix.Name{CommonName: "carol", Organization: []string{"Globex"}}
+	dave := &pkix.Name{CommonName: "dave"}
+	// alice is admin of Acme and bob is its member, carol of Globex and dave of default tenant have the same user id as alice
+	tests := []struct {
+		subject                           *pkix.Name
+		method, target, contentType, body string
+		code                              int
+		contains, excludes                []string
+		save                              string
+	}{
+		{subject: alice, method: "POST", target: "/create_event", contentType: form, body: "user_id=1&title=Acme+board&date=2024-05-15", code: 201},
+		{subject: bob, method: "POST", target: "/create_event", contentType: form, body: "user_id=2&title=Acme+roadmap&date=2024-05-15", code: 201, save: "roadmap"},
+		{subject: carol, method: "POST", target: "/create_event", contentType: form, body: "user_id=1&title=Globex+board&date=2024-05-15", code: 201},
+		{subject: carol, method: "GET", target: "/events_for_day?user_id=1&date=2024-05-15", code: 200, contains: []string{"Globex board"}, excludes: []string{"Acme"}},
+		{subject: alice, method: "GET", target: "/events_for_day?user_id=1&date=2024-05-15", code: 200, contains: []string{"Acme board"}, excludes: []string{"Globex", "roadmap"}},
+		{subject: dave, method: "GET", target: "/events_for_day?user_id=1&date=2024-05-15", code: 404},
+		{subject: nil, method: "GET", target: "/events_for_day?user_id=1&date=2024-05-15", code: 404},
+		{subject: carol, method: "GET", target: "/events/search?user_id=1&q=board", code: 200, contains: []string{"Globex"}, excludes: []string{"Acme"}},
+		{subject: carol, method: "GET", target: "/caldav/1/events/", code: 200, contains: []string{"Globex board"}, excludes: []string{"Acme"}},
+		{subject: carol, method: "GET", target: "/events_for_day?user_id=2&date=2024-05-15", code: 403},
+		// admin reads calendars of members but doesn't change them
+		{subject: alice, method: "GET", target: "/events_for_week?user_id=2&date=2024-05-13", code: 200, contains: []string{"Acme roadmap"}},
+		{subject: alice, method: "GET", target: "/caldav/2/events/", code: 200, contains: []string{"Acme roadmap"}},
+		{subject: alice, method: "POST", target: "/delete_event", contentType: form, body: "user_id=2&id={roadmap}", code: 403},
+		{subject: alice, method: "DELETE", target: "/caldav/2/events/{roadmap}.ics", code: 403},
+		{subject: alice, method: "GET", target: "/tasks/overdue?user_id=2", code: 403},
+		{subject: bob, method: "GET", target: "/events_for_day?user_id=1&date=2024-05-15", code: 403},
+		{subject: bob, method: "GET", target: "/caldav/1/events/", code: 403},
+		{subject: alice, method: "GET", target: "/organisation", code: 200, contains: []string{`"name":"Acme"`, `"role":"admin"`, `"members":[1,2]`}},
+		{subject: bob, method: "GET", target: "/organisation", code: 200, contains: []string{`"role":"member"`}, excludes: []string{"members"}},
+		{subject: carol, method: "GET", target: "/organisation", code: 200, contains: []string{`"name":"Globex"`}},
+		{subject: dave, method: "GET", target: "/organisation", code: 404},
+		{subject: nil, method: "GET", target: "/organisation", code: 404},
+		{subject: bob, method: "POST", target: "/delete_event", contentType: form, body: "user_id=2&id={roadmap}", code: 200},
+	}
+	ids := map[string]string{}
+	for i, v := range tests {
+		for key, id := range ids {
+			v.target = strings.ReplaceAll(v.target, "{"+key+"}", id)
+			v.body = strings.ReplaceAll(v.body, "{"+key+"}", id)
+		}
+		problems = nil
+		req := httptest.NewRequest(v.method, v.target, strings.NewReader(v.body))
+		if v.contentType != "" {
+			req.Header.Set("Content-Type", v.contentType)
+		}
+		if v.subject != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: *v.subject}}}}
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		name := fmt.Sprintf("%d: %s %s", i, v.method, v.target)
+		if w.Code != v.code {
+			t.Errorf("%s: expected: %d, got: %d %s", name, v.code, w.Code, w.Body.String())
+		}
+		for _, s := range v.contains {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: expected: %q in response, got: %s", name, s, w.Body.String())
+			}
+		}
+		for _, s := range v.excludes {
+			if strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: expected: no %q in response, got: %s", name, s, w.Body.String())
+			}
+		}
+		for _, p := range problems {
+			t.Errorf("unexpected problem: %s", p)
+		}
+		if v.save != "" {
+			ids[v.save] = savedID(t, w.Body.Bytes())
+		}
+	}
+}
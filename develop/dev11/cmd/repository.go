@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"dev11/internal/calendar"
+	"dev11/internal/raft"
+	"dev11/internal/repository"
+	"dev11/internal/repository/cache"
+	"dev11/internal/repository/memory"
+	"dev11/internal/repository/replicated"
+	"dev11/pkg/model"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventRepository is a storage of events used by controller
+type eventRepository interface {
+	Create(ctx context.Context, e *model.Event) (uint64, error)
+	Update(ctx context.Context, e *model.Event) error
+	Delete(ctx context.Context, userID, id uint64) error
+	Get(ctx context.Context, userID, id uint64) (*model.Event, error)
+	GetAll(ctx context.Context, userID uint64) ([]*model.Event, error)
+	GetBefore(ctx context.Context, t time.Time) ([]*model.Event, error)
+	GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error)
+	Upsert(ctx context.Context, e *model.Event) (bool, error)
+	Changes(ctx context.Context, userID, since uint64) ([]repository.Change, uint64, error)
+	Seq() uint64
+	LastChange(ctx context.Context, userID uint64) uint64
+	Health(ctx context.Context) error
+	calendar.HolidayStore
+}
+
+// newRepository creates cached repository of a single node, or replicated one if -raft-id is set.
+// Replicated repository comes with server of RPCs of cluster which is not started yet.
+// Returned function stops repository.
+func newRepository(c *config) (eventRepository, *http.Server, func(), error) {
+	if c.raftID == 0 {
+		r := cache.New(memory.New(), c.cacheSize)
+		return r, nil, func() { log.Printf("cache stats: %+v", r.Stats()) }, nil
+	}
+	peers, err := parsePeers(c.raftPeers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if c.raftAddr == "" || c.raftSecretFile == "" {
+		return nil, nil, nil, errors.New("-raft-id requires -raft-addr and -raft-secret-file")
+	}
+	secret, err := readSecret(c.raftSecretFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// writes are applied to local replica bypassing cache, so replicated repository is not cached
+	r, err := replicated.New(raft.Config{ID: c.raftID, Peers: peers, Dir: c.raftDir, Secret: secret,
+		SnapshotEntries: c.raftSnapshotEntries}, memory.New(), c.maxStale)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r.Start()
+	// RPCs of cluster are served apart from API: they are authorized by secret of cluster, not by users,
+	// and are neither logged nor limited by request timeout
+	peer := &http.Server{Handler: r.Node().Handler(), Addr: c.raftAddr, ReadHeaderTimeout: c.timeout}
+	return r, peer, r.Stop, nil
+}
+
+// readSecret reads secret of cluster from file, surrounding whitespace is ignored
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s: secret of cluster is empty", path)
+	}
+	return secret, nil
+}
+
+// parsePeers parses comma separated list of nodes in id=url form
+func parsePeers(s string) (map[uint64]string, error) {
+	peers := map[uint64]string{}
+	for _, peer := range strings.Split(s, ",") {
+		if peer == "" {
+			continue
+		}
+		idValue, addr, ok := strings.Cut(peer, "=")
+		id, err := strconv.ParseUint(idValue, 10, 64)
+		if !ok || err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid peer %q", peer)
+		}
+		peers[id] = strings.TrimSuffix(addr, "/")
+	}
+	return peers, nil
+}
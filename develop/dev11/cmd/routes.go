@@ -0,0 +1,72 @@
+package main
+
+import (
+	"dev11/internal/controller/event"
+	"dev11/internal/handler/caldav"
+	httphandler "dev11/internal/handler/http"
+	"dev11/internal/handler/web"
+	"dev11/internal/health"
+	"dev11/internal/openapi"
+	"dev11/internal/tenant"
+	"net/http"
+)
+
+// mux is http.ServeMux remembering registered patterns, so tests can check that all of them are documented
+type mux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *mux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *mux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// routes registers handlers of the API described by openapi.json, probes of checker are served for load balancers
+func routes(h *httphandler.Handler, ctrl *event.Controller, tenants *tenant.Directory, checker *health.Checker) *mux {
+	m := &mux{ServeMux: http.NewServeMux()}
+	m.Handle("/create_event", h.Post(http.HandlerFunc(h.PostCreateEvent)))
+	m.Handle("/update_event", h.Post(http.HandlerFunc(h.PostUpdateEvent)))
+	m.Handle("/delete_event", h.Post(http.HandlerFunc(h.PostDeleteEvent)))
+	m.Handle("/quick_add", h.Post(http.HandlerFunc(h.PostQuickAdd)))
+	m.Handle("/create_holiday", h.Post(http.HandlerFunc(h.PostCreateHoliday)))
+	m.Handle("/delete_holiday", h.Post(http.HandlerFunc(h.PostDeleteHoliday)))
+	m.Handle("/events_for_day", h.Get(http.HandlerFunc(h.GetEventsForDay)))
+	m.Handle("/events_for_week", h.Get(http.HandlerFunc(h.GetEventsForWeek)))
+	m.Handle("/events_for_month", h.Get(http.HandlerFunc(h.GetEventsForMonth)))
+	m.Handle("/events/search", h.Get(http.HandlerFunc(h.GetSearchEvents)))
+	m.Handle("/usage", h.Get(http.HandlerFunc(h.GetUsage)))
+	m.Handle("/agenda", h.Get(http.HandlerFunc(h.GetAgenda)))
+	m.Handle("/create_task", h.Post(http.HandlerFunc(h.PostCreateTask)))
+	m.Handle("/update_task", h.Post(http.HandlerFunc(h.PostUpdateTask)))
+	m.Handle("/delete_task", h.Post(http.HandlerFunc(h.PostDeleteTask)))
+	m.Handle("/complete_task", h.Post(http.HandlerFunc(h.PostCompleteTask)))
+	m.Handle("/reopen_task", h.Post(http.HandlerFunc(h.PostReopenTask)))
+	m.Handle("/tasks/overdue", h.Get(http.HandlerFunc(h.GetOverdueTasks)))
+	m.Handle("/upload_attachments", h.Post(http.HandlerFunc(h.PostUploadAttachments)))
+	m.Handle("/delete_attachment", h.Post(http.HandlerFunc(h.PostDeleteAttachment)))
+	m.Handle("/attachments", h.Get(http.HandlerFunc(h.GetAttachments)))
+	m.Handle("/attachments/download", h.Get(http.HandlerFunc(h.GetDownloadAttachment)))
+	m.Handle("/create_webhook", h.Post(http.HandlerFunc(h.PostCreateWebhook)))
+	m.Handle("/delete_webhook", h.Post(http.HandlerFunc(h.PostDeleteWebhook)))
+	m.Handle("/webhooks", h.Get(http.HandlerFunc(h.GetWebhooks)))
+	m.Handle("/webhooks/deliveries", h.Get(http.HandlerFunc(h.GetWebhookDeliveries)))
+	m.Handle("/organisation", h.Get(http.HandlerFunc(h.GetOrganisation)))
+	m.Handle("/caldav/", caldav.New(ctrl, "/caldav/", tenants))
+	m.Handle("/openapi.json", h.Get(openapi.Handler()))
+	m.Handle("/healthz", h.Get(checker.Live()))
+	m.Handle("/readyz", h.Get(checker.Readyz()))
+	m.Handle("/ui/", h.Get(http.StripPrefix("/ui/", web.Handler())))
+	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" || r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	})
+	return m
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"dev11/internal/identity"
+	"dev11/internal/tenant"
+	"dev11/internal/tlsserver"
+	"log"
+	"net/http"
+)
+
+// newTLSConfig creates config of HTTPS listener with certificates reloaded on SIGHUP,
+// nil config and reloader mean that -tls-cert and -tls-key are not set and API is served over plain HTTP
+func newTLSConfig(c *config) (*tls.Config, *tlsserver.Reloader, error) {
+	if c.tlsCert == "" && c.tlsKey == "" {
+		return nil, nil, nil
+	}
+	clientAuth, err := tlsserver.ParseClientAuth(c.tlsClientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader, err := tlsserver.NewReloader(c.tlsCert, c.tlsKey, c.tlsClientCA)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := reloader.Config(clientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, reloader, nil
+}
+
+// serve runs s in background, over HTTPS if it has TLS config
+func serve(s *http.Server) {
+	go func() {
+		var err error
+		if s.TLSConfig != nil {
+			err = s.ListenAndServeTLS("", "")
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// reload loads certificates, identities of clients and organisations again, previous ones are kept on errors
+func reload(reloader *tlsserver.Reloader, identities *identity.Registry, tenants *tenant.Directory) {
+	if reloader != nil {
+		if err := reloader.Reload(); err != nil {
+			log.Printf("reloading certificates: %v", err)
+		} else {
+			log.Printf("certificates reloaded")
+		}
+	}
+	if identities != nil {
+		if err := identities.Reload(); err != nil {
+			log.Printf("reloading identities: %v", err)
+		} else {
+			log.Printf("identities reloaded")
+		}
+	}
+	if tenants != nil {
+		if err := tenants.Reload(); err != nil {
+			log.Printf("reloading organisations: %v", err)
+		} else {
+			log.Printf("organisations reloaded")
+		}
+	}
+}
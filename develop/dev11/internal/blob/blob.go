@@ -0,0 +1,162 @@
+// Package blob stores immutable files on local disk addressed by SHA-256 of their content,
+// so equal files are stored once.
+//
+// Files are written in two phases: Write copies data to a temporary file computing its hash
+// and Commit moves it to blobs/{first two hex digits of hash}/{hash}.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Errors of Store
+var (
+	ErrNotFound    = errors.New("blob not found")
+	ErrTooLarge    = errors.New("blob is too large")
+	ErrInvalidHash = errors.New("invalid blob hash")
+)
+
+// Store is a content-addressed storage of files in a directory
+type Store struct {
+	dir string
+}
+
+// Pending is a written but not committed blob
+type Pending struct {
+	Hash string
+	Size int64
+	path string
+}
+
+// New creates Store in directory dir and returns pointer to it, temporary files left by previous runs are removed
+func New(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+	if err := os.RemoveAll(s.tmpDir()); err != nil {
+		return nil, err
+	}
+	for _, d := range []string{s.blobsDir(), s.tmpDir()} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Write copies at most limit bytes of r to a temporary file and returns it as Pending blob,
+// ErrTooLarge is returned if r has more data
+func (s *Store) Write(r io.Reader, limit int64) (*Pending, error) {
+	f, err := os.CreateTemp(s.tmpDir(), "blob-")
+	if err != nil {
+		return nil, err
+	}
+	p := &Pending{path: f.Name()}
+	h := sha256.New()
+	if limit < math.MaxInt64 {
+		// one more byte is read to tell data of exactly limit bytes from larger one
+		r = io.LimitReader(r, limit+1)
+	}
+	p.Size, err = io.Copy(io.MultiWriter(f, h), r)
+	if err == nil && p.Size > limit {
+		err = ErrTooLarge
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p.path)
+		return nil, err
+	}
+	p.Hash = hex.EncodeToString(h.Sum(nil))
+	return p, nil
+}
+
+// Commit moves Pending blob to Store, content which is already stored is not written again
+func (s *Store) Commit(p *Pending) error {
+	path := s.path(p.Hash)
+	if _, err := os.Stat(path); err == nil {
+		return os.Remove(p.path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(p.path, path)
+}
+
+// Discard removes Pending blob
+func (s *Store) Discard(p *Pending) error {
+	return os.Remove(p.path)
+}
+
+// Open opens blob for reading
+func (s *Store) Open(hash string) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, ErrInvalidHash
+	}
+	f, err := os.Open(s.path(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes blob from Store
+func (s *Store) Delete(hash string) error {
+	if !validHash(hash) {
+		return ErrInvalidHash
+	}
+	err := os.Remove(s.path(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Hashes returns hashes of all stored blobs
+func (s *Store) Hashes() ([]string, error) {
+	hashes := []string{}
+	err := filepath.WalkDir(s.blobsDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && validHash(d.Name()) {
+			hashes = append(hashes, d.Name())
+		}
+		return nil
+	})
+	return hashes, err
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.blobsDir(), hash[:2], hash)
+}
+
+func (s *Store) blobsDir() string {
+	return filepath.Join(s.dir, "blobs")
+}
+
+func (s *Store) tmpDir() string {
+	return filepath.Join(s.dir, "tmp")
+}
+
+// validHash reports whether hash is a lowercase hex SHA-256, so it can't escape directory of Store
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
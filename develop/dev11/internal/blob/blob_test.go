@@ -0,0 +1,146 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		limit   int64
+		err     error
+	}{
+		"empty":         {content: "", limit: 10},
+		"under limit":   {content: "hello", limit: 10},
+		"exactly limit": {content: "0123456789", limit: 10},
+		"over limit":    {content: "0123456789!", limit: 10, err: ErrTooLarge},
+		"no limit":      {content: "0123456789!", limit: math.MaxInt64},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			s, err := New(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := s.Write(strings.NewReader(v.content), v.limit)
+			if !errors.Is(err, v.err) {
+				t.Fatalf("expected: %v, got: %v", v.err, err)
+			}
+			if tmp, _ := os.ReadDir(s.tmpDir()); len(tmp) != 0 && v.err != nil {
+				t.Errorf("expected: temporary file is removed, got: %d files", len(tmp))
+			}
+			if v.err != nil {
+				return
+			}
+			sum := sha256.Sum256([]byte(v.content))
+			if p.Hash != hex.EncodeToString(sum[:]) || p.Size != int64(len(v.content)) {
+				t.Errorf("expected: %x of %d bytes, got: %s of %d bytes", sum, len(v.content), p.Hash, p.Size)
+			}
+			if err := s.Commit(p); err != nil {
+				t.Fatal(err)
+			}
+			f, err := s.Open(p.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if data, err := io.ReadAll(f); err != nil || string(data) != v.content {
+				t.Errorf("expected: %q, got: %q, %v", v.content, data, err)
+			}
+		})
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		p, err := s.Write(strings.NewReader("same"), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Commit(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := s.Write(strings.NewReader("other"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(p); err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := s.Hashes()
+	if err != nil || len(hashes) != 2 {
+		t.Fatalf("expected: 2 blobs, got: %v, %v", hashes, err)
+	}
+	if tmp, _ := os.ReadDir(s.tmpDir()); len(tmp) != 0 {
+		t.Errorf("expected: no temporary files, got: %d", len(tmp))
+	}
+
+	if err := s.Delete(p.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(p.Hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected: %v, got: %v", ErrNotFound, err)
+	}
+	if err := s.Delete(p.Hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected: %v, got: %v", ErrNotFound, err)
+	}
+	hashes, _ = s.Hashes()
+	sort.Strings(hashes)
+	if len(hashes) != 1 || hashes[0] == p.Hash {
+		t.Errorf("expected: only blob of other content, got: %v", hashes)
+	}
+}
+
+func TestInvalidHash(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{"", "../../secret", strings.Repeat("A", 64), strings.Repeat("a", 63) + "/", strings.Repeat("a", 65)} {
+		if _, err := s.Open(hash); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("%q: expected: %v, got: %v", hash, ErrInvalidHash, err)
+		}
+		if err := s.Delete(hash); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("%q: expected: %v, got: %v", hash, ErrInvalidHash, err)
+		}
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Errorf("expected: file outside of store is kept, got: %v", err)
+	}
+}
+
+func TestNewRemovesTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(strings.NewReader("interrupted upload"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir); err != nil {
+		t.Fatal(err)
+	}
+	if tmp, _ := os.ReadDir(s.tmpDir()); len(tmp) != 0 {
+		t.Errorf("expected: no temporary files, got: %d", len(tmp))
+	}
+}
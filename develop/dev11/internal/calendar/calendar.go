@@ -0,0 +1,139 @@
+package calendar
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Calendar errors
+var (
+	ErrUnknownCountry    = errors.New("unknown country")
+	ErrNoSuchWorkingDay  = errors.New("no such working day in month")
+	ErrHolidayNotFound   = errors.New("holiday not found")
+	ErrInvalidWorkingDay = errors.New("working day number must be positive")
+)
+
+const dateLayout = "2006-01-02"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Day describes a single calendar day: whether it is a working day and whether it is a holiday
+type Day struct {
+	Date    time.Time `json:"date"`
+	Working bool      `json:"working"`
+	Holiday bool      `json:"holiday"`
+	Name    string    `json:"name,omitempty"`
+}
+
+// Calendar is a production calendar of a country.
+// By default saturday and sunday are days off, holidays are days off and
+// transferred working days are working even if they fall on weekend.
+type Calendar struct {
+	Country  string
+	weekend  map[time.Weekday]bool
+	holidays map[time.Time]string
+	workdays map[time.Time]string
+}
+
+// New creates an empty Calendar of given country with saturday and sunday as weekend and returns pointer to it
+func New(country string) *Calendar {
+	return &Calendar{
+		Country:  strings.ToUpper(country),
+		weekend:  map[time.Weekday]bool{time.Saturday: true, time.Sunday: true},
+		holidays: map[time.Time]string{},
+		workdays: map[time.Time]string{},
+	}
+}
+
+// Parse reads a calendar of given country from r.
+//
+// Every non-empty line which is not a comment (starts with #) has one of the forms:
+//
+//	weekend <day> [<day>...]     - days of week which are days off, e.g. "weekend sat sun"
+//	<YYYY-MM-DD> holiday [name]  - a holiday
+//	<YYYY-MM-DD> workday [name]  - a working day transferred to weekend
+func Parse(country string, r io.Reader) (*Calendar, error) {
+	c := New(country)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if fields[0] == "weekend" {
+			c.weekend = map[time.Weekday]bool{}
+			for _, f := range fields[1:] {
+				wd, ok := weekdays[strings.ToLower(f)]
+				if !ok {
+					return nil, fmt.Errorf("%s:%d: unknown day of week %q", country, line, f)
+				}
+				c.weekend[wd] = true
+			}
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected date and kind of day", country, line)
+		}
+		date, err := time.Parse(dateLayout, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid date %q", country, line, fields[0])
+		}
+		name := strings.Join(fields[2:], " ")
+		switch fields[1] {
+		case "holiday":
+			c.holidays[date] = name
+		case "workday":
+			c.workdays[date] = name
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown kind of day %q", country, line, fields[1])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads a calendar from file, country code is taken from the file name without extension
+func Load(path string) (*Calendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	country := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return Parse(country, f)
+}
+
+// Day returns description of given day
+func (c *Calendar) Day(t time.Time) Day {
+	t = truncate(t)
+	if name, ok := c.holidays[t]; ok {
+		return Day{Date: t, Holiday: true, Name: name}
+	}
+	if name, ok := c.workdays[t]; ok {
+		return Day{Date: t, Working: true, Name: name}
+	}
+	return Day{Date: t, Working: !c.weekend[t.Weekday()]}
+}
+
+// truncate drops time of day and location from t, so it can be used as a key of the maps
+func truncate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
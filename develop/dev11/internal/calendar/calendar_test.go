@@ -0,0 +1,90 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = `# test calendar
+weekend sat sun
+
+2023-01-02 holiday New Year
+2023-01-07 holiday
+2023-01-14 workday Transferred day
+`
+
+func date(s string) time.Time {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		err   string
+	}{
+		"valid":               {input: testCalendar},
+		"empty":               {input: ""},
+		"only comments":       {input: "# nothing\n\n   \n"},
+		"custom weekend":      {input: "weekend FRI sat"},
+		"empty weekend":       {input: "weekend"},
+		"unknown weekday":     {input: "weekend sat sunday", err: `xx:1: unknown day of week "sunday"`},
+		"missing kind of day": {input: "\n2023-01-02", err: "xx:2: expected date and kind of day"},
+		"invalid date":        {input: "2023-02-30 holiday", err: `xx:1: invalid date "2023-02-30"`},
+		"unknown kind of day": {input: "2023-01-02 vacation", err: `xx:1: unknown kind of day "vacation"`},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			c, err := Parse("xx", strings.NewReader(v.input))
+			if v.err != "" {
+				if err == nil || err.Error() != v.err {
+					t.Errorf("expected: %s, got: %v", v.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected: %v, got: %v", nil, err)
+			}
+			if c.Country != "XX" {
+				t.Errorf("expected: %s, got: %s", "XX", c.Country)
+			}
+		})
+	}
+}
+
+func TestDay(t *testing.T) {
+	c, err := Parse("ru", strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := Parse("il", strings.NewReader("weekend fri sat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		calendar *Calendar
+		date     time.Time
+		expected Day
+	}{
+		"working day":           {calendar: c, date: date("2023-01-03"), expected: Day{Working: true}},
+		"weekend":               {calendar: c, date: date("2023-01-08")},
+		"holiday":               {calendar: c, date: date("2023-01-02"), expected: Day{Holiday: true, Name: "New Year"}},
+		"holiday on weekend":    {calendar: c, date: date("2023-01-07"), expected: Day{Holiday: true}},
+		"transferred workday":   {calendar: c, date: date("2023-01-14"), expected: Day{Working: true, Name: "Transferred day"}},
+		"time of day ignored":   {calendar: c, date: time.Date(2023, 1, 2, 23, 59, 0, 0, time.FixedZone("X", 3*3600)), expected: Day{Holiday: true, Name: "New Year"}},
+		"custom weekend friday": {calendar: custom, date: date("2023-01-06")},
+		"custom weekend sunday": {calendar: custom, date: date("2023-01-08"), expected: Day{Working: true}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			v.expected.Date = truncate(v.date)
+			if got := v.calendar.Day(v.date); got != v.expected {
+				t.Errorf("expected: %v, got: %v", v.expected, got)
+			}
+		})
+	}
+}
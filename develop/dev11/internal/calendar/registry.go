@@ -0,0 +1,176 @@
+package calendar
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HolidayStore keeps days off defined by users of tenant of context, dates are midnights in UTC.
+// DeleteHoliday returns repository.ErrHolidayNotFound if user has no such day off.
+type HolidayStore interface {
+	AddHoliday(ctx context.Context, userID uint64, date time.Time, name string) error
+	DeleteHoliday(ctx context.Context, userID uint64, date time.Time) error
+	Holidays(ctx context.Context, userID uint64) (map[time.Time]string, error)
+}
+
+// StoreError is an error of HolidayStore returned as is, so it can carry details of the store
+type StoreError struct {
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return "holidays: " + e.Err.Error()
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Registry holds production calendars of countries and takes holidays defined by users from HolidayStore.
+// It's protected from concurrent read/write with sync.RWMutex.
+type Registry struct {
+	m              sync.RWMutex
+	defaultCountry string
+	countries      map[string]*Calendar
+	holidays       HolidayStore
+}
+
+// NewRegistry creates an empty Registry keeping holidays of users in given store and returns pointer to it.
+// defaultCountry is used when country is not specified in request.
+func NewRegistry(defaultCountry string, holidays HolidayStore) *Registry {
+	return &Registry{
+		defaultCountry: strings.ToUpper(defaultCountry),
+		countries:      map[string]*Calendar{},
+		holidays:       holidays,
+	}
+}
+
+// Add puts a calendar to registry replacing the calendar of the same country
+func (r *Registry) Add(c *Calendar) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.countries[c.Country] = c
+}
+
+// LoadDir loads every *.txt file of dir as a calendar of a country.
+// It fails if calendar of default country is not among them, so the server doesn't start answering every request with ErrUnknownCountry.
+func (r *Registry) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		c, err := Load(path)
+		if err != nil {
+			return err
+		}
+		r.Add(c)
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	if _, err := r.calendar(""); err != nil {
+		return fmt.Errorf("%s: calendar of default country %s: %w", dir, r.defaultCountry, err)
+	}
+	return nil
+}
+
+// AddUserHoliday adds a day off defined by user
+func (r *Registry) AddUserHoliday(ctx context.Context, userID uint64, date time.Time, name string) error {
+	if err := r.holidays.AddHoliday(ctx, userID, truncate(date), name); err != nil {
+		return &StoreError{Err: err}
+	}
+	return nil
+}
+
+// DeleteUserHoliday removes a day off defined by user
+func (r *Registry) DeleteUserHoliday(ctx context.Context, userID uint64, date time.Time) error {
+	err := r.holidays.DeleteHoliday(ctx, userID, truncate(date))
+	if errors.Is(err, repository.ErrHolidayNotFound) {
+		return ErrHolidayNotFound
+	}
+	if err != nil {
+		return &StoreError{Err: err}
+	}
+	return nil
+}
+
+// Days returns descriptions of n days starting from given day for user.
+// Empty country means default country of registry.
+func (r *Registry) Days(ctx context.Context, userID uint64, country string, from time.Time, n int) ([]Day, error) {
+	holidays, err := r.userHolidays(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	c, err := r.calendar(country)
+	if err != nil {
+		return nil, err
+	}
+	days := make([]Day, 0, n)
+	for i := 0; i < n; i++ {
+		days = append(days, day(holidays, c, from.AddDate(0, 0, i)))
+	}
+	return days, nil
+}
+
+// NthWorkingDay returns n-th (starting from 1) working day of month for user
+func (r *Registry) NthWorkingDay(ctx context.Context, userID uint64, country string, year int, month time.Month, n int) (time.Time, error) {
+	if n < 1 {
+		return time.Time{}, ErrInvalidWorkingDay
+	}
+	holidays, err := r.userHolidays(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	c, err := r.calendar(country)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for t := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC); t.Month() == month; t = t.AddDate(0, 0, 1) {
+		if day(holidays, c, t).Working {
+			n--
+			if n == 0 {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, ErrNoSuchWorkingDay
+}
+
+func (r *Registry) calendar(country string) (*Calendar, error) {
+	if country == "" {
+		country = r.defaultCountry
+	}
+	c, ok := r.countries[strings.ToUpper(country)]
+	if !ok {
+		return nil, ErrUnknownCountry
+	}
+	return c, nil
+}
+
+// userHolidays returns days off defined by user
+func (r *Registry) userHolidays(ctx context.Context, userID uint64) (map[time.Time]string, error) {
+	holidays, err := r.holidays.Holidays(ctx, userID)
+	if err != nil {
+		return nil, &StoreError{Err: err}
+	}
+	return holidays, nil
+}
+
+// day describes day t of calendar c with holidays of user
+func day(holidays map[time.Time]string, c *Calendar, t time.Time) Day {
+	d := c.Day(t)
+	if name, ok := holidays[d.Date]; ok {
+		d.Working, d.Holiday, d.Name = false, true, name
+	}
+	return d
+}
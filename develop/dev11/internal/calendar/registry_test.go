@@ -0,0 +1,191 @@
+package calendar
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"dev11/internal/repository/memory"
+	"dev11/internal/tenant"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	c, err := Parse("ru", strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry("ru", memory.New())
+	r.Add(c)
+	r.Add(New("us"))
+	return r
+}
+
+func TestLoadDir(t *testing.T) {
+	tests := map[string]struct {
+		files   map[string]string
+		country string
+		fails   bool
+		err     error
+	}{
+		"default loaded":     {files: map[string]string{"ru.txt": testCalendar, "us.txt": ""}, country: "RU"},
+		"lowercase default":  {files: map[string]string{"ru.txt": testCalendar}, country: "ru"},
+		"no calendars":       {files: map[string]string{"readme.md": "calendars"}, country: "RU", fails: true, err: ErrUnknownCountry},
+		"default missing":    {files: map[string]string{"us.txt": ""}, country: "RU", fails: true, err: ErrUnknownCountry},
+		"invalid calendar":   {files: map[string]string{"ru.txt": "2023-01-02 vacation"}, country: "RU", fails: true},
+		"other files ignore": {files: map[string]string{"ru.txt": "", "ru.bak": "invalid"}, country: "RU"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range v.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := NewRegistry(v.country, memory.New()).LoadDir(dir)
+			if (err != nil) != v.fails || v.err != nil && !errors.Is(err, v.err) {
+				t.Errorf("expected: %v, got: %v", v.err, err)
+			}
+		})
+	}
+}
+
+func TestDays(t *testing.T) {
+	r := newTestRegistry(t)
+	tests := map[string]struct {
+		user    uint64
+		country string
+		from    time.Time
+		n       int
+		working []bool
+		err     error
+	}{
+		"default country":   {from: date("2023-01-01"), n: 4, working: []bool{false, false, true, true}},
+		"other country":     {country: "us", from: date("2023-01-01"), n: 3, working: []bool{false, true, true}},
+		"lowercase":         {country: "Ru", from: date("2023-01-13"), n: 3, working: []bool{true, true, false}},
+		"no days":           {from: date("2023-01-01"), n: 0, working: []bool{}},
+		"across year":       {from: date("2022-12-31"), n: 3, working: []bool{false, false, false}},
+		"unknown country":   {country: "de", from: date("2023-01-01"), n: 1, err: ErrUnknownCountry},
+		"untrimmed country": {country: "RU ", from: date("2023-01-01"), n: 1, err: ErrUnknownCountry},
+		"user holiday":      {user: 7, from: date("2023-01-04"), n: 2, working: []bool{false, true}},
+		"other user":        {user: 8, from: date("2023-01-04"), n: 1, working: []bool{true}},
+	}
+	ctx := tenant.NewContext(context.Background(), 1)
+	if err := r.AddUserHoliday(ctx, 7, date("2023-01-04"), "Birthday"); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			days, err := r.Days(ctx, v.user, v.country, v.from, v.n)
+			if !errors.Is(err, v.err) {
+				t.Fatalf("expected: %v, got: %v", v.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if len(days) != len(v.working) {
+				t.Fatalf("expected: %d, got: %d", len(v.working), len(days))
+			}
+			for i, d := range days {
+				if d.Working != v.working[i] || !d.Date.Equal(v.from.AddDate(0, 0, i)) {
+					t.Errorf("expected: %v %v, got: %v %v", v.from.AddDate(0, 0, i), v.working[i], d.Date, d.Working)
+				}
+			}
+		})
+	}
+}
+
+func TestNthWorkingDay(t *testing.T) {
+	r := newTestRegistry(t)
+	ctx := tenant.NewContext(context.Background(), 1)
+	other := tenant.NewContext(context.Background(), 2)
+	if err := r.AddUserHoliday(ctx, 5, date("2023-01-03"), "Day off"); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		ctx      context.Context
+		user     uint64
+		country  string
+		month    time.Month
+		n        int
+		expected string
+		err      error
+	}{
+		"first":                  {ctx: ctx, month: time.January, n: 1, expected: "2023-01-03"},
+		"after transferred":      {ctx: ctx, month: time.January, n: 10, expected: "2023-01-14"},
+		"last":                   {ctx: ctx, month: time.January, n: 22, expected: "2023-01-31"},
+		"beyond month":           {ctx: ctx, month: time.January, n: 23, err: ErrNoSuchWorkingDay},
+		"zero":                   {ctx: ctx, month: time.January, n: 0, err: ErrInvalidWorkingDay},
+		"negative":               {ctx: ctx, month: time.January, n: -1, err: ErrInvalidWorkingDay},
+		"other country":          {ctx: ctx, country: "us", month: time.January, n: 1, expected: "2023-01-02"},
+		"unknown country":        {ctx: ctx, country: "de", month: time.January, n: 1, err: ErrUnknownCountry},
+		"user holiday":           {ctx: ctx, user: 5, month: time.January, n: 1, expected: "2023-01-04"},
+		"holiday of other users": {ctx: other, user: 5, month: time.January, n: 1, expected: "2023-01-03"},
+		"february":               {ctx: ctx, month: time.February, n: 1, expected: "2023-02-01"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			got, err := r.NthWorkingDay(v.ctx, v.user, v.country, 2023, v.month, v.n)
+			if !errors.Is(err, v.err) {
+				t.Fatalf("expected: %v, got: %v", v.err, err)
+			}
+			if err == nil && got.Format(dateLayout) != v.expected {
+				t.Errorf("expected: %s, got: %s", v.expected, got.Format(dateLayout))
+			}
+		})
+	}
+}
+
+func TestDeleteUserHoliday(t *testing.T) {
+	r := newTestRegistry(t)
+	ctx := tenant.NewContext(context.Background(), 1)
+	if err := r.AddUserHoliday(ctx, 1, date("2023-01-10"), "Day off"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteUserHoliday(ctx, 1, date("2023-01-10")); err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err)
+	}
+	if err := r.DeleteUserHoliday(ctx, 1, date("2023-01-10")); !errors.Is(err, ErrHolidayNotFound) {
+		t.Errorf("expected: %v, got: %v", ErrHolidayNotFound, err)
+	}
+}
+
+// failingStore is a HolidayStore which fails every call
+type failingStore struct{}
+
+func (failingStore) AddHoliday(ctx context.Context, userID uint64, date time.Time, name string) error {
+	return repository.ErrUnavailable
+}
+
+func (failingStore) DeleteHoliday(ctx context.Context, userID uint64, date time.Time) error {
+	return repository.ErrUnavailable
+}
+
+func (failingStore) Holidays(ctx context.Context, userID uint64) (map[time.Time]string, error) {
+	return nil, repository.ErrUnavailable
+}
+
+func TestStoreError(t *testing.T) {
+	r := NewRegistry("us", failingStore{})
+	r.Add(New("us"))
+	ctx := context.Background()
+	errs := map[string]error{
+		"add":     r.AddUserHoliday(ctx, 1, date("2023-01-10"), "Day off"),
+		"delete":  r.DeleteUserHoliday(ctx, 1, date("2023-01-10")),
+		"days":    func() error { _, err := r.Days(ctx, 1, "", date("2023-01-10"), 1); return err }(),
+		"working": func() error { _, err := r.NthWorkingDay(ctx, 1, "", 2023, time.January, 1); return err }(),
+	}
+	for k, err := range errs {
+		t.Run(k, func(t *testing.T) {
+			var storeErr *StoreError
+			if !errors.As(err, &storeErr) || !errors.Is(err, repository.ErrUnavailable) {
+				t.Errorf("expected: %v, got: %v", repository.ErrUnavailable, err)
+			}
+		})
+	}
+}
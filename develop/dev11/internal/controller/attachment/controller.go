@@ -0,0 +1,384 @@
+// Package attachment manages files attached to events. Content of files is kept in blob.Store,
+// their metadata is kept in memory and saved to a JSON index file on every change.
+// Blobs are removed when the last attachment referencing them is removed, including removal of its event.
+package attachment
+
+import (
+	"bufio"
+	"context"
+	"dev11/internal/blob"
+	"dev11/internal/controller/event"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Errors of Controller
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrEventNotFound      = event.ErrEventNotFound
+)
+
+// MaxNameLength is a maximal length of file name in characters
+const MaxNameLength = 255
+
+// Limits of attachments, zero fields mean no limit
+type Limits struct {
+	MaxFileSize  int64
+	MaxEventSize int64
+	MaxFiles     int
+}
+
+// LimitError describes a limit which would be exceeded by upload
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("attachment limit exceeded: at most %d %s allowed", e.Max, e.Limit)
+}
+
+// Controller contains a blob.Store and metadata of attachments
+type Controller struct {
+	events *event.Controller
+	blobs  *blob.Store
+	limits Limits
+	index  string
+
+	m           sync.Mutex
+	nextID      uint64
+	attachments map[uint64]*model.Attachment
+}
+
+// index is a content of index file
+type index struct {
+	NextID      uint64              `json:"next_id"`
+	Attachments []*model.Attachment `json:"attachments"`
+}
+
+// New creates Controller storing attachments of events in blobs with metadata in index file
+// and returns pointer to it. Controller listens to deletion of events to remove their attachments.
+func New(events *event.Controller, blobs *blob.Store, indexPath string, limits Limits) (*Controller, error) {
+	c := &Controller{events: events, blobs: blobs, limits: limits, index: indexPath, nextID: 1, attachments: map[uint64]*model.Attachment{}}
+	data, err := os.ReadFile(indexPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var idx index
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return nil, fmt.Errorf("%s: %w", indexPath, err)
+		}
+		c.nextID = idx.NextID
+		for _, a := range idx.Attachments {
+			c.attachments[a.ID] = a
+		}
+	}
+	events.AddListener(c)
+	return c, nil
+}
+
+// Upload stores file with given name read from r as an attachment of Event of user.
+// Content type is detected from content of the file, type declared by client is not trusted.
+func (c *Controller) Upload(ctx context.Context, userID, eventID uint64, name string, r io.Reader) (*model.Attachment, error) {
+	if err := c.checkEvent(ctx, userID, eventID); err != nil {
+		return nil, err
+	}
+	user := tenant.UserKey(ctx, userID)
+	limit, err := c.available(user, eventID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head)
+	p, err := c.blobs.Write(br, limit)
+	if errors.Is(err, blob.ErrTooLarge) {
+		return nil, c.sizeError(limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	// other uploads to the same event could be finished meanwhile
+	if _, err := c.availableLocked(user, eventID, p.Size); err != nil {
+		c.m.Unlock()
+		c.blobs.Discard(p)
+		return nil, err
+	}
+	if err := c.blobs.Commit(p); err != nil {
+		c.m.Unlock()
+		c.blobs.Discard(p)
+		return nil, err
+	}
+	a := &model.Attachment{
+		ID:          c.nextID,
+		EventID:     eventID,
+		TenantID:    user.TenantID,
+		UserID:      userID,
+		Name:        cleanName(name),
+		ContentType: contentType,
+		Size:        p.Size,
+		SHA256:      p.Hash,
+		Created:     time.Now().UTC().Truncate(time.Second),
+	}
+	c.nextID++
+	c.attachments[a.ID] = a
+	err = c.save()
+	c.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// event could be deleted while file was uploaded
+	if err := c.checkEvent(ctx, userID, eventID); errors.Is(err, ErrEventNotFound) {
+		c.EventDeleted(user.TenantID, userID, eventID)
+		return nil, err
+	}
+	return a, nil
+}
+
+// List returns attachments of Event of user ordered by upload
+func (c *Controller) List(ctx context.Context, userID, eventID uint64) ([]*model.Attachment, error) {
+	if err := c.checkEvent(ctx, userID, eventID); err != nil {
+		return nil, err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.ofEvent(tenant.UserKey(ctx, userID), eventID), nil
+}
+
+// Open returns attachment of user with file to read its content, caller must close the file
+func (c *Controller) Open(ctx context.Context, userID, id uint64) (*model.Attachment, *os.File, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	a, ok := c.attachments[id]
+	if !ok || owner(a) != tenant.UserKey(ctx, userID) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	f, err := c.blobs.Open(a.SHA256)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	copied := *a
+	return &copied, f, nil
+}
+
+// Delete removes attachment of user, its blob is removed if no other attachment has the same content
+func (c *Controller) Delete(ctx context.Context, userID, id uint64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	a, ok := c.attachments[id]
+	if !ok || owner(a) != tenant.UserKey(ctx, userID) {
+		return ErrAttachmentNotFound
+	}
+	return c.remove([]*model.Attachment{a})
+}
+
+// EventDeleted removes attachments of deleted Event
+func (c *Controller) EventDeleted(tenantID, userID, eventID uint64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if err := c.remove(c.ofEvent(tenant.Key{TenantID: tenantID, UserID: userID}, eventID)); err != nil {
+		log.Printf("attachments of event %d: %v", eventID, err)
+	}
+}
+
+// Collect removes attachments of events which don't exist anymore and blobs without attachments,
+// it cleans up after events deleted on other nodes or while the server was down.
+// It returns number of removed blobs.
+func (c *Controller) Collect(ctx context.Context) (int, error) {
+	c.m.Lock()
+	attachments := make([]*model.Attachment, 0, len(c.attachments))
+	for _, a := range c.attachments {
+		attachments = append(attachments, a)
+	}
+	c.m.Unlock()
+	for _, a := range attachments {
+		if err := c.checkEvent(tenant.NewContext(ctx, a.TenantID), a.UserID, a.EventID); errors.Is(err, ErrEventNotFound) {
+			c.EventDeleted(a.TenantID, a.UserID, a.EventID)
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	hashes, err := c.blobs.Hashes()
+	if err != nil {
+		return 0, err
+	}
+	used := c.hashes()
+	n := 0
+	for _, hash := range hashes {
+		if used[hash] {
+			continue
+		}
+		if err := c.blobs.Delete(hash); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// remove removes attachments and their blobs if they are not used anymore, c.m must be held
+func (c *Controller) remove(attachments []*model.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	for _, a := range attachments {
+		delete(c.attachments, a.ID)
+	}
+	if err := c.save(); err != nil {
+		return err
+	}
+	used := c.hashes()
+	for _, a := range attachments {
+		if used[a.SHA256] {
+			continue
+		}
+		used[a.SHA256] = true
+		if err := c.blobs.Delete(a.SHA256); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashes returns set of hashes of blobs referenced by attachments, c.m must be held
+func (c *Controller) hashes() map[string]bool {
+	used := map[string]bool{}
+	for _, a := range c.attachments {
+		used[a.SHA256] = true
+	}
+	return used
+}
+
+// ofEvent returns attachments of Event of user ordered by id, c.m must be held
+func (c *Controller) ofEvent(user tenant.Key, eventID uint64) []*model.Attachment {
+	attachments := []*model.Attachment{}
+	for _, a := range c.attachments {
+		if owner(a) == user && a.EventID == eventID {
+			attachments = append(attachments, a)
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].ID < attachments[j].ID })
+	return attachments
+}
+
+// available returns number of bytes which can be attached to Event of user in addition to size bytes
+func (c *Controller) available(user tenant.Key, eventID uint64, size int64) (int64, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.availableLocked(user, eventID, size)
+}
+
+func (c *Controller) availableLocked(user tenant.Key, eventID uint64, size int64) (int64, error) {
+	files := 0
+	var total int64
+	for _, a := range c.attachments {
+		if owner(a) == user && a.EventID == eventID {
+			files++
+			total += a.Size
+		}
+	}
+	if c.limits.MaxFiles > 0 && files >= c.limits.MaxFiles {
+		return 0, &LimitError{Limit: "files per event", Max: int64(c.limits.MaxFiles)}
+	}
+	limit := int64(math.MaxInt64)
+	if c.limits.MaxFileSize > 0 {
+		limit = c.limits.MaxFileSize
+	}
+	if c.limits.MaxEventSize > 0 {
+		if rest := c.limits.MaxEventSize - total; rest < limit {
+			limit = rest
+		}
+	}
+	if size > limit {
+		return 0, c.sizeError(limit)
+	}
+	return limit, nil
+}
+
+// sizeError returns LimitError of the limit which restricts size of file to limit bytes
+func (c *Controller) sizeError(limit int64) error {
+	if c.limits.MaxFileSize > 0 && limit == c.limits.MaxFileSize {
+		return &LimitError{Limit: "bytes per file", Max: c.limits.MaxFileSize}
+	}
+	return &LimitError{Limit: "bytes per event", Max: c.limits.MaxEventSize}
+}
+
+// owner returns Key of user who uploaded attachment
+func owner(a *model.Attachment) tenant.Key {
+	return tenant.Key{TenantID: a.TenantID, UserID: a.UserID}
+}
+
+// checkEvent returns ErrEventNotFound if user has no Event with given id
+func (c *Controller) checkEvent(ctx context.Context, userID, eventID uint64) error {
+	_, err := c.events.Get(ctx, userID, eventID)
+	if errors.Is(err, event.ErrUserNotFound) || errors.Is(err, event.ErrEventNotFound) {
+		return ErrEventNotFound
+	}
+	return err
+}
+
+// save writes index file atomically, c.m must be held
+func (c *Controller) save() error {
+	idx := index{NextID: c.nextID, Attachments: make([]*model.Attachment, 0, len(c.attachments))}
+	for _, a := range c.attachments {
+		idx.Attachments = append(idx.Attachments, a)
+	}
+	sort.Slice(idx.Attachments, func(i, j int) bool { return idx.Attachments[i].ID < idx.Attachments[j].ID })
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp := c.index + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.index)
+}
+
+// cleanName returns base name of file without control characters limited to MaxNameLength characters
+func cleanName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '\\' {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.ReplaceAll(name, `\`, "/")))
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "attachment"
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		name = string([]rune(name)[:MaxNameLength])
+	}
+	return name
+}
@@ -0,0 +1,284 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"dev11/internal/blob"
+	"dev11/internal/controller/event"
+	"dev11/internal/repository/memory"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC)
+
+// setup returns Controller with its event.Controller and ids of events of users 1 and 2
+func setup(t *testing.T, limits Limits) (*Controller, *event.Controller, uint64, uint64) {
+	t.Helper()
+	ctx := context.Background()
+	events := event.New(memory.New())
+	first, err := events.Create(ctx, &model.Event{UserID: 1, Title: "first", Date: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := events.Create(ctx, &model.Event{UserID: 2, Title: "second", Date: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	blobs, err := blob.New(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(events, blobs, filepath.Join(dir, "index.json"), limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, events, first, second
+}
+
+func TestUpload(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 20)
+	tests := map[string]struct {
+		name        string
+		content     string
+		contentType string
+		resultName  string
+	}{
+		"text":            {name: "notes.txt", content: "agenda", contentType: "text/plain; charset=utf-8", resultName: "notes.txt"},
+		"png":             {name: "photo.png", content: png, contentType: "image/png", resultName: "photo.png"},
+		"html is sniffed": {name: "photo.png", content: "<html><script>alert(1)</script>", contentType: "text/html; charset=utf-8", resultName: "photo.png"},
+		"binary":          {name: "data.bin", content: "\x00\x01\x02", contentType: "application/octet-stream", resultName: "data.bin"},
+		"path is removed": {name: `C:\Users\me\..\report.pdf`, content: "%PDF-1.4", contentType: "application/pdf", resultName: "report.pdf"},
+		"control chars":   {name: "a\r\nb.txt", content: "x", contentType: "text/plain; charset=utf-8", resultName: "ab.txt"},
+		"no name":         {name: "../", content: "x", contentType: "text/plain; charset=utf-8", resultName: "attachment"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			ctx := context.Background()
+			c, _, id, _ := setup(t, Limits{})
+			a, err := c.Upload(ctx, 1, id, v.name, strings.NewReader(v.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.ContentType != v.contentType || a.Name != v.resultName || a.Size != int64(len(v.content)) {
+				t.Errorf("expected: %s of type %s, got: %+v", v.resultName, v.contentType, a)
+			}
+			_, f, err := c.Open(ctx, 1, a.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if data, _ := io.ReadAll(f); string(data) != v.content {
+				t.Errorf("expected: %q, got: %q", v.content, data)
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := map[string]struct {
+		limits   Limits
+		existing []int
+		size     int
+		limit    string
+	}{
+		"no limits":             {existing: []int{100, 100}, size: 100},
+		"file size":             {limits: Limits{MaxFileSize: 10}, size: 11, limit: "bytes per file"},
+		"exactly file size":     {limits: Limits{MaxFileSize: 10}, size: 10},
+		"event size":            {limits: Limits{MaxEventSize: 25}, existing: []int{10, 10}, size: 6, limit: "bytes per event"},
+		"exactly event size":    {limits: Limits{MaxEventSize: 25}, existing: []int{10, 10}, size: 5},
+		"file and event size":   {limits: Limits{MaxFileSize: 10, MaxEventSize: 25}, existing: []int{10}, size: 11, limit: "bytes per file"},
+		"file count":            {limits: Limits{MaxFiles: 2}, existing: []int{1, 1}, size: 1, limit: "files per event"},
+		"under file count":      {limits: Limits{MaxFiles: 2}, existing: []int{1}, size: 1},
+		"full event, empty one": {limits: Limits{MaxEventSize: 0, MaxFiles: 1}, existing: []int{1}, size: 1, limit: "files per event"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			ctx := context.Background()
+			c, _, id, other := setup(t, v.limits)
+			for i, size := range v.existing {
+				if _, err := c.Upload(ctx, 1, id, "existing", bytes.NewReader(bytes.Repeat([]byte{byte(i)}, size))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := c.Upload(ctx, 1, id, "new", bytes.NewReader(bytes.Repeat([]byte{'n'}, v.size)))
+			var limitErr *LimitError
+			if v.limit == "" && err != nil {
+				t.Errorf("expected: no error, got: %v", err)
+			}
+			if v.limit != "" && (!errors.As(err, &limitErr) || limitErr.Limit != v.limit) {
+				t.Errorf("expected: limit of %s exceeded, got: %v", v.limit, err)
+			}
+			// attachments of other events don't affect limits
+			if v.size <= 10 {
+				if _, err := c.Upload(ctx, 2, other, "other", bytes.NewReader(bytes.Repeat([]byte{'o'}, v.size))); err != nil {
+					t.Errorf("expected: no error for other event, got: %v", err)
+				}
+			}
+			hashes, _ := c.blobs.Hashes()
+			if len(hashes) != len(c.hashes()) {
+				t.Errorf("expected: %d blobs, got: %d", len(c.hashes()), len(hashes))
+			}
+		})
+	}
+}
+
+func TestAccess(t *testing.T) {
+	ctx := context.Background()
+	c, _, id, other := setup(t, Limits{})
+	a, err := c.Upload(ctx, 1, id, "notes.txt", strings.NewReader("agenda"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(ctx, 1, other, "notes.txt", strings.NewReader("agenda")); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("expected: %v for event of other user, got: %v", ErrEventNotFound, err)
+	}
+	if _, err := c.List(ctx, 2, id); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("expected: %v for event of other user, got: %v", ErrEventNotFound, err)
+	}
+	if _, _, err := c.Open(ctx, 2, a.ID); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected: %v for attachment of other user, got: %v", ErrAttachmentNotFound, err)
+	}
+	if err := c.Delete(ctx, 2, a.ID); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected: %v for attachment of other user, got: %v", ErrAttachmentNotFound, err)
+	}
+	if list, err := c.List(ctx, 1, id); err != nil || len(list) != 1 || list[0].ID != a.ID {
+		t.Errorf("expected: [%+v], got: %v, %v", a, list, err)
+	}
+
+	// user with the same id in other tenant
+	tenantCtx := tenant.NewContext(ctx, 7)
+	if _, err := c.List(tenantCtx, 1, id); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("expected: %v for event of other tenant, got: %v", ErrEventNotFound, err)
+	}
+	if _, _, err := c.Open(tenantCtx, 1, a.ID); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected: %v for attachment of other tenant, got: %v", ErrAttachmentNotFound, err)
+	}
+	if err := c.Delete(tenantCtx, 1, a.ID); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected: %v for attachment of other tenant, got: %v", ErrAttachmentNotFound, err)
+	}
+}
+
+func TestGarbageCollection(t *testing.T) {
+	ctx := context.Background()
+	c, events, id, other := setup(t, Limits{})
+	shared, err := c.Upload(ctx, 1, id, "shared.txt", strings.NewReader("shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(ctx, 1, id, "own.txt", strings.NewReader("own")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(ctx, 2, other, "shared.txt", strings.NewReader("shared")); err != nil {
+		t.Fatal(err)
+	}
+	if hashes, _ := c.blobs.Hashes(); len(hashes) != 2 {
+		t.Fatalf("expected: 2 blobs, got: %v", hashes)
+	}
+
+	// blob of attachment deleted by user is kept while another attachment has the same content
+	if err := c.Delete(ctx, 1, shared.ID); err != nil {
+		t.Fatal(err)
+	}
+	if hashes, _ := c.blobs.Hashes(); len(hashes) != 2 {
+		t.Errorf("expected: 2 blobs, got: %v", hashes)
+	}
+
+	if err := events.Delete(ctx, 1, id); err != nil {
+		t.Fatal(err)
+	}
+	if hashes, _ := c.blobs.Hashes(); len(hashes) != 1 || hashes[0] != shared.SHA256 {
+		t.Errorf("expected: only shared blob, got: %v", hashes)
+	}
+	if err := events.Delete(ctx, 2, other); err != nil {
+		t.Fatal(err)
+	}
+	if hashes, _ := c.blobs.Hashes(); len(hashes) != 0 {
+		t.Errorf("expected: no blobs, got: %v", hashes)
+	}
+	if len(c.attachments) != 0 {
+		t.Errorf("expected: no attachments, got: %d", len(c.attachments))
+	}
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := memory.New()
+	events := event.New(repo)
+	id, err := events.Create(ctx, &model.Event{UserID: 1, Title: "first", Date: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := events.Create(ctx, &model.Event{UserID: 1, Title: "kept", Date: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantCtx := tenant.NewContext(ctx, 7)
+	keptOfTenant, err := events.Create(tenantCtx, &model.Event{UserID: 1, Title: "kept", Date: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blob.New(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := filepath.Join(dir, "index.json")
+	c, err := New(events, blobs, index, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(ctx, 1, id, "deleted.txt", strings.NewReader("deleted")); err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.Upload(ctx, 1, kept, "kept.txt", strings.NewReader("kept"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(tenantCtx, 1, keptOfTenant, "kept.txt", strings.NewReader("kept")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := blobs.Write(strings.NewReader("orphan"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Commit(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// event is deleted while attachments are not watching, as after restart
+	if err := repo.Delete(ctx, 1, id); err != nil {
+		t.Fatal(err)
+	}
+	c, err = New(event.New(repo), blobs, index, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.Collect(ctx)
+	if err != nil || n != 1 {
+		t.Errorf("expected: 1 orphaned blob removed, got: %d, %v", n, err)
+	}
+	hashes, _ := blobs.Hashes()
+	if len(hashes) != 1 || hashes[0] != a.SHA256 {
+		t.Errorf("expected: only blob of kept attachment, got: %v", hashes)
+	}
+	if list, err := c.List(ctx, 1, kept); err != nil || len(list) != 1 || list[0].ID != a.ID {
+		t.Errorf("expected: [%+v], got: %v, %v", a, list, err)
+	}
+	if list, err := c.List(tenantCtx, 1, keptOfTenant); err != nil || len(list) != 1 {
+		t.Errorf("expected: attachment of other tenant is kept, got: %v, %v", list, err)
+	}
+
+	// ids are not reused after restart
+	b, err := c.Upload(ctx, 1, kept, "new.txt", strings.NewReader("new"))
+	if err != nil || b.ID <= a.ID {
+		t.Errorf("expected: id greater than %d, got: %+v, %v", a.ID, b, err)
+	}
+}
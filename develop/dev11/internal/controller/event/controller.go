@@ -1,9 +1,12 @@
 package event
 
 import (
+	"context"
 	"dev11/internal/repository"
+	"dev11/internal/tenant"
 	"dev11/pkg/model"
 	"errors"
+	"sync"
 	"time"
 )
 
@@ -12,35 +15,93 @@ var (
 	ErrUserNotFound  = errors.New("user not found")
 	ErrEventNotFound = errors.New("event not found")
 	ErrDuplicateID   = errors.New("duplicate event id")
+	// ErrUnavailable is returned as is, so it can carry details of the repository
+	ErrUnavailable = repository.ErrUnavailable
+	// ErrInvalidSyncToken is returned by Changes when changes since given sequence number are not known
+	ErrInvalidSyncToken = repository.ErrInvalidSyncToken
 )
 
+// Change is a record about created, updated or deleted Event in journal of repository
+type Change = repository.Change
+
 type eventRepository interface {
-	Create(e *model.Event) (uint64, error)
-	Update(e *model.Event) error
-	Delete(userID, id uint64) error
-	GetForDay(userID uint64, t time.Time) ([]*model.Event, error)
-	GetForWeek(userID uint64, t time.Time) ([]*model.Event, error)
-	GetForMonth(userID uint64, t time.Time) ([]*model.Event, error)
+	Create(ctx context.Context, e *model.Event) (uint64, error)
+	Update(ctx context.Context, e *model.Event) error
+	Delete(ctx context.Context, userID, id uint64) error
+	Get(ctx context.Context, userID, id uint64) (*model.Event, error)
+	GetAll(ctx context.Context, userID uint64) ([]*model.Event, error)
+	GetBefore(ctx context.Context, t time.Time) ([]*model.Event, error)
+	GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error)
+	Upsert(ctx context.Context, e *model.Event) (bool, error)
+	Changes(ctx context.Context, userID, since uint64) ([]repository.Change, uint64, error)
+	Seq() uint64
+	LastChange(ctx context.Context, userID uint64) uint64
 }
 
-// Controller contains an instance of repository and provides its methods to client
+// Controller contains an instance of repository and provides its methods to client.
+// Changes of events are recorded in journal by repository, so they include writes made through other nodes
+// of replicated repository.
 type Controller struct {
 	repo eventRepository
+
+	// quotaMu guards quota, retention and workingDays
+	quotaMu     sync.Mutex
+	quota       Quota
+	retention   Retention
+	workingDays WorkingDays
+	// users are locked by writes of their events while Quota is set
+	usersMu sync.Mutex
+	users   map[tenant.Key]*userLock
+
+	listenersMu     sync.RWMutex
+	listeners       []Listener
+	changeListeners []ChangeListener
 }
 
 // New creates an instance of Controller provided with repository and returns pointer to it
 func New(repo eventRepository) *Controller {
-	return &Controller{repo: repo}
+	return &Controller{repo: repo, users: map[tenant.Key]*userLock{}}
 }
 
-// Create adds an Event to repository
-func (c *Controller) Create(e *model.Event) (uint64, error) {
-	return c.repo.Create(e)
+// Create validates an Event, checks Quota and adds it to repository
+func (c *Controller) Create(ctx context.Context, e *model.Event) (uint64, error) {
+	if err := validate(e); err != nil {
+		return 0, err
+	}
+	q, unlock := c.guard(ctx, e.UserID)
+	defer unlock()
+	if err := c.checkQuota(ctx, q, e, true); err != nil {
+		return 0, err
+	}
+	id, err := c.repo.Create(ctx, e)
+	if err == nil {
+		c.notifyChanged(Created, tenant.UserKey(ctx, e.UserID), id, e)
+	}
+	return id, err
 }
 
-// Update changes an Event from repository
-func (c *Controller) Update(e *model.Event) error {
-	err := c.repo.Update(e)
+// Update validates an Event, checks Quota if the Event is moved to other day and changes it in repository.
+// ICalUID of the Event is kept if e doesn't provide one.
+func (c *Controller) Update(ctx context.Context, e *model.Event) error {
+	if err := validate(e); err != nil {
+		return err
+	}
+	q, unlock := c.guard(ctx, e.UserID)
+	defer unlock()
+	if old, err := c.repo.Get(ctx, e.UserID, e.ID); err == nil {
+		if e.ICalUID == "" {
+			e.ICalUID = old.ICalUID
+		}
+		if !sameDay(old.Date, e.Date) {
+			if err := c.checkQuota(ctx, q, e, false); err != nil {
+				return err
+			}
+		}
+	}
+	err := c.repo.Update(ctx, e)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
 			return ErrUserNotFound
@@ -48,13 +109,67 @@ func (c *Controller) Update(e *model.Event) error {
 		if errors.Is(err, repository.ErrEventNotFound) {
 			return ErrEventNotFound
 		}
+		return err
 	}
-	return err
+	c.notifyChanged(Updated, tenant.UserKey(ctx, e.UserID), e.ID, e)
+	return nil
+}
+
+// Upsert validates an Event, checks Quota and adds it to repository or replaces the Event of the same user
+// with the same ICalUID keeping its id. Both are done by repository at once, so concurrent Upsert calls
+// never add two events with the same ICalUID. It reports whether the Event was added.
+func (c *Controller) Upsert(ctx context.Context, e *model.Event) (bool, error) {
+	if err := validate(e); err != nil {
+		return false, err
+	}
+	q, unlock := c.guard(ctx, e.UserID)
+	defer unlock()
+	if q != (Quota{}) {
+		old, err := c.findByICalUID(ctx, e.UserID, e.ICalUID)
+		if err != nil {
+			return false, err
+		}
+		if old == nil {
+			err = c.checkQuota(ctx, q, e, true)
+		} else if !sameDay(old.Date, e.Date) {
+			e.ID = old.ID
+			err = c.checkQuota(ctx, q, e, false)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	created, err := c.repo.Upsert(ctx, e)
+	if err != nil {
+		return false, err
+	}
+	t := Updated
+	if created {
+		t = Created
+	}
+	c.notifyChanged(t, tenant.UserKey(ctx, e.UserID), e.ID, e)
+	return created, nil
+}
+
+// findByICalUID returns Event of user with given ICalUID or nil if there is none
+func (c *Controller) findByICalUID(ctx context.Context, userID uint64, icalUID string) (*model.Event, error) {
+	events, err := c.repo.GetAll(ctx, userID)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+	for _, e := range events {
+		if e.ICalUID == icalUID {
+			return e, nil
+		}
+	}
+	return nil, nil
 }
 
 // Delete removes an Event from repository
-func (c *Controller) Delete(userID, id uint64) error {
-	err := c.repo.Delete(userID, id)
+func (c *Controller) Delete(ctx context.Context, userID, id uint64) error {
+	// old Event is passed to listeners of changes
+	old, _ := c.repo.Get(ctx, userID, id)
+	err := c.repo.Delete(ctx, userID, id)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
 			return ErrUserNotFound
@@ -62,33 +177,121 @@ func (c *Controller) Delete(userID, id uint64) error {
 		if errors.Is(err, repository.ErrEventNotFound) {
 			return ErrEventNotFound
 		}
+		return err
 	}
-	return err
+	user := tenant.UserKey(ctx, userID)
+	c.notifyDeleted(user, id)
+	c.notifyChanged(Deleted, user, id, old)
+	return nil
 }
 
-// GetForDay returns a list of events for given day
-func (c *Controller) GetForDay(userID uint64, t time.Time) ([]*model.Event, error) {
-	events, err := c.repo.GetForDay(userID, t)
-	if err != nil && errors.Is(err, repository.ErrUserNotFound) {
-		return nil, ErrUserNotFound
+// Get returns an Event by its id
+func (c *Controller) Get(ctx context.Context, userID, id uint64) (*model.Event, error) {
+	e, err := c.repo.Get(ctx, userID, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		if errors.Is(err, repository.ErrEventNotFound) {
+			return nil, ErrEventNotFound
+		}
+		return nil, err
 	}
-	return events, err
+	return e, nil
 }
 
-// GetForWeek returns a list of events for a week starting from given day
-func (c *Controller) GetForWeek(userID uint64, t time.Time) ([]*model.Event, error) {
-	events, err := c.repo.GetForWeek(userID, t)
-	if err != nil && errors.Is(err, repository.ErrUserNotFound) {
-		return nil, ErrUserNotFound
+// GetAll returns a list of all events of user
+func (c *Controller) GetAll(ctx context.Context, userID uint64) ([]*model.Event, error) {
+	events, err := c.repo.GetAll(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
 	}
-	return events, err
+	return events, nil
 }
 
-// GetForMonth returns a list of events for a month starting from given day
-func (c *Controller) GetForMonth(userID uint64, t time.Time) ([]*model.Event, error) {
-	events, err := c.repo.GetForMonth(userID, t)
-	if err != nil && errors.Is(err, repository.ErrUserNotFound) {
-		return nil, ErrUserNotFound
+// GetForDay returns a list of events for given day matching Filter
+func (c *Controller) GetForDay(ctx context.Context, userID uint64, t time.Time, f Filter) ([]*model.Event, error) {
+	events, err := c.repo.GetForDay(ctx, userID, t)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	events, err = c.withOccurrences(ctx, userID, events, t, t.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return f.apply(events), nil
+}
+
+// GetForWeek returns a list of events for a week starting from given day matching Filter
+func (c *Controller) GetForWeek(ctx context.Context, userID uint64, t time.Time, f Filter) ([]*model.Event, error) {
+	events, err := c.repo.GetForWeek(ctx, userID, t)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	events, err = c.withOccurrences(ctx, userID, events, t, t.AddDate(0, 0, 7))
+	if err != nil {
+		return nil, err
+	}
+	return f.apply(events), nil
+}
+
+// GetForMonth returns a list of events for a month starting from given day matching Filter
+func (c *Controller) GetForMonth(ctx context.Context, userID uint64, t time.Time, f Filter) ([]*model.Event, error) {
+	events, err := c.repo.GetForMonth(ctx, userID, t)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
 	}
-	return events, err
+	events, err = c.withOccurrences(ctx, userID, events, t, t.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	return f.apply(events), nil
+}
+
+// Search returns at most limit events which titles or descriptions match query ordered by relevance
+func (c *Controller) Search(ctx context.Context, userID uint64, query string, limit int) ([]*model.SearchResult, error) {
+	results, err := c.repo.Search(ctx, userID, query)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// Changes returns the latest change of every Event of user of tenant of ctx made after sequence number since
+// and current sequence number. Since must be a sequence number returned by Changes or Seq earlier.
+func (c *Controller) Changes(ctx context.Context, userID, since uint64) ([]Change, uint64, error) {
+	return c.repo.Changes(ctx, userID, since)
+}
+
+// Seq returns current sequence number of journal
+func (c *Controller) Seq() uint64 {
+	return c.repo.Seq()
+}
+
+// LastChange returns sequence number of the latest change of events of user of tenant of ctx,
+// it is zero if there are no changes
+func (c *Controller) LastChange(ctx context.Context, userID uint64) uint64 {
+	return c.repo.LastChange(ctx, userID)
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
 }
@@ -0,0 +1,78 @@
+package event
+
+import (
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+)
+
+// Listener is notified about events deleted through Controller, including removal by Retention policy.
+// Listeners are called synchronously after the change is made, so they must not block.
+type Listener interface {
+	EventDeleted(tenantID, userID, id uint64)
+}
+
+// ChangeType is a kind of change of an event
+type ChangeType string
+
+// Types of changes reported to ChangeListener
+const (
+	Created ChangeType = "created"
+	Updated ChangeType = "updated"
+	Deleted ChangeType = "deleted"
+)
+
+// ChangeNotice describes an event created, updated or deleted through Controller.
+// Event is the state after the change, for deletions it is the last known state and may be nil.
+type ChangeNotice struct {
+	Type     ChangeType
+	TenantID uint64
+	UserID   uint64
+	ID       uint64
+	Event    *model.Event
+}
+
+// ChangeListener is notified about every change of events made through Controller.
+// Like Listener it is called synchronously after the change is made, so it must not block.
+type ChangeListener interface {
+	EventChanged(n ChangeNotice)
+}
+
+// AddListener registers Listener which is notified about every following deletion of events
+func (c *Controller) AddListener(l Listener) {
+	c.listenersMu.Lock()
+	defer c.listenersMu.Unlock()
+	c.listeners = append(c.listeners, l)
+}
+
+// AddChangeListener registers ChangeListener which is notified about every following change of events
+func (c *Controller) AddChangeListener(l ChangeListener) {
+	c.listenersMu.Lock()
+	defer c.listenersMu.Unlock()
+	c.changeListeners = append(c.changeListeners, l)
+}
+
+func (c *Controller) notifyDeleted(user tenant.Key, id uint64) {
+	c.listenersMu.RLock()
+	listeners := c.listeners
+	c.listenersMu.RUnlock()
+	for _, l := range listeners {
+		l.EventDeleted(user.TenantID, user.UserID, id)
+	}
+}
+
+// notifyChanged reports ChangeNotice to listeners, Event of the change is copied for every listener
+func (c *Controller) notifyChanged(t ChangeType, user tenant.Key, id uint64, e *model.Event) {
+	c.listenersMu.RLock()
+	listeners := c.changeListeners
+	c.listenersMu.RUnlock()
+	for _, l := range listeners {
+		change := ChangeNotice{Type: t, TenantID: user.TenantID, UserID: user.UserID, ID: id}
+		if e != nil {
+			copied := *e
+			copied.ID = id
+			copied.Tags = append([]string(nil), e.Tags...)
+			change.Event = &copied
+		}
+		l.EventChanged(change)
+	}
+}
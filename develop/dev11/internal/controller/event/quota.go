@@ -0,0 +1,145 @@
+package event
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Quota limits number of events of user, zero fields mean no limit
+type Quota struct {
+	MaxEvents       int
+	MaxEventsPerDay int
+}
+
+// QuotaError describes a limit of Quota which would be exceeded by a write
+type QuotaError struct {
+	Limit string
+	Max   int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: at most %d %s allowed", e.Max, e.Limit)
+}
+
+// Usage describes how many events user has compared with Quota and Retention
+type Usage struct {
+	Events           int        `json:"events"`
+	MaxEvents        int        `json:"max_events,omitempty"`
+	MaxEventsPerDay  int        `json:"max_events_per_day,omitempty"`
+	BusiestDay       *time.Time `json:"busiest_day,omitempty"`
+	BusiestDayEvents int        `json:"busiest_day_events"`
+	Oldest           *time.Time `json:"oldest,omitempty"`
+	RetentionMonths  int        `json:"retention_months,omitempty"`
+}
+
+// SetQuota sets limits checked on every create and update of events
+func (c *Controller) SetQuota(q Quota) {
+	c.quotaMu.Lock()
+	defer c.quotaMu.Unlock()
+	c.quota = q
+}
+
+// guard returns current Quota and function to call after write of events of user of tenant of ctx.
+// If Quota is set it keeps the user locked till the call, so the check of Quota and the write are atomic,
+// while writes of other users go on. Locks are held by a node, so concurrent writes of the same user
+// through different nodes of replicated repository may exceed Quota.
+func (c *Controller) guard(ctx context.Context, userID uint64) (Quota, func()) {
+	c.quotaMu.Lock()
+	q := c.quota
+	c.quotaMu.Unlock()
+	if q == (Quota{}) {
+		return q, func() {}
+	}
+	return q, c.lockUser(tenant.UserKey(ctx, userID))
+}
+
+// userLock serializes writes of a user, refs counts writers holding or waiting for it
+type userLock struct {
+	m    sync.Mutex
+	refs int
+}
+
+// lockUser locks user and returns function unlocking it, lock is dropped when nobody holds or waits for it
+func (c *Controller) lockUser(user tenant.Key) func() {
+	c.usersMu.Lock()
+	l, ok := c.users[user]
+	if !ok {
+		l = &userLock{}
+		c.users[user] = l
+	}
+	l.refs++
+	c.usersMu.Unlock()
+
+	l.m.Lock()
+	return func() {
+		l.m.Unlock()
+		c.usersMu.Lock()
+		defer c.usersMu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.users, user)
+		}
+	}
+}
+
+// checkQuota returns QuotaError if user can't have Event e which is created or moved to other date
+func (c *Controller) checkQuota(ctx context.Context, q Quota, e *model.Event, create bool) error {
+	if q.MaxEvents > 0 && create {
+		events, err := c.repo.GetAll(ctx, e.UserID)
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			return err
+		}
+		if len(events) >= q.MaxEvents {
+			return &QuotaError{Limit: "events", Max: q.MaxEvents}
+		}
+	}
+	if q.MaxEventsPerDay > 0 {
+		events, err := c.repo.GetForDay(ctx, e.UserID, e.Date)
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			return err
+		}
+		n := 0
+		for _, other := range events {
+			if create || other.ID != e.ID {
+				n++
+			}
+		}
+		if n >= q.MaxEventsPerDay {
+			return &QuotaError{Limit: "events per day", Max: q.MaxEventsPerDay}
+		}
+	}
+	return nil
+}
+
+// Usage returns number of events of user and his busiest day with limits of Quota and Retention.
+// User without events has zero usage.
+func (c *Controller) Usage(ctx context.Context, userID uint64) (Usage, error) {
+	c.quotaMu.Lock()
+	u := Usage{MaxEvents: c.quota.MaxEvents, MaxEventsPerDay: c.quota.MaxEventsPerDay, RetentionMonths: c.retention.Months}
+	c.quotaMu.Unlock()
+
+	events, err := c.repo.GetAll(ctx, userID)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return u, err
+	}
+	u.Events = len(events)
+	perDay := map[time.Time]int{}
+	for _, e := range events {
+		day := time.Date(e.Date.Year(), e.Date.Month(), e.Date.Day(), 0, 0, 0, 0, time.UTC)
+		perDay[day]++
+		if perDay[day] > u.BusiestDayEvents || perDay[day] == u.BusiestDayEvents && day.Before(*u.BusiestDay) {
+			u.BusiestDay, u.BusiestDayEvents = &day, perDay[day]
+		}
+		if u.Oldest == nil || e.Date.Before(*u.Oldest) {
+			date := e.Date
+			u.Oldest = &date
+		}
+	}
+	return u, nil
+}
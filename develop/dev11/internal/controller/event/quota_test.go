@@ -0,0 +1,276 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"dev11/internal/repository/memory"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	tests := map[string]struct {
+		quota    Quota
+		existing []time.Time
+		date     time.Time
+		limit    string
+	}{
+		"no quota":              {existing: []time.Time{day, day, day}, date: day},
+		"under events quota":    {quota: Quota{MaxEvents: 3}, existing: []time.Time{day, day.AddDate(0, 0, 1)}, date: day},
+		"events quota":          {quota: Quota{MaxEvents: 2}, existing: []time.Time{day, day.AddDate(0, 0, 1)}, date: day.AddDate(0, 0, 2), limit: "events"},
+		"under per day quota":   {quota: Quota{MaxEventsPerDay: 2}, existing: []time.Time{day, day.AddDate(0, 0, 1)}, date: day},
+		"per day quota":         {quota: Quota{MaxEventsPerDay: 2}, existing: []time.Time{day, day}, date: day, limit: "events per day"},
+		"other day is not full": {quota: Quota{MaxEventsPerDay: 2}, existing: []time.Time{day, day}, date: day.AddDate(0, 0, 1)},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			ctx := context.Background()
+			c := New(memory.New())
+			for _, date := range v.existing {
+				if _, err := c.Create(ctx, &model.Event{UserID: 1, Title: "existing", Date: date}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// other users don't affect quota
+			if _, err := c.Create(ctx, &model.Event{UserID: 2, Title: "other", Date: v.date}); err != nil {
+				t.Fatal(err)
+			}
+			c.SetQuota(v.quota)
+			_, err := c.Create(ctx, &model.Event{UserID: 1, Title: "new", Date: v.date})
+			var quotaErr *QuotaError
+			if v.limit == "" && err != nil {
+				t.Errorf("expected: no error, got: %v", err)
+			}
+			if v.limit != "" && (!errors.As(err, &quotaErr) || quotaErr.Limit != v.limit) {
+				t.Errorf("expected: quota of %s exceeded, got: %v", v.limit, err)
+			}
+		})
+	}
+}
+
+func TestQuotaOnUpdate(t *testing.T) {
+	ctx := context.Background()
+	c := New(memory.New())
+	c.SetQuota(Quota{MaxEventsPerDay: 1})
+	first, err := c.Create(ctx, &model.Event{UserID: 1, Title: "first", Date: day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Create(ctx, &model.Event{UserID: 1, Title: "second", Date: day.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(ctx, &model.Event{ID: first, UserID: 1, Title: "renamed", Date: day}); err != nil {
+		t.Errorf("update on the same day: expected: no error, got: %v", err)
+	}
+	var quotaErr *QuotaError
+	if err := c.Update(ctx, &model.Event{ID: second, UserID: 1, Title: "second", Date: day}); !errors.As(err, &quotaErr) {
+		t.Errorf("move to full day: expected: quota error, got: %v", err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	ctx := context.Background()
+	c := New(memory.New())
+	c.SetQuota(Quota{MaxEvents: 10, MaxEventsPerDay: 3})
+	c.SetRetention(Retention{Months: 12})
+	usage, err := c.Usage(ctx, 1)
+	if err != nil || usage.Events != 0 || usage.BusiestDay != nil || usage.Oldest != nil {
+		t.Errorf("user without events: expected: zero usage, got: %+v, %v", usage, err)
+	}
+	for _, date := range []time.Time{day, day.AddDate(0, 0, 1), day.AddDate(0, 0, 1), day.AddDate(0, 0, -3)} {
+		if _, err := c.Create(ctx, &model.Event{UserID: 1, Title: "event", Date: date}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage, err = c.Usage(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Events != 4 || usage.MaxEvents != 10 || usage.MaxEventsPerDay != 3 || usage.RetentionMonths != 12 ||
+		usage.BusiestDayEvents != 2 || !usage.BusiestDay.Equal(day.AddDate(0, 0, 1)) || !usage.Oldest.Equal(day.AddDate(0, 0, -3)) {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+type failingArchive struct{}
+
+func (failingArchive) Store(events []*model.Event) error {
+	return errors.New("disk is full")
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.May, 15, 13, 0, 0, 0, time.UTC)
+	create := func(c *Controller) {
+		for title, date := range map[string]time.Time{
+			"old":          time.Date(2024, time.February, 14, 0, 0, 0, 0, time.UTC),
+			"other user":   time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+			"other tenant": time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC),
+			"cutoff":       time.Date(2024, time.February, 15, 0, 0, 0, 0, time.UTC),
+			"recent":       time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC),
+			"payroll":      time.Date(2023, time.January, 10, 0, 0, 0, 0, time.UTC),
+		} {
+			userID, ctx := uint64(1), ctx
+			if title == "other user" {
+				userID = 2
+			}
+			if title == "other tenant" {
+				ctx = tenant.NewContext(ctx, 7)
+			}
+			e := &model.Event{UserID: userID, Title: title, Date: date}
+			if title == "payroll" {
+				e.WorkingDay = 5
+			}
+			if _, err := c.Create(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c := New(memory.New())
+	create(c)
+	if n, err := c.Purge(ctx, now); err != nil || n != 0 {
+		t.Errorf("without retention: expected: 0, got: %d, %v", n, err)
+	}
+
+	var archive bytes.Buffer
+	c.SetRetention(Retention{Months: 3, Archive: NewJSONArchive(&archive)})
+	if n, err := c.Purge(ctx, now); err != nil || n != 3 {
+		t.Errorf("expected: 3 purged events, got: %d, %v", n, err)
+	}
+	if lines := strings.Split(strings.TrimSpace(archive.String()), "\n"); len(lines) != 3 {
+		t.Errorf("expected: 3 archived events, got: %q", archive.String())
+	}
+	for userID, expected := range map[uint64][]string{1: {"cutoff", "payroll", "recent"}, 2: {}} {
+		events, err := c.GetAll(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := []string{}
+		for _, e := range events {
+			titles = append(titles, e.Title)
+		}
+		sort.Strings(titles)
+		if strings.Join(titles, ",") != strings.Join(expected, ",") {
+			t.Errorf("user %d: expected: %v, got: %v", userID, expected, titles)
+		}
+	}
+	changes, _, err := c.Changes(ctx, 2, 0)
+	if err != nil || len(changes) != 1 || !changes[0].Deleted {
+		t.Errorf("expected: purge recorded in journal, got: %+v, %v", changes, err)
+	}
+	if events, err := c.GetAll(tenant.NewContext(ctx, 7), 1); err != nil || len(events) != 0 {
+		t.Errorf("other tenant: expected: [], got: %v, %v", events, err)
+	}
+	changes, _, err = c.Changes(tenant.NewContext(ctx, 7), 1, 0)
+	if err != nil || len(changes) != 1 || !changes[0].Deleted {
+		t.Errorf("other tenant: expected: purge recorded in journal of tenant, got: %+v, %v", changes, err)
+	}
+
+	c = New(memory.New())
+	create(c)
+	c.SetRetention(Retention{Months: 3, Archive: failingArchive{}})
+	if n, err := c.Purge(ctx, now); err == nil || n != 0 {
+		t.Errorf("failing archive: expected: error, got: %d, %v", n, err)
+	}
+	if events, _ := c.GetAll(ctx, 2); len(events) != 1 {
+		t.Errorf("failing archive: expected: events are kept, got: %v", events)
+	}
+}
+
+// blockingRepository holds creates of user 1 until release is closed
+type blockingRepository struct {
+	*memory.Repository
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRepository) Create(ctx context.Context, e *model.Event) (uint64, error) {
+	if e.UserID == 1 {
+		close(r.blocked)
+		<-r.release
+	}
+	return r.Repository.Create(ctx, e)
+}
+
+func TestQuotaLocksUser(t *testing.T) {
+	ctx := context.Background()
+	repo := &blockingRepository{Repository: memory.New(), blocked: make(chan struct{}), release: make(chan struct{})}
+	c := New(repo)
+	c.SetQuota(Quota{MaxEvents: 2})
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Create(ctx, &model.Event{UserID: 1, Title: "slow", Date: day})
+		done <- err
+	}()
+	<-repo.blocked
+	// a slow write of one user doesn't hold writes of others
+	if _, err := c.Create(ctx, &model.Event{UserID: 2, Title: "other", Date: day}); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	close(repo.release)
+	if err := <-done; err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+
+	// concurrent writes of the same user don't exceed quota
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			_, err := c.Create(ctx, &model.Event{UserID: 3, Title: "concurrent", Date: day})
+			errs <- err
+		}()
+	}
+	created := 0
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err == nil {
+			created++
+		}
+	}
+	if created != 2 {
+		t.Errorf("expected: %d, got: %d", 2, created)
+	}
+	if len(c.users) != 0 {
+		t.Errorf("expected: no locks of users left, got: %d", len(c.users))
+	}
+}
+
+// replicaRepository is a repository of node which is leader of cluster or not
+type replicaRepository struct {
+	*memory.Repository
+	leader bool
+}
+
+func (r *replicaRepository) Leader() bool {
+	return r.leader
+}
+
+func TestJanitorOnlyOnLeader(t *testing.T) {
+	for _, leader := range []bool{true, false} {
+		ctx := context.Background()
+		c := New(&replicaRepository{Repository: memory.New(), leader: leader})
+		if _, err := c.Create(ctx, &model.Event{UserID: 1, Title: "old", Date: day.AddDate(-2, 0, 0)}); err != nil {
+			t.Fatal(err)
+		}
+		c.SetRetention(Retention{Months: 12})
+		j := NewJanitor(c, time.Hour)
+		// the first purge is done on start, Stop waits for it
+		j.Start()
+		j.Stop()
+		events, err := c.GetAll(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[bool]int{true: 0, false: 1}[leader]; len(events) != expected {
+			t.Errorf("leader %v: expected: %d events, got: %d", leader, expected, len(events))
+		}
+	}
+}
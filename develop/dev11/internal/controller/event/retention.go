@@ -0,0 +1,163 @@
+package event
+
+import (
+	"context"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Retention is a policy of removal of events older than Months months, zero Months keeps events forever.
+// Removed events are stored to Archive first if it is not nil.
+type Retention struct {
+	Months  int
+	Archive Archive
+}
+
+// Archive stores events removed by Retention policy
+type Archive interface {
+	Store(events []*model.Event) error
+}
+
+// JSONArchive is an Archive which writes events to io.Writer as JSON, one event per line
+type JSONArchive struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+// NewJSONArchive creates JSONArchive writing to w and returns pointer to it
+func NewJSONArchive(w io.Writer) *JSONArchive {
+	return &JSONArchive{w: w}
+}
+
+// Store writes events to archive
+func (a *JSONArchive) Store(events []*model.Event) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	enc := json.NewEncoder(a.w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetRetention sets policy applied by Purge
+func (c *Controller) SetRetention(r Retention) {
+	c.quotaMu.Lock()
+	defer c.quotaMu.Unlock()
+	c.retention = r
+}
+
+// Purge removes events of all users of all tenants which are older than Retention allows at moment now
+// and returns number of removed events. Events are archived before removal,
+// so nothing is removed if archive fails.
+func (c *Controller) Purge(ctx context.Context, now time.Time) (int, error) {
+	c.quotaMu.Lock()
+	r := c.retention
+	c.quotaMu.Unlock()
+	if r.Months <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, -r.Months, 0)
+	events, err := c.repo.GetBefore(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	// events repeating on a working day of month keep occurring after their first Date
+	old := make([]*model.Event, 0, len(events))
+	for _, e := range events {
+		if e.WorkingDay == 0 {
+			old = append(old, e)
+		}
+	}
+	events = old
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if r.Archive != nil {
+		if err := r.Archive.Store(events); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for _, e := range events {
+		err := c.Delete(tenant.NewContext(ctx, e.TenantID), e.UserID, e.ID)
+		// event may be removed by user meanwhile
+		if err != nil && !errors.Is(err, ErrEventNotFound) {
+			return n, err
+		}
+		if err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// leader reports whether this node is leader of replicated repository, repositories of a single node always are
+func (c *Controller) leader() bool {
+	if r, ok := c.repo.(interface{ Leader() bool }); ok {
+		return r.Leader()
+	}
+	return true
+}
+
+// Janitor applies Retention policy of Controller periodically.
+// If repository of Controller is replicated, only Janitor of leader node purges events,
+// so events are archived once.
+type Janitor struct {
+	ctrl     *Controller
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewJanitor creates Janitor which purges old events of ctrl every interval and returns pointer to it
+func NewJanitor(ctrl *Controller, interval time.Duration) *Janitor {
+	return &Janitor{ctrl: ctrl, interval: interval, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Start runs Janitor in background, the first purge is done immediately
+func (j *Janitor) Start() {
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			j.purge()
+			select {
+			case <-ticker.C:
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops Janitor and waits for the current purge to finish
+func (j *Janitor) Stop() {
+	close(j.stop)
+	<-j.done
+}
+
+func (j *Janitor) purge() {
+	if !j.ctrl.leader() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), j.interval)
+	defer cancel()
+	n, err := j.ctrl.Purge(ctx, time.Now())
+	if err != nil {
+		log.Printf("retention: purged %d events: %v", n, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("retention: purged %d events", n)
+	}
+}
@@ -0,0 +1,156 @@
+package event
+
+import (
+	"dev11/pkg/model"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits of Event fields
+const (
+	MaxTitleLength       = 100
+	MaxDescriptionLength = 2000
+	MaxLocationLength    = 200
+	MaxTags              = 10
+	MaxTagLength         = 32
+	MaxICalUIDLength     = 255
+	MaxDuration          = 24 * 60
+	MaxWorkingDay        = 31
+)
+
+// Bounds of Event date
+var (
+	MinDate = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+	MaxDate = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Categories contains allowed categories of events with their default colors
+var Categories = map[string]string{
+	"work":     "#1e88e5",
+	"meeting":  "#8e24aa",
+	"personal": "#43a047",
+	"birthday": "#fb8c00",
+	"holiday":  "#e53935",
+	"other":    "#757575",
+}
+
+var colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ValidationError describes a field of Event which breaks validation rules
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// Filter selects events by tag and category, empty fields match any event
+type Filter struct {
+	Tag      string
+	Category string
+}
+
+// Match reports whether Event satisfies Filter
+func (f Filter) Match(e *model.Event) bool {
+	if f.Category != "" && e.Category != strings.ToLower(f.Category) {
+		return false
+	}
+	if f.Tag != "" && !e.HasTag(strings.ToLower(f.Tag)) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) apply(events []*model.Event) []*model.Event {
+	if f.Tag == "" && f.Category == "" {
+		return events
+	}
+	filtered := []*model.Event{}
+	for _, e := range events {
+		if f.Match(e) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// validate checks Event against validation rules and normalizes category, color and tags
+func validate(e *model.Event) error {
+	e.Title = strings.TrimSpace(e.Title)
+	if e.Title == "" {
+		return &ValidationError{Field: "title", Reason: "must not be empty"}
+	}
+	if utf8.RuneCountInString(e.Title) > MaxTitleLength {
+		return &ValidationError{Field: "title", Reason: fmt.Sprintf("must be at most %d characters", MaxTitleLength)}
+	}
+	if utf8.RuneCountInString(e.Description) > MaxDescriptionLength {
+		return &ValidationError{Field: "description", Reason: fmt.Sprintf("must be at most %d characters", MaxDescriptionLength)}
+	}
+	if utf8.RuneCountInString(e.Location) > MaxLocationLength {
+		return &ValidationError{Field: "location", Reason: fmt.Sprintf("must be at most %d characters", MaxLocationLength)}
+	}
+	if len(e.ICalUID) > MaxICalUIDLength {
+		return &ValidationError{Field: "ical_uid", Reason: fmt.Sprintf("must be at most %d bytes", MaxICalUIDLength)}
+	}
+
+	e.Category = strings.ToLower(strings.TrimSpace(e.Category))
+	defaultColor, ok := Categories[e.Category]
+	if e.Category != "" && !ok {
+		return &ValidationError{Field: "category", Reason: fmt.Sprintf("unknown category %q", e.Category)}
+	}
+	if e.Color == "" {
+		e.Color = defaultColor
+	} else if !colorRegexp.MatchString(e.Color) {
+		return &ValidationError{Field: "color", Reason: "must be in #rrggbb format"}
+	}
+
+	if len(e.Tags) > MaxTags {
+		return &ValidationError{Field: "tags", Reason: fmt.Sprintf("must be at most %d tags", MaxTags)}
+	}
+	tags := make([]string, 0, len(e.Tags))
+	for _, tag := range e.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return &ValidationError{Field: "tags", Reason: fmt.Sprintf("tag must be at most %d characters", MaxTagLength)}
+		}
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	e.Tags = tags
+
+	if e.Priority < model.PriorityLow || e.Priority > model.PriorityUrgent {
+		return &ValidationError{Field: "priority", Reason: fmt.Sprintf("must be between %d and %d", model.PriorityLow, model.PriorityUrgent)}
+	}
+	if e.Date.Before(MinDate) || !e.Date.Before(MaxDate) {
+		return &ValidationError{Field: "date", Reason: fmt.Sprintf("must be between %s and %s", MinDate.Format("2006-01-02"), MaxDate.Format("2006-01-02"))}
+	}
+	if e.Duration < 0 || e.Duration > MaxDuration {
+		return &ValidationError{Field: "duration", Reason: fmt.Sprintf("must be between 0 and %d minutes", MaxDuration)}
+	}
+	if e.WorkingDay < 0 || e.WorkingDay > MaxWorkingDay {
+		return &ValidationError{Field: "working_day", Reason: fmt.Sprintf("must be between 0 and %d", MaxWorkingDay)}
+	}
+	e.Country = strings.ToUpper(strings.TrimSpace(e.Country))
+	if e.WorkingDay == 0 {
+		e.Country = ""
+	}
+	return nil
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
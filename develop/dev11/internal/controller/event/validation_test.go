@@ -0,0 +1,148 @@
+package event
+
+import (
+	"dev11/pkg/model"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC)
+
+func TestValidate(t *testing.T) {
+	valid := func(change func(e *model.Event)) *model.Event {
+		e := &model.Event{UserID: 1, Title: "Meeting", Date: day}
+		if change != nil {
+			change(e)
+		}
+		return e
+	}
+	tests := map[string]struct {
+		event *model.Event
+		field string
+	}{
+		"valid":                  {event: valid(nil)},
+		"empty title":            {event: valid(func(e *model.Event) { e.Title = "" }), field: "title"},
+		"blank title":            {event: valid(func(e *model.Event) { e.Title = " \t\n" }), field: "title"},
+		"longest title":          {event: valid(func(e *model.Event) { e.Title = strings.Repeat("ж", MaxTitleLength) })},
+		"long title":             {event: valid(func(e *model.Event) { e.Title = strings.Repeat("a", MaxTitleLength+1) }), field: "title"},
+		"longest description":    {event: valid(func(e *model.Event) { e.Description = strings.Repeat("ж", MaxDescriptionLength) })},
+		"long description":       {event: valid(func(e *model.Event) { e.Description = strings.Repeat("a", MaxDescriptionLength+1) }), field: "description"},
+		"longest location":       {event: valid(func(e *model.Event) { e.Location = strings.Repeat("ж", MaxLocationLength) })},
+		"long location":          {event: valid(func(e *model.Event) { e.Location = strings.Repeat("a", MaxLocationLength+1) }), field: "location"},
+		"longest ical uid":       {event: valid(func(e *model.Event) { e.ICalUID = strings.Repeat("a", MaxICalUIDLength) })},
+		"long ical uid in bytes": {event: valid(func(e *model.Event) { e.ICalUID = strings.Repeat("ж", MaxICalUIDLength/2+1) }), field: "ical_uid"},
+		"known category":         {event: valid(func(e *model.Event) { e.Category = " Work " })},
+		"unknown category":       {event: valid(func(e *model.Event) { e.Category = "sport" }), field: "category"},
+		"color":                  {event: valid(func(e *model.Event) { e.Color = "#A0b1C2" })},
+		"short color":            {event: valid(func(e *model.Event) { e.Color = "#fff" }), field: "color"},
+		"color without hash":     {event: valid(func(e *model.Event) { e.Color = "a0b1c2" }), field: "color"},
+		"named color":            {event: valid(func(e *model.Event) { e.Color = "red" }), field: "color"},
+		"most tags":              {event: valid(func(e *model.Event) { e.Tags = make([]string, MaxTags) })},
+		"too many tags":          {event: valid(func(e *model.Event) { e.Tags = make([]string, MaxTags+1) }), field: "tags"},
+		"longest tag":            {event: valid(func(e *model.Event) { e.Tags = []string{strings.Repeat("ж", MaxTagLength)} })},
+		"long tag":               {event: valid(func(e *model.Event) { e.Tags = []string{strings.Repeat("a", MaxTagLength+1)} }), field: "tags"},
+		"lowest priority":        {event: valid(func(e *model.Event) { e.Priority = model.PriorityLow })},
+		"highest priority":       {event: valid(func(e *model.Event) { e.Priority = model.PriorityUrgent })},
+		"negative priority":      {event: valid(func(e *model.Event) { e.Priority = -1 }), field: "priority"},
+		"too high priority":      {event: valid(func(e *model.Event) { e.Priority = model.PriorityUrgent + 1 }), field: "priority"},
+		"zero date":              {event: valid(func(e *model.Event) { e.Date = time.Time{} }), field: "date"},
+		"min date":               {event: valid(func(e *model.Event) { e.Date = MinDate })},
+		"before min date":        {event: valid(func(e *model.Event) { e.Date = MinDate.Add(-time.Second) }), field: "date"},
+		"last day":               {event: valid(func(e *model.Event) { e.Date = MaxDate.AddDate(0, 0, -1) })},
+		"max date":               {event: valid(func(e *model.Event) { e.Date = MaxDate }), field: "date"},
+		"zero duration":          {event: valid(func(e *model.Event) { e.Duration = 0 })},
+		"max duration":           {event: valid(func(e *model.Event) { e.Duration = MaxDuration })},
+		"negative duration":      {event: valid(func(e *model.Event) { e.Duration = -1 }), field: "duration"},
+		"long duration":          {event: valid(func(e *model.Event) { e.Duration = MaxDuration + 1 }), field: "duration"},
+		"working day":            {event: valid(func(e *model.Event) { e.WorkingDay = MaxWorkingDay })},
+		"negative working day":   {event: valid(func(e *model.Event) { e.WorkingDay = -1 }), field: "working_day"},
+		"large working day":      {event: valid(func(e *model.Event) { e.WorkingDay = MaxWorkingDay + 1 }), field: "working_day"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			err := validate(v.event)
+			var validationErr *ValidationError
+			if v.field == "" && err != nil {
+				t.Errorf("expected: %v, got: %v", nil, err)
+			}
+			if v.field != "" && (!errors.As(err, &validationErr) || validationErr.Field != v.field) {
+				t.Errorf("expected: invalid %s, got: %v", v.field, err)
+			}
+		})
+	}
+}
+
+func TestValidateNormalizes(t *testing.T) {
+	tests := map[string]struct {
+		event    model.Event
+		expected model.Event
+	}{
+		"trimmed title": {event: model.Event{Title: "  Meeting \n"}, expected: model.Event{Title: "Meeting", Tags: []string{}}},
+		"category and its color": {event: model.Event{Title: "a", Category: " MEETING"},
+			expected: model.Event{Title: "a", Category: "meeting", Color: Categories["meeting"], Tags: []string{}}},
+		"own color kept": {event: model.Event{Title: "a", Category: "work", Color: "#000000"},
+			expected: model.Event{Title: "a", Category: "work", Color: "#000000", Tags: []string{}}},
+		"no category no color": {event: model.Event{Title: "a"}, expected: model.Event{Title: "a", Tags: []string{}}},
+		"tags": {event: model.Event{Title: "a", Tags: []string{" Go ", "", "go", "GO", "rust", "  "}},
+			expected: model.Event{Title: "a", Tags: []string{"go", "rust"}}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			v.event.Date, v.expected.Date = day, day
+			if err := validate(&v.event); err != nil {
+				t.Fatalf("expected: %v, got: %v", nil, err)
+			}
+			if !reflect.DeepEqual(v.event, v.expected) {
+				t.Errorf("expected: %+v, got: %+v", v.expected, v.event)
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &ValidationError{Field: "title", Reason: "must not be empty"}
+	if expected := "invalid title: must not be empty"; err.Error() != expected {
+		t.Errorf("expected: %s, got: %s", expected, err.Error())
+	}
+}
+
+func TestFilter(t *testing.T) {
+	work := &model.Event{ID: 1, Category: "work", Tags: []string{"go", "backend"}}
+	personal := &model.Event{ID: 2, Category: "personal", Tags: []string{"go"}}
+	untagged := &model.Event{ID: 3}
+	events := []*model.Event{work, personal, untagged}
+	tests := map[string]struct {
+		filter   Filter
+		expected []*model.Event
+	}{
+		"empty filter":          {expected: events},
+		"category":              {filter: Filter{Category: "work"}, expected: []*model.Event{work}},
+		"category in uppercase": {filter: Filter{Category: "PERSONAL"}, expected: []*model.Event{personal}},
+		"tag":                   {filter: Filter{Tag: "go"}, expected: []*model.Event{work, personal}},
+		"tag in uppercase":      {filter: Filter{Tag: "Backend"}, expected: []*model.Event{work}},
+		"tag and category":      {filter: Filter{Tag: "go", Category: "personal"}, expected: []*model.Event{personal}},
+		"tag of other category": {filter: Filter{Tag: "backend", Category: "personal"}, expected: []*model.Event{}},
+		"unknown tag":           {filter: Filter{Tag: "rust"}, expected: []*model.Event{}},
+		"unknown category":      {filter: Filter{Category: "holiday"}, expected: []*model.Event{}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			got := v.filter.apply(events)
+			if !reflect.DeepEqual(got, v.expected) {
+				t.Errorf("expected: %v, got: %v", v.expected, got)
+			}
+			matched := map[*model.Event]bool{}
+			for _, e := range v.expected {
+				matched[e] = true
+			}
+			for _, e := range events {
+				if v.filter.Match(e) != matched[e] {
+					t.Errorf("expected: %v, got: %v for event %d", matched[e], v.filter.Match(e), e.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package event
+
+import (
+	"context"
+	"dev11/internal/calendar"
+	"dev11/pkg/model"
+	"errors"
+	"time"
+)
+
+// WorkingDays finds working days of months by production calendars of countries and holidays of users,
+// NthWorkingDay returns calendar.ErrNoSuchWorkingDay if month has less than n working days
+type WorkingDays interface {
+	NthWorkingDay(ctx context.Context, userID uint64, country string, year int, month time.Month, n int) (time.Time, error)
+}
+
+// SetWorkingDays sets calendars which place events repeating on a working day of month in every month queried,
+// without them such events occur only on their Date
+func (c *Controller) SetWorkingDays(w WorkingDays) {
+	c.quotaMu.Lock()
+	defer c.quotaMu.Unlock()
+	c.workingDays = w
+}
+
+// withOccurrences replaces events repeating on a working day of month in events found in window [from, to)
+// with their occurrences in the window. Every occurrence is a copy of Event with Date of the occurrence
+// and time of day of the Event, so it follows changes of calendars and holidays.
+func (c *Controller) withOccurrences(ctx context.Context, userID uint64, events []*model.Event, from, to time.Time) ([]*model.Event, error) {
+	c.quotaMu.Lock()
+	w := c.workingDays
+	c.quotaMu.Unlock()
+	if w == nil {
+		return events, nil
+	}
+	result := make([]*model.Event, 0, len(events))
+	for _, e := range events {
+		if e.WorkingDay == 0 {
+			result = append(result, e)
+		}
+	}
+	// the first occurrence of a repeating event may be long before the window
+	all, err := c.repo.GetAll(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range all {
+		if e.WorkingDay == 0 {
+			continue
+		}
+		first := time.Date(e.Date.Year(), e.Date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); m.Before(to); m = m.AddDate(0, 1, 0) {
+			if m.Before(first) {
+				continue
+			}
+			day, err := w.NthWorkingDay(ctx, userID, e.Country, m.Year(), m.Month(), e.WorkingDay)
+			if errors.Is(err, calendar.ErrNoSuchWorkingDay) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			date := time.Date(day.Year(), day.Month(), day.Day(), e.Date.Hour(), e.Date.Minute(), e.Date.Second(), 0,
+				e.Date.Location())
+			if date.Before(from) || !date.Before(to) {
+				continue
+			}
+			occurrence := *e
+			occurrence.Date = date
+			result = append(result, &occurrence)
+		}
+	}
+	return result, nil
+}
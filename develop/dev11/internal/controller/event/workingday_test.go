@@ -0,0 +1,84 @@
+package event
+
+import (
+	"context"
+	"dev11/internal/calendar"
+	"dev11/internal/repository/memory"
+	"dev11/pkg/model"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWorkingDayOccurrences(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.New()
+	cal := calendar.NewRegistry("RU", repo)
+	cal.Add(calendar.New("RU"))
+	c := New(repo)
+	c.SetWorkingDays(cal)
+	// the 3rd working day of May 2024
+	first := time.Date(2024, time.May, 3, 10, 0, 0, 0, time.UTC)
+	if _, err := c.Create(ctx, &model.Event{UserID: 1, Title: "payroll", Date: first, WorkingDay: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Create(ctx, &model.Event{UserID: 1, Title: "lunch", Date: time.Date(2024, time.June, 5, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+	june := func(day int) time.Time { return time.Date(2024, time.June, day, 10, 0, 0, 0, time.UTC) }
+	tests := map[string]struct {
+		get      func(context.Context, uint64, time.Time, Filter) ([]*model.Event, error)
+		date     time.Time
+		holiday  time.Time
+		expected []time.Time
+	}{
+		"first month":     {get: c.GetForMonth, date: time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC), expected: []time.Time{first}},
+		"next month":      {get: c.GetForMonth, date: time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), expected: []time.Time{june(5)}},
+		"before first":    {get: c.GetForMonth, date: time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC), expected: []time.Time{}},
+		"across months":   {get: c.GetForMonth, date: time.Date(2024, time.June, 15, 0, 0, 0, 0, time.UTC), expected: []time.Time{time.Date(2024, time.July, 3, 10, 0, 0, 0, time.UTC)}},
+		"week":            {get: c.GetForWeek, date: time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), expected: []time.Time{june(5)}},
+		"day":             {get: c.GetForDay, date: time.Date(2024, time.June, 5, 0, 0, 0, 0, time.UTC), expected: []time.Time{june(5)}},
+		"other day":       {get: c.GetForDay, date: time.Date(2024, time.June, 4, 0, 0, 0, 0, time.UTC), expected: []time.Time{}},
+		"holiday of user": {get: c.GetForMonth, date: time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), holiday: time.Date(2024, time.June, 4, 0, 0, 0, 0, time.UTC), expected: []time.Time{june(6)}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if !v.holiday.IsZero() {
+				if err := cal.AddUserHoliday(ctx, 1, v.holiday, "Day off"); err != nil {
+					t.Fatal(err)
+				}
+				defer cal.DeleteUserHoliday(ctx, 1, v.holiday)
+			}
+			events, err := v.get(ctx, 1, v.date, Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []time.Time{}
+			for _, e := range events {
+				if e.Title == "payroll" {
+					got = append(got, e.Date)
+				}
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Before(got[j]) })
+			if len(got) != len(v.expected) {
+				t.Fatalf("expected: %v, got: %v", v.expected, got)
+			}
+			for i := range got {
+				if !got[i].Equal(v.expected[i]) {
+					t.Errorf("expected: %v, got: %v", v.expected, got)
+				}
+			}
+		})
+	}
+
+	// stored Event keeps its first occurrence
+	events, err := c.GetAll(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.Title == "payroll" && !e.Date.Equal(first) {
+			t.Errorf("expected: %v, got: %v", first, e.Date)
+		}
+	}
+}
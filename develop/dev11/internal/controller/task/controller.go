@@ -0,0 +1,177 @@
+package task
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"dev11/pkg/model"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors from Repository
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrUnavailable is returned as is, so it can carry details of the repository
+	ErrUnavailable = repository.ErrUnavailable
+)
+
+type taskRepository interface {
+	Create(ctx context.Context, t *model.Task) (uint64, error)
+	Update(ctx context.Context, t *model.Task) error
+	Delete(ctx context.Context, userID, id uint64) error
+	Get(ctx context.Context, userID, id uint64) (*model.Task, error)
+	GetDue(ctx context.Context, userID uint64, from, to time.Time) ([]*model.Task, error)
+	GetOverdue(ctx context.Context, userID uint64, t time.Time) ([]*model.Task, error)
+}
+
+// Controller contains an instance of task repository and provides its methods to client
+type Controller struct {
+	repo taskRepository
+	// m serializes changes of completion status, so recurring task spawns one next occurrence
+	m sync.Mutex
+}
+
+// New creates an instance of Controller provided with repository and returns pointer to it
+func New(repo taskRepository) *Controller {
+	return &Controller{repo: repo}
+}
+
+// Create validates a Task and adds it to repository as not done
+func (c *Controller) Create(ctx context.Context, t *model.Task) (uint64, error) {
+	if err := validate(t); err != nil {
+		return 0, err
+	}
+	t.Done, t.DoneAt, t.NextID = false, nil, 0
+	return c.repo.Create(ctx, t)
+}
+
+// Update validates a Task and changes it in repository, completion status is changed only by Complete and Reopen
+func (c *Controller) Update(ctx context.Context, t *model.Task) error {
+	if err := validate(t); err != nil {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	old, err := c.get(ctx, t.UserID, t.ID)
+	if err != nil {
+		return err
+	}
+	t.Done, t.DoneAt, t.NextID = old.Done, old.DoneAt, old.NextID
+	return mapError(c.repo.Update(ctx, t))
+}
+
+// Delete removes a Task from repository
+func (c *Controller) Delete(ctx context.Context, userID, id uint64) error {
+	return mapError(c.repo.Delete(ctx, userID, id))
+}
+
+// Complete marks a Task as done at moment now. The first completion of recurring Task creates
+// its next occurrence due after the day of completion, which is returned, otherwise returned Task is nil.
+func (c *Controller) Complete(ctx context.Context, userID, id uint64, now time.Time) (*model.Task, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	old, err := c.get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if old.Done {
+		return nil, nil
+	}
+	t := *old
+	t.Done, t.DoneAt = true, &now
+
+	var next *model.Task
+	if t.Recurrence != model.RecurrenceNone && t.NextID == 0 {
+		today := truncate(now)
+		due := t.Recurrence.Next(t.Due)
+		for !due.After(today) {
+			due = t.Recurrence.Next(due)
+		}
+		next = &model.Task{UserID: t.UserID, Title: t.Title, Description: t.Description, Due: due, Recurrence: t.Recurrence}
+		if t.NextID, err = c.repo.Create(ctx, next); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.repo.Update(ctx, &t); err != nil {
+		return nil, mapError(err)
+	}
+	return next, nil
+}
+
+// Reopen marks a Task as not done, next occurrence of recurring Task is kept
+func (c *Controller) Reopen(ctx context.Context, userID, id uint64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	old, err := c.get(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	t := *old
+	t.Done, t.DoneAt = false, nil
+	return mapError(c.repo.Update(ctx, &t))
+}
+
+// GetForDay returns a list of tasks due at given day
+func (c *Controller) GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Task, error) {
+	return c.getDue(ctx, userID, t, t.AddDate(0, 0, 1))
+}
+
+// GetForWeek returns a list of tasks due in a week starting from given day
+func (c *Controller) GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Task, error) {
+	return c.getDue(ctx, userID, t, t.AddDate(0, 0, 7))
+}
+
+// GetForMonth returns a list of tasks due in a month starting from given day
+func (c *Controller) GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Task, error) {
+	return c.getDue(ctx, userID, t, t.AddDate(0, 1, 0))
+}
+
+// Overdue returns a list of tasks which are not done and were due before the day of moment now
+func (c *Controller) Overdue(ctx context.Context, userID uint64, now time.Time) ([]*model.Task, error) {
+	tasks, err := c.repo.GetOverdue(ctx, userID, truncate(now))
+	return sorted(tasks, err)
+}
+
+func (c *Controller) getDue(ctx context.Context, userID uint64, from, to time.Time) ([]*model.Task, error) {
+	tasks, err := c.repo.GetDue(ctx, userID, from, to)
+	return sorted(tasks, err)
+}
+
+func (c *Controller) get(ctx context.Context, userID, id uint64) (*model.Task, error) {
+	t, err := c.repo.Get(ctx, userID, id)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return t, nil
+}
+
+// sorted orders tasks by due date and title, user without tasks has empty list of tasks
+func sorted(tasks []*model.Task, err error) ([]*model.Task, error) {
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return []*model.Task{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].Due.Equal(tasks[j].Due) {
+			return tasks[i].Due.Before(tasks[j].Due)
+		}
+		return tasks[i].Title < tasks[j].Title
+	})
+	return tasks, nil
+}
+
+// mapError maps errors of repository to errors of Controller, unknown user has no tasks
+func mapError(err error) error {
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrTaskNotFound) {
+		return ErrTaskNotFound
+	}
+	return err
+}
+
+func truncate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,157 @@
+package task
+
+import (
+	"context"
+	"dev11/internal/repository/memory"
+	"dev11/pkg/model"
+	"errors"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC)
+
+func TestValidation(t *testing.T) {
+	tests := map[string]struct {
+		task  model.Task
+		field string
+	}{
+		"valid":              {task: model.Task{UserID: 1, Title: "Report", Due: day, Recurrence: "Weekly"}},
+		"empty title":        {task: model.Task{UserID: 1, Title: "  ", Due: day}, field: "title"},
+		"unknown recurrence": {task: model.Task{UserID: 1, Title: "Report", Due: day, Recurrence: "yearly"}, field: "recurrence"},
+		"no due date":        {task: model.Task{UserID: 1, Title: "Report"}, field: "due"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			task := v.task
+			_, err := New(memory.NewTaskRepository()).Create(context.Background(), &task)
+			var validationErr *ValidationError
+			if v.field == "" && err != nil {
+				t.Errorf("expected: no error, got: %v", err)
+			}
+			if v.field != "" && (!errors.As(err, &validationErr) || validationErr.Field != v.field) {
+				t.Errorf("expected: invalid %s, got: %v", v.field, err)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	now := time.Date(2024, time.May, 20, 9, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		recurrence model.Recurrence
+		due        time.Time
+		next       time.Time
+	}{
+		"once":                  {due: day},
+		"weekly":                {recurrence: model.RecurrenceWeekly, due: day, next: day.AddDate(0, 0, 7)},
+		"daily skips past days": {recurrence: model.RecurrenceDaily, due: day, next: time.Date(2024, time.May, 21, 0, 0, 0, 0, time.UTC)},
+		"monthly ahead of due":  {recurrence: model.RecurrenceMonthly, due: day.AddDate(0, 1, 0), next: day.AddDate(0, 2, 0)},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			ctx := context.Background()
+			c := New(memory.NewTaskRepository())
+			id, err := c.Create(ctx, &model.Task{UserID: 1, Title: "Report", Due: v.due, Recurrence: v.recurrence})
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err := c.Complete(ctx, 1, id, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.next.IsZero() != (next == nil) || next != nil && !next.Due.Equal(v.next) {
+				t.Errorf("expected: next occurrence at %v, got: %+v", v.next, next)
+			}
+			done, err := c.repo.Get(ctx, 1, id)
+			if err != nil || !done.Done || done.DoneAt == nil || !done.DoneAt.Equal(now) {
+				t.Errorf("expected: task is done at %v, got: %+v, %v", now, done, err)
+			}
+
+			// completing again after reopening doesn't spawn one more occurrence
+			if err := c.Reopen(ctx, 1, id); err != nil {
+				t.Fatal(err)
+			}
+			if again, err := c.Complete(ctx, 1, id, now); err != nil || again != nil {
+				t.Errorf("expected: no next occurrence, got: %+v, %v", again, err)
+			}
+			tasks, err := c.repo.GetDue(ctx, 1, MinDue, MaxDue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := 1
+			if next != nil {
+				expected = 2
+			}
+			if len(tasks) != expected {
+				t.Errorf("expected: %d tasks, got: %d", expected, len(tasks))
+			}
+		})
+	}
+}
+
+func TestOverdueAndPeriods(t *testing.T) {
+	ctx := context.Background()
+	c := New(memory.NewTaskRepository())
+	if tasks, err := c.Overdue(ctx, 1, day); err != nil || len(tasks) != 0 {
+		t.Errorf("user without tasks: expected: empty list, got: %v, %v", tasks, err)
+	}
+	ids := map[string]uint64{}
+	for title, due := range map[string]time.Time{
+		"b overdue":   day.AddDate(0, 0, -2),
+		"a overdue":   day.AddDate(0, 0, -2),
+		"done":        day.AddDate(0, 0, -1),
+		"today":       day,
+		"next week":   day.AddDate(0, 0, 7),
+		"other month": day.AddDate(0, 1, 0),
+	} {
+		id, err := c.Create(ctx, &model.Task{UserID: 1, Title: title, Due: due})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[title] = id
+	}
+	if _, err := c.Complete(ctx, 1, ids["done"], day); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		get      func() ([]*model.Task, error)
+		expected []string
+	}{
+		"overdue": {get: func() ([]*model.Task, error) { return c.Overdue(ctx, 1, day.Add(15*time.Hour)) }, expected: []string{"a overdue", "b overdue"}},
+		"day":     {get: func() ([]*model.Task, error) { return c.GetForDay(ctx, 1, day) }, expected: []string{"today"}},
+		"week":    {get: func() ([]*model.Task, error) { return c.GetForWeek(ctx, 1, day.AddDate(0, 0, -1)) }, expected: []string{"done", "today"}},
+		"month":   {get: func() ([]*model.Task, error) { return c.GetForMonth(ctx, 1, day) }, expected: []string{"today", "next week"}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			tasks, err := v.get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			titles := []string{}
+			for _, task := range tasks {
+				titles = append(titles, task.Title)
+			}
+			if len(titles) != len(v.expected) {
+				t.Fatalf("expected: %v, got: %v", v.expected, titles)
+			}
+			for i := range titles {
+				if titles[i] != v.expected[i] {
+					t.Errorf("expected: %v, got: %v", v.expected, titles)
+				}
+			}
+		})
+	}
+
+	if err := c.Update(ctx, &model.Task{ID: ids["done"], UserID: 1, Title: "renamed", Due: day}); err != nil {
+		t.Fatal(err)
+	}
+	if renamed, _ := c.repo.Get(ctx, 1, ids["done"]); !renamed.Done {
+		t.Errorf("expected: update keeps completion status, got: %+v", renamed)
+	}
+	if err := c.Delete(ctx, 2, ids["today"]); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("delete task of other user: expected: %v, got: %v", ErrTaskNotFound, err)
+	}
+}
@@ -0,0 +1,54 @@
+package task
+
+import (
+	"dev11/pkg/model"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits of Task fields
+const (
+	MaxTitleLength       = 100
+	MaxDescriptionLength = 2000
+)
+
+// Bounds of Task due date
+var (
+	MinDue = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+	MaxDue = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// ValidationError describes a field of Task which breaks validation rules
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func validate(t *model.Task) error {
+	t.Title = strings.TrimSpace(t.Title)
+	if t.Title == "" {
+		return &ValidationError{Field: "title", Reason: "must not be empty"}
+	}
+	if utf8.RuneCountInString(t.Title) > MaxTitleLength {
+		return &ValidationError{Field: "title", Reason: fmt.Sprintf("must be at most %d characters", MaxTitleLength)}
+	}
+	if utf8.RuneCountInString(t.Description) > MaxDescriptionLength {
+		return &ValidationError{Field: "description", Reason: fmt.Sprintf("must be at most %d characters", MaxDescriptionLength)}
+	}
+	t.Recurrence = model.Recurrence(strings.ToLower(strings.TrimSpace(string(t.Recurrence))))
+	switch t.Recurrence {
+	case model.RecurrenceNone, model.RecurrenceDaily, model.RecurrenceWeekly, model.RecurrenceMonthly:
+	default:
+		return &ValidationError{Field: "recurrence", Reason: fmt.Sprintf("unknown recurrence %q", t.Recurrence)}
+	}
+	if t.Due.Before(MinDue) || !t.Due.Before(MaxDue) {
+		return &ValidationError{Field: "due", Reason: fmt.Sprintf("must be between %s and %s", MinDue.Format("2006-01-02"), MaxDue.Format("2006-01-02"))}
+	}
+	return nil
+}
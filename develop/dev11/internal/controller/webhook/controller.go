@@ -0,0 +1,587 @@
+// Package webhook notifies users about changes of their events by HTTP requests to registered webhooks.
+// Notifications are put to an outbox which is saved to a JSON file on every change, so they survive restarts,
+// and are delivered through a separate queue of every webhook in order of changes: a failing webhook
+// delays only its own notifications. Failed deliveries are retried with exponential backoff
+// and dead-lettered after Config.MaxAttempts attempts.
+//
+// Deliveries are made at least once, receivers may use HeaderDelivery to skip duplicates.
+// Requests are signed with secret of webhook, see Sign.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"dev11/internal/controller/event"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of notifications
+const (
+	EventCreated = "event.created"
+	EventUpdated = "event.updated"
+	EventDeleted = "event.deleted"
+)
+
+// Headers of requests sent to webhooks
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// MaxWebhooks is a maximal number of webhooks of a user
+const MaxWebhooks = 10
+
+// Errors of Controller
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrInvalidURL       = errors.New("invalid webhook url")
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrTooManyWebhooks  = fmt.Errorf("at most %d webhooks allowed", MaxWebhooks)
+)
+
+// Config of deliveries, zero fields are replaced by fields of DefaultConfig
+type Config struct {
+	// MaxAttempts is a number of failed attempts after which delivery is dead-lettered
+	MaxAttempts int
+	// Backoff is a delay before the second attempt, it doubles after every failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout of a single request to webhook
+	Timeout time.Duration
+	// Interval between checks of deliveries due for retry
+	Interval time.Duration
+	// History is a number of finished deliveries kept for every webhook
+	History int
+}
+
+// DefaultConfig is used for zero fields of Config
+var DefaultConfig = Config{
+	MaxAttempts: 8,
+	Backoff:     10 * time.Second,
+	MaxBackoff:  time.Hour,
+	Timeout:     10 * time.Second,
+	Interval:    time.Second,
+	History:     50,
+}
+
+// Notification is a body of request sent to webhook, Event is the last known state of event for deletions
+type Notification struct {
+	ID      uint64       `json:"id"`
+	Type    string       `json:"type"`
+	Created time.Time    `json:"created"`
+	UserID  uint64       `json:"user_id"`
+	EventID uint64       `json:"event_id"`
+	Event   *model.Event `json:"event,omitempty"`
+}
+
+// Controller keeps webhooks of users with their deliveries and sends notifications
+type Controller struct {
+	cfg    Config
+	path   string
+	client *http.Client
+
+	m              sync.Mutex
+	nextWebhookID  uint64
+	nextDeliveryID uint64
+	webhooks       map[uint64]*model.Webhook
+	// queues contain pending deliveries of every webhook in order of changes
+	queues map[uint64][]*model.Delivery
+	// history contains finished deliveries of every webhook, the latest one is the last
+	history map[uint64][]*model.Delivery
+	// sending contains webhooks which deliveries are being sent
+	sending map[uint64]bool
+
+	inflight sync.WaitGroup
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// state is a content of file of Controller
+type state struct {
+	NextWebhookID  uint64            `json:"next_webhook_id"`
+	NextDeliveryID uint64            `json:"next_delivery_id"`
+	Webhooks       []*model.Webhook  `json:"webhooks"`
+	Deliveries     []*model.Delivery `json:"deliveries"`
+}
+
+// New creates Controller keeping webhooks and deliveries in file at path and returns pointer to it.
+// Controller listens to changes of events, notifications are sent after Start.
+func New(events *event.Controller, path string, cfg Config) (*Controller, error) {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultConfig.MaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = DefaultConfig.Backoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultConfig.MaxBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultConfig.Timeout
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultConfig.Interval
+	}
+	if cfg.History <= 0 {
+		cfg.History = DefaultConfig.History
+	}
+	c := &Controller{
+		cfg:  cfg,
+		path: path,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// redirects are failures, otherwise signed notifications could be forwarded anywhere
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		nextWebhookID:  1,
+		nextDeliveryID: 1,
+		webhooks:       map[uint64]*model.Webhook{},
+		queues:         map[uint64][]*model.Delivery{},
+		history:        map[uint64][]*model.Delivery{},
+		sending:        map[uint64]bool{},
+		wake:           make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var s state
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		c.nextWebhookID, c.nextDeliveryID = s.NextWebhookID, s.NextDeliveryID
+		for _, w := range s.Webhooks {
+			c.webhooks[w.ID] = w
+		}
+		sort.Slice(s.Deliveries, func(i, j int) bool { return s.Deliveries[i].ID < s.Deliveries[j].ID })
+		for _, d := range s.Deliveries {
+			if d.Status == model.DeliveryPending {
+				c.queues[d.WebhookID] = append(c.queues[d.WebhookID], d)
+			} else {
+				c.history[d.WebhookID] = append(c.history[d.WebhookID], d)
+			}
+		}
+	}
+	events.AddChangeListener(c)
+	return c, nil
+}
+
+// Create registers webhook of user notified about events of given types sent to rawURL
+// and returns it with generated secret
+func (c *Controller) Create(ctx context.Context, userID uint64, rawURL string, types []string) (*model.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return nil, ErrInvalidURL
+	}
+	for _, t := range types {
+		if t != EventCreated && t != EventUpdated && t != EventDeleted {
+			return nil, fmt.Errorf("%w %q", ErrUnknownEventType, t)
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	user := tenant.UserKey(ctx, userID)
+	if len(c.ofUser(user)) >= MaxWebhooks {
+		return nil, ErrTooManyWebhooks
+	}
+	w := &model.Webhook{
+		ID:       c.nextWebhookID,
+		TenantID: user.TenantID,
+		UserID:   userID,
+		URL:      u.String(),
+		Events:   types,
+		Secret:   hex.EncodeToString(secret),
+		Created:  time.Now().UTC(),
+	}
+	c.webhooks[w.ID] = w
+	c.nextWebhookID++
+	if err := c.save(); err != nil {
+		delete(c.webhooks, w.ID)
+		return nil, err
+	}
+	created := *w
+	return &created, nil
+}
+
+// List returns webhooks of user without their secrets
+func (c *Controller) List(ctx context.Context, userID uint64) []*model.Webhook {
+	c.m.Lock()
+	defer c.m.Unlock()
+	webhooks := c.ofUser(tenant.UserKey(ctx, userID))
+	for i, w := range webhooks {
+		listed := *w
+		listed.Secret = ""
+		webhooks[i] = &listed
+	}
+	return webhooks
+}
+
+// Delete removes webhook of user with its pending and finished deliveries
+func (c *Controller) Delete(ctx context.Context, userID, id uint64) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	w, ok := c.webhooks[id]
+	if !ok || owner(w) != tenant.UserKey(ctx, userID) {
+		return ErrWebhookNotFound
+	}
+	queue, history := c.queues[id], c.history[id]
+	delete(c.webhooks, id)
+	delete(c.queues, id)
+	delete(c.history, id)
+	if err := c.save(); err != nil {
+		c.webhooks[id], c.queues[id], c.history[id] = w, queue, history
+		return err
+	}
+	return nil
+}
+
+// Deliveries returns at most limit latest deliveries of webhook of user, the latest one is the first.
+// Both pending and finished deliveries are returned, finished ones are kept up to Config.History.
+func (c *Controller) Deliveries(ctx context.Context, userID, id uint64, limit int) ([]*model.Delivery, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if w, ok := c.webhooks[id]; !ok || owner(w) != tenant.UserKey(ctx, userID) {
+		return nil, ErrWebhookNotFound
+	}
+	all := append(append([]*model.Delivery{}, c.history[id]...), c.queues[id]...)
+	sort.Slice(all, func(i, j int) bool { return all[i].ID > all[j].ID })
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+	deliveries := make([]*model.Delivery, len(all))
+	for i, d := range all {
+		copied := *d
+		deliveries[i] = &copied
+	}
+	return deliveries, nil
+}
+
+// EventChanged implements event.ChangeListener, it puts notification about the change to queue
+// of every webhook of the user of the tenant subscribed to its type
+func (c *Controller) EventChanged(n event.ChangeNotice) {
+	t := "event." + string(n.Type)
+	now := time.Now().UTC()
+	c.m.Lock()
+	defer c.m.Unlock()
+	queued := false
+	for _, w := range c.ofUser(tenant.Key{TenantID: n.TenantID, UserID: n.UserID}) {
+		if !subscribed(w, t) {
+			continue
+		}
+		payload, err := json.Marshal(Notification{ID: c.nextDeliveryID, Type: t, Created: now, UserID: n.UserID, EventID: n.ID, Event: n.Event})
+		if err != nil {
+			log.Printf("webhook %d: %v", w.ID, err)
+			continue
+		}
+		next := now
+		c.queues[w.ID] = append(c.queues[w.ID], &model.Delivery{
+			ID:          c.nextDeliveryID,
+			WebhookID:   w.ID,
+			Type:        t,
+			EventID:     n.ID,
+			Status:      model.DeliveryPending,
+			Created:     now,
+			NextAttempt: &next,
+			Payload:     payload,
+		})
+		c.nextDeliveryID++
+		queued = true
+	}
+	if !queued {
+		return
+	}
+	if err := c.save(); err != nil {
+		log.Printf("webhook outbox: %v", err)
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ofUser returns webhooks of user ordered by id, c.m must be held
+func (c *Controller) ofUser(user tenant.Key) []*model.Webhook {
+	webhooks := []*model.Webhook{}
+	for _, w := range c.webhooks {
+		if owner(w) == user {
+			webhooks = append(webhooks, w)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks
+}
+
+func subscribed(w *model.Webhook, t string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes webhooks and deliveries to file of Controller, c.m must be held.
+// The file contains secrets of webhooks, so only owner may read it.
+func (c *Controller) save() error {
+	s := state{NextWebhookID: c.nextWebhookID, NextDeliveryID: c.nextDeliveryID, Webhooks: c.ofAll(), Deliveries: []*model.Delivery{}}
+	for _, w := range s.Webhooks {
+		s.Deliveries = append(s.Deliveries, c.history[w.ID]...)
+		s.Deliveries = append(s.Deliveries, c.queues[w.ID]...)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// owner returns Key of user who registered webhook
+func owner(w *model.Webhook) tenant.Key {
+	return tenant.Key{TenantID: w.TenantID, UserID: w.UserID}
+}
+
+// ofAll returns all webhooks ordered by id, c.m must be held
+func (c *Controller) ofAll() []*model.Webhook {
+	webhooks := make([]*model.Webhook, 0, len(c.webhooks))
+	for _, w := range c.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks
+}
+
+// result of delivery to a webhook
+type result struct {
+	delivered int
+	err       error
+}
+
+// Tick sends deliveries of all webhooks which are due at moment now and waits for them.
+// It returns number of delivered notifications and the last error of failed deliveries.
+func (c *Controller) Tick(ctx context.Context, now time.Time) (int, error) {
+	n := 0
+	var lastErr error
+	for r := range c.dispatch(ctx, now) {
+		n += r.delivered
+		if r.err != nil {
+			lastErr = r.err
+		}
+	}
+	return n, lastErr
+}
+
+// dispatch starts delivery to every webhook with due deliveries which is not being delivered already.
+// Results are sent to returned channel which is closed when all deliveries are done.
+func (c *Controller) dispatch(ctx context.Context, now time.Time) <-chan result {
+	c.m.Lock()
+	var due []uint64
+	for id, q := range c.queues {
+		if len(q) > 0 && !c.sending[id] && !q[0].NextAttempt.After(now) {
+			due = append(due, id)
+			c.sending[id] = true
+		}
+	}
+	c.m.Unlock()
+	results := make(chan result, len(due))
+	var wg sync.WaitGroup
+	for _, id := range due {
+		wg.Add(1)
+		c.inflight.Add(1)
+		go func(id uint64) {
+			defer c.inflight.Done()
+			defer wg.Done()
+			n, err := c.drain(ctx, id, now)
+			results <- result{delivered: n, err: err}
+		}(id)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// drain sends due deliveries of webhook one by one until queue is empty or a delivery fails
+// and returns number of delivered notifications
+func (c *Controller) drain(ctx context.Context, id uint64, now time.Time) (int, error) {
+	defer func() {
+		c.m.Lock()
+		delete(c.sending, id)
+		c.m.Unlock()
+	}()
+	n := 0
+	var lastErr error
+	for {
+		c.m.Lock()
+		w, q := c.webhooks[id], c.queues[id]
+		if w == nil || len(q) == 0 || q[0].NextAttempt.After(now) {
+			c.m.Unlock()
+			return n, lastErr
+		}
+		d := q[0]
+		target, secret := w.URL, w.Secret
+		c.m.Unlock()
+
+		status, err := c.send(ctx, target, secret, d)
+		if ctx.Err() != nil {
+			// attempts interrupted by shutdown are not counted
+			return n, ctx.Err()
+		}
+
+		c.m.Lock()
+		// webhook could be deleted while the delivery was sent
+		if c.webhooks[id] == nil {
+			c.m.Unlock()
+			return n, lastErr
+		}
+		d.Attempts++
+		d.LastStatus = status
+		d.LastError = ""
+		switch {
+		case err == nil:
+			c.finish(id, model.DeliveryDelivered, now)
+			n++
+		case d.Attempts >= c.cfg.MaxAttempts:
+			d.LastError = err.Error()
+			c.finish(id, model.DeliveryDead, now)
+			lastErr = fmt.Errorf("webhook %d: delivery %d dead after %d attempts: %w", id, d.ID, d.Attempts, err)
+		default:
+			d.LastError = err.Error()
+			next := now.Add(c.backoff(d.Attempts))
+			d.NextAttempt = &next
+			lastErr = fmt.Errorf("webhook %d: delivery %d: %w", id, d.ID, err)
+		}
+		saveErr := c.save()
+		retry := d.Status == model.DeliveryPending
+		c.m.Unlock()
+		if saveErr != nil {
+			return n, saveErr
+		}
+		if retry {
+			return n, lastErr
+		}
+	}
+}
+
+// finish moves the first delivery of webhook from its queue to its history, c.m must be held
+func (c *Controller) finish(id uint64, status model.DeliveryStatus, now time.Time) {
+	d := c.queues[id][0]
+	c.queues[id] = c.queues[id][1:]
+	if len(c.queues[id]) == 0 {
+		delete(c.queues, id)
+	}
+	finished := now
+	d.Status, d.NextAttempt, d.Finished = status, nil, &finished
+	history := append(c.history[id], d)
+	if len(history) > c.cfg.History {
+		history = append([]*model.Delivery(nil), history[len(history)-c.cfg.History:]...)
+	}
+	c.history[id] = history
+}
+
+// backoff returns delay after given number of failed attempts
+func (c *Controller) backoff(attempts int) time.Duration {
+	d := c.cfg.Backoff
+	for i := 1; i < attempts && d < c.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.cfg.MaxBackoff {
+		return c.cfg.MaxBackoff
+	}
+	return d
+}
+
+// send posts payload of delivery to webhook and returns status code of response.
+// Responses with status other than 2xx are failures.
+func (c *Controller) send(ctx context.Context, target, secret string, d *model.Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dev11-webhook")
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(d.ID, 10))
+	req.Header.Set(HeaderEvent, d.Type)
+	req.Header.Set(HeaderSignature, Sign(secret, time.Now(), d.Payload))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Start sends deliveries in background: new notifications are sent immediately,
+// retries are checked every Config.Interval
+func (c *Controller) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.stop
+		cancel()
+	}()
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			results := c.dispatch(ctx, time.Now())
+			go func() {
+				for r := range results {
+					if r.err != nil && !errors.Is(r.err, context.Canceled) {
+						log.Printf("webhook: %v", r.err)
+					}
+				}
+			}()
+			select {
+			case <-ticker.C:
+			case <-c.wake:
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops Controller, deliveries in progress are interrupted and retried after the next Start
+func (c *Controller) Stop() {
+	close(c.stop)
+	<-c.done
+	c.inflight.Wait()
+}
@@ -0,0 +1,62 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned by Verify for requests not signed with the secret
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns value of HeaderSignature for body sent at moment t: "t=<unix time>,v1=<hex>",
+// where v1 is HMAC-SHA256 of "<unix time>.<body>" keyed with secret of webhook.
+// Signed time lets receivers reject replayed requests.
+func Sign(secret string, t time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), signature(secret, t.Unix(), body))
+}
+
+// Verify checks value of HeaderSignature of body received at moment now,
+// signatures made more than tolerance away from now are rejected
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = t
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(timestamp, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("%w: signed at %s", ErrInvalidSignature, time.Unix(timestamp, 0).UTC().Format(time.RFC3339))
+	}
+	expected := signature(secret, timestamp, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	signed := time.Unix(1715767200, 0)
+	body := []byte(`{"id":1}`)
+	header := Sign("secret", signed, body)
+	tests := map[string]struct {
+		secret string
+		header string
+		body   string
+		now    time.Time
+		valid  bool
+	}{
+		"valid":            {secret: "secret", header: header, body: `{"id":1}`, now: signed, valid: true},
+		"within tolerance": {secret: "secret", header: header, body: `{"id":1}`, now: signed.Add(5 * time.Minute), valid: true},
+		"rotated secret":   {secret: "secret", header: header + ",v1=00", body: `{"id":1}`, now: signed, valid: true},
+		"other secret":     {secret: "other", header: header, body: `{"id":1}`, now: signed},
+		"changed body":     {secret: "secret", header: header, body: `{"id":2}`, now: signed},
+		"replayed":         {secret: "secret", header: header, body: `{"id":1}`, now: signed.Add(6 * time.Minute)},
+		"future":           {secret: "secret", header: header, body: `{"id":1}`, now: signed.Add(-6 * time.Minute)},
+		"no timestamp":     {secret: "secret", header: header[len("t=1715767200,"):], body: `{"id":1}`, now: signed},
+		"empty":            {secret: "secret", body: `{"id":1}`, now: signed},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			err := Verify(v.secret, v.header, []byte(v.body), v.now, 5*time.Minute)
+			if (err == nil) != v.valid || err != nil && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("expected: valid %v, got: %v", v.valid, err)
+			}
+		})
+	}
+}
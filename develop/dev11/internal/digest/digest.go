@@ -0,0 +1,119 @@
+// Package digest builds agendas of users for a day or a week, renders them to plain text, Markdown and HTML
+// and delivers them by email on schedule.
+package digest
+
+import (
+	"context"
+	"dev11/internal/controller/event"
+	"dev11/internal/controller/task"
+	"dev11/pkg/model"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Period of Digest
+type Period string
+
+// Periods of digests
+const (
+	Daily  Period = "daily"
+	Weekly Period = "weekly"
+)
+
+// ErrUnknownPeriod is returned for periods other than Daily and Weekly
+var ErrUnknownPeriod = errors.New("unknown period")
+
+// Digest is an agenda of user for a day or a week starting from From,
+// Days contain only days with events or tasks and Overdue contains tasks which are not done before From
+type Digest struct {
+	UserID  uint64
+	Period  Period
+	From    time.Time
+	To      time.Time
+	Days    []Day
+	Overdue []*model.Task
+}
+
+// Day is a part of Digest with events and tasks of a date
+type Day struct {
+	Date   time.Time
+	Events []*model.Event
+	Tasks  []*model.Task
+}
+
+// Empty reports whether user has nothing to do in Digest
+func (d *Digest) Empty() bool {
+	return len(d.Days) == 0 && len(d.Overdue) == 0
+}
+
+// Builder builds digests from events and tasks of users
+type Builder struct {
+	events *event.Controller
+	tasks  *task.Controller
+}
+
+// NewBuilder creates Builder provided with controllers of events and tasks and returns pointer to it
+func NewBuilder(events *event.Controller, tasks *task.Controller) *Builder {
+	return &Builder{events: events, tasks: tasks}
+}
+
+// Build returns Digest of user for a period starting from date, user without events has an empty Digest
+func (b *Builder) Build(ctx context.Context, userID uint64, period Period, date time.Time) (*Digest, error) {
+	from := truncate(date)
+	d := &Digest{UserID: userID, Period: period, From: from}
+	var getEvents func(context.Context, uint64, time.Time, event.Filter) ([]*model.Event, error)
+	var getTasks func(context.Context, uint64, time.Time) ([]*model.Task, error)
+	switch period {
+	case Daily:
+		d.To, getEvents, getTasks = from.AddDate(0, 0, 1), b.events.GetForDay, b.tasks.GetForDay
+	case Weekly:
+		d.To, getEvents, getTasks = from.AddDate(0, 0, 7), b.events.GetForWeek, b.tasks.GetForWeek
+	default:
+		return nil, ErrUnknownPeriod
+	}
+	events, err := getEvents(ctx, userID, from, event.Filter{})
+	if err != nil && !errors.Is(err, event.ErrUserNotFound) {
+		return nil, err
+	}
+	tasks, err := getTasks(ctx, userID, from)
+	if err != nil {
+		return nil, err
+	}
+	if d.Overdue, err = b.tasks.Overdue(ctx, userID, from); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Date.Equal(events[j].Date) {
+			return events[i].Date.Before(events[j].Date)
+		}
+		return events[i].Title < events[j].Title
+	})
+	days := map[time.Time]*Day{}
+	day := func(t time.Time) *Day {
+		t = truncate(t)
+		if days[t] == nil {
+			days[t] = &Day{Date: t}
+		}
+		return days[t]
+	}
+	for _, e := range events {
+		dd := day(e.Date)
+		dd.Events = append(dd.Events, e)
+	}
+	for _, t := range tasks {
+		dd := day(t.Due)
+		dd.Tasks = append(dd.Tasks, t)
+	}
+	for t := from; t.Before(d.To); t = t.AddDate(0, 0, 1) {
+		if days[t] != nil {
+			d.Days = append(d.Days, *days[t])
+		}
+	}
+	return d, nil
+}
+
+func truncate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
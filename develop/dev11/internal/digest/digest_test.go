@@ -0,0 +1,377 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"dev11/internal/controller/event"
+	"dev11/internal/controller/task"
+	"dev11/internal/repository/memory"
+	"dev11/pkg/model"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// day is Wednesday
+var day = time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC)
+
+func newBuilder(t *testing.T) *Builder {
+	ctx := context.Background()
+	events := event.New(memory.New())
+	tasks := task.New(memory.NewTaskRepository())
+	for _, e := range []*model.Event{
+		{UserID: 1, Title: "Standup", Date: day.Add(10 * time.Hour), Duration: 30},
+		{UserID: 1, Title: "Release *v2* <script>", Date: day, Location: "Room 4", Category: "work"},
+		{UserID: 1, Title: "Lunch", Date: day.Add(13 * time.Hour)},
+		{UserID: 1, Title: "Retro", Date: day.AddDate(0, 0, 2).Add(16 * time.Hour), Duration: 60},
+		{UserID: 1, Title: "Next week", Date: day.AddDate(0, 0, 7)},
+	} {
+		if _, err := events.Create(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, task := range []*model.Task{
+		{UserID: 1, Title: "Report", Due: day},
+		{UserID: 1, Title: "Taxes", Due: day.AddDate(0, 0, -5)},
+		{UserID: 3, Title: "Only task", Due: day},
+	} {
+		if _, err := tasks.Create(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewBuilder(events, tasks)
+}
+
+func TestBuild(t *testing.T) {
+	b := newBuilder(t)
+	tests := map[string]struct {
+		userID  uint64
+		period  Period
+		date    time.Time
+		days    []string
+		overdue int
+		err     error
+	}{
+		"daily":          {userID: 1, period: Daily, date: day.Add(9 * time.Hour), days: []string{"2024-05-15: Release *v2* <script>, Standup, Lunch, Report"}, overdue: 1},
+		"weekly":         {userID: 1, period: Weekly, date: day, days: []string{"2024-05-15: Release *v2* <script>, Standup, Lunch, Report", "2024-05-17: Retro"}, overdue: 1},
+		"empty day":      {userID: 1, period: Daily, date: day.AddDate(0, 0, 1), overdue: 2},
+		"unknown user":   {userID: 2, period: Weekly, date: day},
+		"only tasks":     {userID: 3, period: Daily, date: day, days: []string{"2024-05-15: Only task"}},
+		"unknown period": {userID: 1, period: "yearly", date: day, err: ErrUnknownPeriod},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			d, err := b.Build(context.Background(), v.userID, v.period, v.date)
+			if err != v.err {
+				t.Fatalf("expected: %v, got: %v", v.err, err)
+			}
+			if err != nil {
+				return
+			}
+			days := []string{}
+			for _, dd := range d.Days {
+				titles := []string{}
+				for _, e := range dd.Events {
+					titles = append(titles, e.Title)
+				}
+				for _, task := range dd.Tasks {
+					titles = append(titles, task.Title)
+				}
+				days = append(days, dd.Date.Format("2006-01-02")+": "+strings.Join(titles, ", "))
+			}
+			if strings.Join(days, "\n") != strings.Join(v.days, "\n") {
+				t.Errorf("expected: %q, got: %q", v.days, days)
+			}
+			if len(d.Overdue) != v.overdue {
+				t.Errorf("expected: %d overdue tasks, got: %d", v.overdue, len(d.Overdue))
+			}
+			if d.Empty() != (len(v.days) == 0 && v.overdue == 0) {
+				t.Errorf("expected: empty %v, got: %v", !d.Empty(), d.Empty())
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	b := newBuilder(t)
+	d, err := b.Build(context.Background(), 1, Weekly, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := b.Build(context.Background(), 2, Daily, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		format   Format
+		digest   *Digest
+		contains []string
+		excludes []string
+		err      error
+	}{
+		"text": {format: Text, digest: d, contains: []string{
+			"Agenda for May 15 – May 21, 2024\n",
+			"\nWednesday, May 15\n  all day  Release *v2* <script> (Room 4)\n  10:00–10:30  Standup\n  13:00  Lunch\n  [ ] Report\n",
+			"\nFriday, May 17\n  16:00–17:00  Retro\n",
+			"\nOverdue\n  [ ] Taxes (due Friday, May 10)\n",
+		}, excludes: []string{"Nothing planned"}},
+		"markdown": {format: Markdown, digest: d, contains: []string{
+			"# Agenda for May 15 – May 21, 2024\n",
+			"## Wednesday, May 15\n\n- **all day** Release \\*v2\\* &lt;script> _(Room 4)_\n- **10:00–10:30** Standup\n",
+			"- [ ] Report\n",
+			"## Overdue\n\n- [ ] Taxes (due Friday, May 10)\n",
+		}},
+		"html": {format: HTML, digest: d, contains: []string{
+			"<h1>Agenda for May 15 – May 21, 2024</h1>",
+			"<li><b>all day</b> <span style=\"color: #1e88e5\">&#9679;</span> Release *v2* &lt;script&gt; <i>(Room 4)</i></li>",
+			"<li><b>10:00–10:30</b> Standup</li>",
+			"<li><input type=\"checkbox\" disabled> Taxes (due Friday, May 10)</li>",
+		}, excludes: []string{"<script>"}},
+		"empty text":     {format: Text, digest: empty, contains: []string{"Agenda for Wednesday, May 15, 2024\n\nNothing planned.\n"}, excludes: []string{"Overdue"}},
+		"empty html":     {format: HTML, digest: empty, contains: []string{"<p>Nothing planned.</p>"}},
+		"unknown format": {format: "pdf", digest: d, err: ErrUnknownFormat},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			var b bytes.Buffer
+			err := Render(&b, v.format, v.digest)
+			if err != v.err {
+				t.Fatalf("expected: %v, got: %v", v.err, err)
+			}
+			for _, s := range v.contains {
+				if !strings.Contains(b.String(), s) {
+					t.Errorf("expected: %q in %q", s, b.String())
+				}
+			}
+			for _, s := range v.excludes {
+				if strings.Contains(b.String(), s) {
+					t.Errorf("expected: no %q in %q", s, b.String())
+				}
+			}
+		})
+	}
+}
+
+// smtpServer is a fake SMTP server which accepts every message
+type smtpServer struct {
+	l        net.Listener
+	m        sync.Mutex
+	messages []*mail.Message
+	rcpts    [][]string
+	fail     bool
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{l: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ESMTP")
+	var rcpts []string
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			s.m.Lock()
+			fail := s.fail
+			s.m.Unlock()
+			if fail {
+				c.PrintfLine("451 try again later")
+				continue
+			}
+			rcpts = nil
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			rcpts = append(rcpts, strings.Trim(strings.TrimPrefix(line[4:], " TO:"), "<>"))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(data))
+			if err != nil {
+				c.PrintfLine("554 invalid message")
+				continue
+			}
+			s.m.Lock()
+			s.messages = append(s.messages, msg)
+			s.rcpts = append(s.rcpts, rcpts)
+			s.m.Unlock()
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) received() ([]*mail.Message, [][]string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.messages, s.rcpts
+}
+
+// textPart returns decoded plain text alternative of message
+func textPart(t *testing.T, msg *mail.Message) string {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected: multipart/alternative, got: %s, %v", mediaType, err)
+	}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	types := []string{}
+	text := ""
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType := part.Header.Get("Content-Type")
+		types = append(types, contentType)
+		if strings.HasPrefix(contentType, "text/plain") {
+			text = string(data)
+		}
+	}
+	if strings.Join(types, ",") != "text/plain; charset=utf-8,text/html; charset=utf-8" {
+		t.Errorf("expected: text and html alternatives, got: %v", types)
+	}
+	return text
+}
+
+func TestScheduler(t *testing.T) {
+	server := newSMTPServer(t)
+	subs := []Subscription{
+		{UserID: 1, Email: "alice@example.com", TimeZone: "Europe/Moscow", Period: Daily, Hour: 8},
+		{UserID: 2, Email: "bob@example.com", TimeZone: "America/New_York", Period: Daily, Hour: 8, SkipEmpty: true},
+		{UserID: 1, Email: "alice@example.com", TimeZone: "Asia/Tokyo", Period: Weekly, Hour: 7},
+		// user with the same id in other tenant has nothing to do
+		{TenantID: 7, UserID: 1, Email: "carol@example.com", TimeZone: "Europe/Moscow", Period: Daily, Hour: 8, SkipEmpty: true},
+	}
+	s, err := NewScheduler(newBuilder(t), SMTPSender{Addr: server.l.Addr().String()}, "calendar@example.com", subs, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	steps := []struct {
+		now      time.Time
+		sent     int
+		subjects []string
+	}{
+		// 07:59 in Moscow
+		{now: time.Date(2024, time.May, 15, 4, 59, 0, 0, time.UTC)},
+		// 08:30 in Moscow, 01:30 in New York, Wednesday in Tokyo
+		{now: time.Date(2024, time.May, 15, 5, 30, 0, 0, time.UTC), sent: 1, subjects: []string{"Agenda for Wednesday, May 15, 2024"}},
+		// already sent today
+		{now: time.Date(2024, time.May, 15, 12, 0, 0, 0, time.UTC)},
+		// 08:00 in New York, but bob has nothing to do
+		{now: time.Date(2024, time.May, 15, 20, 0, 0, 0, time.UTC)},
+		// the next day in Moscow
+		{now: time.Date(2024, time.May, 16, 5, 0, 0, 0, time.UTC), sent: 1, subjects: []string{"Agenda for Thursday, May 16, 2024"}},
+		// 07:00 of Monday in Tokyo while it is Sunday in Moscow
+		{now: time.Date(2024, time.May, 19, 22, 0, 0, 0, time.UTC), sent: 1, subjects: []string{"Agenda for May 20 – May 26, 2024"}},
+	}
+	total := 0
+	for i, step := range steps {
+		n, err := s.Tick(ctx, step.now)
+		if err != nil || n != step.sent {
+			t.Fatalf("step %d: expected: %d sent, got: %d, %v", i, step.sent, n, err)
+		}
+		messages, rcpts := server.received()
+		total += n
+		if len(messages) != total {
+			t.Fatalf("step %d: expected: %d messages, got: %d", i, total, len(messages))
+		}
+		for j, subject := range step.subjects {
+			msg := messages[total-len(step.subjects)+j]
+			decoded, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			if decoded != subject {
+				t.Errorf("step %d: expected: %q, got: %q", i, subject, decoded)
+			}
+			if to := rcpts[total-len(step.subjects)+j]; len(to) != 1 || to[0] != "alice@example.com" {
+				t.Errorf("step %d: expected: alice@example.com, got: %v", i, to)
+			}
+		}
+	}
+
+	messages, _ := server.received()
+	if text := textPart(t, messages[0]); !strings.Contains(text, "  10:00–10:30  Standup\n") || !strings.Contains(text, "[ ] Taxes") {
+		t.Errorf("unexpected digest: %q", text)
+	}
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	server := newSMTPServer(t)
+	server.fail = true
+	subs := []Subscription{{UserID: 1, Email: "alice@example.com", TimeZone: "UTC", Period: Daily, Hour: 8}}
+	s, err := NewScheduler(newBuilder(t), SMTPSender{Addr: server.l.Addr().String()}, "calendar@example.com", subs, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := day.Add(9 * time.Hour)
+	if n, err := s.Tick(context.Background(), now); err == nil || n != 0 {
+		t.Errorf("failing server: expected: error, got: %d, %v", n, err)
+	}
+	server.m.Lock()
+	server.fail = false
+	server.m.Unlock()
+	if n, err := s.Tick(context.Background(), now.Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("retry: expected: 1 sent, got: %d, %v", n, err)
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	tests := map[string]Subscription{
+		"unknown time zone": {UserID: 1, Email: "alice@example.com", TimeZone: "Mars/Olympus", Period: Daily},
+		"unknown period":    {UserID: 1, Email: "alice@example.com", Period: "hourly"},
+		"invalid hour":      {UserID: 1, Email: "alice@example.com", Period: Daily, Hour: 24},
+		"no email":          {UserID: 1, Period: Daily},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if _, err := NewScheduler(nil, nil, "", []Subscription{v}, time.Minute); err == nil {
+				t.Errorf("expected: error, got: nil")
+			}
+		})
+	}
+}
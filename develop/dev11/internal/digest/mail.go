@@ -0,0 +1,61 @@
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// Sender delivers email messages
+type Sender interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// SMTPSender is a Sender which delivers messages to SMTP server at Addr, Auth may be nil
+type SMTPSender struct {
+	Addr string
+	Auth smtp.Auth
+}
+
+// Send delivers message to SMTP server
+func (s SMTPSender) Send(from string, to []string, msg []byte) error {
+	return smtp.SendMail(s.Addr, s.Auth, from, to, msg)
+}
+
+// Message returns email message sent at moment now with Digest rendered as plain text and HTML alternatives
+func Message(from, to string, d *Digest, now time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title(d)))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	// the last alternative is the preferred one
+	for _, f := range []Format{Text, HTML} {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {ContentType(f)},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if err := Render(qp, f, d); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
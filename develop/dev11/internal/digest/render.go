@@ -0,0 +1,94 @@
+package digest
+
+import (
+	"dev11/pkg/model"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Format of rendered Digest
+type Format string
+
+// Formats of digests
+const (
+	Text     Format = "text"
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+)
+
+// ErrUnknownFormat is returned for formats other than Text, Markdown and HTML
+var ErrUnknownFormat = errors.New("unknown format")
+
+//go:embed templates
+var templates embed.FS
+
+var funcs = map[string]interface{}{
+	"title": title,
+	"date":  func(t time.Time) string { return t.Format("Monday, January 2") },
+	"clock": clock,
+	"md":    escapeMarkdown,
+}
+
+var (
+	textTemplate     = template.Must(template.New("agenda.txt.tmpl").Funcs(funcs).ParseFS(templates, "templates/agenda.txt.tmpl"))
+	markdownTemplate = template.Must(template.New("agenda.md.tmpl").Funcs(funcs).ParseFS(templates, "templates/agenda.md.tmpl"))
+	htmlTemplate     = htmltemplate.Must(htmltemplate.New("agenda.html.tmpl").Funcs(funcs).ParseFS(templates, "templates/agenda.html.tmpl"))
+)
+
+// ContentType returns media type of Digest rendered in Format
+func ContentType(f Format) string {
+	switch f {
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Render writes Digest in Format to w
+func Render(w io.Writer, f Format, d *Digest) error {
+	switch f {
+	case Text:
+		return textTemplate.Execute(w, d)
+	case Markdown:
+		return markdownTemplate.Execute(w, d)
+	case HTML:
+		return htmlTemplate.Execute(w, d)
+	}
+	return ErrUnknownFormat
+}
+
+// title returns heading of Digest
+func title(d *Digest) string {
+	if d.Period == Weekly {
+		last := d.To.AddDate(0, 0, -1)
+		return fmt.Sprintf("Agenda for %s – %s", d.From.Format("January 2"), last.Format("January 2, 2006"))
+	}
+	return "Agenda for " + d.From.Format("Monday, January 2, 2006")
+}
+
+// clock returns time span of Event or "all day" for events without time of day
+func clock(e *model.Event) string {
+	if e.Date.Hour() == 0 && e.Date.Minute() == 0 && e.Duration == 0 {
+		return "all day"
+	}
+	start := e.Date.Format("15:04")
+	if e.Duration == 0 {
+		return start
+	}
+	return start + "–" + e.Date.Add(time.Duration(e.Duration)*time.Minute).Format("15:04")
+}
+
+var markdownReplacer = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", "&lt;", "#", `\#`)
+
+// escapeMarkdown escapes characters of s which have special meaning in Markdown
+func escapeMarkdown(s string) string {
+	return markdownReplacer.Replace(s)
+}
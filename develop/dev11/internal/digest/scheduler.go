@@ -0,0 +1,165 @@
+package digest
+
+import (
+	"context"
+	"dev11/internal/tenant"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Subscription is a request of user to receive Digest by email every day, or every Monday for Weekly period,
+// at Hour of his time zone. Empty digests are not sent if SkipEmpty is true.
+// TenantID is a tenant of user, it is zero for the default tenant.
+type Subscription struct {
+	TenantID  uint64 `json:"tenant_id,omitempty"`
+	UserID    uint64 `json:"user_id"`
+	Email     string `json:"email"`
+	TimeZone  string `json:"time_zone"`
+	Period    Period `json:"period"`
+	Hour      int    `json:"hour"`
+	SkipEmpty bool   `json:"skip_empty"`
+}
+
+// LoadSubscriptions reads JSON array of subscriptions from file
+func LoadSubscriptions(path string) ([]Subscription, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return subs, nil
+}
+
+// Scheduler sends digests to subscribed users
+type Scheduler struct {
+	b        *Builder
+	sender   Sender
+	from     string
+	subs     []Subscription
+	locs     []*time.Location
+	interval time.Duration
+
+	m sync.Mutex
+	// sent contains local date of the last Digest sent for every subscription
+	sent []time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewScheduler creates Scheduler which checks subscriptions every interval and sends digests built by b
+// with sender from address from and returns pointer to it
+func NewScheduler(b *Builder, sender Sender, from string, subs []Subscription, interval time.Duration) (*Scheduler, error) {
+	s := &Scheduler{
+		b:        b,
+		sender:   sender,
+		from:     from,
+		subs:     subs,
+		interval: interval,
+		sent:     make([]time.Time, len(subs)),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for i, sub := range subs {
+		loc, err := time.LoadLocation(sub.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("subscription %d: %w", i, err)
+		}
+		if sub.Period != Daily && sub.Period != Weekly {
+			return nil, fmt.Errorf("subscription %d: %w %q", i, ErrUnknownPeriod, sub.Period)
+		}
+		if sub.Hour < 0 || sub.Hour > 23 {
+			return nil, fmt.Errorf("subscription %d: invalid hour %d", i, sub.Hour)
+		}
+		if sub.Email == "" {
+			return nil, fmt.Errorf("subscription %d: empty email", i)
+		}
+		s.locs = append(s.locs, loc)
+	}
+	return s, nil
+}
+
+// Tick sends digests which are due at moment now and returns number of sent digests.
+// Digest is due once a day at or after Hour of Subscription in its time zone, failed digests are retried on next Tick.
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	n := 0
+	var lastErr error
+	for i, sub := range s.subs {
+		local := now.In(s.locs[i])
+		today := truncate(local)
+		if local.Hour() < sub.Hour || !s.sent[i].Before(today) || sub.Period == Weekly && local.Weekday() != time.Monday {
+			continue
+		}
+		sent, err := s.send(ctx, sub, today, now)
+		if err != nil {
+			lastErr = fmt.Errorf("digest for user %d: %w", sub.UserID, err)
+			continue
+		}
+		s.sent[i] = today
+		if sent {
+			n++
+		}
+	}
+	return n, lastErr
+}
+
+// send sends Digest starting from date and reports whether it wasn't skipped
+func (s *Scheduler) send(ctx context.Context, sub Subscription, date, now time.Time) (bool, error) {
+	d, err := s.b.Build(tenant.NewContext(ctx, sub.TenantID), sub.UserID, sub.Period, date)
+	if err != nil {
+		return false, err
+	}
+	if d.Empty() && sub.SkipEmpty {
+		return false, nil
+	}
+	msg, err := Message(s.from, sub.Email, d, now)
+	if err != nil {
+		return false, err
+	}
+	return true, s.sender.Send(s.from, []string{sub.Email}, msg)
+}
+
+// Start runs Scheduler in background, the first check is done immediately
+func (s *Scheduler) Start() {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.tick()
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops Scheduler and waits for the current check to finish
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Scheduler) tick() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+	n, err := s.Tick(ctx, time.Now())
+	if err != nil {
+		log.Printf("digest: sent %d digests: %v", n, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("digest: sent %d digests", n)
+	}
+}
@@ -0,0 +1,694 @@
+// Package caldav serves events of users over a subset of CalDAV (RFC 4791):
+// PROPFIND of principal, calendar and events, REPORT calendar-query, calendar-multiget
+// and sync-collection (RFC 6578), GET, PUT and DELETE of events with ETags.
+//
+// Every user has a principal at {prefix}{user_id}/ which is also his calendar home,
+// the only calendar at {prefix}{user_id}/events/ and events at {prefix}{user_id}/events/{uid}.ics.
+package caldav
+
+import (
+	"context"
+	"dev11/internal/controller/event"
+	"dev11/internal/ical"
+	"dev11/internal/identity"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBodySize is a maximum size of request body
+const MaxBodySize = 1 << 20
+
+// StatusClientClosedRequest is a non-standard status code for requests cancelled by client
+const StatusClientClosedRequest = 499
+
+// calendarName is a name of the only calendar collection of user
+const calendarName = "events"
+
+const syncTokenPrefix = "http://dev11/ns/sync/"
+
+// Preconditions of CalDAV and WebDAV
+var (
+	condValidCalendarData      = xml.Name{Space: nsCalDAV, Local: "valid-calendar-data"}
+	condSupportedComponent     = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component"}
+	condSupportedFilter        = xml.Name{Space: nsCalDAV, Local: "supported-filter"}
+	condValidSyncToken         = xml.Name{Space: nsDAV, Local: "valid-sync-token"}
+	condSupportedReport        = xml.Name{Space: nsDAV, Local: "supported-report"}
+	condQuotaNotExceeded       = xml.Name{Space: nsDAV, Local: "quota-not-exceeded"}
+	reportCalendarQuery        = xml.Name{Space: nsCalDAV, Local: "calendar-query"}
+	reportCalendarMultiget     = xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}
+	reportSyncCollection       = xml.Name{Space: nsDAV, Local: "sync-collection"}
+	errInvalidPath             = errors.New("invalid path")
+	errUnsupportedFilter       = errors.New("unsupported filter")
+	errResourceNameMismatch    = errors.New("resource name must be UID of event followed by .ics")
+	errMultipleEventsPerObject = errors.New("calendar object must contain exactly one event")
+)
+
+// Handler processes CalDAV requests to calendars of users
+type Handler struct {
+	ctrl    *event.Controller
+	tenants *tenant.Directory
+	prefix  string
+	// epoch distinguishes sync tokens of different runs of the server, sequence numbers start from 1 on every run
+	epoch int64
+}
+
+// New creates Handler serving calendars under prefix with provided Controller and returns pointer to it.
+// Admins of organisations in Directory may read calendars of their members, Directory may be nil.
+func New(ctrl *event.Controller, prefix string, tenants *tenant.Directory) *Handler {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Handler{ctrl: ctrl, tenants: tenants, prefix: prefix, epoch: time.Now().UnixNano()}
+}
+
+// target is a resource addressed by request path
+type target struct {
+	userID   uint64
+	calendar bool
+	name     string
+}
+
+// parsePath parses escaped path of request, so names of events may contain slashes
+func (h *Handler) parsePath(path string) (target, error) {
+	rest := strings.TrimPrefix(path, h.prefix)
+	if rest == path {
+		return target{}, errInvalidPath
+	}
+	parts := strings.Split(rest, "/")
+	userID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || userID == 0 {
+		return target{}, errInvalidPath
+	}
+	t := target{userID: userID}
+	switch {
+	case len(parts) == 1 || len(parts) == 2 && parts[1] == "":
+		return t, nil
+	case parts[1] != calendarName:
+		return target{}, errInvalidPath
+	case len(parts) == 2 || len(parts) == 3 && parts[2] == "":
+		t.calendar = true
+		return t, nil
+	case len(parts) == 3 && strings.HasSuffix(parts[2], ".ics"):
+		name, err := url.PathUnescape(parts[2])
+		if err != nil {
+			return target{}, errInvalidPath
+		}
+		t.calendar, t.name = true, name
+		return t, nil
+	}
+	return target{}, errInvalidPath
+}
+
+func (h *Handler) principalPath(userID uint64) string {
+	return fmt.Sprintf("%s%d/", h.prefix, userID)
+}
+
+func (h *Handler) calendarPath(userID uint64) string {
+	return fmt.Sprintf("%s%d/%s/", h.prefix, userID, calendarName)
+}
+
+func (h *Handler) eventPath(userID uint64, uid string) string {
+	return h.calendarPath(userID) + url.PathEscape(uid) + ".ics"
+}
+
+func (h *Handler) syncToken(seq uint64) string {
+	return fmt.Sprintf("%s%d-%d", syncTokenPrefix, h.epoch, seq)
+}
+
+// parseSyncToken returns sequence number of token issued by this run of the server
+func (h *Handler) parseSyncToken(token string) (uint64, error) {
+	var epoch int64
+	var seq uint64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(token, syncTokenPrefix), "%d-%d", &epoch, &seq); err != nil ||
+		!strings.HasPrefix(token, syncTokenPrefix) || epoch != h.epoch {
+		return 0, event.ErrInvalidSyncToken
+	}
+	return seq, nil
+}
+
+// ServeHTTP dispatches CalDAV request by its method
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	t, err := h.parsePath(req.URL.EscapedPath())
+	if req.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if userID, ok := identity.FromContext(req.Context()); ok && !h.canAccess(req, userID, t.userID) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	switch req.Method {
+	case "PROPFIND":
+		h.propfind(w, req, t)
+	case "REPORT":
+		h.report(w, req, t)
+	case http.MethodGet, http.MethodHead:
+		h.get(w, req, t)
+	case http.MethodPut:
+		h.put(w, req, t)
+	case http.MethodDelete:
+		h.delete(w, req, t)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// canAccess reports whether authenticated user may make request to calendar of other user of his tenant,
+// admins of organisation may only read calendars of its members
+func (h *Handler) canAccess(req *http.Request, userID, otherID uint64) bool {
+	if userID == otherID {
+		return true
+	}
+	return reads(req.Method) && h.tenants.CanView(tenant.FromContext(req.Context()), userID, otherID)
+}
+
+func reads(method string) bool {
+	return method == "PROPFIND" || method == "REPORT" || method == http.MethodGet || method == http.MethodHead
+}
+
+func (h *Handler) propfind(w http.ResponseWriter, req *http.Request, t target) {
+	var body propfind
+	if err := decodeBody(req, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s := newSelection(body.PropName, body.Prop)
+	depth := req.Header.Get("Depth")
+	ctx := req.Context()
+
+	if t.name != "" {
+		e, err := h.find(ctx, t.userID, t.name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeMultistatus(w, []response{newResponse(h.eventResource(e), s)}, "")
+		return
+	}
+	if !t.calendar {
+		responses := []response{newResponse(h.principalResource(t.userID), s)}
+		if depth != "0" {
+			responses = append(responses, newResponse(h.calendarResource(req.Context(), t.userID), s))
+		}
+		writeMultistatus(w, responses, "")
+		return
+	}
+	responses := []response{newResponse(h.calendarResource(req.Context(), t.userID), s)}
+	if depth != "0" {
+		events, err := h.events(ctx, t.userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, e := range events {
+			responses = append(responses, newResponse(h.eventResource(e), s))
+		}
+	}
+	writeMultistatus(w, responses, "")
+}
+
+func (h *Handler) report(w http.ResponseWriter, req *http.Request, t target) {
+	var body report
+	if err := decodeBody(req, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !t.calendar || t.name != "" {
+		writePrecondition(w, http.StatusForbidden, condSupportedReport)
+		return
+	}
+	s := newSelection(nil, body.Prop)
+	switch body.XMLName {
+	case reportCalendarQuery:
+		h.calendarQuery(w, req, t, body, s)
+	case reportCalendarMultiget:
+		h.calendarMultiget(w, req, t, body, s)
+	case reportSyncCollection:
+		h.syncCollection(w, req, t, body, s)
+	default:
+		writePrecondition(w, http.StatusForbidden, condSupportedReport)
+	}
+}
+
+func (h *Handler) calendarQuery(w http.ResponseWriter, req *http.Request, t target, body report, s selection) {
+	match, err := compileFilter(body.Filter)
+	if err != nil {
+		writePrecondition(w, http.StatusForbidden, condSupportedFilter)
+		return
+	}
+	events, err := h.events(req.Context(), t.userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	responses := []response{}
+	for _, e := range events {
+		if match(e) {
+			responses = append(responses, newResponse(h.eventResource(e), s))
+		}
+	}
+	writeMultistatus(w, responses, "")
+}
+
+func (h *Handler) calendarMultiget(w http.ResponseWriter, req *http.Request, t target, body report, s selection) {
+	responses := []response{}
+	for _, ref := range body.Hrefs {
+		ref = strings.TrimSpace(ref)
+		path := ref
+		if u, err := url.Parse(ref); err == nil {
+			path = u.EscapedPath()
+		}
+		other, err := h.parsePath(path)
+		if err != nil || other.userID != t.userID || other.name == "" {
+			responses = append(responses, response{href: ref, status: http.StatusNotFound})
+			continue
+		}
+		e, err := h.find(req.Context(), t.userID, other.name)
+		if errors.Is(err, event.ErrEventNotFound) {
+			responses = append(responses, response{href: ref, status: http.StatusNotFound})
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		responses = append(responses, newResponse(h.eventResource(e), s))
+	}
+	writeMultistatus(w, responses, "")
+}
+
+func (h *Handler) syncCollection(w http.ResponseWriter, req *http.Request, t target, body report, s selection) {
+	ctx := req.Context()
+	token := ""
+	if body.SyncToken != nil {
+		token = strings.TrimSpace(*body.SyncToken)
+	}
+	responses := []response{}
+	if token == "" {
+		// sequence number is taken before reading events, so changes made meanwhile are reported by next sync
+		seq := h.ctrl.Seq()
+		events, err := h.events(ctx, t.userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, e := range events {
+			responses = append(responses, newResponse(h.eventResource(e), s))
+		}
+		writeMultistatus(w, responses, h.syncToken(seq))
+		return
+	}
+
+	since, err := h.parseSyncToken(token)
+	if err != nil {
+		writePrecondition(w, http.StatusForbidden, condValidSyncToken)
+		return
+	}
+	changes, seq, err := h.ctrl.Changes(ctx, t.userID, since)
+	if err != nil {
+		writePrecondition(w, http.StatusForbidden, condValidSyncToken)
+		return
+	}
+	for _, ch := range changes {
+		path := h.eventPath(t.userID, ical.UID(&model.Event{ID: ch.EventID, ICalUID: ch.ICalUID}))
+		if ch.Deleted {
+			responses = append(responses, response{href: path, status: http.StatusNotFound})
+			continue
+		}
+		e, err := h.ctrl.Get(ctx, t.userID, ch.EventID)
+		if errors.Is(err, event.ErrEventNotFound) || errors.Is(err, event.ErrUserNotFound) {
+			responses = append(responses, response{href: path, status: http.StatusNotFound})
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		responses = append(responses, newResponse(h.eventResource(e), s))
+	}
+	writeMultistatus(w, responses, h.syncToken(seq))
+}
+
+func (h *Handler) get(w http.ResponseWriter, req *http.Request, t target) {
+	var data []byte
+	if t.name != "" {
+		e, err := h.find(req.Context(), t.userID, t.name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data = ical.Marshal(e)
+		w.Header().Set("ETag", etag(data))
+	} else if t.calendar {
+		events, err := h.events(req.Context(), t.userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data = ical.Marshal(events...)
+	} else {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(data)
+	}
+}
+
+func (h *Handler) put(w http.ResponseWriter, req *http.Request, t target) {
+	if t.name == "" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := req.Context()
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, MaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	events, err := ical.Unmarshal(data)
+	if errors.Is(err, ical.ErrUnsupported) {
+		writePrecondition(w, http.StatusForbidden, condSupportedComponent)
+		return
+	}
+	if err != nil {
+		writePrecondition(w, http.StatusForbidden, condValidCalendarData)
+		return
+	}
+	if len(events) != 1 {
+		http.Error(w, errMultipleEventsPerObject.Error(), http.StatusForbidden)
+		return
+	}
+	e := events[0]
+	if e.ICalUID == "" || e.ICalUID+".ics" != t.name {
+		http.Error(w, errResourceNameMismatch.Error(), http.StatusBadRequest)
+		return
+	}
+	e.UserID = t.userID
+	splitCategory(e)
+
+	old, err := h.find(ctx, t.userID, t.name)
+	if err != nil && !errors.Is(err, event.ErrEventNotFound) {
+		writeError(w, err)
+		return
+	}
+	if !checkPreconditions(req, old) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+
+	code := http.StatusNoContent
+	if old != nil && old.ICalUID == "" {
+		// event created without CalDAV is found by UID made of its id
+		e.ID = old.ID
+		err = h.ctrl.Update(ctx, e)
+	} else {
+		// concurrent PUT of the same object may have created it since find
+		var created bool
+		if created, err = h.ctrl.Upsert(ctx, e); created {
+			code = http.StatusCreated
+		}
+	}
+	var quotaErr *event.QuotaError
+	if errors.As(err, &quotaErr) {
+		writePrecondition(w, http.StatusInsufficientStorage, condQuotaNotExceeded)
+		return
+	}
+	var validationErr *event.ValidationError
+	if errors.As(err, &validationErr) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if stored, err := h.ctrl.Get(ctx, t.userID, e.ID); err == nil {
+		w.Header().Set("ETag", etag(ical.Marshal(stored)))
+	}
+	w.WriteHeader(code)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, req *http.Request, t target) {
+	if t.name == "" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	e, err := h.find(req.Context(), t.userID, t.name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !checkPreconditions(req, e) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return
+	}
+	if err := h.ctrl.Delete(req.Context(), t.userID, e.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// events returns all events of user, user without events has empty calendar
+func (h *Handler) events(ctx context.Context, userID uint64) ([]*model.Event, error) {
+	events, err := h.ctrl.GetAll(ctx, userID)
+	if errors.Is(err, event.ErrUserNotFound) {
+		return []*model.Event{}, nil
+	}
+	return events, err
+}
+
+// find returns event of user with resource name
+func (h *Handler) find(ctx context.Context, userID uint64, name string) (*model.Event, error) {
+	events, err := h.events(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		if ical.UID(e)+".ics" == name {
+			return e, nil
+		}
+	}
+	return nil, event.ErrEventNotFound
+}
+
+func (h *Handler) principalResource(userID uint64) resource {
+	principal := h.principalPath(userID)
+	return resource{
+		href: principal,
+		names: []xml.Name{propResourceType, propDisplayName, propCurrentUserPrincipal, propPrincipalURL,
+			propCalendarHomeSet},
+		prop: func(name xml.Name) (string, bool) {
+			switch name {
+			case propResourceType:
+				return "<d:collection/><d:principal/>", true
+			case propDisplayName:
+				return escape(fmt.Sprintf("User %d", userID)), true
+			case propCurrentUserPrincipal, propPrincipalURL, propCalendarHomeSet:
+				return href(principal), true
+			}
+			return "", false
+		},
+	}
+}
+
+func (h *Handler) calendarResource(ctx context.Context, userID uint64) resource {
+	principal := h.principalPath(userID)
+	return resource{
+		href: h.calendarPath(userID),
+		names: []xml.Name{propResourceType, propDisplayName, propOwner, propCurrentUserPrincipal,
+			propSupportedComponents, propSupportedReportSet, propCurrentUserPrivileges, propGetCTag, propSyncToken},
+		prop: func(name xml.Name) (string, bool) {
+			switch name {
+			case propResourceType:
+				return "<d:collection/><c:calendar/>", true
+			case propDisplayName:
+				return "Events", true
+			case propOwner, propCurrentUserPrincipal:
+				return href(principal), true
+			case propSupportedComponents:
+				return `<c:comp name="VEVENT"/>`, true
+			case propSupportedReportSet:
+				reports := ""
+				for _, r := range []xml.Name{reportCalendarQuery, reportCalendarMultiget, reportSyncCollection} {
+					reports += "<d:supported-report><d:report>" + element(r, "") + "</d:report></d:supported-report>"
+				}
+				return reports, true
+			case propCurrentUserPrivileges:
+				if authUserID, ok := identity.FromContext(ctx); ok && authUserID != userID {
+					return "<d:privilege><d:read/></d:privilege>", true
+				}
+				return "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>", true
+			case propGetCTag:
+				return escape(h.syncToken(h.ctrl.LastChange(ctx, userID))), true
+			case propSyncToken:
+				return escape(h.syncToken(h.ctrl.Seq())), true
+			}
+			return "", false
+		},
+	}
+}
+
+func (h *Handler) eventResource(e *model.Event) resource {
+	data := ical.Marshal(e)
+	return resource{
+		href:  h.eventPath(e.UserID, ical.UID(e)),
+		names: []xml.Name{propResourceType, propGetETag, propGetContentType, propCalendarData},
+		prop: func(name xml.Name) (string, bool) {
+			switch name {
+			case propResourceType:
+				return "", true
+			case propGetETag:
+				return escape(etag(data)), true
+			case propGetContentType:
+				return escape(ical.ContentType + "; component=VEVENT"), true
+			case propCalendarData:
+				return escape(string(data)), true
+			}
+			return "", false
+		},
+	}
+}
+
+// compileFilter returns predicate of calendar-query filter,
+// only comp-filter of VEVENT with optional time-range is supported
+func compileFilter(f *filter) (func(e *model.Event) bool, error) {
+	all := func(e *model.Event) bool { return true }
+	if f == nil {
+		return all, nil
+	}
+	calendar := f.CompFilter
+	if !strings.EqualFold(calendar.Name, "VCALENDAR") || calendar.TimeRange != nil || len(calendar.PropFilters) > 0 {
+		return nil, errUnsupportedFilter
+	}
+	if calendar.IsNotDefined != nil {
+		return func(e *model.Event) bool { return false }, nil
+	}
+	if len(calendar.CompFilters) == 0 {
+		return all, nil
+	}
+	if len(calendar.CompFilters) > 1 {
+		return nil, errUnsupportedFilter
+	}
+	component := calendar.CompFilters[0]
+	if len(component.PropFilters) > 0 || len(component.CompFilters) > 0 {
+		return nil, errUnsupportedFilter
+	}
+	isEvent := strings.EqualFold(component.Name, "VEVENT")
+	if component.IsNotDefined != nil {
+		isEvent = !isEvent
+	}
+	if !isEvent {
+		return func(e *model.Event) bool { return false }, nil
+	}
+	if component.TimeRange == nil {
+		return all, nil
+	}
+	start, end, err := parseTimeRange(component.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	// all-day event occupies [Date, Date + 1 day)
+	return func(e *model.Event) bool {
+		return e.Date.Before(end) && e.Date.AddDate(0, 0, 1).After(start)
+	}, nil
+}
+
+func parseTimeRange(tr *timeRange) (start, end time.Time, err error) {
+	const layout = "20060102T150405Z"
+	start, end = time.Time{}, time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	if tr.Start != "" {
+		if start, err = time.Parse(layout, tr.Start); err != nil {
+			return start, end, errUnsupportedFilter
+		}
+	}
+	if tr.End != "" {
+		if end, err = time.Parse(layout, tr.End); err != nil {
+			return start, end, errUnsupportedFilter
+		}
+	}
+	return start, end, nil
+}
+
+// splitCategory moves the first value of CATEGORIES which is a known category from tags to category of Event
+func splitCategory(e *model.Event) {
+	for i, tag := range e.Tags {
+		if _, ok := event.Categories[strings.ToLower(tag)]; ok {
+			e.Category = tag
+			e.Tags = append(e.Tags[:i:i], e.Tags[i+1:]...)
+			return
+		}
+	}
+}
+
+// checkPreconditions checks If-Match and If-None-Match headers against current event, e is nil if there is no event
+func checkPreconditions(req *http.Request, e *model.Event) bool {
+	current := ""
+	if e != nil {
+		current = etag(ical.Marshal(e))
+	}
+	if match := req.Header.Get("If-Match"); match != "" {
+		if current == "" || match != "*" && !containsETag(match, current) {
+			return false
+		}
+	}
+	if noneMatch := req.Header.Get("If-None-Match"); noneMatch != "" {
+		if current != "" && (noneMatch == "*" || containsETag(noneMatch, current)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// etag returns strong entity tag of calendar object
+func etag(data []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, event.ErrEventNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, event.ErrUnavailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		http.Error(w, "client closed request", StatusClientClosedRequest)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,270 @@
+package caldav
+
+import (
+	"bufio"
+	"context"
+	"dev11/internal/controller/event"
+	"dev11/internal/identity"
+	"dev11/internal/repository/memory"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var syncTokenRegexp = regexp.MustCompile(`<d:sync-token>([^<]+)</d:sync-token>`)
+
+// readFixture reads request recorded from CalDAV client replacing placeholders by values of vars.
+// Content-Length of recorded request is ignored, body is everything after the blank line.
+func readFixture(t *testing.T, name string, vars map[string]string) *http.Request {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	for k, v := range vars {
+		text = strings.ReplaceAll(text, "{{"+k+"}}", v)
+	}
+	head, body := text, ""
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		head, body = text[:i], text[i+2:]
+	}
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head + "\n\n")))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.RequestURI = ""
+	return req
+}
+
+func TestRecordedRequests(t *testing.T) {
+	ctrl := event.New(memory.New())
+	h := New(ctrl, "/caldav/", nil)
+	apiID, err := ctrl.Create(context.Background(), &model.Event{UserID: 1, Title: "Created over HTTP API",
+		Date: time.Date(2024, time.May, 20, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiHref := fmt.Sprintf("/caldav/1/events/%d@dev11.ics", apiID)
+	href := "/caldav/1/events/6e1b7a52-3f0c-4a6b-9d51-0b6d8f1e2c47.ics"
+
+	tests := []struct {
+		fixture  string
+		status   int
+		contains []string
+		excludes []string
+	}{
+		{fixture: "01_options.http", status: http.StatusOK},
+		{fixture: "02_propfind_principal.http", status: http.StatusMultiStatus, contains: []string{
+			"<d:resourcetype><d:collection/><d:principal/></d:resourcetype>",
+			"<d:current-user-principal><d:href>/caldav/1/</d:href></d:current-user-principal>",
+			"<c:calendar-home-set><d:href>/caldav/1/</d:href></c:calendar-home-set>",
+			"<c:calendar-user-address-set/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status>",
+		}},
+		{fixture: "03_propfind_home.http", status: http.StatusMultiStatus, contains: []string{
+			"<d:href>/caldav/1/events/</d:href>",
+			"<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>",
+			`<c:supported-calendar-component-set><c:comp name="VEVENT"/></c:supported-calendar-component-set>`,
+			"<cs:getctag>http://dev11/ns/sync/",
+			`<x:calendar-color xmlns:x="http://apple.com/ns/ical/"/>`,
+		}},
+		{fixture: "04_put_create.http", status: http.StatusCreated},
+		{fixture: "05_put_create_again.http", status: http.StatusPreconditionFailed},
+		{fixture: "06_sync_initial.http", status: http.StatusMultiStatus, contains: []string{
+			"<d:href>" + href + "</d:href>", "<d:href>" + apiHref + "</d:href>", "<d:getetag>&#34;",
+		}},
+		{fixture: "07_propfind_calendar.http", status: http.StatusMultiStatus, contains: []string{
+			"<d:href>" + href + "</d:href>", "<d:href>" + apiHref + "</d:href>",
+			"<d:getcontenttype>text/calendar; charset=utf-8; component=VEVENT</d:getcontenttype>",
+		}},
+		{fixture: "08_multiget.http", status: http.StatusMultiStatus, contains: []string{
+			"UID:6e1b7a52-3f0c-4a6b-9d51-0b6d8f1e2c47",
+			`SUMMARY:Quarterly planning\, Q3`,
+			"DTSTART:20240515T100000",
+			"DTEND:20240515T113000",
+			"CATEGORIES:work,planning",
+			"PRIORITY:3",
+			`DESCRIPTION:Agenda:\n1. Roadmap\n2. Hiring plans for the second half of the`,
+			"<d:href>/caldav/1/events/missing.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>",
+		}},
+		{fixture: "09_query_range.http", status: http.StatusMultiStatus, contains: []string{
+			"<d:href>" + href + "</d:href>", "<d:href>" + apiHref + "</d:href>",
+		}},
+		{fixture: "10_query_outside.http", status: http.StatusMultiStatus, excludes: []string{"<d:response>"}},
+		{fixture: "11_get.http", status: http.StatusOK, contains: []string{"BEGIN:VCALENDAR", "LOCATION:Room 4"}},
+		{fixture: "12_put_update_stale.http", status: http.StatusPreconditionFailed},
+		{fixture: "13_put_update.http", status: http.StatusNoContent},
+		{fixture: "14_sync_delta.http", status: http.StatusMultiStatus, contains: []string{"<d:href>" + href + "</d:href>"},
+			excludes: []string{apiHref}},
+		{fixture: "15_delete.http", status: http.StatusNoContent},
+		{fixture: "16_sync_after_delete.http", status: http.StatusMultiStatus, contains: []string{
+			"<d:response><d:href>" + href + "</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>",
+		}},
+		{fixture: "17_sync_invalid_token.http", status: http.StatusForbidden, contains: []string{"<d:valid-sync-token/>"}},
+		{fixture: "18_put_recurring.http", status: http.StatusForbidden, contains: []string{"<c:supported-calendar-component/>"}},
+		{fixture: "19_put_name_mismatch.http", status: http.StatusBadRequest},
+	}
+	vars := map[string]string{}
+	for _, v := range tests {
+		req := readFixture(t, v.fixture, vars)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		body := w.Body.String()
+		if w.Code != v.status {
+			t.Fatalf("%s: expected: %d, got: %d %s", v.fixture, v.status, w.Code, body)
+		}
+		for _, s := range v.contains {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: expected body to contain %q, got: %s", v.fixture, s, body)
+			}
+		}
+		for _, s := range v.excludes {
+			if strings.Contains(body, s) {
+				t.Errorf("%s: expected body not to contain %q, got: %s", v.fixture, s, body)
+			}
+		}
+		if etag := w.Header().Get("ETag"); etag != "" {
+			vars["etag"] = etag
+		}
+		if m := syncTokenRegexp.FindStringSubmatch(body); m != nil {
+			vars["sync_token"] = m[1]
+		}
+	}
+
+	events, err := ctrl.GetAll(context.Background(), 1)
+	if err != nil || len(events) != 1 || events[0].ID != apiID {
+		t.Errorf("expected: only event created over HTTP API, got: %v, %v", events, err)
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	day := func(d int) *model.Event {
+		return &model.Event{Date: time.Date(2024, time.May, d, 0, 0, 0, 0, time.UTC)}
+	}
+	tests := map[string]struct {
+		filter   *filter
+		matches  []*model.Event
+		excludes []*model.Event
+		err      bool
+	}{
+		"no filter": {matches: []*model.Event{day(1)}},
+		"all events": {filter: &filter{CompFilter: compFilter{Name: "VCALENDAR",
+			CompFilters: []compFilter{{Name: "VEVENT"}}}}, matches: []*model.Event{day(1)}},
+		"todos": {filter: &filter{CompFilter: compFilter{Name: "VCALENDAR",
+			CompFilters: []compFilter{{Name: "VTODO"}}}}, excludes: []*model.Event{day(1)}},
+		"time range overlaps all-day event": {filter: &filter{CompFilter: compFilter{Name: "VCALENDAR",
+			CompFilters: []compFilter{{Name: "VEVENT", TimeRange: &timeRange{Start: "20240510T120000Z", End: "20240512T000000Z"}}}}},
+			matches: []*model.Event{day(10), day(11)}, excludes: []*model.Event{day(9), day(12)}},
+		"open time range": {filter: &filter{CompFilter: compFilter{Name: "VCALENDAR",
+			CompFilters: []compFilter{{Name: "VEVENT", TimeRange: &timeRange{Start: "20240510T000000Z"}}}}},
+			matches: []*model.Event{day(10), day(31)}, excludes: []*model.Event{day(9)}},
+		"invalid time range": {filter: &filter{CompFilter: compFilter{Name: "VCALENDAR",
+			CompFilters: []compFilter{{Name: "VEVENT", TimeRange: &timeRange{Start: "2024-05-10"}}}}}, err: true},
+		"prop filter": {filter: &filter{CompFilter: compFilter{Name: "VCALENDAR",
+			CompFilters: []compFilter{{Name: "VEVENT", PropFilters: []anyElement{{}}}}}}, err: true},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			match, err := compileFilter(v.filter)
+			if (err != nil) != v.err {
+				t.Fatalf("expected error: %v, got: %v", v.err, err)
+			}
+			for _, e := range v.matches {
+				if !match(e) {
+					t.Errorf("expected: %s matches", e.Date.Format("2006-01-02"))
+				}
+			}
+			for _, e := range v.excludes {
+				if match(e) {
+					t.Errorf("expected: %s doesn't match", e.Date.Format("2006-01-02"))
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	ctrl := event.New(memory.New())
+	h := New(ctrl, "/caldav/", nil)
+	const clients = 8
+	codes := make(chan int, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		// requests are read before goroutines start, t.Fatal must not be called from them
+		req := readFixture(t, "04_put_create.http", nil)
+		req.Header.Del("If-None-Match")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusNoContent] != clients-1 {
+		t.Errorf("expected: one %d and %d %d, got: %v", http.StatusCreated, clients-1, http.StatusNoContent, counts)
+	}
+	events, err := ctrl.GetAll(context.Background(), 1)
+	if err != nil || len(events) != 1 {
+		t.Errorf("expected: 1 event, got: %d, %v", len(events), err)
+	}
+}
+
+func TestAuthenticatedUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "organisations.json")
+	if err := os.WriteFile(path, []byte(`[{"id": 7, "name": "Example", "admins": [1], "members": [2]}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := tenant.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(event.New(memory.New()), "/caldav/", tenants)
+	tests := map[string]struct {
+		method string
+		tenant uint64
+		path   string
+		status int
+	}{
+		"own calendar":           {path: "/caldav/1/events/", status: http.StatusMultiStatus},
+		"other calendar":         {path: "/caldav/2/events/", status: http.StatusForbidden},
+		"other event":            {path: "/caldav/2/events/a.ics", status: http.StatusForbidden},
+		"calendar of member":     {tenant: 7, path: "/caldav/2/events/", status: http.StatusMultiStatus},
+		"event of member":        {method: http.MethodGet, tenant: 7, path: "/caldav/2/events/a.ics", status: http.StatusNotFound},
+		"deletion of member":     {method: http.MethodDelete, tenant: 7, path: "/caldav/2/events/a.ics", status: http.StatusForbidden},
+		"calendar of non-member": {tenant: 7, path: "/caldav/3/events/", status: http.StatusForbidden},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			method := v.method
+			if method == "" {
+				method = "PROPFIND"
+			}
+			req := httptest.NewRequest(method, v.path, nil)
+			req.Header.Set("Depth", "0")
+			req = req.WithContext(tenant.NewContext(identity.NewContext(req.Context(), 1), v.tenant))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != v.status {
+				t.Errorf("expected: %d, got: %d %s", v.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
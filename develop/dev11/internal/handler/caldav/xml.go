@@ -0,0 +1,225 @@
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Namespaces of WebDAV, CalDAV and CalendarServer extensions
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+var prefixes = map[string]string{nsDAV: "d", nsCalDAV: "c", nsCS: "cs"}
+
+// Properties supported by collections and resources
+var (
+	propResourceType          = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName           = xml.Name{Space: nsDAV, Local: "displayname"}
+	propCurrentUserPrincipal  = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL          = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propOwner                 = xml.Name{Space: nsDAV, Local: "owner"}
+	propSupportedReportSet    = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+	propCurrentUserPrivileges = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propSyncToken             = xml.Name{Space: nsDAV, Local: "sync-token"}
+	propGetETag               = xml.Name{Space: nsDAV, Local: "getetag"}
+	propGetContentType        = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propCalendarHomeSet       = xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}
+	propSupportedComponents   = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}
+	propCalendarData          = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+	propGetCTag               = xml.Name{Space: nsCS, Local: "getctag"}
+)
+
+// anyElement is an element of request which only name is used
+type anyElement struct {
+	XMLName xml.Name
+}
+
+type propNames struct {
+	Names []anyElement `xml:",any"`
+}
+
+// propfind is a body of PROPFIND request, empty body is the same as allprop
+type propfind struct {
+	XMLName  xml.Name   `xml:"DAV: propfind"`
+	PropName *struct{}  `xml:"DAV: propname"`
+	Prop     *propNames `xml:"DAV: prop"`
+}
+
+// report is a body of calendar-query, calendar-multiget or sync-collection REPORT request
+type report struct {
+	XMLName   xml.Name
+	Prop      *propNames `xml:"DAV: prop"`
+	Hrefs     []string   `xml:"DAV: href"`
+	Filter    *filter    `xml:"urn:ietf:params:xml:ns:caldav filter"`
+	SyncToken *string    `xml:"DAV: sync-token"`
+	SyncLevel string     `xml:"DAV: sync-level"`
+}
+
+type filter struct {
+	CompFilter compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type compFilter struct {
+	Name         string       `xml:"name,attr"`
+	IsNotDefined *struct{}    `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *timeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	CompFilters  []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	PropFilters  []anyElement `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+}
+
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// selection is a set of properties requested by client
+type selection struct {
+	all   bool
+	names bool
+	props []xml.Name
+}
+
+// newSelection returns selection of prop element, propname or allprop if neither is present
+func newSelection(names *struct{}, prop *propNames) selection {
+	if prop != nil {
+		s := selection{}
+		for _, p := range prop.Names {
+			s.props = append(s.props, p.XMLName)
+		}
+		return s
+	}
+	if names != nil {
+		return selection{names: true}
+	}
+	return selection{all: true}
+}
+
+// decodeBody decodes XML body of request into v, empty body leaves v as is
+func decodeBody(req *http.Request, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(req.Body, MaxBodySize))
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// resource is a collection or calendar object with its properties,
+// prop returns inner XML of property and false if the property is not defined on the resource
+type resource struct {
+	href  string
+	names []xml.Name
+	prop  func(name xml.Name) (string, bool)
+}
+
+// response is an element of multistatus for resource or for href with status
+type response struct {
+	href     string
+	status   int
+	found    []string
+	notFound []string
+}
+
+func newResponse(r resource, s selection) response {
+	resp := response{href: r.href}
+	switch {
+	case s.names:
+		for _, name := range r.names {
+			resp.found = append(resp.found, element(name, ""))
+		}
+	case s.all:
+		for _, name := range r.names {
+			if value, ok := r.prop(name); ok {
+				resp.found = append(resp.found, element(name, value))
+			}
+		}
+	default:
+		for _, name := range s.props {
+			if value, ok := r.prop(name); ok {
+				resp.found = append(resp.found, element(name, value))
+			} else {
+				resp.notFound = append(resp.notFound, element(name, ""))
+			}
+		}
+	}
+	return resp
+}
+
+// writeMultistatus writes 207 Multi-Status response, syncToken is written if not empty
+func writeMultistatus(w http.ResponseWriter, responses []response, syncToken string) {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+	for _, resp := range responses {
+		b.WriteString("<d:response><d:href>" + escape(resp.href) + "</d:href>")
+		if resp.status != 0 {
+			b.WriteString("<d:status>" + statusLine(resp.status) + "</d:status>")
+		}
+		writePropstat(&b, resp.found, http.StatusOK)
+		writePropstat(&b, resp.notFound, http.StatusNotFound)
+		b.WriteString("</d:response>")
+	}
+	if syncToken != "" {
+		b.WriteString("<d:sync-token>" + escape(syncToken) + "</d:sync-token>")
+	}
+	b.WriteString("</d:multistatus>")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = io.WriteString(w, b.String())
+}
+
+func writePropstat(b *strings.Builder, props []string, status int) {
+	if len(props) == 0 {
+		return
+	}
+	b.WriteString("<d:propstat><d:prop>")
+	for _, p := range props {
+		b.WriteString(p)
+	}
+	b.WriteString("</d:prop><d:status>" + statusLine(status) + "</d:status></d:propstat>")
+}
+
+// writePrecondition writes DAV:error response with failed precondition
+func writePrecondition(w http.ResponseWriter, code int, condition xml.Name) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	_, _ = io.WriteString(w, xml.Header+`<d:error xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`+element(condition, "")+`</d:error>`)
+}
+
+// element returns XML element with given name and inner XML,
+// elements of unknown namespaces declare their namespace
+func element(name xml.Name, inner string) string {
+	tag, decl := name.Local, ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag, decl = "x:"+name.Local, ` xmlns:x="`+escape(name.Space)+`"`
+	}
+	if inner == "" {
+		return "<" + tag + decl + "/>"
+	}
+	return "<" + tag + decl + ">" + inner + "</" + tag + ">"
+}
+
+func href(path string) string {
+	return "<d:href>" + escape(path) + "</d:href>"
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func statusLine(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
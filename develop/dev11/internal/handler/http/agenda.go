@@ -0,0 +1,61 @@
+package http
+
+import (
+	"bytes"
+	"dev11/internal/digest"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// GetAgenda handles GET HTTP Request for a daily or weekly agenda of user rendered as text, markdown or html.
+// Agenda starts from date form value or from today in timezone from tz form value.
+func (h *Handler) GetAgenda(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	period := digest.Period(req.FormValue("period"))
+	if period == "" {
+		period = digest.Daily
+	}
+	format := digest.Format(req.FormValue("format"))
+	if format == "" {
+		format = digest.Text
+	}
+	loc, err := time.LoadLocation(req.FormValue("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidTimezone.Error())
+		return
+	}
+	date := time.Now().In(loc)
+	if req.FormValue("date") != "" {
+		if date, err = parseDate(req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	d, err := h.agenda.Build(req.Context(), userID, period, date)
+	if errors.Is(err, digest.ErrUnknownPeriod) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	var b bytes.Buffer
+	if err := digest.Render(&b, format, d); err != nil {
+		if errors.Is(err, digest.ErrUnknownFormat) {
+			writeError(w, http.StatusBadRequest, err.Error())
+		} else {
+			writeInternalError(w, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", digest.ContentType(format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b.Bytes())
+}
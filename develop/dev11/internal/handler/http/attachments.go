@@ -0,0 +1,176 @@
+package http
+
+import (
+	"context"
+	"dev11/internal/controller/attachment"
+	"dev11/internal/identity"
+	"dev11/pkg/model"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// maxFieldSize is a maximal size of a non-file field of multipart upload
+const maxFieldSize = 1 << 10
+
+// PostUploadAttachments handles POST HTTP Request with multipart/form-data body to attach files to Event.
+// user_id and event_id are taken from URL query or from fields preceding the files,
+// user_id defaults to user authenticated by client certificate.
+// Files are streamed to storage, when one of them is rejected files stored by the request are removed.
+func (h *Handler) PostUploadAttachments(w http.ResponseWriter, req *http.Request) {
+	mr, err := req.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidMultipart.Error())
+		return
+	}
+	query := req.URL.Query()
+	fields := map[string]string{"user_id": query.Get("user_id"), "event_id": query.Get("event_id")}
+	authUserID, authenticated := identity.FromContext(req.Context())
+	if authenticated && fields["user_id"] == "" {
+		fields["user_id"] = strconv.FormatUint(authUserID, 10)
+	}
+	uploaded := []*model.Attachment{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.discardAttachments(req.Context(), uploaded)
+			writeError(w, http.StatusBadRequest, errInvalidMultipart.Error())
+			return
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+			if err != nil {
+				h.discardAttachments(req.Context(), uploaded)
+				writeError(w, http.StatusBadRequest, errInvalidMultipart.Error())
+				return
+			}
+			if _, ok := fields[part.FormName()]; ok {
+				fields[part.FormName()] = string(value)
+			}
+			continue
+		}
+		userID, err := strconv.ParseUint(fields["user_id"], 10, 64)
+		if err != nil {
+			h.discardAttachments(req.Context(), uploaded)
+			writeError(w, http.StatusBadRequest, errInvalidUserID.Error())
+			return
+		}
+		if authenticated && userID != authUserID {
+			h.discardAttachments(req.Context(), uploaded)
+			writeError(w, http.StatusForbidden, errForbiddenUser.Error())
+			return
+		}
+		eventID, err := strconv.ParseUint(fields["event_id"], 10, 64)
+		if err != nil {
+			h.discardAttachments(req.Context(), uploaded)
+			writeError(w, http.StatusBadRequest, errInvalidEventID.Error())
+			return
+		}
+		a, err := h.attachments.Upload(req.Context(), userID, eventID, part.FileName(), part)
+		if err != nil {
+			h.discardAttachments(req.Context(), uploaded)
+			writeAttachmentError(w, err)
+			return
+		}
+		uploaded = append(uploaded, a)
+	}
+	if len(uploaded) == 0 {
+		writeError(w, http.StatusBadRequest, errNoFiles.Error())
+		return
+	}
+	writeResponseJSON(w, http.StatusCreated, map[string]interface{}{"result": uploaded})
+}
+
+// discardAttachments removes attachments stored by failed upload
+func (h *Handler) discardAttachments(ctx context.Context, attachments []*model.Attachment) {
+	for _, a := range attachments {
+		h.attachments.Delete(ctx, a.UserID, a.ID)
+	}
+}
+
+// GetAttachments handles GET HTTP Request for attachments of Event
+func (h *Handler) GetAttachments(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	eventID, err := strconv.ParseUint(req.FormValue("event_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidEventID.Error())
+		return
+	}
+	attachments, err := h.attachments.List(req.Context(), userID, eventID)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": attachments})
+}
+
+// GetDownloadAttachment handles GET HTTP Request for content of attachment, byte ranges and conditional requests are supported.
+// Content is always served as a download in a sandbox, so uploaded HTML can't run scripts on behalf of the calendar.
+func (h *Handler) GetDownloadAttachment(w http.ResponseWriter, req *http.Request) {
+	userID, id, err := parseAttachmentRef(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a, f, err := h.attachments.Open(req.Context(), userID, id)
+	if err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+	defer f.Close()
+	header := w.Header()
+	header.Set("Content-Type", a.ContentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	header.Set("Content-Security-Policy", "sandbox")
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Cache-Control", "private")
+	// content of attachment never changes, so its hash is a strong validator
+	header.Set("ETag", `"`+a.SHA256+`"`)
+	http.ServeContent(w, req, a.Name, a.Created, f)
+}
+
+// PostDeleteAttachment handles POST HTTP Request to remove attachment
+func (h *Handler) PostDeleteAttachment(w http.ResponseWriter, req *http.Request) {
+	userID, id, err := parseAttachmentRef(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.attachments.Delete(req.Context(), userID, id); err != nil {
+		writeAttachmentError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": "successfully deleted"})
+}
+
+// parseAttachmentRef returns user_id and id form values identifying attachment
+func parseAttachmentRef(req *http.Request) (userID, id uint64, err error) {
+	if userID, err = parseUserID(req); err != nil {
+		return
+	}
+	if id, err = strconv.ParseUint(req.FormValue("id"), 10, 64); err != nil {
+		err = errInvalidAttachment
+	}
+	return
+}
+
+// writeAttachmentError writes response for errors of attachment controller
+func writeAttachmentError(w http.ResponseWriter, err error) {
+	var limitErr *attachment.LimitError
+	if errors.As(err, &limitErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+	} else if errors.Is(err, attachment.ErrEventNotFound) || errors.Is(err, attachment.ErrAttachmentNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+	} else {
+		writeInternalError(w, err)
+	}
+}
@@ -1,60 +1,117 @@
 package http
 
 import (
+	"dev11/internal/calendar"
+	"dev11/internal/controller/attachment"
 	"dev11/internal/controller/event"
+	"dev11/internal/controller/task"
+	"dev11/internal/controller/webhook"
+	"dev11/internal/digest"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
 	"errors"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// StatusClientClosedRequest is a non-standard status code for requests cancelled by client
+const StatusClientClosedRequest = 499
+
+// DefaultSearchLimit is a number of search results returned when limit is not provided
+const DefaultSearchLimit = 20
+
 var (
-	errInvalidEventID = errors.New("invalid event id")
-	errInvalidUserID  = errors.New("invalid user id")
-	errEmptyTitle     = errors.New("empty title")
-	errInvalidDate    = errors.New("invalid date")
+	errInvalidEventID    = errors.New("invalid event id")
+	errInvalidUserID     = errors.New("invalid user id")
+	errInvalidPriority   = errors.New("invalid priority")
+	errInvalidDate       = errors.New("invalid date")
+	errInvalidMonth      = errors.New("invalid month")
+	errInvalidWorkingDay = errors.New("invalid working day")
+	errEmptyQuery        = errors.New("empty query")
+	errInvalidLimit      = errors.New("invalid limit")
+	errInvalidTaskID     = errors.New("invalid task id")
+	errInvalidDue        = errors.New("invalid due date")
+	errInvalidTime       = errors.New("invalid time")
+	errInvalidDuration   = errors.New("invalid duration")
+	errEmptyText         = errors.New("empty text")
+	errInvalidTimezone   = errors.New("invalid timezone")
+	errInvalidMultipart  = errors.New("invalid multipart form")
+	errNoFiles           = errors.New("no files")
+	errInvalidAttachment = errors.New("invalid attachment id")
+	errForbiddenUser     = errors.New("access to other user is forbidden")
+	errInvalidWebhook    = errors.New("invalid webhook id")
+	errNoOrganisation    = errors.New("organisation not found")
 )
 
 // Handler processes HTTP requests
 type Handler struct {
-	ctrl *event.Controller
+	ctrl        *event.Controller
+	tasks       *task.Controller
+	attachments *attachment.Controller
+	webhooks    *webhook.Controller
+	cal         *calendar.Registry
+	tenants     *tenant.Directory
+	agenda      *digest.Builder
 }
 
-// New creates Handler instance with provided Controller and returns pointer to it
-func New(ctrl *event.Controller) *Handler {
-	return &Handler{ctrl: ctrl}
+// New creates Handler instance with provided controllers of events, tasks, attachments and webhooks, calendar Registry
+// and Directory of organisations and returns pointer to it. Directory may be nil when there are no organisations.
+func New(ctrl *event.Controller, tasks *task.Controller, attachments *attachment.Controller, webhooks *webhook.Controller,
+	cal *calendar.Registry, tenants *tenant.Directory) *Handler {
+	return &Handler{ctrl: ctrl, tasks: tasks, attachments: attachments, webhooks: webhooks, cal: cal, tenants: tenants,
+		agenda: digest.NewBuilder(ctrl, tasks)}
 }
 
 // PostCreateEvent handles POST HTTP Request to add Event to calendar
 func (h *Handler) PostCreateEvent(w http.ResponseWriter, req *http.Request) {
-	e, err := parseEvent(req)
+	e, err := h.parseEvent(req)
 	if err != nil && !errors.Is(err, errInvalidEventID) {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeCalendarError(w, err)
 		return
 	}
-	id, err := h.ctrl.Create(e)
+	id, err := h.ctrl.Create(req.Context(), e)
 	if err != nil {
-		if errors.Is(err, event.ErrDuplicateID) {
-			writeError(w, http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
-		} else {
-			writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-		}
+		writeCreateError(w, err)
 		return
 	}
 	writeResponseJSON(w, http.StatusCreated, map[string]interface{}{"result": id})
 }
 
+// writeCreateError writes response for errors of Event creation
+func writeCreateError(w http.ResponseWriter, err error) {
+	var validationErr *event.ValidationError
+	var quotaErr *event.QuotaError
+	if errors.As(err, &validationErr) {
+		writeError(w, http.StatusBadRequest, err.Error())
+	} else if errors.As(err, &quotaErr) {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	} else if errors.Is(err, event.ErrDuplicateID) {
+		writeError(w, http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
+	} else {
+		writeInternalError(w, err)
+	}
+}
+
 // PostUpdateEvent handles POST HTTP Request to change Event in calendar
 func (h *Handler) PostUpdateEvent(w http.ResponseWriter, req *http.Request) {
-	e, err := parseEvent(req)
+	e, err := h.parseEvent(req)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeCalendarError(w, err)
 		return
 	}
-	err = h.ctrl.Update(e)
+	err = h.ctrl.Update(req.Context(), e)
 	if err != nil {
-		if errors.Is(err, event.ErrUserNotFound) || errors.Is(err, event.ErrEventNotFound) {
+		var validationErr *event.ValidationError
+		var quotaErr *event.QuotaError
+		if errors.As(err, &validationErr) {
+			writeError(w, http.StatusBadRequest, err.Error())
+		} else if errors.As(err, &quotaErr) {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		} else if errors.Is(err, event.ErrUserNotFound) || errors.Is(err, event.ErrEventNotFound) {
 			writeError(w, http.StatusNotFound, err.Error())
 		} else {
-			writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			writeInternalError(w, err)
 		}
 		return
 	}
@@ -75,9 +132,13 @@ func (h *Handler) PostDeleteEvent(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = h.ctrl.Delete(userID, eventID)
+	err = h.ctrl.Delete(req.Context(), userID, eventID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		if errors.Is(err, event.ErrUserNotFound) || errors.Is(err, event.ErrEventNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+		} else {
+			writeInternalError(w, err)
+		}
 		return
 	}
 
@@ -98,17 +159,15 @@ func (h *Handler) GetEventsForDay(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	events, err := h.ctrl.GetForDay(userID, date)
+	days, err := h.cal.Days(req.Context(), userID, req.FormValue("country"), date, 1)
 	if err != nil {
-		if errors.Is(err, event.ErrUserNotFound) {
-			writeError(w, http.StatusNotFound, err.Error())
-		} else {
-			writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-		}
+		writeCalendarError(w, err)
 		return
 	}
 
-	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": events})
+	events, err := h.ctrl.GetForDay(req.Context(), userID, date, parseFilter(req))
+	tasks, tasksErr := h.tasks.GetForDay(req.Context(), userID, date)
+	writeSchedule(w, events, err, tasks, tasksErr, days)
 }
 
 // GetEventsForWeek handles GET HTTP Request for an events occuring in a week starting from given day
@@ -125,21 +184,94 @@ func (h *Handler) GetEventsForWeek(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	events, err := h.ctrl.GetForWeek(userID, date)
+	days, err := h.cal.Days(req.Context(), userID, req.FormValue("country"), date, 7)
+	if err != nil {
+		writeCalendarError(w, err)
+		return
+	}
+
+	events, err := h.ctrl.GetForWeek(req.Context(), userID, date, parseFilter(req))
+	tasks, tasksErr := h.tasks.GetForWeek(req.Context(), userID, date)
+	writeSchedule(w, events, err, tasks, tasksErr, days)
+}
+
+// GetEventsForMonth handles GET HTTP Request for an events occuring in a month starting from given day
+func (h *Handler) GetEventsForMonth(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	date, err := parseDate(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	days, err := h.cal.Days(req.Context(), userID, req.FormValue("country"), date, daysInMonth(date))
+	if err != nil {
+		writeCalendarError(w, err)
+		return
+	}
+
+	events, err := h.ctrl.GetForMonth(req.Context(), userID, date, parseFilter(req))
+	tasks, tasksErr := h.tasks.GetForMonth(req.Context(), userID, date)
+	writeSchedule(w, events, err, tasks, tasksErr, days)
+}
+
+// GetSearchEvents handles GET HTTP Request for full-text search in titles and descriptions of events
+func (h *Handler) GetSearchEvents(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := req.FormValue("q")
+	if strings.TrimSpace(query) == "" {
+		writeError(w, http.StatusBadRequest, errEmptyQuery.Error())
+		return
+	}
+
+	limit, err := parseLimit(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := h.ctrl.Search(req.Context(), userID, query, limit)
 	if err != nil {
 		if errors.Is(err, event.ErrUserNotFound) {
 			writeError(w, http.StatusNotFound, err.Error())
 		} else {
-			writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			writeInternalError(w, err)
 		}
 		return
 	}
 
-	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": events})
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": results})
 }
 
-// GetEventsForMonth handles GET HTTP Request for an events occuring in a month starting from given day
-func (h *Handler) GetEventsForMonth(w http.ResponseWriter, req *http.Request) {
+// GetUsage handles GET HTTP Request for number of events of user compared with quotas and retention policy
+func (h *Handler) GetUsage(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usage, err := h.ctrl.Usage(req.Context(), userID)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": usage})
+}
+
+// PostCreateHoliday handles POST HTTP Request to add a day off defined by user
+func (h *Handler) PostCreateHoliday(w http.ResponseWriter, req *http.Request) {
 	userID, err := parseUserID(req)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
@@ -152,15 +284,70 @@ func (h *Handler) GetEventsForMonth(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	events, err := h.ctrl.GetForMonth(userID, date)
+	if err := h.cal.AddUserHoliday(req.Context(), userID, date, req.FormValue("name")); err != nil {
+		writeCalendarError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusCreated, map[string]interface{}{"result": "successfully created"})
+}
+
+// PostDeleteHoliday handles POST HTTP Request to remove a day off defined by user
+func (h *Handler) PostDeleteHoliday(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	date, err := parseDate(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.cal.DeleteUserHoliday(req.Context(), userID, date); err != nil {
+		writeCalendarError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": "successfully deleted"})
+}
+
+// writeSchedule writes events, tasks and days of a period,
+// user is not found only if he has neither events nor tasks
+func writeSchedule(w http.ResponseWriter, events []*model.Event, err error, tasks []*model.Task, tasksErr error, days []calendar.Day) {
+	if tasksErr != nil {
+		writeInternalError(w, tasksErr)
+		return
+	}
+	if errors.Is(err, event.ErrUserNotFound) && len(tasks) > 0 {
+		events, err = []*model.Event{}, nil
+	}
 	if err != nil {
 		if errors.Is(err, event.ErrUserNotFound) {
 			writeError(w, http.StatusNotFound, err.Error())
 		} else {
-			writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			writeInternalError(w, err)
 		}
 		return
 	}
 
-	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": events})
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": events, "tasks": tasks, "days": days})
+}
+
+// writeCalendarError writes response for errors of request parsing and calendar lookups
+func writeCalendarError(w http.ResponseWriter, err error) {
+	var storeErr *calendar.StoreError
+	switch {
+	case errors.Is(err, calendar.ErrHolidayNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.As(err, &storeErr):
+		writeInternalError(w, storeErr.Err)
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// daysInMonth returns number of days in a month starting from given day
+func daysInMonth(t time.Time) int {
+	return int(t.AddDate(0, 1, 0).Sub(t) / (24 * time.Hour))
 }
@@ -0,0 +1,30 @@
+package http
+
+import (
+	"dev11/internal/identity"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"net/http"
+)
+
+// GetOrganisation handles GET HTTP Request for organisation of user authenticated by client certificate,
+// admins of the organisation also get ids of its members
+func (h *Handler) GetOrganisation(w http.ResponseWriter, req *http.Request) {
+	userID, ok := identity.FromContext(req.Context())
+	if !ok || h.tenants == nil {
+		writeError(w, http.StatusNotFound, errNoOrganisation.Error())
+		return
+	}
+	tenantID := tenant.FromContext(req.Context())
+	o, found := h.tenants.Organisation(tenantID)
+	role, member := h.tenants.Role(tenantID, userID)
+	if !found || !member {
+		writeError(w, http.StatusNotFound, errNoOrganisation.Error())
+		return
+	}
+	result := &model.Organisation{ID: o.ID, Name: o.Name, Role: string(role)}
+	if role == tenant.RoleAdmin {
+		result.Members = h.tenants.Members(tenantID)
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": result})
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"dev11/pkg/quickadd"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PostQuickAdd handles POST HTTP Request to add Event described by a phrase like "lunch next friday 13:00",
+// relative dates of the phrase are resolved in timezone from tz form value or in UTC
+func (h *Handler) PostQuickAdd(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	text := strings.TrimSpace(req.FormValue("text"))
+	if text == "" {
+		writeError(w, http.StatusBadRequest, errEmptyText.Error())
+		return
+	}
+	loc, err := time.LoadLocation(req.FormValue("tz"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidTimezone.Error())
+		return
+	}
+
+	r, err := quickadd.Parse(text, time.Now().In(loc))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e := r.Event(userID)
+	id, err := h.ctrl.Create(req.Context(), e)
+	if err != nil {
+		writeCreateError(w, err)
+		return
+	}
+	e.ID = id
+	writeResponseJSON(w, http.StatusCreated, map[string]interface{}{"result": id, "event": e})
+}
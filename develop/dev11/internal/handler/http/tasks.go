@@ -0,0 +1,117 @@
+package http
+
+import (
+	"dev11/internal/controller/task"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// PostCreateTask handles POST HTTP Request to add Task to calendar
+func (h *Handler) PostCreateTask(w http.ResponseWriter, req *http.Request) {
+	t, err := parseTask(req)
+	if err != nil && !errors.Is(err, errInvalidTaskID) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := h.tasks.Create(req.Context(), t)
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusCreated, map[string]interface{}{"result": id})
+}
+
+// PostUpdateTask handles POST HTTP Request to change Task in calendar
+func (h *Handler) PostUpdateTask(w http.ResponseWriter, req *http.Request) {
+	t, err := parseTask(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.tasks.Update(req.Context(), t); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": "successfully updated"})
+}
+
+// PostDeleteTask handles POST HTTP Request to remove Task from calendar
+func (h *Handler) PostDeleteTask(w http.ResponseWriter, req *http.Request) {
+	userID, taskID, err := parseTaskRef(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.tasks.Delete(req.Context(), userID, taskID); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": "successfully deleted"})
+}
+
+// PostCompleteTask handles POST HTTP Request to mark Task as done,
+// next occurrence of recurring Task is returned in "next" field
+func (h *Handler) PostCompleteTask(w http.ResponseWriter, req *http.Request) {
+	userID, taskID, err := parseTaskRef(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	next, err := h.tasks.Complete(req.Context(), userID, taskID, time.Now())
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": "successfully completed", "next": next})
+}
+
+// PostReopenTask handles POST HTTP Request to mark Task as not done
+func (h *Handler) PostReopenTask(w http.ResponseWriter, req *http.Request) {
+	userID, taskID, err := parseTaskRef(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.tasks.Reopen(req.Context(), userID, taskID); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": "successfully reopened"})
+}
+
+// GetOverdueTasks handles GET HTTP Request for tasks which are not done and were due before given day or today
+func (h *Handler) GetOverdueTasks(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now()
+	if req.FormValue("date") != "" {
+		if now, err = parseDate(req); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	tasks, err := h.tasks.Overdue(req.Context(), userID, now)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": tasks})
+}
+
+// writeTaskError writes response for errors of task controller
+func writeTaskError(w http.ResponseWriter, err error) {
+	var validationErr *task.ValidationError
+	if errors.As(err, &validationErr) {
+		writeError(w, http.StatusBadRequest, err.Error())
+	} else if errors.Is(err, task.ErrTaskNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+	} else {
+		writeInternalError(w, err)
+	}
+}
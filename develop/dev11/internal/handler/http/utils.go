@@ -1,35 +1,126 @@
 package http
 
 import (
+	"context"
+	"dev11/internal/controller/event"
+	"dev11/internal/identity"
+	"dev11/internal/tenant"
 	"dev11/pkg/model"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
-func parseEvent(req *http.Request) (e *model.Event, err error) {
+func (h *Handler) parseEvent(req *http.Request) (e *model.Event, err error) {
 	userID, err := parseUserID(req)
 	if err != nil {
 		return
 	}
 
-	title := req.FormValue("title")
-	if title == "" {
-		return nil, errEmptyTitle
+	date, workingDay, err := h.parseEventDate(req, userID)
+	if err != nil {
+		return
+	}
+	if date, err = parseTime(req, date); err != nil {
+		return
+	}
+	duration, err := parseDuration(req)
+	if err != nil {
+		return
 	}
 
-	date, err := parseDate(req)
+	priority, err := parsePriority(req)
 	if err != nil {
 		return
 	}
 
 	id, err := parseEventID(req)
-	e = &model.Event{ID: id, UserID: userID, Title: title, Date: date}
+	e = &model.Event{
+		ID:          id,
+		UserID:      userID,
+		Title:       req.FormValue("title"),
+		Description: req.FormValue("description"),
+		Location:    req.FormValue("location"),
+		Category:    req.FormValue("category"),
+		Color:       req.FormValue("color"),
+		Tags:        parseTags(req),
+		Priority:    priority,
+		Date:        date,
+		Duration:    duration,
+		WorkingDay:  workingDay,
+		Country:     req.FormValue("country"),
+	}
 	return
 }
 
+// parseTime adds optional time form value in 15:04 format to date
+func parseTime(req *http.Request, date time.Time) (time.Time, error) {
+	timeValue := req.FormValue("time")
+	if timeValue == "" {
+		return date, nil
+	}
+	t, err := time.Parse("15:04", timeValue)
+	if err != nil {
+		return date, errInvalidTime
+	}
+	return date.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute), nil
+}
+
+// parseDuration returns optional duration form value in minutes
+func parseDuration(req *http.Request) (int, error) {
+	durationValue := req.FormValue("duration")
+	if durationValue == "" {
+		return 0, nil
+	}
+	duration, err := strconv.Atoi(durationValue)
+	if err != nil {
+		return 0, errInvalidDuration
+	}
+	return duration, nil
+}
+
+func parsePriority(req *http.Request) (model.Priority, error) {
+	priorityValue := req.FormValue("priority")
+	if priorityValue == "" {
+		return model.PriorityNormal, nil
+	}
+	priority, err := strconv.Atoi(priorityValue)
+	if err != nil {
+		return 0, errInvalidPriority
+	}
+	return model.Priority(priority), nil
+}
+
+// parseTags returns tags from comma separated tags form value
+func parseTags(req *http.Request) []string {
+	tagsValue := req.FormValue("tags")
+	if tagsValue == "" {
+		return nil
+	}
+	return strings.Split(tagsValue, ",")
+}
+
+// parseLimit returns limit form value or DefaultSearchLimit if it's not provided
+func parseLimit(req *http.Request) (int, error) {
+	limitValue := req.FormValue("limit")
+	if limitValue == "" {
+		return DefaultSearchLimit, nil
+	}
+	limit, err := strconv.Atoi(limitValue)
+	if err != nil || limit < 1 {
+		return 0, errInvalidLimit
+	}
+	return limit, nil
+}
+
+func parseFilter(req *http.Request) event.Filter {
+	return event.Filter{Tag: req.FormValue("tag"), Category: req.FormValue("category")}
+}
+
 func parseEventID(req *http.Request) (uint64, error) {
 	idValue := req.FormValue("id")
 	id, err := strconv.ParseUint(idValue, 10, 64)
@@ -39,6 +130,44 @@ func parseEventID(req *http.Request) (uint64, error) {
 	return id, nil
 }
 
+// parseTask returns Task from form values, errInvalidTaskID is returned with parsed Task if id is not provided
+func parseTask(req *http.Request) (*model.Task, error) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		return nil, err
+	}
+	due, err := time.Parse("2006-01-02", req.FormValue("due"))
+	if err != nil {
+		return nil, errInvalidDue
+	}
+	id, err := parseTaskID(req)
+	return &model.Task{
+		ID:          id,
+		UserID:      userID,
+		Title:       req.FormValue("title"),
+		Description: req.FormValue("description"),
+		Due:         due,
+		Recurrence:  model.Recurrence(req.FormValue("recurrence")),
+	}, err
+}
+
+// parseTaskRef returns user_id and id form values identifying Task
+func parseTaskRef(req *http.Request) (userID, taskID uint64, err error) {
+	if userID, err = parseUserID(req); err != nil {
+		return
+	}
+	taskID, err = parseTaskID(req)
+	return
+}
+
+func parseTaskID(req *http.Request) (uint64, error) {
+	id, err := strconv.ParseUint(req.FormValue("id"), 10, 64)
+	if err != nil {
+		return id, errInvalidTaskID
+	}
+	return id, nil
+}
+
 func parseUserID(req *http.Request) (uint64, error) {
 	idValue := req.FormValue("user_id")
 	id, err := strconv.ParseUint(idValue, 10, 64)
@@ -57,6 +186,25 @@ func parseDate(req *http.Request) (time.Time, error) {
 	return date, nil
 }
 
+// parseEventDate returns date of event and number of working day on which it repeats: either date form value
+// or n-th working day of month and n if working_day and month form values are provided
+func (h *Handler) parseEventDate(req *http.Request, userID uint64) (time.Time, int, error) {
+	if req.FormValue("working_day") == "" {
+		date, err := parseDate(req)
+		return date, 0, err
+	}
+	n, err := strconv.Atoi(req.FormValue("working_day"))
+	if err != nil {
+		return time.Time{}, 0, errInvalidWorkingDay
+	}
+	month, err := time.Parse("2006-01", req.FormValue("month"))
+	if err != nil {
+		return time.Time{}, 0, errInvalidMonth
+	}
+	date, err := h.cal.NthWorkingDay(req.Context(), userID, req.FormValue("country"), month.Year(), month.Month(), n)
+	return date, n, err
+}
+
 func writeResponseJSON(w http.ResponseWriter, code int, data interface{}) {
 	resp, _ := json.Marshal(data)
 	w.Header().Add("content-type", "application/json")
@@ -69,6 +217,70 @@ func writeError(w http.ResponseWriter, code int, msg string) {
 	writeResponseJSON(w, code, resp)
 }
 
+// writeInternalError writes response for errors which are not caused by request:
+// 504 if deadline of request is exceeded, 499 if request is cancelled by client,
+// 503 if repository is unavailable and 500 otherwise
+func writeInternalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, event.ErrUnavailable):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout))
+	case errors.Is(err, context.Canceled):
+		writeError(w, StatusClientClosedRequest, "client closed request")
+	default:
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+// Authorize is a middleware restricting requests authenticated by client certificate to data of their user:
+// user_id form value defaults to id of the user and requests with other user_id are rejected,
+// except GET requests of admins of organisation for calendars of its members listed in calendarPaths.
+// Bodies of multipart requests are not parsed here, their handlers check fields themselves.
+func (h *Handler) Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := identity.FromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		targetID := userID
+		for i, value := range r.Form["user_id"] {
+			otherID, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || i > 0 && otherID != targetID || !h.canAccess(r, userID, otherID) {
+				writeError(w, http.StatusForbidden, errForbiddenUser.Error())
+				return
+			}
+			targetID = otherID
+		}
+		r.Form.Set("user_id", strconv.FormatUint(targetID, 10))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// calendarPaths are paths of requests reading calendars which admins of organisation may make for its members
+var calendarPaths = map[string]bool{
+	"/events_for_day":       true,
+	"/events_for_week":      true,
+	"/events_for_month":     true,
+	"/events/search":        true,
+	"/attachments":          true,
+	"/attachments/download": true,
+}
+
+// canAccess reports whether authenticated user may make request r to data of other user of his tenant
+func (h *Handler) canAccess(r *http.Request, userID, otherID uint64) bool {
+	if userID == otherID {
+		return true
+	}
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	return read && calendarPaths[r.URL.Path] && h.tenants.CanView(tenant.FromContext(r.Context()), userID, otherID)
+}
+
 // Post is a middleware for POST HTTP methods
 func (h *Handler) Post(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -100,3 +312,12 @@ func (h *Handler) Log(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Timeout is a middleware which cancels context of a request after given duration
+func (h *Handler) Timeout(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"context"
+	"dev11/internal/calendar"
+	"dev11/internal/controller/event"
+	"dev11/internal/controller/task"
+	"dev11/internal/repository/memory"
+	"dev11/pkg/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowRepository is a repository of events which doesn't answer until context of request is done
+type slowRepository struct {
+	*memory.Repository
+	delay time.Duration
+}
+
+func (r *slowRepository) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(r.delay):
+		return nil
+	}
+}
+
+func (r *slowRepository) Create(ctx context.Context, e *model.Event) (uint64, error) {
+	if err := r.wait(ctx); err != nil {
+		return 0, err
+	}
+	return r.Repository.Create(ctx, e)
+}
+
+func (r *slowRepository) Delete(ctx context.Context, userID, id uint64) error {
+	if err := r.wait(ctx); err != nil {
+		return err
+	}
+	return r.Repository.Delete(ctx, userID, id)
+}
+
+func (r *slowRepository) Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Repository.Search(ctx, userID, query)
+}
+
+func newTestHandler(t *testing.T, delay time.Duration) *Handler {
+	t.Helper()
+	repo := memory.New()
+	cal := calendar.NewRegistry("RU", repo)
+	cal.Add(calendar.New("RU"))
+	ctrl := event.New(&slowRepository{Repository: repo, delay: delay})
+	return New(ctrl, task.New(memory.NewTaskRepository()), nil, nil, cal, nil)
+}
+
+func TestTimeout(t *testing.T) {
+	tests := map[string]struct {
+		delay   time.Duration
+		timeout time.Duration
+		method  string
+		target  string
+		body    string
+		handler func(h *Handler) http.HandlerFunc
+		code    int
+	}{
+		"create in time": {delay: 0, timeout: time.Second, method: http.MethodPost, target: "/create_event",
+			body: "user_id=1&title=a&date=2024-05-15", handler: func(h *Handler) http.HandlerFunc { return h.PostCreateEvent }, code: http.StatusCreated},
+		"slow create": {delay: time.Second, timeout: 20 * time.Millisecond, method: http.MethodPost, target: "/create_event",
+			body: "user_id=1&title=a&date=2024-05-15", handler: func(h *Handler) http.HandlerFunc { return h.PostCreateEvent }, code: http.StatusGatewayTimeout},
+		"delete missing": {delay: 0, timeout: time.Second, method: http.MethodPost, target: "/delete_event",
+			body: "user_id=1&id=1", handler: func(h *Handler) http.HandlerFunc { return h.PostDeleteEvent }, code: http.StatusNotFound},
+		"slow delete": {delay: time.Second, timeout: 20 * time.Millisecond, method: http.MethodPost, target: "/delete_event",
+			body: "user_id=1&id=1", handler: func(h *Handler) http.HandlerFunc { return h.PostDeleteEvent }, code: http.StatusGatewayTimeout},
+		"slow search": {delay: time.Second, timeout: 20 * time.Millisecond, method: http.MethodGet, target: "/events/search?user_id=1&q=a",
+			handler: func(h *Handler) http.HandlerFunc { return h.GetSearchEvents }, code: http.StatusGatewayTimeout},
+		"search in time": {delay: 0, timeout: time.Second, method: http.MethodGet, target: "/events/search?user_id=1&q=a",
+			handler: func(h *Handler) http.HandlerFunc { return h.GetSearchEvents }, code: http.StatusNotFound},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			h := newTestHandler(t, v.delay)
+			req := httptest.NewRequest(v.method, v.target, strings.NewReader(v.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			start := time.Now()
+			h.Timeout(v.timeout, v.handler(h)).ServeHTTP(w, req)
+			if w.Code != v.code {
+				t.Errorf("expected: %d, got: %d %s", v.code, w.Code, w.Body.String())
+			}
+			if elapsed := time.Since(start); elapsed > v.timeout+500*time.Millisecond {
+				t.Errorf("expected: response within %v, got: %v", v.timeout, elapsed)
+			}
+		})
+	}
+}
+
+func TestCancelledRequest(t *testing.T) {
+	h := newTestHandler(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/create_event", strings.NewReader("user_id=1&title=a&date=2024-05-15")).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	h.Timeout(time.Minute, http.HandlerFunc(h.PostCreateEvent)).ServeHTTP(w, req)
+	if w.Code != StatusClientClosedRequest {
+		t.Errorf("expected: %d, got: %d %s", StatusClientClosedRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestWriteInternalError(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		code int
+		msg  string
+	}{
+		"deadline":          {err: context.DeadlineExceeded, code: http.StatusGatewayTimeout, msg: http.StatusText(http.StatusGatewayTimeout)},
+		"wrapped deadline":  {err: fmt.Errorf("search: %w", context.DeadlineExceeded), code: http.StatusGatewayTimeout, msg: http.StatusText(http.StatusGatewayTimeout)},
+		"canceled":          {err: context.Canceled, code: StatusClientClosedRequest, msg: "client closed request"},
+		"unavailable":       {err: fmt.Errorf("%w: no leader", event.ErrUnavailable), code: http.StatusServiceUnavailable, msg: event.ErrUnavailable.Error() + ": no leader"},
+		"other error":       {err: errors.New("disk is broken"), code: http.StatusInternalServerError, msg: http.StatusText(http.StatusInternalServerError)},
+		"internal not leak": {err: errors.New("password=secret"), code: http.StatusInternalServerError, msg: http.StatusText(http.StatusInternalServerError)},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeInternalError(w, v.err)
+			var resp map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != v.code || resp["error"] != v.msg {
+				t.Errorf("expected: %d %s, got: %d %s", v.code, v.msg, w.Code, resp["error"])
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"dev11/internal/controller/webhook"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PostCreateWebhook handles POST HTTP Request to register webhook of user,
+// the response contains secret of the webhook which is not shown again
+func (h *Handler) PostCreateWebhook(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var types []string
+	if req.FormValue("events") != "" {
+		types = strings.Split(req.FormValue("events"), ",")
+	}
+	created, err := h.webhooks.Create(req.Context(), userID, req.FormValue("url"), types)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusCreated, map[string]interface{}{"result": created})
+}
+
+// PostDeleteWebhook handles POST HTTP Request to remove webhook of user
+func (h *Handler) PostDeleteWebhook(w http.ResponseWriter, req *http.Request) {
+	userID, id, err := parseWebhookRef(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.webhooks.Delete(req.Context(), userID, id); err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": "successfully deleted"})
+}
+
+// GetWebhooks handles GET HTTP Request for webhooks of user
+func (h *Handler) GetWebhooks(w http.ResponseWriter, req *http.Request) {
+	userID, err := parseUserID(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": h.webhooks.List(req.Context(), userID)})
+}
+
+// GetWebhookDeliveries handles GET HTTP Request for the latest deliveries of webhook, including dead ones
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	userID, id, err := parseWebhookRef(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := parseLimit(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	deliveries, err := h.webhooks.Deliveries(req.Context(), userID, id, limit)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeResponseJSON(w, http.StatusOK, map[string]interface{}{"result": deliveries})
+}
+
+// parseWebhookRef returns user_id and id form values identifying webhook
+func parseWebhookRef(req *http.Request) (userID, id uint64, err error) {
+	if userID, err = parseUserID(req); err != nil {
+		return
+	}
+	if id, err = strconv.ParseUint(req.FormValue("id"), 10, 64); err != nil {
+		err = errInvalidWebhook
+	}
+	return
+}
+
+// writeWebhookError writes response for errors of webhook controller
+func writeWebhookError(w http.ResponseWriter, err error) {
+	if errors.Is(err, webhook.ErrInvalidURL) || errors.Is(err, webhook.ErrUnknownEventType) {
+		writeError(w, http.StatusBadRequest, err.Error())
+	} else if errors.Is(err, webhook.ErrTooManyWebhooks) {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	} else if errors.Is(err, webhook.ErrWebhookNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+	} else {
+		writeInternalError(w, err)
+	}
+}
@@ -0,0 +1,360 @@
+'use strict';
+
+// Dates of events are wall clock time without time zone, so they are handled as strings
+// "YYYY-MM-DD" and "HH:MM" and never converted to local time of browser.
+
+const state = {
+  user: localStorage.getItem('user') || '1',
+  month: firstOfMonth(todayString()),
+  selected: todayString(),
+  events: [],
+  tasks: [],
+  days: [],
+};
+
+const $ = (id) => document.getElementById(id);
+
+function pad(n) {
+  return String(n).padStart(2, '0');
+}
+
+function todayString() {
+  const now = new Date();
+  return `${now.getFullYear()}-${pad(now.getMonth() + 1)}-${pad(now.getDate())}`;
+}
+
+function firstOfMonth(date) {
+  return date.slice(0, 8) + '01';
+}
+
+// parseDate returns UTC Date of "YYYY-MM-DD" string, UTC is used only for calendar arithmetic
+function parseDate(date) {
+  const [y, m, d] = date.split('-').map(Number);
+  return new Date(Date.UTC(y, m - 1, d));
+}
+
+function formatDate(date) {
+  return `${date.getUTCFullYear()}-${pad(date.getUTCMonth() + 1)}-${pad(date.getUTCDate())}`;
+}
+
+function addDays(date, n) {
+  const t = parseDate(date);
+  t.setUTCDate(t.getUTCDate() + n);
+  return formatDate(t);
+}
+
+function addMonths(date, n) {
+  const t = parseDate(date);
+  t.setUTCMonth(t.getUTCMonth() + n);
+  return formatDate(t);
+}
+
+function dayOf(event) {
+  return event.date.slice(0, 10);
+}
+
+function timeOf(event) {
+  const time = event.date.slice(11, 16);
+  return time === '00:00' && !event.duration ? '' : time;
+}
+
+function endOf(event) {
+  if (!event.duration) {
+    return '';
+  }
+  const [h, m] = event.date.slice(11, 16).split(':').map(Number);
+  const end = h * 60 + m + event.duration;
+  return `${pad(Math.floor(end / 60) % 24)}:${pad(end % 60)}`;
+}
+
+// parseJSON keeps 64-bit ids as strings, numbers of JavaScript can't represent them exactly
+function parseJSON(text) {
+  return JSON.parse(text.replace(/"(uuid|result|next_id)":(\d{16,})/g, '"$1":"$2"'));
+}
+
+async function request(method, path, params) {
+  const body = new URLSearchParams(params);
+  const options = { method, headers: { Accept: 'application/json' } };
+  if (method === 'GET') {
+    path += '?' + body;
+  } else {
+    options.body = body;
+  }
+  const resp = await fetch(path, options);
+  const data = parseJSON(await resp.text());
+  if (!resp.ok) {
+    const err = new Error(data.error || resp.statusText);
+    err.status = resp.status;
+    throw err;
+  }
+  return data;
+}
+
+function showStatus(message, info) {
+  const status = $('status');
+  status.textContent = message;
+  status.className = info ? 'info' : '';
+  status.hidden = !message;
+}
+
+async function load() {
+  showStatus('');
+  try {
+    const data = await request('GET', '/events_for_month', { user_id: state.user, date: state.month });
+    state.events = data.result || [];
+    state.tasks = data.tasks || [];
+    state.days = data.days || [];
+  } catch (err) {
+    state.events = [];
+    state.tasks = [];
+    state.days = [];
+    // user without events is not an error for calendar view
+    if (err.status !== 404) {
+      showStatus(err.message);
+    }
+  }
+  render();
+}
+
+function eventsOn(date) {
+  return state.events
+    .filter((e) => dayOf(e) === date)
+    .sort((a, b) => a.date.localeCompare(b.date) || a.title.localeCompare(b.title));
+}
+
+function tasksOn(date) {
+  return state.tasks.filter((t) => t.due.slice(0, 10) === date);
+}
+
+function dayInfo(date) {
+  return state.days.find((d) => d.date.slice(0, 10) === date);
+}
+
+function render() {
+  renderMonth();
+  renderDay();
+}
+
+function renderMonth() {
+  const first = parseDate(state.month);
+  $('month').textContent = first.toLocaleDateString(undefined, { month: 'long', year: 'numeric', timeZone: 'UTC' });
+  const grid = $('grid');
+  grid.replaceChildren();
+  // grid starts from Monday of the first week of month and has 6 weeks
+  const offset = (first.getUTCDay() + 6) % 7;
+  const start = addDays(state.month, -offset);
+  const today = todayString();
+  for (let i = 0; i < 42; i++) {
+    const date = addDays(start, i);
+    const cell = document.createElement('div');
+    cell.className = 'cell';
+    cell.dataset.date = date;
+    if (date.slice(0, 7) !== state.month.slice(0, 7)) {
+      cell.classList.add('other');
+    }
+    const info = dayInfo(date);
+    if (info ? !info.working : i % 7 >= 5) {
+      cell.classList.add('off');
+    }
+    if (date === today) {
+      cell.classList.add('today');
+    }
+    if (date === state.selected) {
+      cell.classList.add('selected');
+    }
+    const number = document.createElement('span');
+    number.className = 'number';
+    number.textContent = Number(date.slice(8));
+    cell.append(number);
+
+    const events = eventsOn(date);
+    for (const e of events.slice(0, 3)) {
+      const chip = document.createElement('div');
+      chip.className = 'chip';
+      chip.style.borderLeftColor = e.color || '';
+      chip.textContent = (timeOf(e) ? timeOf(e) + ' ' : '') + e.title;
+      chip.title = e.title;
+      cell.append(chip);
+    }
+    if (events.length > 3) {
+      const more = document.createElement('div');
+      more.className = 'more';
+      more.textContent = `+${events.length - 3} more`;
+      cell.append(more);
+    }
+    cell.addEventListener('click', () => select(date));
+    cell.addEventListener('dblclick', () => openEditor(null, date));
+    grid.append(cell);
+  }
+}
+
+// select changes selected day without rebuilding the grid, so double click on a cell reaches it
+function select(date) {
+  state.selected = date;
+  for (const cell of $('grid').children) {
+    cell.classList.toggle('selected', cell.dataset.date === date);
+  }
+  renderDay();
+}
+
+function renderDay() {
+  const date = state.selected;
+  $('day-title').textContent = parseDate(date).toLocaleDateString(undefined, {
+    weekday: 'long', day: 'numeric', month: 'long', timeZone: 'UTC',
+  });
+  const info = dayInfo(date);
+  $('day-name').textContent = info && info.name ? info.name : '';
+
+  const list = $('day-events');
+  list.replaceChildren();
+  const events = eventsOn(date);
+  for (const e of events) {
+    const item = document.createElement('li');
+    item.style.borderLeftColor = e.color || '';
+    const time = document.createElement('div');
+    time.className = 'time';
+    time.textContent = timeOf(e) ? timeOf(e) + (endOf(e) ? '–' + endOf(e) : '') : 'all day';
+    const title = document.createElement('div');
+    title.textContent = e.title;
+    const meta = document.createElement('div');
+    meta.className = 'meta';
+    meta.textContent = [e.location, e.category, (e.tags || []).map((t) => '#' + t).join(' ')].filter(Boolean).join(' · ');
+    item.append(time, title, meta);
+    item.addEventListener('click', () => openEditor(e));
+    list.append(item);
+  }
+
+  const tasks = $('day-tasks');
+  tasks.replaceChildren();
+  for (const t of tasksOn(date)) {
+    const item = document.createElement('li');
+    item.className = t.done ? 'done' : '';
+    item.textContent = (t.done ? '☑ ' : '☐ ') + t.title;
+    tasks.append(item);
+  }
+  $('day-empty').hidden = events.length > 0 || tasksOn(date).length > 0;
+}
+
+function openEditor(event, date) {
+  const form = $('event-form');
+  const f = form.elements;
+  form.reset();
+  $('editor-error').hidden = true;
+  $('editor-title').textContent = event ? 'Edit event' : 'New event';
+  $('delete').hidden = !event;
+  if (event) {
+    f.id.value = event.uuid;
+    f.title.value = event.title;
+    f.date.value = dayOf(event);
+    f.time.value = timeOf(event);
+    f.duration.value = event.duration || '';
+    f.category.value = event.category || '';
+    f.priority.value = String(event.priority);
+    f.color.value = event.color || '';
+    f.location.value = event.location || '';
+    f.tags.value = (event.tags || []).join(', ');
+    f.description.value = event.description || '';
+  } else {
+    f.id.value = '';
+    f.date.value = date || state.selected;
+  }
+  $('editor').showModal();
+  f.title.focus();
+}
+
+// formParams returns form values of event editor, named controls are taken from elements
+// because properties like id and title of form itself shadow them
+function formParams(form) {
+  const f = form.elements;
+  const params = { user_id: state.user };
+  for (const name of ['title', 'date', 'time', 'duration', 'category', 'priority', 'color', 'location', 'description']) {
+    params[name] = f[name].value.trim();
+  }
+  params.tags = f.tags.value.split(',').map((t) => t.trim()).filter(Boolean).join(',');
+  if (f.id.value) {
+    params.id = f.id.value;
+  }
+  return params;
+}
+
+async function save(ev) {
+  ev.preventDefault();
+  const form = $('event-form');
+  try {
+    const params = formParams(form);
+    await request('POST', params.id ? '/update_event' : '/create_event', params);
+    state.selected = params.date;
+    $('editor').close();
+    await load();
+  } catch (err) {
+    $('editor-error').textContent = err.message;
+    $('editor-error').hidden = false;
+  }
+}
+
+async function remove() {
+  const f = $('event-form').elements;
+  if (!confirm(`Delete "${f.title.value}"?`)) {
+    return;
+  }
+  try {
+    await request('POST', '/delete_event', { user_id: state.user, id: f.id.value });
+    $('editor').close();
+    await load();
+  } catch (err) {
+    $('editor-error').textContent = err.message;
+    $('editor-error').hidden = false;
+  }
+}
+
+async function quickAdd(ev) {
+  ev.preventDefault();
+  const input = ev.target.elements.text;
+  if (!input.value.trim()) {
+    return;
+  }
+  try {
+    const data = await request('POST', '/quick_add', {
+      user_id: state.user,
+      text: input.value,
+      tz: Intl.DateTimeFormat().resolvedOptions().timeZone || 'UTC',
+    });
+    input.value = '';
+    state.selected = dayOf(data.event);
+    state.month = firstOfMonth(state.selected);
+    await load();
+    showStatus(`Added "${data.event.title}"`, true);
+  } catch (err) {
+    showStatus(err.message);
+  }
+}
+
+function init() {
+  $('user').value = state.user;
+  $('user').addEventListener('change', (ev) => {
+    state.user = ev.target.value;
+    localStorage.setItem('user', state.user);
+    load();
+  });
+  $('prev').addEventListener('click', () => {
+    state.month = addMonths(state.month, -1);
+    load();
+  });
+  $('next').addEventListener('click', () => {
+    state.month = addMonths(state.month, 1);
+    load();
+  });
+  $('today').addEventListener('click', () => {
+    state.selected = todayString();
+    state.month = firstOfMonth(state.selected);
+    load();
+  });
+  $('new-event').addEventListener('click', () => openEditor(null));
+  $('event-form').addEventListener('submit', save);
+  $('cancel').addEventListener('click', () => $('editor').close());
+  $('delete').addEventListener('click', remove);
+  $('quick-add').addEventListener('submit', quickAdd);
+  load();
+}
+
+init();
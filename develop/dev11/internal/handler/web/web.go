@@ -0,0 +1,30 @@
+// Package web serves user interface of calendar embedded into the binary.
+// The interface uses only its own files and the API of the server, so it works on hosts without internet access.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler returns http.Handler serving files of user interface from the root of its path,
+// mount it with http.StripPrefix
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// browser refuses resources of other origins even if some slip into the files
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// embedded files change only with the binary, but their modification time is unknown
+		w.Header().Set("Cache-Control", "no-cache")
+		fileServer.ServeHTTP(w, r)
+	})
+}
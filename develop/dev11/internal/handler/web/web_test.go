@@ -0,0 +1,67 @@
+package web
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := http.StripPrefix("/ui/", Handler())
+	tests := map[string]struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		"index":   {path: "/ui/", status: http.StatusOK, contentType: "text/html; charset=utf-8", contains: `<script src="app.js"></script>`},
+		"script":  {path: "/ui/app.js", status: http.StatusOK, contentType: "text/javascript; charset=utf-8", contains: "/events_for_month"},
+		"style":   {path: "/ui/style.css", status: http.StatusOK, contentType: "text/css; charset=utf-8", contains: ".grid"},
+		"missing": {path: "/ui/missing.js", status: http.StatusNotFound},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, v.path, nil))
+			resp := w.Result()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != v.status {
+				t.Fatalf("expected: %d, got: %d", v.status, resp.StatusCode)
+			}
+			if contentType := resp.Header.Get("Content-Type"); v.contentType != "" && contentType != v.contentType {
+				t.Errorf("expected: %s, got: %s", v.contentType, contentType)
+			}
+			if !strings.Contains(string(body), v.contains) {
+				t.Errorf("expected: %q in body", v.contains)
+			}
+			if csp := resp.Header.Get("Content-Security-Policy"); !strings.HasPrefix(csp, "default-src 'self'") {
+				t.Errorf("expected: same origin policy, got: %q", csp)
+			}
+		})
+	}
+}
+
+// TestNoExternalResources checks that interface doesn't load anything from other hosts
+func TestNoExternalResources(t *testing.T) {
+	external := regexp.MustCompile(`(?i)(https?:)?//[a-z0-9.-]+\.[a-z]{2,}|@import|url\(`)
+	err := fs.WalkDir(static, "static", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := static.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if m := external.Find(data); m != nil {
+			t.Errorf("%s: expected: no external resources, got: %q", path, m)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
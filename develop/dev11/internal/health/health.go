@@ -0,0 +1,240 @@
+// Package health serves operational endpoints of the server: liveness, readiness and build info
+// for load balancers and orchestrators, and profiling with runtime stats for a separate admin listener.
+//
+// Readiness fails once the server starts draining, so load balancers stop sending requests
+// before in-flight ones are finished by http.Server.Shutdown.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version of the server, set at build time with -ldflags "-X dev11/internal/health.Version=v1.2.3"
+var Version = "dev"
+
+// Statuses of checks and of the server
+const (
+	StatusOK          = "ok"
+	StatusReady       = "ready"
+	StatusDraining    = "draining"
+	StatusUnavailable = "unavailable"
+)
+
+// Check returns error if a dependency of the server is not healthy, it must respect cancellation of ctx
+type Check func(ctx context.Context) error
+
+// Build describes binary of the server
+type Build struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuild returns Build of running binary, revision and time of commit are known for binaries built from VCS checkout
+func ReadBuild() Build {
+	b := Build{Version: Version, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+// Checker runs named checks of dependencies to report readiness of the server
+type Checker struct {
+	timeout  time.Duration
+	build    Build
+	started  time.Time
+	draining atomic.Bool
+
+	m      sync.RWMutex
+	checks map[string]Check
+}
+
+// New creates Checker running every check at most for timeout and returns pointer to it
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, build: ReadBuild(), started: time.Now(), checks: map[string]Check{}}
+}
+
+// Add registers check with given name, check with the same name is replaced
+func (c *Checker) Add(name string, check Check) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.checks[name] = check
+}
+
+// Drain marks the server as shutting down, readiness fails from now on while liveness still succeeds
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Draining reports whether Drain was called
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Readiness is a result of checks, Checks maps names of checks to StatusOK or their errors
+type Readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Ready runs all checks concurrently and returns Readiness of the server and whether it's ready
+func (c *Checker) Ready(ctx context.Context) (Readiness, bool) {
+	c.m.RLock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.m.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func(name string, check Check) {
+			results <- result{name: name, err: check(ctx)}
+		}(name, check)
+	}
+	r := Readiness{Status: StatusReady, Checks: make(map[string]string, len(checks))}
+	for range checks {
+		var res result
+		select {
+		case res = <-results:
+		case <-ctx.Done():
+			// checks ignoring ctx are not waited for, unfinished ones are reported by name below
+		}
+		if res.name == "" {
+			break
+		}
+		r.Checks[res.name] = StatusOK
+		if res.err != nil {
+			r.Checks[res.name] = res.err.Error()
+			r.Status = StatusUnavailable
+		}
+	}
+	for name := range checks {
+		if _, ok := r.Checks[name]; !ok {
+			r.Checks[name] = ctx.Err().Error()
+			r.Status = StatusUnavailable
+		}
+	}
+	if c.Draining() {
+		r.Status = StatusDraining
+	}
+	return r, r.Status == StatusReady
+}
+
+// Liveness is a result of liveness probe
+type Liveness struct {
+	Status string `json:"status"`
+	Build  Build  `json:"build"`
+}
+
+// Live returns handler of liveness probe, it succeeds while the process serves requests
+func (c *Checker) Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Liveness{Status: StatusOK, Build: c.build})
+	})
+}
+
+// Readyz returns handler of readiness probe responding 503 if any check fails or the server is draining
+func (c *Checker) Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readiness, ok := c.Ready(r.Context())
+		code := http.StatusOK
+		if !ok {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, readiness)
+	})
+}
+
+// Runtime contains stats of Go runtime of the server
+type Runtime struct {
+	Uptime       string  `json:"uptime"`
+	Goroutines   int     `json:"goroutines"`
+	GOMAXPROCS   int     `json:"gomaxprocs"`
+	NumCPU       int     `json:"num_cpu"`
+	HeapAlloc    uint64  `json:"heap_alloc"`
+	HeapInuse    uint64  `json:"heap_inuse"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	Sys          uint64  `json:"sys"`
+	TotalAlloc   uint64  `json:"total_alloc"`
+	NumGC        uint32  `json:"num_gc"`
+	PauseTotalNs uint64  `json:"pause_total_ns"`
+	GCCPU        float64 `json:"gc_cpu_fraction"`
+	Build        Build   `json:"build"`
+}
+
+// Runtime returns current stats of Go runtime, reading them briefly stops the world
+func (c *Checker) Runtime() Runtime {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return Runtime{
+		Uptime:       time.Since(c.started).Truncate(time.Second).String(),
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapObjects:  ms.HeapObjects,
+		Sys:          ms.Sys,
+		TotalAlloc:   ms.TotalAlloc,
+		NumGC:        ms.NumGC,
+		PauseTotalNs: ms.PauseTotalNs,
+		GCCPU:        ms.GCCPUFraction,
+		Build:        c.build,
+	}
+}
+
+// AdminHandler returns handler of the admin listener: probes, build info, runtime stats and net/http/pprof profiles.
+// Profiles expose internals of the server, so it must not be reachable by clients of the API.
+func (c *Checker) AdminHandler() http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/healthz", c.Live())
+	m.Handle("/readyz", c.Readyz())
+	m.HandleFunc("/debug/build", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.build)
+	})
+	m.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Runtime())
+	})
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReady(t *testing.T) {
+	healthy := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("disk is full") }
+	// stuck ignores cancellation, so readiness must not wait for it
+	stuck := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+	tests := map[string]struct {
+		checks   map[string]Check
+		drain    bool
+		code     int
+		expected Readiness
+	}{
+		"no checks": {code: http.StatusOK, expected: Readiness{Status: StatusReady, Checks: map[string]string{}}},
+		"healthy": {checks: map[string]Check{"repository": healthy}, code: http.StatusOK,
+			expected: Readiness{Status: StatusReady, Checks: map[string]string{"repository": StatusOK}}},
+		"failing": {checks: map[string]Check{"repository": healthy, "blobs": failing}, code: http.StatusServiceUnavailable,
+			expected: Readiness{Status: StatusUnavailable, Checks: map[string]string{"repository": StatusOK, "blobs": "disk is full"}}},
+		"timeout": {checks: map[string]Check{"repository": stuck}, code: http.StatusServiceUnavailable,
+			expected: Readiness{Status: StatusUnavailable, Checks: map[string]string{"repository": context.DeadlineExceeded.Error()}}},
+		"draining": {checks: map[string]Check{"repository": healthy}, drain: true, code: http.StatusServiceUnavailable,
+			expected: Readiness{Status: StatusDraining, Checks: map[string]string{"repository": StatusOK}}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			c := New(50 * time.Millisecond)
+			for name, check := range v.checks {
+				c.Add(name, check)
+			}
+			if v.drain {
+				c.Drain()
+			}
+			w := httptest.NewRecorder()
+			c.Readyz().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			var got Readiness
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != v.code || !reflect.DeepEqual(got, v.expected) {
+				t.Errorf("expected: %d %v, got: %d %v", v.code, v.expected, w.Code, got)
+			}
+
+			// liveness doesn't depend on checks and draining
+			w = httptest.NewRecorder()
+			c.Live().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"ok"`) {
+				t.Errorf("expected: %d, got: %d %s", http.StatusOK, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	Version = "v1.2.3"
+	defer func() { Version = "dev" }()
+	h := New(time.Second).AdminHandler()
+	tests := map[string]struct {
+		target   string
+		code     int
+		contains string
+	}{
+		"liveness":  {target: "/healthz", code: http.StatusOK, contains: `"version":"v1.2.3"`},
+		"readiness": {target: "/readyz", code: http.StatusOK, contains: `"status":"ready"`},
+		"build":     {target: "/debug/build", code: http.StatusOK, contains: `"go_version":"go`},
+		"runtime":   {target: "/debug/runtime", code: http.StatusOK, contains: `"goroutines":`},
+		"profiles":  {target: "/debug/pprof/", code: http.StatusOK, contains: "goroutine"},
+		"profile":   {target: "/debug/pprof/heap?debug=1", code: http.StatusOK, contains: "heap profile"},
+		"unknown":   {target: "/events_for_day", code: http.StatusNotFound},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, v.target, nil))
+			if w.Code != v.code || !strings.Contains(w.Body.String(), v.contains) {
+				t.Errorf("expected: %d %s, got: %d %s", v.code, v.contains, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,432 @@
+// Package ical encodes and decodes events in iCalendar format (RFC 5545).
+// Events of calendar are either all-day or have time of day and duration up to one day,
+// times are floating, that is wall clock time without time zone, and recurrence is not supported.
+package ical
+
+import (
+	"bytes"
+	"dev11/pkg/model"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProdID is an identifier of product which created calendar
+const ProdID = "-//dev11//calendar//EN"
+
+// ContentType is a media type of iCalendar data
+const ContentType = "text/calendar; charset=utf-8"
+
+// Errors of decoding
+var (
+	ErrInvalid     = errors.New("invalid iCalendar data")
+	ErrUnsupported = errors.New("unsupported iCalendar data")
+)
+
+// lineLength is a maximum length of content line in octets without line break
+const lineLength = 75
+
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+)
+
+// UID returns UID of Event in iCalendar format, events created without CalDAV get UID from their id
+func UID(e *model.Event) string {
+	if e.ICalUID != "" {
+		return e.ICalUID
+	}
+	return fmt.Sprintf("%d@dev11", e.ID)
+}
+
+// Marshal returns VCALENDAR object with VEVENT component for every Event.
+// Category of Event is the first value of CATEGORIES followed by tags.
+func Marshal(events ...*model.Event) []byte {
+	var b bytes.Buffer
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:"+ProdID)
+	for _, e := range events {
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, "UID:"+escape(UID(e)))
+		writeLine(&b, "DTSTAMP:"+e.Date.Format(dateTimeLayout)+"Z")
+		if timed(e) {
+			writeLine(&b, "DTSTART:"+e.Date.Format(dateTimeLayout))
+			if e.Duration > 0 {
+				writeLine(&b, "DTEND:"+e.Date.Add(time.Duration(e.Duration)*time.Minute).Format(dateTimeLayout))
+			}
+		} else {
+			writeLine(&b, "DTSTART;VALUE=DATE:"+e.Date.Format(dateLayout))
+			writeLine(&b, "DTEND;VALUE=DATE:"+e.Date.AddDate(0, 0, 1).Format(dateLayout))
+		}
+		writeLine(&b, "SUMMARY:"+escape(e.Title))
+		if e.Description != "" {
+			writeLine(&b, "DESCRIPTION:"+escape(e.Description))
+		}
+		if e.Location != "" {
+			writeLine(&b, "LOCATION:"+escape(e.Location))
+		}
+		categories := []string{}
+		if e.Category != "" {
+			categories = append(categories, escape(e.Category))
+		}
+		for _, tag := range e.Tags {
+			categories = append(categories, escape(tag))
+		}
+		if len(categories) > 0 {
+			writeLine(&b, "CATEGORIES:"+strings.Join(categories, ","))
+		}
+		writeLine(&b, "PRIORITY:"+strconv.Itoa(encodePriority(e.Priority)))
+		if e.Color != "" {
+			writeLine(&b, "X-DEV11-COLOR:"+e.Color)
+		}
+		writeLine(&b, "END:VEVENT")
+	}
+	writeLine(&b, "END:VCALENDAR")
+	return b.Bytes()
+}
+
+// Unmarshal returns events of VEVENT components of VCALENDAR object.
+// All values of CATEGORIES are returned as tags, it is up to caller to choose category among them.
+// Date-times are converted to wall clock of their time zone, duration of events is limited to one day.
+func Unmarshal(data []byte) ([]*model.Event, error) {
+	lines, err := unfold(data)
+	if err != nil {
+		return nil, err
+	}
+	var events []*model.Event
+	var e *model.Event
+	var hasStart, allDay bool
+	var end time.Time
+	var duration time.Duration
+	stack := []string{}
+	for i, line := range lines {
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalid, i+1, err)
+		}
+		switch p.name {
+		case "BEGIN":
+			component := strings.ToUpper(p.value)
+			if len(stack) == 0 && component != "VCALENDAR" {
+				return nil, fmt.Errorf("%w: expected VCALENDAR, got %s", ErrInvalid, component)
+			}
+			if component == "VEVENT" && len(stack) == 1 {
+				e, hasStart, end, duration = &model.Event{Priority: model.PriorityNormal}, false, time.Time{}, 0
+			}
+			stack = append(stack, component)
+			continue
+		case "END":
+			component := strings.ToUpper(p.value)
+			if len(stack) == 0 || stack[len(stack)-1] != component {
+				return nil, fmt.Errorf("%w: unexpected END:%s", ErrInvalid, component)
+			}
+			stack = stack[:len(stack)-1]
+			if component == "VEVENT" && len(stack) == 1 {
+				if !hasStart {
+					return nil, fmt.Errorf("%w: VEVENT without DTSTART", ErrInvalid)
+				}
+				if !end.IsZero() {
+					duration = end.Sub(e.Date)
+				}
+				if !allDay && duration > 0 {
+					e.Duration = int(minDuration(duration, maxDuration) / time.Minute)
+				}
+				events = append(events, e)
+				e = nil
+			}
+			continue
+		}
+		if len(stack) != 2 || stack[1] != "VEVENT" {
+			continue
+		}
+		switch p.name {
+		case "UID":
+			e.ICalUID = unescape(p.value)
+		case "SUMMARY":
+			e.Title = unescape(p.value)
+		case "DESCRIPTION":
+			e.Description = unescape(p.value)
+		case "LOCATION":
+			e.Location = unescape(p.value)
+		case "CATEGORIES":
+			for _, category := range splitList(p.value) {
+				if category = unescape(category); category != "" {
+					e.Tags = append(e.Tags, category)
+				}
+			}
+		case "PRIORITY":
+			n, err := strconv.Atoi(strings.TrimSpace(p.value))
+			if err != nil || n < 0 || n > 9 {
+				return nil, fmt.Errorf("%w: invalid PRIORITY %q", ErrInvalid, p.value)
+			}
+			e.Priority = decodePriority(n)
+		case "X-DEV11-COLOR":
+			e.Color = p.value
+		case "DTSTART":
+			date, err := parseDate(p)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid DTSTART: %v", ErrInvalid, err)
+			}
+			e.Date, hasStart, allDay = date, true, isDate(p)
+		case "DTEND":
+			if end, err = parseDate(p); err != nil {
+				return nil, fmt.Errorf("%w: invalid DTEND: %v", ErrInvalid, err)
+			}
+		case "DURATION":
+			if duration, err = parseDuration(p.value); err != nil {
+				return nil, fmt.Errorf("%w: invalid DURATION %q", ErrInvalid, p.value)
+			}
+		case "RRULE", "RDATE", "RECURRENCE-ID":
+			return nil, fmt.Errorf("%w: recurring events", ErrUnsupported)
+		}
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("%w: unterminated %s", ErrInvalid, stack[len(stack)-1])
+	}
+	if events == nil {
+		return nil, fmt.Errorf("%w: no VEVENT", ErrInvalid)
+	}
+	return events, nil
+}
+
+// property is a parsed content line
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func parseLine(line string) (property, error) {
+	p := property{params: map[string]string{}}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return p, errors.New("no property name")
+	}
+	p.name = strings.ToUpper(line[:i])
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return p, errors.New("invalid parameter of " + p.name)
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return p, errors.New("unterminated quoted parameter of " + p.name)
+			}
+			value, line = line[1:end+1], line[end+2:]
+		} else {
+			end := strings.IndexAny(line, ";:")
+			if end < 0 {
+				return p, errors.New("no value of " + p.name)
+			}
+			value, line = line[:end], line[end:]
+		}
+		p.params[name] = value
+		if line == "" {
+			return p, errors.New("no value of " + p.name)
+		}
+		i = 0
+	}
+	if line[i] != ':' {
+		return p, errors.New("no value of " + p.name)
+	}
+	p.value = line[i+1:]
+	return p, nil
+}
+
+// maxDuration is the longest duration of event
+const maxDuration = 24 * time.Hour
+
+// timed reports whether Event has time of day or duration, otherwise it is an all-day event
+func timed(e *model.Event) bool {
+	return e.Duration > 0 || e.Date.Hour() != 0 || e.Date.Minute() != 0 || e.Date.Second() != 0
+}
+
+// isDate reports whether property has DATE value
+func isDate(p property) bool {
+	return strings.EqualFold(p.params["VALUE"], "DATE") || len(strings.TrimSpace(p.value)) == len(dateLayout)
+}
+
+// parseDate returns DATE or DATE-TIME value as wall clock of time zone of the value in UTC
+func parseDate(p property) (time.Time, error) {
+	value := strings.TrimSpace(p.value)
+	if isDate(p) {
+		return time.Parse(dateLayout, value)
+	}
+	loc := time.UTC
+	if strings.HasSuffix(value, "Z") {
+		value = strings.TrimSuffix(value, "Z")
+	} else if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation(dateTimeLayout, value, loc)
+	if err != nil {
+		return t, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC), nil
+}
+
+// parseDuration parses positive DURATION value like PT1H30M, P1D or P1W
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "+")
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, errors.New("invalid duration")
+	}
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+	var d time.Duration
+	n := -1
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= '0' && c <= '9':
+			if n < 0 {
+				n = 0
+			}
+			n = n*10 + int(c-'0')
+		case c == 'T':
+			if n >= 0 {
+				return 0, errors.New("invalid duration")
+			}
+			units = map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+		default:
+			unit, ok := units[c]
+			if !ok || n < 0 {
+				return 0, errors.New("invalid duration")
+			}
+			d += time.Duration(n) * unit
+			n = -1
+		}
+	}
+	if n >= 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// unfold splits data into content lines joining folded ones
+func unfold(data []byte) ([]string, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("%w: not UTF-8", ErrInvalid)
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" && (line[0] == ' ' || line[0] == '\t') {
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("%w: continuation of no line", ErrInvalid)
+			}
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// writeLine writes content line folding it into lines of at most lineLength octets
+func writeLine(b *bytes.Buffer, line string) {
+	limit := lineLength
+	for len(line) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		b.WriteString(line[:n])
+		b.WriteString("\r\n ")
+		line = line[n:]
+		// continuation lines start with space
+		limit = lineLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// splitList splits value by commas which are not escaped
+func splitList(s string) []string {
+	list := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			list = append(list, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(list, s[start:])
+}
+
+// encodePriority maps Priority to PRIORITY property where 1 is the highest and 9 is the lowest
+func encodePriority(p model.Priority) int {
+	switch p {
+	case model.PriorityUrgent:
+		return 1
+	case model.PriorityHigh:
+		return 3
+	case model.PriorityLow:
+		return 9
+	default:
+		return 5
+	}
+}
+
+// decodePriority maps PRIORITY property to Priority, undefined priority 0 is normal
+func decodePriority(n int) model.Priority {
+	switch {
+	case n == 0 || n == 5:
+		return model.PriorityNormal
+	case n <= 2:
+		return model.PriorityUrgent
+	case n <= 4:
+		return model.PriorityHigh
+	default:
+		return model.PriorityLow
+	}
+}
@@ -0,0 +1,120 @@
+package ical
+
+import (
+	"dev11/pkg/model"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	e := &model.Event{
+		ID:          42,
+		Title:       "Встреча; обсуждение, итоги",
+		Description: strings.Repeat("Длинное описание\\ ", 10) + "\nконец",
+		Location:    "Room 4",
+		Category:    "work",
+		Tags:        []string{"q3", "planning"},
+		Priority:    model.PriorityUrgent,
+		Color:       "#1e88e5",
+		Date:        time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC),
+	}
+	data := Marshal(e)
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > lineLength {
+			t.Errorf("expected: lines of at most %d octets, got: %q", lineLength, line)
+		}
+	}
+	events, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected: 1 event, got: %d", len(events))
+	}
+	got := events[0]
+	if got.ICalUID != "42@dev11" || got.Title != e.Title || got.Description != e.Description || got.Location != e.Location ||
+		strings.Join(got.Tags, ",") != "work,q3,planning" || got.Priority != e.Priority || got.Color != e.Color || !got.Date.Equal(e.Date) {
+		t.Errorf("expected: %+v, got: %+v", *e, *got)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	wrap := func(lines ...string) string {
+		return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:1\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	}
+	tests := map[string]struct {
+		input    string
+		date     string
+		clock    string
+		duration int
+		priority model.Priority
+		err      error
+	}{
+		"date":                 {input: wrap("DTSTART;VALUE=DATE:20240515"), date: "2024-05-15", priority: model.PriorityNormal},
+		"utc date-time":        {input: wrap("DTSTART:20240515T230000Z"), date: "2024-05-15", priority: model.PriorityNormal},
+		"date-time in zone":    {input: wrap(`DTSTART;TZID="Asia/Tokyo":20240516T010000`), date: "2024-05-16", priority: model.PriorityNormal},
+		"unknown zone":         {input: wrap("DTSTART;TZID=Mars/Olympus:20240516T010000"), date: "2024-05-16", priority: model.PriorityNormal},
+		"low priority":         {input: wrap("DTSTART:20240515T100000Z", "PRIORITY:7"), date: "2024-05-15", priority: model.PriorityLow},
+		"lf line breaks":       {input: strings.ReplaceAll(wrap("DTSTART;VALUE=DATE:20240515"), "\r\n", "\n"), date: "2024-05-15", priority: model.PriorityNormal},
+		"floating date-time":   {input: wrap("DTSTART:20240515T103000"), date: "2024-05-15", clock: "10:30", priority: model.PriorityNormal},
+		"wall clock of zone":   {input: wrap(`DTSTART;TZID=Europe/Moscow:20240515T100000`, `DTEND;TZID=Europe/Moscow:20240515T113000`), date: "2024-05-15", clock: "10:00", duration: 90, priority: model.PriorityNormal},
+		"duration":             {input: wrap("DTSTART:20240515T100000Z", "DURATION:PT1H15M"), date: "2024-05-15", clock: "10:00", duration: 75, priority: model.PriorityNormal},
+		"longer than a day":    {input: wrap("DTSTART:20240515T100000Z", "DURATION:P1W"), date: "2024-05-15", clock: "10:00", duration: 24 * 60, priority: model.PriorityNormal},
+		"all-day with end":     {input: wrap("DTSTART;VALUE=DATE:20240515", "DTEND;VALUE=DATE:20240516"), date: "2024-05-15", clock: "00:00", priority: model.PriorityNormal},
+		"invalid duration":     {input: wrap("DTSTART:20240515T100000Z", "DURATION:1H"), err: ErrInvalid},
+		"invalid end":          {input: wrap("DTSTART:20240515T100000Z", "DTEND:later"), err: ErrInvalid},
+		"no start":             {input: wrap("SUMMARY:title"), err: ErrInvalid},
+		"invalid start":        {input: wrap("DTSTART:tomorrow"), err: ErrInvalid},
+		"invalid priority":     {input: wrap("DTSTART;VALUE=DATE:20240515", "PRIORITY:high"), err: ErrInvalid},
+		"recurring":            {input: wrap("DTSTART;VALUE=DATE:20240515", "RRULE:FREQ=DAILY"), err: ErrUnsupported},
+		"not a calendar":       {input: "BEGIN:VCARD\r\nEND:VCARD\r\n", err: ErrInvalid},
+		"unterminated":         {input: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20240515\r\n", err: ErrInvalid},
+		"no events":            {input: "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n", err: ErrInvalid},
+		"line without a value": {input: wrap("DTSTART;VALUE=DATE:20240515", "SUMMARY"), err: ErrInvalid},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			events, err := Unmarshal([]byte(v.input))
+			if v.err != nil {
+				if !errors.Is(err, v.err) {
+					t.Errorf("expected: %v, got: %v", v.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if date := events[0].Date.Format("2006-01-02"); date != v.date {
+				t.Errorf("expected: %s, got: %s", v.date, date)
+			}
+			if clock := events[0].Date.Format("15:04"); v.clock != "" && clock != v.clock {
+				t.Errorf("expected: %s, got: %s", v.clock, clock)
+			}
+			if events[0].Duration != v.duration {
+				t.Errorf("expected: duration %d, got: %d", v.duration, events[0].Duration)
+			}
+			if events[0].Priority != v.priority {
+				t.Errorf("expected: %v, got: %v", v.priority, events[0].Priority)
+			}
+		})
+	}
+}
+
+func TestMarshalTimed(t *testing.T) {
+	e := &model.Event{ID: 1, Title: "Standup", Date: time.Date(2024, time.May, 15, 10, 0, 0, 0, time.UTC), Duration: 30}
+	data := string(Marshal(e))
+	for _, line := range []string{"DTSTART:20240515T100000\r\n", "DTEND:20240515T103000\r\n"} {
+		if !strings.Contains(data, line) {
+			t.Errorf("expected: %q in %q", line, data)
+		}
+	}
+	events, err := Unmarshal([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !events[0].Date.Equal(e.Date) || events[0].Duration != e.Duration {
+		t.Errorf("expected: %v for %d minutes, got: %v for %d minutes", e.Date, e.Duration, events[0].Date, events[0].Duration)
+	}
+}
@@ -0,0 +1,109 @@
+// Package identity maps subjects of verified client certificates to users of the calendar.
+// Requests authenticated by certificate carry id of user and his tenant in their context and may access only his data,
+// requests without certificate belong to the default tenant and are not restricted.
+package identity
+
+import (
+	"context"
+	"crypto/x509"
+	"dev11/internal/tenant"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type contextKey struct{}
+
+// NewContext returns copy of ctx carrying id of authenticated user
+func NewContext(ctx context.Context, userID uint64) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// FromContext returns id of user authenticated by request with ctx
+func FromContext(ctx context.Context) (uint64, bool) {
+	userID, ok := ctx.Value(contextKey{}).(uint64)
+	return userID, ok
+}
+
+// Registry maps subjects of certificates in RFC 2253 form, like "CN=alice,O=Example", to users.
+// A user is either an id of user of the default tenant or an object {"tenant": id, "user": id}.
+type Registry struct {
+	path string
+
+	m        sync.RWMutex
+	subjects map[string]tenant.Key
+}
+
+// entry is a user in file of Registry
+type entry struct {
+	Tenant uint64 `json:"tenant"`
+	User   uint64 `json:"user"`
+}
+
+func (e *entry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.User); err == nil {
+		return nil
+	}
+	type plain entry
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// Load reads JSON object mapping subjects to ids of users from file and returns pointer to Registry
+func Load(path string) (*Registry, error) {
+	r := &Registry{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads file of Registry again, previous mapping is kept if file is invalid
+func (r *Registry) Reload() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	var entries map[string]entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s: %w", r.path, err)
+	}
+	subjects := make(map[string]tenant.Key, len(entries))
+	for subject, e := range entries {
+		if e.User == 0 {
+			return fmt.Errorf("%s: invalid user id of %q", r.path, subject)
+		}
+		subjects[subject] = tenant.Key{TenantID: e.Tenant, UserID: e.User}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.subjects = subjects
+	return nil
+}
+
+// User returns user identified by certificate
+func (r *Registry) User(cert *x509.Certificate) (tenant.Key, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	user, ok := r.subjects[cert.Subject.String()]
+	return user, ok
+}
+
+// Middleware adds id of user identified by verified client certificate and his tenant to context of request.
+// Requests with certificate of unknown subject are rejected.
+func (r *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		user, ok := r.User(req.TLS.VerifiedChains[0][0])
+		if !ok {
+			http.Error(w, "unknown client certificate", http.StatusForbidden)
+			return
+		}
+		ctx := tenant.NewContext(NewContext(req.Context(), user.UserID), user.TenantID)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,98 @@
+package identity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dev11/internal/tenant"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRegistry(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	writeRegistry(t, path, `{"CN=alice,O=Example": 1, "CN=bob": 2, "CN=carol": {"tenant": 7, "user": 1}}`)
+	r, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		subject *pkix.Name
+		code    int
+		user    string
+	}{
+		"no certificate":  {code: http.StatusOK, user: "anonymous"},
+		"known subject":   {subject: &pkix.Name{CommonName: "alice", Organization: []string{"Example"}}, code: http.StatusOK, user: "1"},
+		"only cn":         {subject: &pkix.Name{CommonName: "bob"}, code: http.StatusOK, user: "2"},
+		"other tenant":    {subject: &pkix.Name{CommonName: "carol"}, code: http.StatusOK, user: "7/1"},
+		"partial subject": {subject: &pkix.Name{CommonName: "alice"}, code: http.StatusForbidden},
+		"unknown subject": {subject: &pkix.Name{CommonName: "mallory"}, code: http.StatusForbidden},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/events_for_day", nil)
+			if v.subject != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: *v.subject}}}}
+			}
+			w := httptest.NewRecorder()
+			r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if userID, ok := FromContext(req.Context()); !ok {
+					fmt.Fprint(w, "anonymous")
+				} else if tenantID := tenant.FromContext(req.Context()); tenantID != 0 {
+					fmt.Fprintf(w, "%d/%d", tenantID, userID)
+				} else {
+					fmt.Fprint(w, userID)
+				}
+			})).ServeHTTP(w, req)
+			if w.Code != v.code || v.user != "" && w.Body.String() != v.user {
+				t.Errorf("expected: %d %s, got: %d %s", v.code, v.user, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	writeRegistry(t, path, `{"CN=alice": 1}`)
+	r, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	tests := []struct {
+		data string
+		fail bool
+		user tenant.Key
+	}{
+		{data: `{"CN=alice": 3}`, user: tenant.Key{UserID: 3}},
+		{data: `not json`, fail: true, user: tenant.Key{UserID: 3}},
+		{data: `{"CN=alice": 0}`, fail: true, user: tenant.Key{UserID: 3}},
+		{data: `{"CN=alice": {"tenant": 7, "user": 4}}`, user: tenant.Key{TenantID: 7, UserID: 4}},
+		{data: `{"CN=alice": {"tenant": 7}}`, fail: true, user: tenant.Key{TenantID: 7, UserID: 4}},
+		{data: `{"CN=alice": "3"}`, fail: true, user: tenant.Key{TenantID: 7, UserID: 4}},
+		{data: `{"CN=bob": 2}`},
+	}
+	for i, v := range tests {
+		writeRegistry(t, path, v.data)
+		if err := r.Reload(); (err != nil) != v.fail {
+			t.Errorf("%d: expected: error %v, got: %v", i, v.fail, err)
+		}
+		if user, _ := r.User(alice); user != v.user {
+			t.Errorf("%d: expected: %v, got: %v", i, v.user, user)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected: error for missing file, got: nil")
+	}
+}
@@ -0,0 +1,281 @@
+// Package openapi publishes OpenAPI 3 document of the calendar API and validates
+// requests and responses against it, so tests catch handlers drifting apart from the documentation.
+//
+// Only the subset of OpenAPI used by the document is supported: references to components,
+// parameters in path and query, form and multipart request bodies and JSON schemas
+// of types, formats, enums, patterns, bounds, allOf, required and additional properties.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// Components are reusable parts of Document referenced by "#/components/{kind}/{name}"
+type Components struct {
+	Schemas       map[string]*Schema      `json:"schemas"`
+	Parameters    map[string]*Parameter   `json:"parameters"`
+	RequestBodies map[string]*RequestBody `json:"requestBodies"`
+	Responses     map[string]*Response    `json:"responses"`
+}
+
+// PathItem contains operations of a path, path templates like {user_id} match a single segment.
+// WebDAVMethods lists methods which OpenAPI can't describe, their requests and responses are not validated.
+type PathItem struct {
+	Summary       string       `json:"summary"`
+	Parameters    []*Parameter `json:"parameters"`
+	Get           *Operation   `json:"get"`
+	Head          *Operation   `json:"head"`
+	Post          *Operation   `json:"post"`
+	Put           *Operation   `json:"put"`
+	Delete        *Operation   `json:"delete"`
+	Options       *Operation   `json:"options"`
+	WebDAVMethods []string     `json:"x-webdav-methods"`
+}
+
+// Operations returns operations of PathItem by HTTP methods
+func (p *PathItem) Operations() map[string]*Operation {
+	ops := map[string]*Operation{}
+	for method, op := range map[string]*Operation{
+		http.MethodGet: p.Get, http.MethodHead: p.Head, http.MethodPost: p.Post,
+		http.MethodPut: p.Put, http.MethodDelete: p.Delete, http.MethodOptions: p.Options,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// Operation is a single API operation on a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Description string               `json:"description"`
+	Tags        []string             `json:"tags"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter of an Operation in path or query
+type Parameter struct {
+	Ref         string  `json:"$ref"`
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody of an Operation by media types
+type RequestBody struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Required    bool                  `json:"required"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// Response of an Operation, Content is empty for responses without body
+type Response struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// MediaType describes content of a media type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema of a value, form values are validated against schemas of their properties
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Description          string             `json:"description"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	AllOf                []*Schema          `json:"allOf"`
+	Example              interface{}        `json:"example"`
+}
+
+// Spec returns OpenAPI document of the calendar API in JSON
+func Spec() []byte {
+	return spec
+}
+
+// Load parses the document of the calendar API
+func Load() (*Document, error) {
+	return Parse(spec)
+}
+
+// Parse parses OpenAPI document in JSON and resolves its references to components
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", doc.OpenAPI)
+	}
+	r := resolver{doc: &doc, seen: map[*Schema]bool{}}
+	for _, s := range doc.Components.Schemas {
+		r.schema(&s)
+	}
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		item := doc.Paths[path]
+		r.parameters(item.Parameters)
+		for method, op := range item.Operations() {
+			r.at = method + " " + path
+			r.parameters(op.Parameters)
+			if op.RequestBody != nil {
+				r.requestBody(&op.RequestBody)
+			}
+			if len(op.Responses) == 0 {
+				r.fail("no responses")
+			}
+			for code, resp := range op.Responses {
+				r.response(&resp)
+				op.Responses[code] = resp
+			}
+		}
+	}
+	return &doc, r.err
+}
+
+// Handler returns http.Handler serving the document of the calendar API
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	})
+}
+
+// resolver replaces references of Document by referenced components
+type resolver struct {
+	doc  *Document
+	seen map[*Schema]bool
+	at   string
+	err  error
+}
+
+func (r *resolver) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%s: %s", r.at, fmt.Sprintf(format, args...))
+	}
+}
+
+// component returns name of component referenced by ref of given kind
+func (r *resolver) component(ref, kind string) string {
+	name := strings.TrimPrefix(ref, "#/components/"+kind+"/")
+	if name == ref {
+		r.fail("unsupported reference %q", ref)
+	}
+	return name
+}
+
+func (r *resolver) schema(s **Schema) {
+	if *s == nil {
+		return
+	}
+	if (*s).Ref != "" {
+		target, ok := r.doc.Components.Schemas[r.component((*s).Ref, "schemas")]
+		if !ok {
+			r.fail("unknown schema %q", (*s).Ref)
+			return
+		}
+		*s = target
+	}
+	if r.seen[*s] {
+		return
+	}
+	r.seen[*s] = true
+	for name, p := range (*s).Properties {
+		r.schema(&p)
+		(*s).Properties[name] = p
+	}
+	r.schema(&(*s).Items)
+	for i := range (*s).AllOf {
+		r.schema(&(*s).AllOf[i])
+	}
+}
+
+func (r *resolver) parameters(params []*Parameter) {
+	for i, p := range params {
+		if p.Ref != "" {
+			target, ok := r.doc.Components.Parameters[r.component(p.Ref, "parameters")]
+			if !ok {
+				r.fail("unknown parameter %q", p.Ref)
+				continue
+			}
+			params[i] = target
+		}
+		if params[i].In != "query" && params[i].In != "path" {
+			r.fail("unsupported location %q of parameter %s", params[i].In, params[i].Name)
+		}
+		r.schema(&params[i].Schema)
+	}
+}
+
+func (r *resolver) requestBody(b **RequestBody) {
+	if (*b).Ref != "" {
+		target, ok := r.doc.Components.RequestBodies[r.component((*b).Ref, "requestBodies")]
+		if !ok {
+			r.fail("unknown request body %q", (*b).Ref)
+			return
+		}
+		*b = target
+	}
+	for _, m := range (*b).Content {
+		r.schema(&m.Schema)
+	}
+}
+
+func (r *resolver) response(resp **Response) {
+	if (*resp).Ref != "" {
+		target, ok := r.doc.Components.Responses[r.component((*resp).Ref, "responses")]
+		if !ok {
+			r.fail("unknown response %q", (*resp).Ref)
+			return
+		}
+		*resp = target
+	}
+	for _, m := range (*resp).Content {
+		r.schema(&m.Schema)
+	}
+}
@@ -0,0 +1,250 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSpec = `{
+	"openapi": "3.0.3",
+	"info": {"title": "test", "version": "1"},
+	"paths": {
+		"/items": {
+			"get": {
+				"parameters": [
+					{"$ref": "#/components/parameters/UserID"},
+					{"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 10}}
+				],
+				"responses": {
+					"200": {"description": "ok", "content": {"application/json": {"schema": {
+						"type": "object", "required": ["result"], "additionalProperties": false,
+						"properties": {"result": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}}}
+					}}}},
+					"4XX": {"description": "error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+				}
+			},
+			"post": {
+				"requestBody": {"required": true, "content": {"application/x-www-form-urlencoded": {"schema": {
+					"type": "object", "required": ["name"], "additionalProperties": false,
+					"properties": {"name": {"type": "string"}, "due": {"type": "string", "format": "date"},
+						"kind": {"type": "string", "enum": ["a", "b"]}}
+				}}}},
+				"responses": {"204": {"description": "created"}}
+			}
+		},
+		"/items/{id}": {
+			"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "format": "uint64"}}],
+			"x-webdav-methods": ["PROPFIND"],
+			"get": {"responses": {"200": {"description": "ok", "content": {"application/json": {"schema": {
+				"type": "object", "properties": {"next": {"nullable": true, "allOf": [{"$ref": "#/components/schemas/Item"}]}}
+			}}}}}}
+		},
+		"/items/search": {
+			"get": {"responses": {"200": {"description": "ok", "content": {"text/*": {}}}}}
+		}
+	},
+	"components": {
+		"parameters": {"UserID": {"name": "user_id", "in": "query", "required": true, "schema": {"type": "integer", "format": "uint64"}}},
+		"schemas": {
+			"Item": {"type": "object", "required": ["id"], "additionalProperties": false, "properties": {
+				"id": {"type": "integer", "format": "uint64"},
+				"color": {"type": "string", "pattern": "^#[0-9a-f]{6}$"},
+				"created": {"type": "string", "format": "date-time"}
+			}},
+			"Error": {"type": "object", "required": ["error"], "properties": {"error": {"type": "string"}}}
+		}
+	}
+}`
+
+func testValidator(t *testing.T) *Validator {
+	t.Helper()
+	doc, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewValidator(doc)
+}
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]string{}
+	for _, op := range NewValidator(doc).Operations() {
+		method, path, _ := strings.Cut(op, " ")
+		operation := doc.Paths[path].Operations()[method]
+		if operation.OperationID == "" || operation.Summary == "" {
+			t.Errorf("%s: expected: operationId and summary, got: %q, %q", op, operation.OperationID, operation.Summary)
+		}
+		if other, ok := ids[operation.OperationID]; ok {
+			t.Errorf("%s: expected: unique operationId, got: %s of %s", op, operation.OperationID, other)
+		}
+		ids[operation.OperationID] = op
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		spec string
+		fail bool
+	}{
+		"valid":             {spec: testSpec},
+		"invalid json":      {spec: `{`, fail: true},
+		"swagger 2":         {spec: `{"swagger": "2.0", "paths": {}}`, fail: true},
+		"unknown schema":    {spec: `{"openapi": "3.0.3", "components": {"schemas": {"A": {"$ref": "#/components/schemas/B"}}}}`, fail: true},
+		"external ref":      {spec: `{"openapi": "3.0.3", "components": {"schemas": {"A": {"$ref": "other.json#/B"}}}}`, fail: true},
+		"no responses":      {spec: `{"openapi": "3.0.3", "paths": {"/a": {"get": {}}}}`, fail: true},
+		"header parameter":  {spec: `{"openapi": "3.0.3", "paths": {"/a": {"get": {"parameters": [{"name": "X", "in": "header"}], "responses": {"200": {}}}}}}`, fail: true},
+		"unknown parameter": {spec: `{"openapi": "3.0.3", "paths": {"/a": {"get": {"parameters": [{"$ref": "#/components/parameters/X"}], "responses": {"200": {}}}}}}`, fail: true},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if _, err := Parse([]byte(v.spec)); (err != nil) != v.fail {
+				t.Errorf("expected: error %v, got: %v", v.fail, err)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	v := testValidator(t)
+	tests := map[string]struct {
+		path     string
+		expected string
+		id       string
+	}{
+		"static":             {path: "/items", expected: "/items"},
+		"static over param":  {path: "/items/search", expected: "/items/search"},
+		"template":           {path: "/items/42", expected: "/items/{id}", id: "42"},
+		"escaped":            {path: "/items/a%20b", expected: "/items/{id}", id: "a b"},
+		"empty segment":      {path: "/items/"},
+		"too many segments":  {path: "/items/42/x"},
+		"undocumented path":  {path: "/other"},
+		"trailing separator": {path: "/items/search/"},
+	}
+	for k, tc := range tests {
+		t.Run(k, func(t *testing.T) {
+			path, _, params := v.Find(tc.path)
+			if path != tc.expected || params["id"] != tc.id {
+				t.Errorf("expected: %q %q, got: %q %q", tc.expected, tc.id, path, params["id"])
+			}
+		})
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	tests := map[string]struct {
+		method, target, body string
+		problems             []string
+	}{
+		"valid query":        {method: "GET", target: "/items?user_id=1&limit=10"},
+		"missing required":   {method: "GET", target: "/items?limit=1", problems: []string{"missing query parameter user_id"}},
+		"out of bounds":      {method: "GET", target: "/items?user_id=1&limit=11", problems: []string{"limit: 11 is greater than 10"}},
+		"negative uint":      {method: "GET", target: "/items?user_id=-1", problems: []string{"user_id"}},
+		"undocumented query": {method: "GET", target: "/items?user_id=1&page=2", problems: []string{"query parameter page is not documented"}},
+		"valid form":         {method: "POST", target: "/items", body: "name=x&due=2024-05-15&kind=a"},
+		"invalid form":       {method: "POST", target: "/items", body: "due=15.05.2024&kind=c&extra=1", problems: []string{"name", "due", "kind", "extra"}},
+		"path parameter":     {method: "GET", target: "/items/x", problems: []string{"id"}},
+		"webdav method":      {method: "PROPFIND", target: "/items/1"},
+		"undocumented":       {method: "DELETE", target: "/items", problems: []string{"method DELETE of /items is not documented"}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			req := httptest.NewRequest(v.method, v.target, strings.NewReader(v.body))
+			if v.body != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			_, err := testValidator(t).ValidateRequest(req)
+			if (err != nil) != (len(v.problems) > 0) {
+				t.Fatalf("expected: problems %v, got: %v", v.problems, err)
+			}
+			for _, p := range v.problems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("expected: problem with %q, got: %v", p, err)
+				}
+			}
+			if v.body != "" {
+				if err := req.ParseForm(); err != nil || req.PostForm.Encode() == "" {
+					t.Errorf("expected: restored body, got: %v %v", req.PostForm, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	v := testValidator(t)
+	list := v.doc.Paths["/items"].Get
+	item := v.doc.Paths["/items/{id}"].Get
+	search := v.doc.Paths["/items/search"].Get
+	tests := map[string]struct {
+		op          *Operation
+		code        int
+		contentType string
+		body        string
+		problems    []string
+	}{
+		"valid":               {op: list, code: 200, body: `{"result": [{"id": 1, "color": "#00ff00", "created": "2024-05-15T10:00:00Z"}]}`},
+		"range of status":     {op: list, code: 404, body: `{"error": "not found"}`},
+		"undocumented status": {op: list, code: 500, body: `{"error": "internal"}`, problems: []string{"status 500 is not documented"}},
+		"missing property":    {op: list, code: 200, body: `{}`, problems: []string{"missing property result"}},
+		"extra property":      {op: list, code: 200, body: `{"result": [], "total": 0}`, problems: []string{"property total is not documented"}},
+		"invalid items": {op: list, code: 200, body: `{"result": [{"id": -1, "color": "red", "created": "today"}, null]}`,
+			problems: []string{"body.result[0].id", "body.result[0].color", "body.result[0].created", "body.result[1]: null"}},
+		"media type":      {op: list, code: 200, contentType: "text/plain", body: "ok", problems: []string{"media type text/plain"}},
+		"invalid json":    {op: list, code: 200, body: `{"result": [`, problems: []string{"invalid JSON"}},
+		"nullable allOf":  {op: item, code: 200, body: `{"next": null}`},
+		"allOf":           {op: item, code: 200, body: `{"next": {"name": "x"}}`, problems: []string{"missing property id", "property name"}},
+		"no content":      {op: v.doc.Paths["/items"].Post, code: 204},
+		"unexpected body": {op: v.doc.Paths["/items"].Post, code: 204, body: "x", problems: []string{"body is not documented"}},
+		"wildcard":        {op: search, code: 200, contentType: "text/html; charset=utf-8", body: "<p>"},
+	}
+	for k, tc := range tests {
+		t.Run(k, func(t *testing.T) {
+			header := http.Header{}
+			if tc.contentType == "" {
+				tc.contentType = "application/json"
+			}
+			header.Set("Content-Type", tc.contentType)
+			err := v.ValidateResponse(tc.op, tc.code, header, []byte(tc.body))
+			if (err != nil) != (len(tc.problems) > 0) {
+				t.Fatalf("expected: problems %v, got: %v", tc.problems, err)
+			}
+			for _, p := range tc.problems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("expected: problem with %q, got: %v", p, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	v := testValidator(t)
+	var reported []string
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": [{"id": "1"}]}`))
+	}), func(req *http.Request, err error) {
+		reported = append(reported, err.Error())
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items?limit=0", nil))
+	if len(reported) != 2 || !strings.HasPrefix(reported[0], "request: ") || !strings.HasPrefix(reported[1], "response: ") {
+		t.Errorf("expected: request and response problems, got: %v", reported)
+	}
+	unused := v.Unused()
+	if strings.Join(unused, ",") != "GET /items/search,GET /items/{id},POST /items" {
+		t.Errorf("expected: other operations unused, got: %v", unused)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" || w.Body.String() != string(Spec()) {
+		t.Errorf("expected: document, got: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
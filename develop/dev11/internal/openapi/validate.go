@@ -0,0 +1,517 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidationError lists problems of a request or a response
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// problems collects problems found by validation
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: p}
+}
+
+// Validator checks requests and responses against Document
+type Validator struct {
+	doc    *Document
+	routes []route
+
+	m sync.Mutex
+	// used contains operations which were requested
+	used map[string]bool
+}
+
+// route is a path of Document split into segments, templates are kept with braces
+type route struct {
+	path     string
+	segments []string
+	item     *PathItem
+}
+
+// NewValidator creates Validator of Document and returns pointer to it
+func NewValidator(doc *Document) *Validator {
+	v := &Validator{doc: doc, used: map[string]bool{}}
+	for path, item := range doc.Paths {
+		v.routes = append(v.routes, route{path: path, segments: strings.Split(path, "/"), item: item})
+	}
+	// paths without templates take precedence, like /attachments/download over /attachments/{id}
+	sort.Slice(v.routes, func(i, j int) bool {
+		return strings.Count(v.routes[i].path, "{") < strings.Count(v.routes[j].path, "{") ||
+			strings.Count(v.routes[i].path, "{") == strings.Count(v.routes[j].path, "{") && v.routes[i].path < v.routes[j].path
+	})
+	return v
+}
+
+// Find returns documented path matching escaped path of request with values of its path parameters
+func (v *Validator) Find(escapedPath string) (string, *PathItem, map[string]string) {
+	segments := strings.Split(escapedPath, "/")
+	for _, r := range v.routes {
+		if len(r.segments) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		for i, s := range r.segments {
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") && segments[i] != "" {
+				value, err := url.PathUnescape(segments[i])
+				if err != nil {
+					break
+				}
+				params[s[1:len(s)-1]] = value
+			} else if s != segments[i] {
+				break
+			}
+			if i == len(segments)-1 {
+				return r.path, r.item, params
+			}
+		}
+	}
+	return "", nil, nil
+}
+
+// Operations returns all operations of Document as "METHOD path"
+func (v *Validator) Operations() []string {
+	ops := []string{}
+	for _, r := range v.routes {
+		for method := range r.item.Operations() {
+			ops = append(ops, method+" "+r.path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Unused returns operations which were not requested through Middleware
+func (v *Validator) Unused() []string {
+	v.m.Lock()
+	defer v.m.Unlock()
+	unused := []string{}
+	for _, op := range v.Operations() {
+		if !v.used[op] {
+			unused = append(unused, op)
+		}
+	}
+	return unused
+}
+
+// ValidateRequest checks path, parameters and body of request and returns its Operation.
+// Nil Operation is returned for methods listed in x-webdav-methods. Body of request is restored after reading.
+func (v *Validator) ValidateRequest(req *http.Request) (*Operation, error) {
+	path, item, pathParams := v.Find(req.URL.EscapedPath())
+	if item == nil {
+		return nil, &ValidationError{Problems: []string{fmt.Sprintf("path %s is not documented", req.URL.Path)}}
+	}
+	for _, method := range item.WebDAVMethods {
+		if method == req.Method {
+			return nil, nil
+		}
+	}
+	op := item.Operations()[req.Method]
+	if op == nil {
+		return nil, &ValidationError{Problems: []string{fmt.Sprintf("method %s of %s is not documented", req.Method, path)}}
+	}
+	v.m.Lock()
+	v.used[req.Method+" "+path] = true
+	v.m.Unlock()
+
+	var p problems
+	query := req.URL.Query()
+	known := map[string]bool{}
+	for _, param := range append(append([]*Parameter{}, item.Parameters...), op.Parameters...) {
+		var values []string
+		switch param.In {
+		case "path":
+			values = []string{pathParams[param.Name]}
+		case "query":
+			values = query[param.Name]
+			known[param.Name] = true
+		}
+		if len(values) == 0 || values[0] == "" {
+			if param.Required {
+				p.add("missing %s parameter %s", param.In, param.Name)
+			}
+			continue
+		}
+		for _, value := range values {
+			validateString(param.Schema, value, param.Name, &p)
+		}
+	}
+	for name := range query {
+		if !known[name] {
+			p.add("query parameter %s is not documented", name)
+		}
+	}
+	v.validateBody(req, op, &p)
+	return op, p.err()
+}
+
+// validateBody checks form body of request, multipart bodies are checked only for their media type
+// because handlers stream them
+func (v *Validator) validateBody(req *http.Request, op *Operation, p *problems) {
+	if op.RequestBody == nil {
+		if req.ContentLength > 0 {
+			p.add("request body is not documented")
+		}
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		if op.RequestBody.Required {
+			p.add("missing request body")
+		}
+		return
+	}
+	media := matchMedia(op.RequestBody.Content, mediaType)
+	if media == nil {
+		p.add("request media type %s is not documented", mediaType)
+		return
+	}
+	if mediaType != "application/x-www-form-urlencoded" || media.Schema == nil {
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		p.add("reading request body: %v", err)
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		p.add("invalid form: %v", err)
+		return
+	}
+	validateForm(media.Schema, form, p)
+}
+
+// validateForm checks form values against properties of object schema
+func validateForm(s *Schema, form url.Values, p *problems) {
+	for _, name := range s.Required {
+		if form.Get(name) == "" {
+			p.add("missing form field %s", name)
+		}
+	}
+	for name, values := range form {
+		prop, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties == nil || *s.AdditionalProperties {
+				continue
+			}
+			p.add("form field %s is not documented", name)
+			continue
+		}
+		for _, value := range values {
+			if value != "" {
+				validateString(prop, value, name, p)
+			}
+		}
+	}
+}
+
+// validateString checks value of parameter or form field given as a string against schema of its type
+func validateString(s *Schema, value, name string, p *problems) {
+	if s == nil {
+		return
+	}
+	switch s.Type {
+	case "integer":
+		n, err := parseInteger(value, s.Format)
+		if err != nil {
+			p.add("%s: %q is not an integer", name, value)
+			return
+		}
+		checkBounds(s, n, name, p)
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			p.add("%s: %q is not a number", name, value)
+			return
+		}
+		checkBounds(s, n, name, p)
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			p.add("%s: %q is not a boolean", name, value)
+		}
+	default:
+		checkString(s, value, name, p)
+	}
+	checkEnum(s, value, name, p)
+}
+
+// parseInteger parses integer of format int32, int64 or uint64 and returns it as float64 for bound checks
+func parseInteger(value, format string) (float64, error) {
+	if format == "uint64" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		return float64(n), err
+	}
+	bits := 64
+	if format == "int32" {
+		bits = 32
+	}
+	n, err := strconv.ParseInt(value, 10, bits)
+	return float64(n), err
+}
+
+func checkBounds(s *Schema, n float64, name string, p *problems) {
+	if s.Minimum != nil && n < *s.Minimum {
+		p.add("%s: %v is less than %v", name, n, *s.Minimum)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		p.add("%s: %v is greater than %v", name, n, *s.Maximum)
+	}
+}
+
+var patterns sync.Map
+
+func checkString(s *Schema, value, name string, p *problems) {
+	switch s.Format {
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			p.add("%s: %q is not a date", name, value)
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			p.add("%s: %q is not a date-time", name, value)
+		}
+	}
+	if s.Pattern != "" {
+		re, ok := patterns.Load(s.Pattern)
+		if !ok {
+			compiled, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				p.add("%s: invalid pattern %q", name, s.Pattern)
+				return
+			}
+			re, _ = patterns.LoadOrStore(s.Pattern, compiled)
+		}
+		if !re.(*regexp.Regexp).MatchString(value) {
+			p.add("%s: %q doesn't match %s", name, value, s.Pattern)
+		}
+	}
+}
+
+// checkEnum checks value against enum of schema comparing their text
+func checkEnum(s *Schema, value, name string, p *problems) {
+	if len(s.Enum) == 0 {
+		return
+	}
+	for _, e := range s.Enum {
+		if fmt.Sprint(e) == value {
+			return
+		}
+	}
+	p.add("%s: %q is not one of %v", name, value, s.Enum)
+}
+
+// ValidateResponse checks status code, media type and JSON body of response to Operation
+func (v *Validator) ValidateResponse(op *Operation, code int, header http.Header, body []byte) error {
+	var p problems
+	resp := op.Responses[strconv.Itoa(code)]
+	if resp == nil {
+		resp = op.Responses[strconv.Itoa(code/100)+"XX"]
+	}
+	if resp == nil {
+		resp = op.Responses["default"]
+	}
+	if resp == nil {
+		p.add("status %d is not documented", code)
+		return p.err()
+	}
+	if len(resp.Content) == 0 {
+		if len(body) > 0 {
+			p.add("status %d: body is not documented", code)
+		}
+		return p.err()
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		p.add("status %d: invalid media type %q", code, header.Get("Content-Type"))
+		return p.err()
+	}
+	media := matchMedia(resp.Content, mediaType)
+	if media == nil {
+		p.add("status %d: media type %s is not documented", code, mediaType)
+		return p.err()
+	}
+	if mediaType != "application/json" || media.Schema == nil {
+		return p.err()
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var value interface{}
+	if err := d.Decode(&value); err != nil {
+		p.add("status %d: invalid JSON: %v", code, err)
+		return p.err()
+	}
+	validateJSON(media.Schema, value, "body", &p)
+	return p.err()
+}
+
+// matchMedia returns MediaType of content matching media type, wildcards like text/* and */* are supported
+func matchMedia(content map[string]*MediaType, mediaType string) *MediaType {
+	if m, ok := content[mediaType]; ok {
+		return m
+	}
+	if i := strings.Index(mediaType, "/"); i >= 0 {
+		if m, ok := content[mediaType[:i]+"/*"]; ok {
+			return m
+		}
+	}
+	return content["*/*"]
+}
+
+// validateJSON checks decoded JSON value against schema, numbers are decoded as json.Number
+func validateJSON(s *Schema, value interface{}, at string, p *problems) {
+	if s == nil {
+		return
+	}
+	if value == nil {
+		if !s.Nullable && (s.Type != "" || len(s.AllOf) > 0) {
+			p.add("%s: null is not allowed", at)
+		}
+		return
+	}
+	for _, sub := range s.AllOf {
+		validateJSON(sub, value, at, p)
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			p.add("%s: expected object, got %T", at, value)
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				p.add("%s: missing property %s", at, name)
+			}
+		}
+		for name, v := range obj {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					p.add("%s: property %s is not documented", at, name)
+				}
+				continue
+			}
+			validateJSON(prop, v, at+"."+name, p)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			p.add("%s: expected array, got %T", at, value)
+			return
+		}
+		for i, item := range items {
+			validateJSON(s.Items, item, fmt.Sprintf("%s[%d]", at, i), p)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			p.add("%s: expected string, got %T", at, value)
+			return
+		}
+		checkString(s, str, at, p)
+		checkEnum(s, str, at, p)
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			p.add("%s: expected integer, got %T", at, value)
+			return
+		}
+		f, err := parseInteger(n.String(), s.Format)
+		if err != nil {
+			p.add("%s: %s is not an integer of format %q", at, n, s.Format)
+			return
+		}
+		checkBounds(s, f, at, p)
+		checkEnum(s, n.String(), at, p)
+	case "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			p.add("%s: expected number, got %T", at, value)
+			return
+		}
+		f, err := n.Float64()
+		if err != nil || math.IsInf(f, 0) {
+			p.add("%s: %s is not a number", at, n)
+			return
+		}
+		checkBounds(s, f, at, p)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			p.add("%s: expected boolean, got %T", at, value)
+		}
+	}
+}
+
+// recorder captures status code and body of response passing them through
+type recorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// Middleware validates requests and their responses and passes problems to report,
+// requests are served even if they are invalid
+func (v *Validator) Middleware(next http.Handler, report func(req *http.Request, err error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		op, err := v.ValidateRequest(req)
+		if err != nil {
+			report(req, fmt.Errorf("request: %w", err))
+		}
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, req)
+		if op == nil {
+			return
+		}
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		if err := v.ValidateResponse(op, rec.code, w.Header(), rec.body.Bytes()); err != nil {
+			report(req, fmt.Errorf("response: %w", err))
+		}
+	})
+}
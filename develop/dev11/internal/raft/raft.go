@@ -0,0 +1,934 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Raft errors
+var (
+	ErrNotLeader      = errors.New("node is not a leader")
+	ErrNoLeader       = errors.New("leader is unknown")
+	ErrLeadershipLost = errors.New("leadership lost before command was committed")
+	ErrStopped        = errors.New("node is stopped")
+	ErrStorage        = errors.New("storage of node failed")
+)
+
+// Defaults of Config
+const (
+	DefaultMaxAppendSize = 1 << 20
+	// DefaultDedupSize is enough for retries of proposals made during a few seconds under heavy load
+	DefaultDedupSize = 1 << 14
+	// DefaultSnapshotEntries keeps log small enough to be loaded and replayed quickly
+	DefaultSnapshotEntries = 10000
+)
+
+// State is a role of a node in cluster
+type State int
+
+// States of a node
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+// ApplyFunc applies a committed command to state machine and returns result of the command.
+// It is called for every command in the same order on every node of cluster.
+type ApplyFunc func(command []byte) []byte
+
+// SnapshotFunc returns state of state machine with every command applied so far,
+// it's called between calls of ApplyFunc
+type SnapshotFunc func() ([]byte, error)
+
+// RestoreFunc replaces state of state machine with state returned by SnapshotFunc,
+// it's called before node starts or between calls of ApplyFunc
+type RestoreFunc func(state []byte) error
+
+// Config contains settings of a node
+type Config struct {
+	// ID of node, must be positive and unique in cluster
+	ID uint64
+	// Peers contains base URLs of nodes of cluster by their ids, the node itself may be omitted
+	Peers map[uint64]string
+	// HeartbeatInterval is an interval between AppendEntries requests sent by leader
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is a minimal time without requests from leader after which follower starts election,
+	// the actual timeout is chosen randomly from [ElectionTimeout, 2*ElectionTimeout)
+	ElectionTimeout time.Duration
+	// Client is used to send requests to other nodes
+	Client *http.Client
+	// Dir is a directory where term, vote, snapshot and log are saved, it's required:
+	// a node forgetting them could vote twice in a term or lose committed entries
+	Dir string
+	// Secret is shared by nodes of cluster, requests of Handler without it are rejected.
+	// It's required if cluster has other nodes.
+	Secret string
+	// MaxAppendSize limits total size of commands sent in one AppendEntries request,
+	// a single larger command is still sent alone. DefaultMaxAppendSize is used if it's 0.
+	MaxAppendSize int
+	// DedupSize is a number of the latest proposals remembered to apply a retried proposal only once,
+	// DefaultDedupSize is used if it's 0
+	DedupSize int
+	// Snapshot and Restore save and load state of state machine. If they are set, node saves snapshot of applied state
+	// after every SnapshotEntries applied entries and drops the log before it, so log in memory and on disk is bounded
+	// by about SnapshotEntries entries. Followers missing dropped entries get the snapshot instead.
+	// Without Snapshot log is never compacted.
+	Snapshot SnapshotFunc
+	Restore  RestoreFunc
+	// SnapshotEntries is a number of applied entries between snapshots, DefaultSnapshotEntries is used if it's 0
+	SnapshotEntries int
+}
+
+// Entry is a record of replicated log, ID identifies proposal of command, so its retries are applied once
+type Entry struct {
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"`
+	ID      uint64 `json:"id,omitempty"`
+	Command []byte `json:"command"`
+}
+
+type waiter struct {
+	term   uint64
+	result chan []byte
+	err    chan error
+}
+
+// Node is a member of Raft cluster: it takes part in leader election,
+// replicates log from leader and applies committed commands with ApplyFunc.
+// Term, vote and log are saved to Config.Dir before node answers requests depending on them,
+// a restarted node restores its snapshot and applies log after it again as entries are committed.
+// If saving fails node stops taking part in cluster, so it never acknowledges what it may forget.
+// The first entry of log is a placeholder with index and term of the latest snapshot, they are zero without one.
+type Node struct {
+	cfg   Config
+	apply ApplyFunc
+	rnd   *rand.Rand
+	store *storage
+
+	m           sync.Mutex
+	state       State
+	term        uint64
+	votedFor    uint64
+	leaderID    uint64
+	log         []Entry
+	commitIndex uint64
+	lastApplied uint64
+	nextIndex   map[uint64]uint64
+	matchIndex  map[uint64]uint64
+	inflight    map[uint64]bool
+	lastContact time.Time
+	deadline    time.Time
+	waiters     map[uint64]*waiter
+	failed      error
+
+	// results of the latest applied proposals by their ids, only applier uses them
+	results     map[uint64][]byte
+	resultOrder []uint64
+	// installed is a snapshot received from leader which applier must restore before applying entries after it
+	installed *snapshot
+
+	applyCh chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates a Node with given Config and ApplyFunc loading its state from Config.Dir and returns pointer to it
+func New(cfg Config, apply ApplyFunc) (*Node, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("raft: directory of node is required")
+	}
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if cfg.ElectionTimeout == 0 {
+		cfg.ElectionTimeout = 10 * cfg.HeartbeatInterval
+	}
+	if cfg.MaxAppendSize == 0 {
+		cfg.MaxAppendSize = DefaultMaxAppendSize
+	}
+	if cfg.DedupSize == 0 {
+		cfg.DedupSize = DefaultDedupSize
+	}
+	if cfg.SnapshotEntries == 0 {
+		cfg.SnapshotEntries = DefaultSnapshotEntries
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{}
+	}
+	peers := map[uint64]string{}
+	for id, addr := range cfg.Peers {
+		if id != cfg.ID {
+			peers[id] = addr
+		}
+	}
+	cfg.Peers = peers
+	if len(peers) > 0 && cfg.Secret == "" {
+		return nil, errors.New("raft: secret of cluster is required")
+	}
+	store, state, snap, entries, err := openStorage(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("raft: %w", err)
+	}
+	n := &Node{
+		cfg:      cfg,
+		apply:    apply,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano() + int64(cfg.ID))),
+		store:    store,
+		term:     state.Term,
+		votedFor: state.VotedFor,
+		log:      append([]Entry{{}}, entries...),
+		waiters:  map[uint64]*waiter{},
+		results:  map[uint64][]byte{},
+		applyCh:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	if snap != nil {
+		if err := n.restore(snap); err != nil {
+			store.close()
+			return nil, fmt.Errorf("raft: %w", err)
+		}
+		n.log[0] = Entry{Index: snap.Index, Term: snap.Term}
+		n.commitIndex, n.lastApplied = snap.Index, snap.Index
+	}
+	n.resetDeadline()
+	return n, nil
+}
+
+// Start runs election timer, heartbeats and applying of committed commands in background
+func (n *Node) Start() {
+	n.wg.Add(2)
+	go n.runTicker()
+	go n.runApplier()
+}
+
+// Stop stops background goroutines of node and closes its storage, pending Submit calls return ErrStopped
+func (n *Node) Stop() {
+	close(n.stop)
+	n.wg.Wait()
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.failLocked(ErrStopped)
+	n.store.close()
+}
+
+// Status returns state, current term and id of known leader of node
+func (n *Node) Status() (State, uint64, uint64) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return n.state, n.term, n.leaderID
+}
+
+// LastContact returns time of the last request from leader, or now if node is a leader itself
+func (n *Node) LastContact() time.Time {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.state == Leader {
+		return time.Now()
+	}
+	return n.lastContact
+}
+
+// Submit appends command to log if node is a leader, waits until it's committed and applied and returns its result
+func (n *Node) Submit(ctx context.Context, command []byte) ([]byte, error) {
+	return n.submit(ctx, n.newProposalID(), command)
+}
+
+// submit appends command of proposal with given id to log, a proposal already applied is not applied again:
+// its entry gets result of the first one
+func (n *Node) submit(ctx context.Context, id uint64, command []byte) ([]byte, error) {
+	n.m.Lock()
+	if n.failed != nil {
+		n.m.Unlock()
+		return nil, n.failed
+	}
+	if n.state != Leader {
+		n.m.Unlock()
+		return nil, ErrNotLeader
+	}
+	index := n.appendLocked(id, command)
+	if n.failed != nil {
+		n.m.Unlock()
+		return nil, n.failed
+	}
+	w := &waiter{term: n.term, result: make(chan []byte, 1), err: make(chan error, 1)}
+	n.waiters[index] = w
+	n.advanceCommitLocked()
+	n.m.Unlock()
+	n.broadcast()
+
+	select {
+	case result := <-w.result:
+		return result, nil
+	case err := <-w.err:
+		return nil, err
+	case <-ctx.Done():
+		n.m.Lock()
+		delete(n.waiters, index)
+		n.m.Unlock()
+		return nil, ctx.Err()
+	case <-n.stop:
+		return nil, ErrStopped
+	}
+}
+
+// Propose submits command to leader of cluster: directly if node is a leader or by forwarding it to leader otherwise.
+// While leader is unknown or changing it retries until ctx is done. Retries keep id of proposal,
+// so command which reached log before an error is applied once.
+func (n *Node) Propose(ctx context.Context, command []byte) ([]byte, error) {
+	id := n.newProposalID()
+	for {
+		state, _, leaderID := n.Status()
+		var result []byte
+		var err error
+		switch {
+		case state == Leader:
+			result, err = n.submit(ctx, id, command)
+		case leaderID != 0:
+			result, err = n.forward(ctx, leaderID, id, command)
+		default:
+			err = ErrNoLeader
+		}
+		if err == nil || !retryable(err) {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-n.stop:
+			return nil, ErrStopped
+		case <-time.After(n.cfg.HeartbeatInterval):
+		}
+	}
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, ErrNotLeader) || errors.Is(err, ErrNoLeader) || errors.Is(err, ErrLeadershipLost) ||
+		errors.Is(err, errUnreachable)
+}
+
+func (n *Node) newProposalID() uint64 {
+	n.m.Lock()
+	defer n.m.Unlock()
+	for {
+		if id := n.rnd.Uint64(); id != 0 {
+			return id
+		}
+	}
+}
+
+func (n *Node) runTicker() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			n.m.Lock()
+			n.failWaitersLocked(ErrStopped)
+			n.m.Unlock()
+			return
+		case now := <-ticker.C:
+			n.m.Lock()
+			state := n.state
+			expired := now.After(n.deadline)
+			failed := n.failed != nil
+			n.m.Unlock()
+			switch {
+			case failed:
+			case state == Leader:
+				n.broadcast()
+			case expired:
+				n.startElection()
+			}
+		}
+	}
+}
+
+func (n *Node) runApplier() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.applyCh:
+		}
+		for {
+			n.m.Lock()
+			if snap := n.installed; snap != nil {
+				n.installed = nil
+				n.m.Unlock()
+				err := n.restore(snap)
+				n.m.Lock()
+				if err != nil {
+					n.failLocked(err)
+					n.m.Unlock()
+					return
+				}
+				n.lastApplied = snap.Index
+				n.m.Unlock()
+				continue
+			}
+			if n.lastApplied >= n.commitIndex {
+				n.m.Unlock()
+				break
+			}
+			entry := n.entry(n.lastApplied + 1)
+			n.m.Unlock()
+
+			result := n.applyEntry(entry)
+
+			n.m.Lock()
+			n.lastApplied = entry.Index
+			if w, ok := n.waiters[entry.Index]; ok {
+				delete(n.waiters, entry.Index)
+				if w.term == entry.Term {
+					w.result <- result
+				} else {
+					w.err <- ErrLeadershipLost
+				}
+			}
+			n.m.Unlock()
+		}
+		n.snapshotIfDue()
+	}
+}
+
+// applyEntry applies command of entry unless its proposal was applied already
+func (n *Node) applyEntry(entry Entry) []byte {
+	if entry.Command == nil {
+		return nil
+	}
+	if entry.ID == 0 {
+		return n.apply(entry.Command)
+	}
+	if result, ok := n.results[entry.ID]; ok {
+		return result
+	}
+	result := n.apply(entry.Command)
+	// every node applies the same entries in the same order, so they forget the same proposals
+	n.results[entry.ID] = result
+	n.resultOrder = append(n.resultOrder, entry.ID)
+	if len(n.resultOrder) > n.cfg.DedupSize {
+		delete(n.results, n.resultOrder[0])
+		n.resultOrder = n.resultOrder[1:]
+	}
+	return result
+}
+
+// restore replaces state of state machine and results of proposals with those of snapshot,
+// it's called before node starts or by applier
+func (n *Node) restore(snap *snapshot) error {
+	if n.cfg.Restore == nil {
+		return fmt.Errorf("%w: snapshot %d can't be restored without Restore", ErrStorage, snap.Index)
+	}
+	if err := n.cfg.Restore(snap.State); err != nil {
+		return fmt.Errorf("%w: restoring snapshot %d: %v", ErrStorage, snap.Index, err)
+	}
+	n.results = map[uint64][]byte{}
+	n.resultOrder = nil
+	for _, r := range snap.Results {
+		n.results[r.ID] = r.Result
+		n.resultOrder = append(n.resultOrder, r.ID)
+	}
+	return nil
+}
+
+// snapshotIfDue saves snapshot of applied state and drops log before it once Config.SnapshotEntries entries
+// were applied after the previous snapshot. It's called by applier, so state machine doesn't change meanwhile.
+func (n *Node) snapshotIfDue() {
+	if n.cfg.Snapshot == nil {
+		return
+	}
+	n.m.Lock()
+	index := n.lastApplied
+	due := n.failed == nil && n.installed == nil && index-n.log[0].Index >= uint64(n.cfg.SnapshotEntries)
+	var term uint64
+	if due {
+		term = n.entry(index).Term
+	}
+	n.m.Unlock()
+	if !due {
+		return
+	}
+	state, err := n.cfg.Snapshot()
+	if err != nil {
+		// log is kept until the next attempt
+		log.Printf("raft: node %d: snapshot: %v", n.cfg.ID, err)
+		return
+	}
+	snap := &snapshot{Index: index, Term: term, State: state}
+	for _, id := range n.resultOrder {
+		snap.Results = append(snap.Results, proposalResult{ID: id, Result: n.results[id]})
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+	// snapshot installed from leader meanwhile is newer
+	if index <= n.log[0].Index {
+		return
+	}
+	n.compactLocked(snap, n.log[index-n.log[0].Index+1:])
+}
+
+// compactLocked saves snapshot with entries following it and replaces log with them
+func (n *Node) compactLocked(snap *snapshot, entries []Entry) {
+	l := append([]Entry{{Index: snap.Index, Term: snap.Term}}, entries...)
+	if err := n.store.saveSnapshot(snap, l[1:]); err != nil {
+		n.failLocked(fmt.Errorf("%w: snapshot: %v", ErrStorage, err))
+		return
+	}
+	n.log = l
+}
+
+func (n *Node) startElection() {
+	n.m.Lock()
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.cfg.ID
+	n.leaderID = 0
+	n.resetDeadline()
+	if !n.saveStateLocked() {
+		n.m.Unlock()
+		return
+	}
+	term := n.term
+	args := requestVoteArgs{Term: term, CandidateID: n.cfg.ID, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+	n.m.Unlock()
+
+	votes := 1
+	if votes > (len(n.cfg.Peers)+1)/2 {
+		n.m.Lock()
+		n.becomeLeaderLocked()
+		n.m.Unlock()
+		return
+	}
+	for id := range n.cfg.Peers {
+		go func(id uint64) {
+			var reply requestVoteReply
+			if err := n.call(id, pathRequestVote, args, &reply); err != nil {
+				return
+			}
+			n.m.Lock()
+			defer n.m.Unlock()
+			if reply.Term > n.term {
+				n.becomeFollowerLocked(reply.Term)
+				return
+			}
+			if n.state != Candidate || n.term != term || !reply.VoteGranted {
+				return
+			}
+			votes++
+			if votes > (len(n.cfg.Peers)+1)/2 {
+				n.becomeLeaderLocked()
+				go n.broadcast()
+			}
+		}(id)
+	}
+}
+
+// broadcast sends AppendEntries to every peer which has no request in flight
+func (n *Node) broadcast() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.state != Leader {
+		return
+	}
+	for id := range n.cfg.Peers {
+		if !n.inflight[id] {
+			n.inflight[id] = true
+			go n.replicate(id)
+		}
+	}
+}
+
+// replicate sends entries to peer until it catches up with leader log
+func (n *Node) replicate(id uint64) {
+	for {
+		n.m.Lock()
+		if n.state != Leader {
+			n.inflight[id] = false
+			n.m.Unlock()
+			return
+		}
+		next := n.nextIndex[id]
+		if next <= n.log[0].Index {
+			term := n.term
+			n.m.Unlock()
+			if !n.sendSnapshot(id, term) {
+				return
+			}
+			continue
+		}
+		args := appendEntriesArgs{
+			Term:         n.term,
+			LeaderID:     n.cfg.ID,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  n.entry(next - 1).Term,
+			Entries:      n.batchLocked(next),
+			LeaderCommit: n.commitIndex,
+		}
+		n.m.Unlock()
+
+		var reply appendEntriesReply
+		err := n.call(id, pathAppendEntries, args, &reply)
+
+		n.m.Lock()
+		if err != nil || n.state != Leader || n.term != args.Term {
+			n.inflight[id] = false
+			n.m.Unlock()
+			return
+		}
+		if reply.Term > n.term {
+			n.becomeFollowerLocked(reply.Term)
+			n.inflight[id] = false
+			n.m.Unlock()
+			return
+		}
+		if reply.Success {
+			match := args.PrevLogIndex + uint64(len(args.Entries))
+			if match > n.matchIndex[id] {
+				n.matchIndex[id] = match
+			}
+			n.nextIndex[id] = match + 1
+			n.advanceCommitLocked()
+		} else {
+			n.nextIndex[id] = reply.ConflictIndex
+			if n.nextIndex[id] < 1 {
+				n.nextIndex[id] = 1
+			}
+		}
+		if n.nextIndex[id] > n.lastIndex() {
+			n.inflight[id] = false
+			n.m.Unlock()
+			return
+		}
+		n.m.Unlock()
+	}
+}
+
+// sendSnapshot sends the latest snapshot to peer which needs entries dropped from log
+// and reports whether replication to peer goes on
+func (n *Node) sendSnapshot(id, term uint64) bool {
+	snap, err := n.store.loadSnapshot()
+	if err == nil && snap == nil {
+		err = errors.New("no snapshot")
+	}
+	var reply installSnapshotReply
+	if err == nil {
+		// snapshot may be much larger than a batch of entries
+		ctx, cancel := context.WithTimeout(context.Background(), 10*n.cfg.ElectionTimeout)
+		err = n.callContext(ctx, id, pathInstallSnapshot, installSnapshotArgs{Term: term, LeaderID: n.cfg.ID, Snapshot: *snap}, &reply)
+		cancel()
+	} else {
+		log.Printf("raft: node %d: loading snapshot: %v", n.cfg.ID, err)
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+	if err != nil || n.state != Leader || n.term != term {
+		n.inflight[id] = false
+		return false
+	}
+	if reply.Term > n.term {
+		n.becomeFollowerLocked(reply.Term)
+		n.inflight[id] = false
+		return false
+	}
+	if snap.Index > n.matchIndex[id] {
+		n.matchIndex[id] = snap.Index
+	}
+	n.nextIndex[id] = snap.Index + 1
+	n.advanceCommitLocked()
+	if n.nextIndex[id] > n.lastIndex() {
+		n.inflight[id] = false
+		return false
+	}
+	return true
+}
+
+// batchLocked returns entries of log from index next whose commands fit in Config.MaxAppendSize, at least one entry
+func (n *Node) batchLocked(next uint64) []Entry {
+	end, size := next, 0
+	for end <= n.lastIndex() {
+		size += len(n.entry(end).Command)
+		if end > next && size > n.cfg.MaxAppendSize {
+			break
+		}
+		end++
+	}
+	base := n.log[0].Index
+	return append([]Entry(nil), n.log[next-base:end-base]...)
+}
+
+func (n *Node) handleRequestVote(args requestVoteArgs) (requestVoteReply, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.failed != nil {
+		return requestVoteReply{}, n.failed
+	}
+	if args.Term > n.term {
+		n.becomeFollowerLocked(args.Term)
+	}
+	upToDate := args.LastLogTerm > n.lastTerm() || (args.LastLogTerm == n.lastTerm() && args.LastLogIndex >= n.lastIndex())
+	granted := args.Term == n.term && (n.votedFor == 0 || n.votedFor == args.CandidateID) && upToDate
+	if granted && n.votedFor == 0 {
+		n.votedFor = args.CandidateID
+		n.saveStateLocked()
+	}
+	if n.failed != nil {
+		return requestVoteReply{}, n.failed
+	}
+	if granted {
+		n.resetDeadline()
+	}
+	return requestVoteReply{Term: n.term, VoteGranted: granted}, nil
+}
+
+func (n *Node) handleAppendEntries(args appendEntriesArgs) (appendEntriesReply, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.failed != nil {
+		return appendEntriesReply{}, n.failed
+	}
+	if args.Term < n.term {
+		return appendEntriesReply{Term: n.term}, nil
+	}
+	if args.Term > n.term || n.state != Follower {
+		n.becomeFollowerLocked(args.Term)
+		if n.failed != nil {
+			return appendEntriesReply{}, n.failed
+		}
+	}
+	n.leaderID = args.LeaderID
+	n.lastContact = time.Now()
+	n.resetDeadline()
+
+	base := n.log[0].Index
+	if args.PrevLogIndex < base {
+		// entries up to snapshot are committed, so they match log of leader
+		skip := base - args.PrevLogIndex
+		if skip < uint64(len(args.Entries)) {
+			args.Entries = args.Entries[skip:]
+		} else {
+			args.Entries = nil
+		}
+		args.PrevLogIndex, args.PrevLogTerm = base, n.log[0].Term
+	}
+	if args.PrevLogIndex > n.lastIndex() {
+		return appendEntriesReply{Term: n.term, ConflictIndex: n.lastIndex() + 1}, nil
+	}
+	if term := n.entry(args.PrevLogIndex).Term; term != args.PrevLogTerm {
+		conflict := args.PrevLogIndex
+		for conflict > base+1 && n.entry(conflict-1).Term == term {
+			conflict--
+		}
+		return appendEntriesReply{Term: n.term, ConflictIndex: conflict}, nil
+	}
+	var appended []Entry
+	truncated := false
+	for _, e := range args.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.entry(e.Index).Term == e.Term {
+				continue
+			}
+			n.log = n.log[:e.Index-base]
+			truncated = true
+		}
+		n.log = append(n.log, e)
+		appended = append(appended, e)
+	}
+	// entries are saved before leader counts them as replicated
+	var err error
+	switch {
+	case truncated:
+		err = n.store.rewrite(n.log[1:])
+	case len(appended) > 0:
+		err = n.store.append(appended)
+	}
+	if err != nil {
+		n.failLocked(fmt.Errorf("%w: log: %v", ErrStorage, err))
+		return appendEntriesReply{}, n.failed
+	}
+	if args.LeaderCommit > n.commitIndex {
+		last := args.PrevLogIndex + uint64(len(args.Entries))
+		if args.LeaderCommit < last {
+			last = args.LeaderCommit
+		}
+		if last > n.commitIndex {
+			n.commitIndex = last
+			n.notifyApplier()
+		}
+	}
+	return appendEntriesReply{Term: n.term, Success: true}, nil
+}
+
+// handleInstallSnapshot replaces log of follower which is behind snapshot of leader with the snapshot,
+// entries following snapshot are kept if log has the last entry of snapshot
+func (n *Node) handleInstallSnapshot(args installSnapshotArgs) (installSnapshotReply, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	if n.failed != nil {
+		return installSnapshotReply{}, n.failed
+	}
+	if args.Term < n.term {
+		return installSnapshotReply{Term: n.term}, nil
+	}
+	if args.Term > n.term || n.state != Follower {
+		n.becomeFollowerLocked(args.Term)
+		if n.failed != nil {
+			return installSnapshotReply{}, n.failed
+		}
+	}
+	n.leaderID = args.LeaderID
+	n.lastContact = time.Now()
+	n.resetDeadline()
+
+	snap := args.Snapshot
+	// committed entries are applied from log already
+	if snap.Index <= n.commitIndex {
+		return installSnapshotReply{Term: n.term}, nil
+	}
+	var entries []Entry
+	if snap.Index <= n.lastIndex() && n.entry(snap.Index).Term == snap.Term {
+		entries = n.log[snap.Index-n.log[0].Index+1:]
+	}
+	n.compactLocked(&snap, entries)
+	if n.failed != nil {
+		return installSnapshotReply{}, n.failed
+	}
+	n.commitIndex = snap.Index
+	n.installed = &snap
+	n.notifyApplier()
+	return installSnapshotReply{Term: n.term}, nil
+}
+
+func (n *Node) becomeFollowerLocked(term uint64) {
+	if n.state == Leader {
+		n.failWaitersLocked(ErrLeadershipLost)
+	}
+	n.state = Follower
+	if term > n.term {
+		n.term = term
+		n.votedFor = 0
+		n.saveStateLocked()
+	}
+	n.resetDeadline()
+}
+
+func (n *Node) becomeLeaderLocked() {
+	n.state = Leader
+	n.leaderID = n.cfg.ID
+	n.nextIndex = map[uint64]uint64{}
+	n.matchIndex = map[uint64]uint64{}
+	n.inflight = map[uint64]bool{}
+	for id := range n.cfg.Peers {
+		n.nextIndex[id] = n.lastIndex() + 1
+	}
+	// an empty entry of the new term commits entries of previous terms
+	n.appendLocked(0, nil)
+	n.advanceCommitLocked()
+}
+
+// appendLocked appends entry of current term to log and saves it, leader counts itself as a replica only after that
+func (n *Node) appendLocked(id uint64, command []byte) uint64 {
+	index := n.lastIndex() + 1
+	e := Entry{Index: index, Term: n.term, ID: id, Command: command}
+	n.log = append(n.log, e)
+	if err := n.store.append([]Entry{e}); err != nil {
+		n.failLocked(fmt.Errorf("%w: log: %v", ErrStorage, err))
+	}
+	return index
+}
+
+// saveStateLocked saves term and vote of node and reports whether it succeeded
+func (n *Node) saveStateLocked() bool {
+	if err := n.store.saveState(hardState{Term: n.term, VotedFor: n.votedFor}); err != nil {
+		n.failLocked(fmt.Errorf("%w: state: %v", ErrStorage, err))
+		return false
+	}
+	return true
+}
+
+// failLocked stops node from taking part in cluster: it can't promise anything it may not remember after restart
+func (n *Node) failLocked(err error) {
+	if n.failed != nil {
+		return
+	}
+	if !errors.Is(err, ErrStopped) {
+		log.Printf("raft: node %d failed: %v", n.cfg.ID, err)
+	}
+	n.failed = err
+	n.state = Follower
+	n.leaderID = 0
+	n.failWaitersLocked(err)
+}
+
+// advanceCommitLocked commits the last entry of current term replicated to majority of cluster
+func (n *Node) advanceCommitLocked() {
+	if n.state != Leader {
+		return
+	}
+	for index := n.lastIndex(); index > n.commitIndex && n.entry(index).Term == n.term; index-- {
+		replicas := 1
+		for id := range n.cfg.Peers {
+			if n.matchIndex[id] >= index {
+				replicas++
+			}
+		}
+		if replicas > (len(n.cfg.Peers)+1)/2 {
+			n.commitIndex = index
+			n.notifyApplier()
+			return
+		}
+	}
+}
+
+func (n *Node) failWaitersLocked(err error) {
+	for index, w := range n.waiters {
+		w.err <- err
+		delete(n.waiters, index)
+	}
+}
+
+func (n *Node) notifyApplier() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) resetDeadline() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(n.rnd.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// entry returns entry of log with given index, it must not be before the latest snapshot
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.log[0].Index]
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
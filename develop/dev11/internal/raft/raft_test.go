@@ -0,0 +1,655 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+// machine is a state machine counting applied commands
+type machine struct {
+	m       sync.Mutex
+	applied []string
+}
+
+func (s *machine) apply(command []byte) []byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.applied = append(s.applied, string(command))
+	return []byte(strconv.Itoa(len(s.applied)))
+}
+
+func (s *machine) snapshot() ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return json.Marshal(s.applied)
+}
+
+func (s *machine) restore(state []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.applied = nil
+	return json.Unmarshal(state, &s.applied)
+}
+
+func (s *machine) commands() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]string{}, s.applied...)
+}
+
+// testNode is a node of cluster with its own listener, it doesn't answer while down is set.
+// If slowSubmit is set, the next submit request is handled in time, but its reply is delayed by slowSubmit.
+type testNode struct {
+	node       *Node
+	machine    *machine
+	server     *http.Server
+	down       atomic.Bool
+	slowSubmit atomic.Int64
+	stopped    bool
+}
+
+type testCluster struct {
+	t     *testing.T
+	nodes map[uint64]*testNode
+}
+
+func testConfig(t *testing.T, id uint64, peers map[uint64]string) Config {
+	return Config{ID: id, Peers: peers, HeartbeatInterval: 20 * time.Millisecond, ElectionTimeout: 100 * time.Millisecond,
+		Dir: t.TempDir(), Secret: testSecret}
+}
+
+// newTestCluster starts cluster of given size, change may adjust Config of every node.
+// Nodes take snapshots of their machines if change sets SnapshotEntries.
+func newTestCluster(t *testing.T, size int, change func(cfg *Config)) *testCluster {
+	c := &testCluster{t: t, nodes: map[uint64]*testNode{}}
+	listeners := map[uint64]net.Listener{}
+	addrs := map[uint64]string{}
+	for id := uint64(1); id <= uint64(size); id++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[id] = l
+		addrs[id] = "http://" + l.Addr().String()
+	}
+	for id, l := range listeners {
+		cfg := testConfig(t, id, addrs)
+		if change != nil {
+			change(&cfg)
+		}
+		tn := &testNode{machine: &machine{}}
+		if cfg.SnapshotEntries > 0 {
+			cfg.Snapshot, cfg.Restore = tn.machine.snapshot, tn.machine.restore
+		}
+		node, err := New(cfg, tn.machine.apply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tn.node = node
+		handler := node.Handler()
+		tn.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tn.down.Load() {
+				http.Error(w, "down", http.StatusServiceUnavailable)
+				return
+			}
+			if r.URL.Path == pathSubmit {
+				if delay := tn.slowSubmit.Swap(0); delay > 0 {
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, r.WithContext(context.Background()))
+					time.Sleep(time.Duration(delay))
+					w.Write(rec.Body.Bytes())
+					return
+				}
+			}
+			handler.ServeHTTP(w, r)
+		})}
+		go tn.server.Serve(l)
+		node.Start()
+		c.nodes[id] = tn
+	}
+	t.Cleanup(func() {
+		for id := range c.nodes {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+func (c *testCluster) stop(id uint64) {
+	tn := c.nodes[id]
+	if tn.stopped {
+		return
+	}
+	tn.stopped = true
+	tn.server.Close()
+	tn.node.Stop()
+}
+
+// leader waits until running nodes agree on a single leader and returns its id
+func (c *testCluster) leader() uint64 {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		leaders := map[uint64]bool{}
+		for id, tn := range c.nodes {
+			if tn.stopped || tn.down.Load() {
+				continue
+			}
+			if state, _, leaderID := tn.node.Status(); state == Leader {
+				leaders[id] = true
+			} else {
+				leaders[leaderID] = true
+			}
+		}
+		if len(leaders) == 1 {
+			for id := range leaders {
+				if tn, ok := c.nodes[id]; ok && !tn.stopped && !tn.down.Load() {
+					return id
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatal("leader is not elected")
+	return 0
+}
+
+func (c *testCluster) follower() uint64 {
+	leader := c.leader()
+	for id, tn := range c.nodes {
+		if id != leader && !tn.stopped {
+			return id
+		}
+	}
+	c.t.Fatal("cluster has no followers")
+	return 0
+}
+
+// waitApplied waits until node applies given commands
+func (c *testCluster) waitApplied(id uint64, expected []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := c.nodes[id].machine.commands()
+		if reflect.DeepEqual(got, expected) {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("node %d: expected: %v, got: %v", id, expected, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitSnapshot waits until node takes or installs snapshot with index not less than given one
+func waitSnapshot(t *testing.T, n *Node, index uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n.m.Lock()
+		got := n.log[0].Index
+		n.m.Unlock()
+		if got >= index {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected: snapshot %d, got: %d", index, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElection(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	old := c.leader()
+	_, term, _ := c.nodes[old].node.Status()
+	for id, tn := range c.nodes {
+		if state, _, leaderID := tn.node.Status(); id != old && (state != Follower || leaderID != old) {
+			t.Errorf("expected: follower of %d, got: %v of %d", old, state, leaderID)
+		}
+	}
+	c.stop(old)
+	leader := c.leader()
+	if leader == old {
+		t.Fatalf("expected: new leader, got: %d", leader)
+	}
+	if _, newTerm, _ := c.nodes[leader].node.Status(); newTerm <= term {
+		t.Errorf("expected: term greater than %d, got: %d", term, newTerm)
+	}
+	if _, err := c.nodes[leader].node.Submit(context.Background(), []byte("a")); err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err)
+	}
+}
+
+func TestLogRepair(t *testing.T) {
+	// every entry is sent alone, so repair takes several requests
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.MaxAppendSize = 1 })
+	leader := c.leader()
+	follower := c.follower()
+	ctx := context.Background()
+	if _, err := c.nodes[leader].node.Submit(ctx, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	c.waitApplied(follower, []string{"a"})
+
+	c.nodes[follower].down.Store(true)
+	expected := []string{"a"}
+	for i := 0; i < 5; i++ {
+		command := strconv.Itoa(i)
+		if _, err := c.nodes[leader].node.Submit(ctx, []byte(command)); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, command)
+	}
+	c.nodes[follower].down.Store(false)
+	c.waitApplied(follower, expected)
+}
+
+func TestConflictingEntriesReplaced(t *testing.T) {
+	dir := t.TempDir()
+	n, err := New(Config{ID: 1, Dir: dir}, (&machine{}).apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := n.handleAppendEntries(appendEntriesArgs{Term: 1, LeaderID: 2, Entries: []Entry{
+		{Index: 1, Term: 1, Command: []byte("a")},
+		{Index: 2, Term: 1, Command: []byte("b")},
+		{Index: 3, Term: 1, Command: []byte("c")},
+	}})
+	if err != nil || !reply.Success {
+		t.Fatalf("expected: success, got: %+v %v", reply, err)
+	}
+	// a new leader of term 2 never had entries 2 and 3
+	reply, err = n.handleAppendEntries(appendEntriesArgs{Term: 2, LeaderID: 3, PrevLogIndex: 1, PrevLogTerm: 1, Entries: []Entry{
+		{Index: 2, Term: 2, Command: []byte("x")},
+	}})
+	if err != nil || !reply.Success {
+		t.Fatalf("expected: success, got: %+v %v", reply, err)
+	}
+	n.Stop()
+
+	n, err = New(Config{ID: 1, Dir: dir}, (&machine{}).apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+	expected := []Entry{{}, {Index: 1, Term: 1, Command: []byte("a")}, {Index: 2, Term: 2, Command: []byte("x")}}
+	if !reflect.DeepEqual(n.log, expected) {
+		t.Errorf("expected: %v, got: %v", expected, n.log)
+	}
+	if n.term != 2 {
+		t.Errorf("expected: %d, got: %d", 2, n.term)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	dir := t.TempDir()
+	m := &machine{}
+	n, err := New(Config{ID: 1, Dir: dir, HeartbeatInterval: 5 * time.Millisecond, ElectionTimeout: 20 * time.Millisecond}, m.apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, command := range []string{"a", "b"} {
+		if _, err := n.Propose(ctx, []byte(command)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, term, _ := n.Status()
+	n.Stop()
+
+	m = &machine{}
+	n, err = New(Config{ID: 1, Dir: dir, HeartbeatInterval: 5 * time.Millisecond, ElectionTimeout: 20 * time.Millisecond}, m.apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, restored, _ := n.Status(); restored != term {
+		t.Errorf("expected: %d, got: %d", term, restored)
+	}
+	n.Start()
+	defer n.Stop()
+	// log is applied again once the node commits an entry of its new term
+	if _, err := n.Propose(ctx, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"a", "b", "c"}, m.commands(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSnapshotRestart(t *testing.T) {
+	dir := t.TempDir()
+	m := &machine{}
+	cfg := Config{ID: 1, Dir: dir, HeartbeatInterval: 5 * time.Millisecond, ElectionTimeout: 20 * time.Millisecond,
+		Snapshot: m.snapshot, Restore: m.restore, SnapshotEntries: 2}
+	n, err := New(cfg, m.apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	expected := []string{"a", "b", "c", "d", "e"}
+	for _, command := range expected {
+		if _, err := n.Propose(ctx, []byte(command)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// entry 1 is appended by the new leader
+	waitSnapshot(t, n, 5)
+	n.Stop()
+
+	s, _, snap, entries, err := openStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+	if snap == nil || snap.Index+uint64(len(entries)) != 6 || len(entries) >= cfg.SnapshotEntries {
+		t.Errorf("expected: snapshot followed by less than %d entries, got: %+v %v", cfg.SnapshotEntries, snap, entries)
+	}
+
+	m = &machine{}
+	cfg.Snapshot, cfg.Restore = m.snapshot, m.restore
+	n, err = New(cfg, m.apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.commands(); !reflect.DeepEqual(got, expected[:snap.Index-1]) {
+		t.Errorf("expected: %v, got: %v", expected[:snap.Index-1], got)
+	}
+	n.Start()
+	defer n.Stop()
+	if _, err := n.Propose(ctx, []byte("f")); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := append(expected, "f"), m.commands(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestInstallSnapshot(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *Config) {
+		cfg.SnapshotEntries = 3
+		cfg.MaxAppendSize = 1
+	})
+	leader := c.leader()
+	follower := c.follower()
+	ctx := context.Background()
+	if _, err := c.nodes[leader].node.Submit(ctx, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	c.waitApplied(follower, []string{"a"})
+
+	c.nodes[follower].down.Store(true)
+	expected := []string{"a"}
+	for i := 0; i < 10; i++ {
+		command := strconv.Itoa(i)
+		if _, err := c.nodes[leader].node.Submit(ctx, []byte(command)); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, command)
+	}
+	waitSnapshot(t, c.nodes[leader].node, 9)
+	c.nodes[leader].node.m.Lock()
+	size := len(c.nodes[leader].node.log)
+	c.nodes[leader].node.m.Unlock()
+	if size > 3 {
+		t.Errorf("expected: at most %d entries, got: %d", 3, size)
+	}
+
+	// entries the follower misses were dropped from log of leader
+	c.nodes[follower].down.Store(false)
+	c.waitApplied(follower, expected)
+	waitSnapshot(t, c.nodes[follower].node, 9)
+	if _, err := c.nodes[leader].node.Submit(ctx, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	c.waitApplied(follower, append(expected, "next"))
+}
+
+func TestVoteSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	peers := map[uint64]string{2: "http://127.0.0.1:1", 3: "http://127.0.0.1:1"}
+	cfg := Config{ID: 1, Peers: peers, Dir: dir, Secret: testSecret}
+	n, err := New(cfg, (&machine{}).apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := n.handleRequestVote(requestVoteArgs{Term: 5, CandidateID: 2}); err != nil || !reply.VoteGranted {
+		t.Fatalf("expected: vote granted, got: %+v %v", reply, err)
+	}
+	n.Stop()
+
+	n, err = New(cfg, (&machine{}).apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+	tests := map[string]struct {
+		args    requestVoteArgs
+		granted bool
+	}{
+		"other candidate of same term": {args: requestVoteArgs{Term: 5, CandidateID: 3}},
+		"same candidate again":         {args: requestVoteArgs{Term: 5, CandidateID: 2}, granted: true},
+		"older term":                   {args: requestVoteArgs{Term: 4, CandidateID: 3}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			reply, err := n.handleRequestVote(v.args)
+			if err != nil || reply.VoteGranted != v.granted || reply.Term != 5 {
+				t.Errorf("expected: %v in term %d, got: %+v %v", v.granted, 5, reply, err)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	n := &Node{cfg: Config{MaxAppendSize: 4}, log: []Entry{{}}}
+	for i, size := range []int{2, 2, 1, 10, 3} {
+		n.log = append(n.log, Entry{Index: uint64(i + 1), Command: make([]byte, size)})
+	}
+	tests := map[string]struct {
+		next     uint64
+		expected []uint64
+	}{
+		"fits limit":        {next: 1, expected: []uint64{1, 2}},
+		"large entry alone": {next: 4, expected: []uint64{4}},
+		"before large":      {next: 3, expected: []uint64{3}},
+		"tail":              {next: 5, expected: []uint64{5}},
+		"nothing to send":   {next: 6, expected: []uint64{}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			got := []uint64{}
+			for _, e := range n.batchLocked(v.next) {
+				got = append(got, e.Index)
+			}
+			if !reflect.DeepEqual(got, v.expected) {
+				t.Errorf("expected: %v, got: %v", v.expected, got)
+			}
+		})
+	}
+}
+
+func TestForwardTimeoutApplied(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	c := newTestCluster(t, 3, func(cfg *Config) { cfg.Client = &http.Client{Timeout: timeout} })
+	leader := c.leader()
+	follower := c.follower()
+
+	// the first forwarded proposal is committed, but its reply comes after client gave up
+	c.nodes[leader].slowSubmit.Store(int64(2 * timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := c.nodes[follower].node.Propose(ctx, []byte("create"))
+	if err != nil {
+		t.Fatalf("expected: %v, got: %v", nil, err)
+	}
+	if delay := c.nodes[leader].slowSubmit.Load(); delay != 0 {
+		t.Fatal("expected: slowed reply, got: none")
+	}
+	if string(result) != "1" {
+		t.Errorf("expected: result of the first apply, got: %s", result)
+	}
+	if _, err := c.nodes[leader].node.Submit(ctx, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	for id := range c.nodes {
+		c.waitApplied(id, []string{"create", "next"})
+	}
+}
+
+func TestHandlerAuthorization(t *testing.T) {
+	n, err := New(Config{ID: 1, Peers: map[uint64]string{2: "http://127.0.0.1:1"}, Dir: t.TempDir(), Secret: testSecret},
+		(&machine{}).apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+	noSecret, err := New(Config{ID: 1, Dir: t.TempDir()}, (&machine{}).apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noSecret.Stop()
+	body := `{"term":1,"candidate_id":2,"entries":[{"index":1,"term":1,"command":"YQ=="}]}`
+	// signature returns Authorization header of body sent to path signed with secret age ago
+	signature := func(secret, path, body string, age time.Duration) (string, string) {
+		timestamp := strconv.FormatInt(time.Now().Add(-age).Unix(), 10)
+		return signatureScheme + " " + (&Node{cfg: Config{Secret: secret}}).sign(path, timestamp, []byte(body)), timestamp
+	}
+	tests := map[string]struct {
+		node   *Node
+		path   string
+		secret string
+		// signed is a path and body of signature, they are path and body of request if empty
+		signedPath string
+		signedBody string
+		age        time.Duration
+		// header replaces scheme of signature in Authorization header
+		header string
+		code   int
+	}{
+		"no header":         {node: n, path: pathAppendEntries, code: http.StatusUnauthorized},
+		"wrong secret":      {node: n, path: pathAppendEntries, secret: "secreT", code: http.StatusUnauthorized},
+		"secret as bearer":  {node: n, path: pathRequestVote, header: "Bearer " + testSecret, code: http.StatusUnauthorized},
+		"other scheme":      {node: n, path: pathRequestVote, secret: testSecret, header: "Basic", code: http.StatusUnauthorized},
+		"unknown path":      {node: n, path: "/raft/unknown", code: http.StatusUnauthorized},
+		"node without":      {node: noSecret, path: pathRequestVote, secret: testSecret, code: http.StatusUnauthorized},
+		"other path":        {node: n, path: pathSubmit, secret: testSecret, signedPath: pathRequestVote, code: http.StatusUnauthorized},
+		"tampered body":     {node: n, path: pathRequestVote, secret: testSecret, signedBody: `{"term":9}`, code: http.StatusUnauthorized},
+		"expired":           {node: n, path: pathRequestVote, secret: testSecret, age: time.Minute, code: http.StatusUnauthorized},
+		"from future":       {node: n, path: pathRequestVote, secret: testSecret, age: -time.Minute, code: http.StatusUnauthorized},
+		"valid signature":   {node: n, path: pathRequestVote, secret: testSecret, code: http.StatusOK},
+		"clock skew":        {node: n, path: pathRequestVote, secret: testSecret, age: 10 * time.Second, code: http.StatusOK},
+		"submit without id": {node: n, path: pathSubmit, secret: testSecret, code: http.StatusBadRequest},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, v.path, strings.NewReader(body))
+			if v.secret != "" {
+				path, signed := v.signedPath, v.signedBody
+				if path == "" {
+					path = v.path
+				}
+				if signed == "" {
+					signed = body
+				}
+				auth, timestamp := signature(v.secret, path, signed, v.age)
+				req.Header.Set("Authorization", auth)
+				req.Header.Set(headerTimestamp, timestamp)
+			}
+			if v.header != "" {
+				auth := req.Header.Get("Authorization")
+				req.Header.Set("Authorization", v.header+strings.TrimPrefix(auth, signatureScheme))
+			}
+			w := httptest.NewRecorder()
+			v.node.Handler().ServeHTTP(w, req)
+			if w.Code != v.code {
+				t.Errorf("expected: %d, got: %d %s", v.code, w.Code, w.Body.String())
+			}
+		})
+	}
+	if n.lastIndex() != 0 {
+		t.Errorf("expected: %d, got: %d", 0, n.lastIndex())
+	}
+}
+
+func TestOpenStorage(t *testing.T) {
+	tests := map[string]struct {
+		snapshot string
+		log      string
+		entries  int
+		fails    bool
+	}{
+		"empty":              {log: "", entries: 0},
+		"complete":           {log: "{\"index\":1,\"term\":1}\n{\"index\":2,\"term\":1}\n", entries: 2},
+		"partial last line":  {log: "{\"index\":1,\"term\":1}\n{\"index\":2,\"te", entries: 1},
+		"gap in indexes":     {log: "{\"index\":1,\"term\":1}\n{\"index\":3,\"term\":1}\n", fails: true},
+		"corrupted line":     {log: "{\"index\":1,\"term\":1}\nxxx\n", fails: true},
+		"not first entry":    {log: "{\"index\":2,\"term\":1}\n", fails: true},
+		"only snapshot":      {snapshot: `{"index":2,"term":1}`, log: "", entries: 0},
+		"after snapshot":     {snapshot: `{"index":2,"term":1}`, log: "{\"index\":3,\"term\":1}\n", entries: 1},
+		"gap after snapshot": {snapshot: `{"index":2,"term":1}`, log: "{\"index\":4,\"term\":1}\n", fails: true},
+		"not rewritten": {snapshot: `{"index":2,"term":1}`,
+			log: "{\"index\":1,\"term\":1}\n{\"index\":2,\"term\":1}\n{\"index\":3,\"term\":1}\n", entries: 1},
+		"behind snapshot": {snapshot: `{"index":5,"term":2}`,
+			log: "{\"index\":1,\"term\":1}\n{\"index\":2,\"term\":1}\n", entries: 0},
+		"conflicting snapshot": {snapshot: `{"index":2,"term":2}`,
+			log: "{\"index\":1,\"term\":1}\n{\"index\":2,\"term\":1}\n{\"index\":3,\"term\":1}\n", entries: 0},
+		"corrupted snapshot": {snapshot: `{"index":`, log: "", fails: true},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, logFile), []byte(v.log), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if v.snapshot != "" {
+				if err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte(v.snapshot), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s, _, snap, entries, err := openStorage(dir)
+			if (err != nil) != v.fails {
+				t.Fatalf("expected: fails %v, got: %v", v.fails, err)
+			}
+			if err != nil {
+				return
+			}
+			if len(entries) != v.entries {
+				t.Errorf("expected: %d, got: %d", v.entries, len(entries))
+			}
+			// appended entry follows complete ones
+			next := Entry{Index: uint64(len(entries) + 1), Term: 2}
+			if snap != nil {
+				next.Index += snap.Index
+			}
+			if err := s.append([]Entry{next}); err != nil {
+				t.Fatal(err)
+			}
+			s.close()
+			s, _, _, entries, err = openStorage(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.close()
+			if len(entries) != v.entries+1 || !reflect.DeepEqual(entries[len(entries)-1], next) {
+				t.Errorf("expected: %v last, got: %v", next, entries)
+			}
+		})
+	}
+}
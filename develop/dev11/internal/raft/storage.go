@@ -0,0 +1,253 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Names of files of storage in directory of node
+const (
+	stateFile    = "state.json"
+	logFile      = "log.jsonl"
+	snapshotFile = "snapshot.json"
+)
+
+// hardState is a part of state of node which must survive restarts: without it a restarted node could vote twice in a term
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor uint64 `json:"voted_for"`
+}
+
+// snapshot is state of state machine after applying entries up to Index with results of the latest proposals
+type snapshot struct {
+	Index   uint64           `json:"index"`
+	Term    uint64           `json:"term"`
+	State   []byte           `json:"state"`
+	Results []proposalResult `json:"results,omitempty"`
+}
+
+// proposalResult is a result of applied proposal kept to answer its retries
+type proposalResult struct {
+	ID     uint64 `json:"id"`
+	Result []byte `json:"result"`
+}
+
+// storage keeps hardState, the latest snapshot and log after it in directory, every write is synced to disk before it returns.
+// Log is a file of JSON lines appended to, it's rewritten when a conflicting suffix is truncated or snapshot is saved.
+type storage struct {
+	dir string
+	log *os.File
+}
+
+// openStorage opens or creates storage in dir and returns it with saved state, snapshot (nil if there is none)
+// and log after snapshot without the zero entry.
+// A partially written last line of log is a write interrupted by crash which was never acknowledged, so it's dropped.
+// Entries included in snapshot are left in log by crash before log was rewritten, they are skipped.
+func openStorage(dir string) (*storage, hardState, *snapshot, []Entry, error) {
+	var state hardState
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, state, nil, nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, state, nil, nil, fmt.Errorf("%s: %w", stateFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, state, nil, nil, err
+	}
+	s := &storage{dir: dir}
+	snap, err := s.loadSnapshot()
+	if err != nil {
+		return nil, state, nil, nil, err
+	}
+	var first uint64 = 1
+	if snap != nil {
+		first = snap.Index + 1
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, state, nil, nil, err
+	}
+	entries, valid, err := readLog(f)
+	if err == nil && len(entries) > 0 && (entries[0].Index > first || snap == nil && entries[0].Index != first) {
+		err = fmt.Errorf("%s: expected entry %d, got %d", logFile, first, entries[0].Index)
+	}
+	if err != nil {
+		f.Close()
+		return nil, state, nil, nil, err
+	}
+	stale := len(entries) > 0 && entries[0].Index < first
+	if stale {
+		// log saved before snapshot installed from leader is kept only if it has the last entry of snapshot
+		i := first - 1 - entries[0].Index
+		if i < uint64(len(entries)) && entries[i].Term == snap.Term {
+			entries = entries[i+1:]
+		} else {
+			entries = nil
+		}
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, state, nil, nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, state, nil, nil, err
+	}
+	s.log = f
+	if stale {
+		if err := s.rewrite(entries); err != nil {
+			s.close()
+			return nil, state, nil, nil, err
+		}
+	}
+	return s, state, snap, entries, nil
+}
+
+// readLog returns entries of log file and length of its part which contains complete entries,
+// indexes of entries must follow each other
+func readLog(r io.Reader) ([]Entry, int64, error) {
+	var entries []Entry
+	var valid int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// line without newline is not complete
+			return entries, valid, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var e Entry
+		if err := json.Unmarshal(bytes.TrimSpace(line), &e); err != nil {
+			return nil, 0, fmt.Errorf("%s: entry %d: %w", logFile, len(entries)+1, err)
+		}
+		if len(entries) > 0 && e.Index != entries[len(entries)-1].Index+1 {
+			return nil, 0, fmt.Errorf("%s: expected entry %d, got %d", logFile, entries[len(entries)-1].Index+1, e.Index)
+		}
+		entries = append(entries, e)
+		valid += int64(len(line))
+	}
+}
+
+// saveState replaces saved hardState atomically
+func (s *storage) saveState(state hardState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(filepath.Join(s.dir, stateFile), data)
+}
+
+// append appends entries to the end of saved log
+func (s *storage) append(entries []Entry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(data); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// rewrite replaces saved log with entries atomically
+func (s *storage) rewrite(entries []Entry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, logFile)
+	if err := writeFileSync(path, data); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.log.Close()
+	s.log = f
+	return nil
+}
+
+// loadSnapshot returns saved snapshot or nil if there is none
+func (s *storage) loadSnapshot() (*snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("%s: %w", snapshotFile, err)
+	}
+	return &snap, nil
+}
+
+// saveSnapshot replaces saved snapshot and then log with entries following it, both atomically.
+// Crash between them leaves entries of snapshot in log, openStorage skips them.
+func (s *storage) saveSnapshot(snap *snapshot, entries []Entry) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		return err
+	}
+	return s.rewrite(entries)
+}
+
+func (s *storage) close() error {
+	return s.log.Close()
+}
+
+func encodeEntries(entries []Entry) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// writeFileSync writes data to a temporary file, syncs it and renames it to path, so path has either old or new content
+func writeFileSync(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// rename is durable only when directory is synced
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,258 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths of RPC endpoints served by Handler
+const (
+	pathRequestVote     = "/raft/request_vote"
+	pathAppendEntries   = "/raft/append_entries"
+	pathInstallSnapshot = "/raft/install_snapshot"
+	pathSubmit          = "/raft/submit"
+)
+
+// Signature of request is sent in Authorization header with signatureScheme, time of signing is sent in headerTimestamp
+const (
+	signatureScheme = "HMAC-SHA256"
+	headerTimestamp = "X-Raft-Timestamp"
+)
+
+// maxRequestAge is the largest difference between time of signing and time of receiving of request,
+// it covers delivery and skew of clocks of nodes. Raft tolerates duplicated messages,
+// so a request replayed in this window does no harm: retried proposals are applied once.
+const maxRequestAge = 30 * time.Second
+
+var errUnreachable = errors.New("node is unreachable")
+
+type requestVoteArgs struct {
+	Term         uint64 `json:"term"`
+	CandidateID  uint64 `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type requestVoteReply struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+type appendEntriesArgs struct {
+	Term         uint64  `json:"term"`
+	LeaderID     uint64  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+type appendEntriesReply struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// ConflictIndex is an index from which leader should retry when Success is false
+	ConflictIndex uint64 `json:"conflict_index"`
+}
+
+type installSnapshotArgs struct {
+	Term     uint64   `json:"term"`
+	LeaderID uint64   `json:"leader_id"`
+	Snapshot snapshot `json:"snapshot"`
+}
+
+type installSnapshotReply struct {
+	Term uint64 `json:"term"`
+}
+
+type submitArgs struct {
+	ID      uint64 `json:"id"`
+	Command []byte `json:"command"`
+}
+
+type submitReply struct {
+	Result []byte `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Handler returns http.Handler serving RPC requests of other nodes under /raft/ path.
+// Requests must be signed with secret of cluster, other requests get 401. Secret itself is never sent,
+// so peer listener may be plain http, but bodies of requests with commands aren't encrypted.
+func (n *Node) Handler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc(pathRequestVote, func(w http.ResponseWriter, r *http.Request) {
+		var args requestVoteArgs
+		if !decode(w, r, &args) {
+			return
+		}
+		reply, err := n.handleRequestVote(args)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		encode(w, reply)
+	})
+	m.HandleFunc(pathAppendEntries, func(w http.ResponseWriter, r *http.Request) {
+		var args appendEntriesArgs
+		if !decode(w, r, &args) {
+			return
+		}
+		reply, err := n.handleAppendEntries(args)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		encode(w, reply)
+	})
+	m.HandleFunc(pathInstallSnapshot, func(w http.ResponseWriter, r *http.Request) {
+		var args installSnapshotArgs
+		if !decode(w, r, &args) {
+			return
+		}
+		reply, err := n.handleInstallSnapshot(args)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		encode(w, reply)
+	})
+	m.HandleFunc(pathSubmit, func(w http.ResponseWriter, r *http.Request) {
+		var args submitArgs
+		if !decode(w, r, &args) {
+			return
+		}
+		if args.ID == 0 {
+			http.Error(w, "id of proposal is required", http.StatusBadRequest)
+			return
+		}
+		result, err := n.submit(r.Context(), args.ID, args.Command)
+		reply := submitReply{Result: result}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		encode(w, reply)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !n.authorized(r) {
+			w.Header().Set("WWW-Authenticate", signatureScheme)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		m.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether request is signed with secret of cluster recently, nothing is authorized without secret.
+// Body of request is read to check signature and replaced with its copy.
+func (n *Node) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if n.cfg.Secret == "" || !strings.HasPrefix(auth, signatureScheme+" ") {
+		return false
+	}
+	timestamp := r.Header.Get(headerTimestamp)
+	signed, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(signed, 0)).Abs() > maxRequestAge {
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	signature := strings.TrimPrefix(auth, signatureScheme+" ")
+	return hmac.Equal([]byte(signature), []byte(n.sign(r.URL.Path, timestamp, body)))
+}
+
+// sign returns HMAC of path, time of signing and body of request keyed with secret of cluster
+func (n *Node) sign(path, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(n.cfg.Secret))
+	mac.Write([]byte(path + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// forward submits command of proposal with given id to leader with given id
+func (n *Node) forward(ctx context.Context, leaderID, id uint64, command []byte) ([]byte, error) {
+	var reply submitReply
+	if err := n.callContext(ctx, leaderID, pathSubmit, submitArgs{ID: id, Command: command}, &reply); err != nil {
+		return nil, err
+	}
+	switch reply.Error {
+	case "":
+		return reply.Result, nil
+	case ErrNotLeader.Error():
+		return nil, ErrNotLeader
+	case ErrLeadershipLost.Error():
+		return nil, ErrLeadershipLost
+	case ErrStopped.Error():
+		return nil, errUnreachable
+	default:
+		return nil, errors.New(reply.Error)
+	}
+}
+
+func (n *Node) call(id uint64, path string, args, reply interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	defer cancel()
+	return n.callContext(ctx, id, path, args, reply)
+}
+
+func (n *Node) callContext(ctx context.Context, id uint64, path string, args, reply interface{}) error {
+	addr, ok := n.cfg.Peers[id]
+	if !ok {
+		return fmt.Errorf("%w: unknown node %d", errUnreachable, id)
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set("Authorization", signatureScheme+" "+n.sign(path, timestamp, body))
+	resp, err := n.cfg.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %v", errUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%w: %s", errUnreachable, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func encode(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"dev11/internal/repository"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Repository is a storage of events which results of queries are cached
+type Repository interface {
+	Create(ctx context.Context, e *model.Event) (uint64, error)
+	Update(ctx context.Context, e *model.Event) error
+	Delete(ctx context.Context, userID, id uint64) error
+	Get(ctx context.Context, userID, id uint64) (*model.Event, error)
+	GetAll(ctx context.Context, userID uint64) ([]*model.Event, error)
+	GetBefore(ctx context.Context, t time.Time) ([]*model.Event, error)
+	GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error)
+	Upsert(ctx context.Context, e *model.Event) (bool, error)
+	Changes(ctx context.Context, userID, since uint64) ([]repository.Change, uint64, error)
+	Seq() uint64
+	LastChange(ctx context.Context, userID uint64) uint64
+	AddHoliday(ctx context.Context, userID uint64, date time.Time, name string) error
+	DeleteHoliday(ctx context.Context, userID uint64, date time.Time) error
+	Holidays(ctx context.Context, userID uint64) (map[time.Time]string, error)
+}
+
+// Stats contains counters of cache usage
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+}
+
+type period int
+
+const (
+	day period = iota
+	week
+	month
+)
+
+type key struct {
+	user   tenant.Key
+	period period
+	date   int64
+}
+
+// entry is a cached result of a query for events in window [from, to)
+type entry struct {
+	key      key
+	from, to time.Time
+	events   []*model.Event
+	ids      map[uint64]struct{}
+	// notFound is true when repository returned ErrUserNotFound
+	notFound bool
+}
+
+func (e *entry) covers(t time.Time) bool {
+	return !t.Before(e.from) && t.Before(e.to)
+}
+
+// Cache is a read-through cache of day, week and month queries wrapping a Repository.
+// Entries are evicted in least recently used order when number of entries exceeds capacity.
+// Entries affected by a write are invalidated after the write is done in repository.
+// Users are keyed by tenant of context of the query.
+// Every write increments version of user, so results of queries started before the write are not cached.
+type Cache struct {
+	repo     Repository
+	capacity int
+
+	m        sync.Mutex
+	lru      *list.List
+	entries  map[key]*list.Element
+	users    map[tenant.Key]map[key]*list.Element
+	versions map[tenant.Key]uint64
+	stats    Stats
+}
+
+// New creates Cache which wraps repo and holds at most capacity entries and returns pointer to it
+func New(repo Repository, capacity int) *Cache {
+	return &Cache{
+		repo:     repo,
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  map[key]*list.Element{},
+		users:    map[tenant.Key]map[key]*list.Element{},
+		versions: map[tenant.Key]uint64{},
+	}
+}
+
+// Stats returns counters of cache usage
+func (c *Cache) Stats() Stats {
+	c.m.Lock()
+	defer c.m.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// Health returns error of health check of wrapped repository, repositories without health checks are healthy
+func (c *Cache) Health(ctx context.Context) error {
+	if h, ok := c.repo.(interface{ Health(context.Context) error }); ok {
+		return h.Health(ctx)
+	}
+	return ctx.Err()
+}
+
+// Create adds an Event to repository and invalidates queries of the user which window covers date of the Event
+func (c *Cache) Create(ctx context.Context, e *model.Event) (uint64, error) {
+	id, err := c.repo.Create(ctx, e)
+	if err != nil {
+		return id, err
+	}
+	c.invalidate(tenant.UserKey(ctx, e.UserID), func(en *entry) bool {
+		return en.notFound || en.covers(e.Date)
+	})
+	return id, nil
+}
+
+// Update changes an Event in repository and invalidates queries of the user which contain the Event
+// or which window covers new date of the Event
+func (c *Cache) Update(ctx context.Context, e *model.Event) error {
+	if err := c.repo.Update(ctx, e); err != nil {
+		return err
+	}
+	c.invalidate(tenant.UserKey(ctx, e.UserID), func(en *entry) bool {
+		_, ok := en.ids[e.ID]
+		return ok || en.covers(e.Date)
+	})
+	return nil
+}
+
+// Upsert adds or replaces an Event with the same ICalUID in repository and invalidates queries of the user
+// which contain the Event or which window covers its new date
+func (c *Cache) Upsert(ctx context.Context, e *model.Event) (bool, error) {
+	created, err := c.repo.Upsert(ctx, e)
+	if err != nil {
+		return created, err
+	}
+	c.invalidate(tenant.UserKey(ctx, e.UserID), func(en *entry) bool {
+		_, ok := en.ids[e.ID]
+		return ok || en.notFound || en.covers(e.Date)
+	})
+	return created, nil
+}
+
+// Delete removes an Event from repository and invalidates queries of the user which contain the Event
+func (c *Cache) Delete(ctx context.Context, userID, id uint64) error {
+	if err := c.repo.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	c.invalidate(tenant.UserKey(ctx, userID), func(en *entry) bool {
+		_, ok := en.ids[id]
+		return ok
+	})
+	return nil
+}
+
+// Changes is not cached and goes straight to repository
+func (c *Cache) Changes(ctx context.Context, userID, since uint64) ([]repository.Change, uint64, error) {
+	return c.repo.Changes(ctx, userID, since)
+}
+
+// Seq is not cached and goes straight to repository
+func (c *Cache) Seq() uint64 {
+	return c.repo.Seq()
+}
+
+// LastChange is not cached and goes straight to repository
+func (c *Cache) LastChange(ctx context.Context, userID uint64) uint64 {
+	return c.repo.LastChange(ctx, userID)
+}
+
+// AddHoliday is not cached and goes straight to repository
+func (c *Cache) AddHoliday(ctx context.Context, userID uint64, date time.Time, name string) error {
+	return c.repo.AddHoliday(ctx, userID, date, name)
+}
+
+// DeleteHoliday is not cached and goes straight to repository
+func (c *Cache) DeleteHoliday(ctx context.Context, userID uint64, date time.Time) error {
+	return c.repo.DeleteHoliday(ctx, userID, date)
+}
+
+// Holidays is not cached and goes straight to repository
+func (c *Cache) Holidays(ctx context.Context, userID uint64) (map[time.Time]string, error) {
+	return c.repo.Holidays(ctx, userID)
+}
+
+// Get is not cached and goes straight to repository
+func (c *Cache) Get(ctx context.Context, userID, id uint64) (*model.Event, error) {
+	return c.repo.Get(ctx, userID, id)
+}
+
+// GetAll is not cached and goes straight to repository
+func (c *Cache) GetAll(ctx context.Context, userID uint64) ([]*model.Event, error) {
+	return c.repo.GetAll(ctx, userID)
+}
+
+// GetBefore is not cached and goes straight to repository
+func (c *Cache) GetBefore(ctx context.Context, t time.Time) ([]*model.Event, error) {
+	return c.repo.GetBefore(ctx, t)
+}
+
+// GetForDay returns a list of events for given day
+func (c *Cache) GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	return c.get(ctx, key{user: tenant.UserKey(ctx, userID), period: day, date: t.UnixNano()}, t, t.AddDate(0, 0, 1), c.repo.GetForDay)
+}
+
+// GetForWeek returns a list of events for a week starting from given day
+func (c *Cache) GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	return c.get(ctx, key{user: tenant.UserKey(ctx, userID), period: week, date: t.UnixNano()}, t, t.AddDate(0, 0, 7), c.repo.GetForWeek)
+}
+
+// GetForMonth returns a list of events for a month starting from given day
+func (c *Cache) GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	return c.get(ctx, key{user: tenant.UserKey(ctx, userID), period: month, date: t.UnixNano()}, t, t.AddDate(0, 1, 0), c.repo.GetForMonth)
+}
+
+// Search is not cached and goes straight to repository
+func (c *Cache) Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error) {
+	return c.repo.Search(ctx, userID, query)
+}
+
+type queryFunc func(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+
+func (c *Cache) get(ctx context.Context, k key, from, to time.Time, query queryFunc) ([]*model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.m.Lock()
+	if el, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(el)
+		c.stats.Hits++
+		en := el.Value.(*entry)
+		c.m.Unlock()
+		if en.notFound {
+			return nil, repository.ErrUserNotFound
+		}
+		return copyEvents(en.events), nil
+	}
+	c.stats.Misses++
+	version := c.versions[k.user]
+	c.m.Unlock()
+
+	events, err := query(ctx, k.user.UserID, from)
+	notFound := errors.Is(err, repository.ErrUserNotFound)
+	if err != nil && !notFound {
+		return nil, err
+	}
+
+	en := &entry{key: k, from: from, to: to, events: copyEvents(events), ids: map[uint64]struct{}{}, notFound: notFound}
+	for _, e := range events {
+		en.ids[e.ID] = struct{}{}
+	}
+	c.m.Lock()
+	if c.versions[k.user] == version {
+		c.put(en)
+	}
+	c.m.Unlock()
+	return events, err
+}
+
+// put adds entry to cache evicting the least recently used entries, must be called with c.m locked
+func (c *Cache) put(en *entry) {
+	if c.capacity <= 0 {
+		return
+	}
+	if el, ok := c.entries[en.key]; ok {
+		c.remove(el)
+	}
+	el := c.lru.PushFront(en)
+	c.entries[en.key] = el
+	if _, ok := c.users[en.key.user]; !ok {
+		c.users[en.key.user] = map[key]*list.Element{}
+	}
+	c.users[en.key.user][en.key] = el
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops element from cache, must be called with c.m locked
+func (c *Cache) remove(el *list.Element) {
+	en := el.Value.(*entry)
+	c.lru.Remove(el)
+	delete(c.entries, en.key)
+	delete(c.users[en.key.user], en.key)
+	if len(c.users[en.key.user]) == 0 {
+		delete(c.users, en.key.user)
+	}
+}
+
+// invalidate increments version of user and drops entries of the user matching predicate
+func (c *Cache) invalidate(user tenant.Key, match func(en *entry) bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.versions[user]++
+	for _, el := range c.users[user] {
+		if match(el.Value.(*entry)) {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+func copyEvents(events []*model.Event) []*model.Event {
+	if events == nil {
+		return nil
+	}
+	return append(make([]*model.Event, 0, len(events)), events...)
+}
@@ -0,0 +1,271 @@
+package cache
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"dev11/internal/repository/memory"
+	"dev11/internal/repository/repotest"
+	"dev11/pkg/model"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+// slowRepository delays queries to widen the window between a miss and filling the cache
+type slowRepository struct {
+	Repository
+}
+
+func (r slowRepository) GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+	return r.Repository.GetForDay(ctx, userID, t)
+}
+
+func (r slowRepository) GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+	return r.Repository.GetForWeek(ctx, userID, t)
+}
+
+func (r slowRepository) GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+	return r.Repository.GetForMonth(ctx, userID, t)
+}
+
+func ids(events []*model.Event) map[uint64]bool {
+	m := map[uint64]bool{}
+	for _, e := range events {
+		m[e.ID] = true
+	}
+	return m
+}
+
+func TestCacheContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository { return New(memory.New(), 16) })
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	ctx := context.Background()
+	c := New(memory.New(), 10)
+
+	if _, err := c.GetForDay(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Fatalf("expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if _, err := c.GetForDay(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Fatalf("expected cached %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if _, err := c.Create(ctx, &model.Event{UserID: 1, Title: "a", Date: base}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := c.GetForDay(ctx, 1, base)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected: 1 event, got: %v, %v", events, err)
+	}
+	if _, err := c.GetForDay(ctx, 1, base); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	c := New(memory.New(), 10)
+	e := &model.Event{UserID: 1, Title: "a", Date: base}
+	if _, err := c.Create(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	other := &model.Event{UserID: 1, Title: "b", Date: base.AddDate(0, 2, 0)}
+	if _, err := c.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	// cases are run in order, each of them sees writes of the previous ones
+	tests := []struct {
+		name  string
+		write func() error
+		// query and number of events it must return after write
+		query func() ([]*model.Event, error)
+		count int
+	}{
+		{
+			name: "create in window",
+			write: func() error {
+				_, err := c.Create(ctx, &model.Event{UserID: 1, Title: "c", Date: base.AddDate(0, 0, 3)})
+				return err
+			},
+			query: func() ([]*model.Event, error) { return c.GetForWeek(ctx, 1, base) },
+			count: 2,
+		},
+		{
+			name: "update moves event out of window",
+			write: func() error {
+				moved := *e
+				moved.Date = base.AddDate(0, 0, 20)
+				return c.Update(ctx, &moved)
+			},
+			query: func() ([]*model.Event, error) { return c.GetForDay(ctx, 1, base) },
+			count: 0,
+		},
+		{
+			name: "update moves event into window",
+			write: func() error {
+				moved := *other
+				moved.Date = base.AddDate(0, 0, 1)
+				return c.Update(ctx, &moved)
+			},
+			query: func() ([]*model.Event, error) { return c.GetForMonth(ctx, 1, base) },
+			count: 3,
+		},
+		{
+			name:  "delete",
+			write: func() error { return c.Delete(ctx, 1, e.ID) },
+			query: func() ([]*model.Event, error) { return c.GetForMonth(ctx, 1, base) },
+			count: 2,
+		},
+	}
+	for _, v := range tests {
+		v := v
+		t.Run(v.name, func(t *testing.T) {
+			if _, err := v.query(); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.write(); err != nil {
+				t.Fatal(err)
+			}
+			events, err := v.query()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != v.count {
+				t.Errorf("expected: %d events, got: %d", v.count, len(events))
+			}
+		})
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	c := New(memory.New(), 2)
+	if _, err := c.Create(ctx, &model.Event{UserID: 1, Title: "a", Date: base}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetForDay(ctx, 1, base.AddDate(0, 0, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first day is the least recently used and must be evicted
+	if _, err := c.GetForDay(ctx, 1, base); err != nil {
+		t.Fatal(err)
+	}
+	stats := c.Stats()
+	if stats.Entries != 2 || stats.Evictions != 2 || stats.Hits != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// TestCacheConcurrentWriters checks that a reader never sees a result older than a write which has returned:
+// every writer reads its own writes through the cache, and readers hammer the same windows meanwhile.
+func TestCacheConcurrentWriters(t *testing.T) {
+	ctx := context.Background()
+	c := New(slowRepository{memory.New()}, 64)
+	const writers, readers, iterations = 8, 8, 200
+
+	done := make(chan struct{})
+	var readersWG sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		readersWG.Add(1)
+		go func(seed int64) {
+			defer readersWG.Done()
+			r := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				t := base.AddDate(0, 0, r.Intn(14))
+				switch r.Intn(3) {
+				case 0:
+					c.GetForDay(ctx, 1, t)
+				case 1:
+					c.GetForWeek(ctx, 1, t)
+				default:
+					c.GetForMonth(ctx, 1, t)
+				}
+			}
+		}(int64(i))
+	}
+
+	var writersWG sync.WaitGroup
+	errs := make(chan string, writers*iterations)
+	for i := 0; i < writers; i++ {
+		writersWG.Add(1)
+		go func(seed int64) {
+			defer writersWG.Done()
+			r := rand.New(rand.NewSource(seed))
+			for j := 0; j < iterations; j++ {
+				e := &model.Event{UserID: 1, Title: "e", Date: base.AddDate(0, 0, r.Intn(14))}
+				id, err := c.Create(ctx, e)
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				events, _ := c.GetForWeek(ctx, 1, e.Date)
+				if !ids(events)[id] {
+					errs <- "created event is not visible"
+				}
+
+				moved := *e
+				moved.Date = base.AddDate(0, 0, r.Intn(14))
+				if err := c.Update(ctx, &moved); err != nil {
+					errs <- err.Error()
+					return
+				}
+				events, _ = c.GetForDay(ctx, 1, e.Date)
+				if !moved.Date.Equal(e.Date) && ids(events)[id] {
+					errs <- "updated event is visible at old date"
+				}
+				events, _ = c.GetForDay(ctx, 1, moved.Date)
+				if !ids(events)[id] {
+					errs <- "updated event is not visible at new date"
+				}
+
+				if r.Intn(2) == 0 {
+					if err := c.Delete(ctx, 1, id); err != nil {
+						errs <- err.Error()
+						return
+					}
+					events, _ = c.GetForMonth(ctx, 1, base)
+					if ids(events)[id] {
+						errs <- "deleted event is visible"
+					}
+				}
+			}
+		}(int64(100 + i))
+	}
+	writersWG.Wait()
+	close(done)
+	readersWG.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// after all writers have finished every cached window must match repository
+	for i := 0; i < 14; i++ {
+		day := base.AddDate(0, 0, i)
+		cached, _ := c.GetForWeek(ctx, 1, day)
+		actual, _ := c.repo.GetForWeek(ctx, 1, day)
+		if len(cached) != len(actual) {
+			t.Errorf("week of %s: expected: %d events, got: %d", day.Format("2006-01-02"), len(actual), len(cached))
+		}
+	}
+}
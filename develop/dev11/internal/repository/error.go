@@ -4,7 +4,11 @@ import "errors"
 
 // Repository errors
 var (
-	ErrUserNotFound  = errors.New("user not found")
-	ErrEventNotFound = errors.New("event not found")
-	ErrDuplicateID   = errors.New("duplicate event id")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrEventNotFound   = errors.New("event not found")
+	ErrTaskNotFound    = errors.New("task not found")
+	ErrHolidayNotFound = errors.New("holiday not found")
+	ErrDuplicateID     = errors.New("duplicate event id")
+	ErrNoICalUID       = errors.New("event has no ical uid")
+	ErrUnavailable     = errors.New("repository is unavailable")
 )
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"dev11/internal/tenant"
+	"errors"
+	"sync"
+)
+
+// ErrInvalidSyncToken is returned by Changes when changes since given sequence number are not known
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// Change is a record about created, updated or deleted Event
+type Change struct {
+	Seq     uint64
+	EventID uint64
+	ICalUID string
+	Deleted bool
+}
+
+// Journal keeps latest changes of events of every user of every tenant.
+// Sequence numbers are shared by all users of all tenants. Repository records changes in order they are applied,
+// so replicas applying the same writes in the same order have the same sequence numbers.
+type Journal struct {
+	size int
+
+	m     sync.Mutex
+	seq   uint64
+	users map[tenant.Key]*userJournal
+}
+
+type userJournal struct {
+	changes []Change
+	// trimmed is a sequence number of the latest change dropped from the journal
+	trimmed uint64
+}
+
+// NewJournal creates a Journal keeping given number of latest changes of every user, 0 means all changes
+func NewJournal(size int) *Journal {
+	return &Journal{size: size, users: map[tenant.Key]*userJournal{}}
+}
+
+// Record adds a change of Event of user
+func (j *Journal) Record(user tenant.Key, eventID uint64, icalUID string, deleted bool) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	j.seq++
+	u, ok := j.users[user]
+	if !ok {
+		u = &userJournal{}
+		j.users[user] = u
+	}
+	u.changes = append(u.changes, Change{Seq: j.seq, EventID: eventID, ICalUID: icalUID, Deleted: deleted})
+	if j.size > 0 && len(u.changes) > j.size {
+		n := len(u.changes) - j.size
+		u.trimmed = u.changes[n-1].Seq
+		u.changes = append([]Change(nil), u.changes[n:]...)
+	}
+}
+
+// Changes returns the latest change of every Event of user made after sequence number since
+// and current sequence number. Since must be a sequence number returned by Changes or Seq earlier.
+func (j *Journal) Changes(user tenant.Key, since uint64) ([]Change, uint64, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	if since > j.seq {
+		return nil, 0, ErrInvalidSyncToken
+	}
+	u, ok := j.users[user]
+	if !ok {
+		return []Change{}, j.seq, nil
+	}
+	if since != 0 && since < u.trimmed {
+		return nil, 0, ErrInvalidSyncToken
+	}
+	latest := map[uint64]int{}
+	changes := []Change{}
+	for _, ch := range u.changes {
+		if ch.Seq <= since {
+			continue
+		}
+		if i, ok := latest[ch.EventID]; ok {
+			changes[i] = ch
+			continue
+		}
+		latest[ch.EventID] = len(changes)
+		changes = append(changes, ch)
+	}
+	return changes, j.seq, nil
+}
+
+// Seq returns current sequence number
+func (j *Journal) Seq() uint64 {
+	j.m.Lock()
+	defer j.m.Unlock()
+	return j.seq
+}
+
+// LastChange returns sequence number of the latest change of events of user, it is zero if there are no changes
+func (j *Journal) LastChange(user tenant.Key) uint64 {
+	j.m.Lock()
+	defer j.m.Unlock()
+	u, ok := j.users[user]
+	if !ok || len(u.changes) == 0 {
+		return 0
+	}
+	return u.changes[len(u.changes)-1].Seq
+}
+
+// JournalState is content of Journal saved in snapshots, so a restored replica keeps sequence numbers of changes
+type JournalState struct {
+	Seq   uint64        `json:"seq"`
+	Users []UserChanges `json:"users,omitempty"`
+}
+
+// UserChanges are changes of events of user kept in Journal
+type UserChanges struct {
+	User    tenant.Key `json:"user"`
+	Changes []Change   `json:"changes"`
+	Trimmed uint64     `json:"trimmed,omitempty"`
+}
+
+// State returns a copy of content of journal
+func (j *Journal) State() JournalState {
+	j.m.Lock()
+	defer j.m.Unlock()
+	state := JournalState{Seq: j.seq}
+	for user, u := range j.users {
+		state.Users = append(state.Users, UserChanges{User: user, Changes: append([]Change(nil), u.changes...),
+			Trimmed: u.trimmed})
+	}
+	return state
+}
+
+// Restore replaces content of journal with state returned by State
+func (j *Journal) Restore(state JournalState) {
+	j.m.Lock()
+	defer j.m.Unlock()
+	j.seq = state.Seq
+	j.users = map[tenant.Key]*userJournal{}
+	for _, u := range state.Users {
+		j.users[u.User] = &userJournal{changes: u.Changes, trimmed: u.Trimmed}
+	}
+}
@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"dev11/internal/tenant"
+	"time"
+)
+
+// AddHoliday adds a day off defined by user replacing name of the same day
+func (r *Repository) AddHoliday(ctx context.Context, userID uint64, date time.Time, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.holidays[key]; !ok {
+		r.holidays[key] = map[time.Time]string{}
+	}
+	r.holidays[key][date] = name
+	return nil
+}
+
+// DeleteHoliday removes a day off defined by user
+func (r *Repository) DeleteHoliday(ctx context.Context, userID uint64, date time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.holidays[key][date]; !ok {
+		return repository.ErrHolidayNotFound
+	}
+	delete(r.holidays[key], date)
+	return nil
+}
+
+// Holidays returns days off defined by user with their names
+func (r *Repository) Holidays(ctx context.Context, userID uint64) (map[time.Time]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	days := map[time.Time]string{}
+	for date, name := range r.holidays[tenant.UserKey(ctx, userID)] {
+		days[date] = name
+	}
+	return days, nil
+}
@@ -1,81 +1,236 @@
 package memory
 
 import (
+	"context"
 	"dev11/internal/repository"
+	"dev11/internal/search"
+	"dev11/internal/tenant"
 	"dev11/pkg/model"
+	"encoding/json"
 	"math/rand"
 	"sync"
 	"time"
 )
 
-// Repository is in-memory storage of Events where key is user_id and value is a map of events (key - event_id, value - Event).
+// SnippetWidth is a number of words in snippets of search results
+const SnippetWidth = 12
+
+// JournalSize is a number of latest changes kept in journal for every user of every tenant
+var JournalSize = 1000
+
+// Repository is in-memory storage of Events where key is tenant and user_id of context and value is a map of events
+// (key - event_id, value - Event).
 // It's protected from concurrent read/write with sync.RWMutex.
 // It uses *rand.Rand to generate event id.
+// Titles and descriptions of events are kept in inverted index of every tenant for full-text search.
+// Every write is recorded in journal while repository is locked, so journal has writes in order they were applied.
+// Days off defined by users are kept with events, so they are replicated and saved in snapshots with them.
 type Repository struct {
 	m          sync.RWMutex
 	randomizer *rand.Rand
-	data       map[uint64]map[uint64]*model.Event
+	data       map[tenant.Key]map[uint64]*model.Event
+	indexes    map[uint64]*search.Index
+	journal    *repository.Journal
+	holidays   map[tenant.Key]map[time.Time]string
 }
 
 // New creates an instance of repository and returns pointer to it
 func New() *Repository {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return &Repository{randomizer: r, data: map[uint64]map[uint64]*model.Event{}}
+	return &Repository{randomizer: r, data: map[tenant.Key]map[uint64]*model.Event{}, indexes: map[uint64]*search.Index{},
+		journal: repository.NewJournal(JournalSize), holidays: map[tenant.Key]map[time.Time]string{}}
 }
 
 // Create adds an Event to repository
-func (r *Repository) Create(e *model.Event) (uint64, error) {
+func (r *Repository) Create(ctx context.Context, e *model.Event) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
 	e.ID = r.randomizer.Uint64()
+	if err := r.insert(ctx, e); err != nil {
+		return 0, err
+	}
+	return e.ID, nil
+}
+
+// Insert adds an Event with id chosen by caller to repository
+func (r *Repository) Insert(ctx context.Context, e *model.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.m.Lock()
 	defer r.m.Unlock()
-	if _, ok := r.data[e.UserID]; !ok {
-		r.data[e.UserID] = make(map[uint64]*model.Event)
+	return r.insert(ctx, e)
+}
+
+func (r *Repository) insert(ctx context.Context, e *model.Event) error {
+	key := tenant.UserKey(ctx, e.UserID)
+	if _, ok := r.data[key]; !ok {
+		r.data[key] = make(map[uint64]*model.Event)
 	}
-	if _, ok := r.data[e.UserID][e.ID]; ok {
-		return 0, repository.ErrDuplicateID
+	if _, ok := r.data[key][e.ID]; ok {
+		return repository.ErrDuplicateID
 	}
-	r.data[e.UserID][e.ID] = e
-	return e.ID, nil
+	e.TenantID = key.TenantID
+	r.data[key][e.ID] = e
+	r.index(key.TenantID).Add(e.UserID, e.ID, e.Title, e.Description)
+	r.journal.Record(key, e.ID, e.ICalUID, false)
+	return nil
+}
+
+// Upsert adds an Event or replaces the Event of the same user with the same ICalUID keeping its id,
+// it reports whether the Event was added. An added Event gets e.ID if it's set or a random id otherwise.
+func (r *Repository) Upsert(ctx context.Context, e *model.Event) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if e.ICalUID == "" {
+		return false, repository.ErrNoICalUID
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := tenant.UserKey(ctx, e.UserID)
+	for id, old := range r.data[key] {
+		if old.ICalUID == e.ICalUID {
+			e.ID = id
+			e.TenantID = key.TenantID
+			r.data[key][id] = e
+			r.index(key.TenantID).Add(e.UserID, e.ID, e.Title, e.Description)
+			r.journal.Record(key, e.ID, e.ICalUID, false)
+			return false, nil
+		}
+	}
+	if e.ID == 0 {
+		e.ID = r.randomizer.Uint64()
+	}
+	if err := r.insert(ctx, e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// index returns search index of tenant, r.m must be locked for writing
+func (r *Repository) index(tenantID uint64) *search.Index {
+	idx, ok := r.indexes[tenantID]
+	if !ok {
+		idx = search.NewIndex()
+		r.indexes[tenantID] = idx
+	}
+	return idx
 }
 
 // Update changes an Event in repository
-func (r *Repository) Update(e *model.Event) error {
+func (r *Repository) Update(ctx context.Context, e *model.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.m.Lock()
 	defer r.m.Unlock()
-	if _, ok := r.data[e.UserID]; !ok {
+	key := tenant.UserKey(ctx, e.UserID)
+	if _, ok := r.data[key]; !ok {
 		return repository.ErrUserNotFound
 	}
-	if _, ok := r.data[e.UserID][e.ID]; !ok {
+	if _, ok := r.data[key][e.ID]; !ok {
 		return repository.ErrEventNotFound
 	}
-	r.data[e.UserID][e.ID] = e
+	e.TenantID = key.TenantID
+	r.data[key][e.ID] = e
+	r.index(key.TenantID).Add(e.UserID, e.ID, e.Title, e.Description)
+	r.journal.Record(key, e.ID, e.ICalUID, false)
 	return nil
 }
 
 // Delete removes an Event from repository
-func (r *Repository) Delete(userID, id uint64) error {
+func (r *Repository) Delete(ctx context.Context, userID, id uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.m.Lock()
 	defer r.m.Unlock()
-	if _, ok := r.data[userID]; !ok {
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
 		return repository.ErrUserNotFound
 	}
-	if _, ok := r.data[userID][id]; !ok {
+	old, ok := r.data[key][id]
+	if !ok {
 		return repository.ErrEventNotFound
 	}
-	delete(r.data[userID], id)
+	delete(r.data[key], id)
+	r.index(key.TenantID).Remove(userID, id)
+	r.journal.Record(key, id, old.ICalUID, true)
 	return nil
 }
 
+// Get returns an Event by its id
+func (r *Repository) Get(ctx context.Context, userID, id uint64) (*model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	e, ok := r.data[key][id]
+	if !ok {
+		return nil, repository.ErrEventNotFound
+	}
+	return e, nil
+}
+
+// GetAll returns a list of all events of user
+func (r *Repository) GetAll(ctx context.Context, userID uint64) ([]*model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	events := make([]*model.Event, 0, len(r.data[key]))
+	for _, event := range r.data[key] {
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// GetBefore returns a list of events of all users of all tenants which date is before t
+func (r *Repository) GetBefore(ctx context.Context, t time.Time) ([]*model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	events := []*model.Event{}
+	for _, userEvents := range r.data {
+		for _, event := range userEvents {
+			if event.Date.Before(t) {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
 // GetForDay returns a list of events for given day
-func (r *Repository) GetForDay(userID uint64, t time.Time) ([]*model.Event, error) {
+func (r *Repository) GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.m.RLock()
 	defer r.m.RUnlock()
-	if _, ok := r.data[userID]; !ok {
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
 		return nil, repository.ErrUserNotFound
 	}
 	events := []*model.Event{}
-	for _, event := range r.data[userID] {
-		if event.Date == t {
+	for _, event := range r.data[key] {
+		if !event.Date.Before(t) && event.Date.Before(t.AddDate(0, 0, 1)) {
 			events = append(events, event)
 		}
 	}
@@ -83,15 +238,19 @@ func (r *Repository) GetForDay(userID uint64, t time.Time) ([]*model.Event, erro
 }
 
 // GetForWeek returns a list of events for a week starting from given day
-func (r *Repository) GetForWeek(userID uint64, t time.Time) ([]*model.Event, error) {
+func (r *Repository) GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.m.RLock()
 	defer r.m.RUnlock()
-	if _, ok := r.data[userID]; !ok {
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
 		return nil, repository.ErrUserNotFound
 	}
 	events := []*model.Event{}
-	for _, event := range r.data[userID] {
-		if event.Date == t || (event.Date.After(t) && event.Date.Before(t.AddDate(0, 0, 7))) {
+	for _, event := range r.data[key] {
+		if !event.Date.Before(t) && event.Date.Before(t.AddDate(0, 0, 7)) {
 			events = append(events, event)
 		}
 	}
@@ -99,17 +258,141 @@ func (r *Repository) GetForWeek(userID uint64, t time.Time) ([]*model.Event, err
 }
 
 // GetForMonth returns a list of events for a month starting from given day
-func (r *Repository) GetForMonth(userID uint64, t time.Time) ([]*model.Event, error) {
+func (r *Repository) GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.m.RLock()
 	defer r.m.RUnlock()
-	if _, ok := r.data[userID]; !ok {
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
 		return nil, repository.ErrUserNotFound
 	}
 	events := []*model.Event{}
-	for _, event := range r.data[userID] {
-		if event.Date == t || (event.Date.After(t) && event.Date.Before(t.AddDate(0, 1, 0))) {
+	for _, event := range r.data[key] {
+		if !event.Date.Before(t) && event.Date.Before(t.AddDate(0, 1, 0)) {
 			events = append(events, event)
 		}
 	}
 	return events, nil
 }
+
+// Search returns events which titles or descriptions match query ordered by relevance
+func (r *Repository) Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	q := search.ParseQuery(query)
+	results := []*model.SearchResult{}
+	for _, hit := range r.indexes[key.TenantID].Search(userID, q) {
+		e := r.data[key][hit.ID]
+		results = append(results, &model.SearchResult{
+			Event:   e,
+			Score:   hit.Score,
+			Title:   search.Highlight(e.Title, q),
+			Snippet: search.Snippet(e.Description, q, SnippetWidth),
+		})
+	}
+	return results, nil
+}
+
+// Changes returns the latest change of every Event of user made after sequence number since and current sequence number
+func (r *Repository) Changes(ctx context.Context, userID, since uint64) ([]repository.Change, uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	return r.journal.Changes(tenant.UserKey(ctx, userID), since)
+}
+
+// Seq returns sequence number of the latest change of repository
+func (r *Repository) Seq() uint64 {
+	return r.journal.Seq()
+}
+
+// LastChange returns sequence number of the latest change of events of user, it is zero if there are no changes
+func (r *Repository) LastChange(ctx context.Context, userID uint64) uint64 {
+	return r.journal.LastChange(tenant.UserKey(ctx, userID))
+}
+
+// snapshot is content of Repository saved by Snapshot, users without events are kept as they don't get ErrUserNotFound
+type snapshot struct {
+	Users    []userEvents            `json:"users"`
+	Journal  repository.JournalState `json:"journal"`
+	Holidays []userHolidays          `json:"holidays,omitempty"`
+}
+
+type userEvents struct {
+	User   tenant.Key     `json:"user"`
+	Events []*model.Event `json:"events"`
+}
+
+type userHolidays struct {
+	User tenant.Key           `json:"user"`
+	Days map[time.Time]string `json:"days"`
+}
+
+// Snapshot returns events and holidays of all users of all tenants with journal of repository encoded to JSON
+func (r *Repository) Snapshot() ([]byte, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	s := snapshot{Users: make([]userEvents, 0, len(r.data)), Journal: r.journal.State()}
+	for key, events := range r.data {
+		u := userEvents{User: key, Events: make([]*model.Event, 0, len(events))}
+		for _, e := range events {
+			u.Events = append(u.Events, e)
+		}
+		s.Users = append(s.Users, u)
+	}
+	for key, days := range r.holidays {
+		s.Holidays = append(s.Holidays, userHolidays{User: key, Days: days})
+	}
+	return json.Marshal(s)
+}
+
+// Restore replaces content of repository with content returned by Snapshot and rebuilds search indexes
+func (r *Repository) Restore(data []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.data = make(map[tenant.Key]map[uint64]*model.Event, len(s.Users))
+	r.indexes = map[uint64]*search.Index{}
+	for _, u := range s.Users {
+		events := make(map[uint64]*model.Event, len(u.Events))
+		for _, e := range u.Events {
+			events[e.ID] = e
+			r.index(u.User.TenantID).Add(e.UserID, e.ID, e.Title, e.Description)
+		}
+		r.data[u.User] = events
+	}
+	r.journal.Restore(s.Journal)
+	r.holidays = make(map[tenant.Key]map[time.Time]string, len(s.Holidays))
+	for _, u := range s.Holidays {
+		r.holidays[u.User] = u.Days
+	}
+	return nil
+}
+
+// Health returns error if repository can't serve queries in time, in-memory repository fails only if locked too long
+func (r *Repository) Health(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		r.m.RLock()
+		r.m.RUnlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"dev11/internal/repository/repotest"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository { return New() })
+}
+
+func TestSnapshot(t *testing.T) {
+	r := New()
+	ctx := context.Background()
+	other := tenant.NewContext(ctx, 2)
+	date := time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)
+	id, err := r.Create(ctx, &model.Event{UserID: 1, Title: "team meeting", Date: date})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Create(other, &model.Event{UserID: 1, Title: "dentist", Date: date}); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := r.Create(ctx, &model.Event{UserID: 2, Title: "lunch", Date: date})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(ctx, 2, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddHoliday(other, 1, date, "Vacation"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := r.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New()
+	if _, err := restored.Create(ctx, &model.Event{UserID: 3, Title: "replaced", Date: date}); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.GetAll(ctx, 3); err == nil {
+		t.Errorf("expected: user 3 not found, got: %v", err)
+	}
+	// user without events is still known
+	if events, err := restored.GetAll(ctx, 2); err != nil || len(events) != 0 {
+		t.Errorf("expected: no events, got: %v %v", events, err)
+	}
+	e, err := restored.Get(ctx, 1, id)
+	if err != nil || e.Title != "team meeting" || !e.Date.Equal(date) {
+		t.Errorf("expected: team meeting, got: %+v %v", e, err)
+	}
+	results, err := restored.Search(other, 1, "dentist")
+	if err != nil || len(results) != 1 {
+		t.Errorf("expected: one result, got: %v %v", results, err)
+	}
+	holidays, err := restored.Holidays(other, 1)
+	if expected := map[time.Time]string{date: "Vacation"}; err != nil || !reflect.DeepEqual(holidays, expected) {
+		t.Errorf("expected: %v, got: %v %v", expected, holidays, err)
+	}
+	if restored.Seq() != r.Seq() {
+		t.Errorf("expected: %d, got: %d", r.Seq(), restored.Seq())
+	}
+	for _, userID := range []uint64{1, 2} {
+		expected, _, _ := r.Changes(ctx, userID, 0)
+		got, _, err := restored.Changes(ctx, userID, 0)
+		if err != nil || !reflect.DeepEqual(got, expected) {
+			t.Errorf("expected: %v, got: %v %v", expected, got, err)
+		}
+	}
+}
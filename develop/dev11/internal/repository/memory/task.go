@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// TaskRepository is in-memory storage of Tasks where key is tenant and user_id of context and value is a map of tasks (key - task_id, value - Task).
+// It's protected from concurrent read/write with sync.RWMutex.
+type TaskRepository struct {
+	m          sync.RWMutex
+	randomizer *rand.Rand
+	data       map[tenant.Key]map[uint64]*model.Task
+}
+
+// NewTaskRepository creates an instance of TaskRepository and returns pointer to it
+func NewTaskRepository() *TaskRepository {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &TaskRepository{randomizer: r, data: map[tenant.Key]map[uint64]*model.Task{}}
+}
+
+// Create adds a Task to repository
+func (r *TaskRepository) Create(ctx context.Context, t *model.Task) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := tenant.UserKey(ctx, t.UserID)
+	if _, ok := r.data[key]; !ok {
+		r.data[key] = make(map[uint64]*model.Task)
+	}
+	t.ID = r.randomizer.Uint64()
+	if _, ok := r.data[key][t.ID]; ok {
+		return 0, repository.ErrDuplicateID
+	}
+	r.data[key][t.ID] = t
+	return t.ID, nil
+}
+
+// Update changes a Task in repository
+func (r *TaskRepository) Update(ctx context.Context, t *model.Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := tenant.UserKey(ctx, t.UserID)
+	if _, ok := r.data[key]; !ok {
+		return repository.ErrUserNotFound
+	}
+	if _, ok := r.data[key][t.ID]; !ok {
+		return repository.ErrTaskNotFound
+	}
+	r.data[key][t.ID] = t
+	return nil
+}
+
+// Delete removes a Task from repository
+func (r *TaskRepository) Delete(ctx context.Context, userID, id uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
+		return repository.ErrUserNotFound
+	}
+	if _, ok := r.data[key][id]; !ok {
+		return repository.ErrTaskNotFound
+	}
+	delete(r.data[key], id)
+	return nil
+}
+
+// Get returns a Task by its id
+func (r *TaskRepository) Get(ctx context.Context, userID, id uint64) (*model.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	t, ok := r.data[key][id]
+	if !ok {
+		return nil, repository.ErrTaskNotFound
+	}
+	return t, nil
+}
+
+// GetDue returns a list of tasks of user which are due in [from, to)
+func (r *TaskRepository) GetDue(ctx context.Context, userID uint64, from, to time.Time) ([]*model.Task, error) {
+	return r.filter(ctx, userID, func(t *model.Task) bool {
+		return !t.Due.Before(from) && t.Due.Before(to)
+	})
+}
+
+// GetOverdue returns a list of tasks of user which are not done and due before t
+func (r *TaskRepository) GetOverdue(ctx context.Context, userID uint64, t time.Time) ([]*model.Task, error) {
+	return r.filter(ctx, userID, func(task *model.Task) bool {
+		return !task.Done && task.Due.Before(t)
+	})
+}
+
+func (r *TaskRepository) filter(ctx context.Context, userID uint64, match func(t *model.Task) bool) ([]*model.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	key := tenant.UserKey(ctx, userID)
+	if _, ok := r.data[key]; !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	tasks := []*model.Task{}
+	for _, t := range r.data[key] {
+		if match(t) {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, nil
+}
@@ -0,0 +1,321 @@
+package replicated
+
+import (
+	"context"
+	"dev11/internal/raft"
+	"dev11/internal/repository"
+	"dev11/internal/repository/memory"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrStale is returned by reads on a follower which has not heard from leader for longer than allowed staleness
+var ErrStale = fmt.Errorf("%w: replica is too stale", repository.ErrUnavailable)
+
+// ErrNoLeader is returned by health check of node which doesn't know leader of cluster
+var ErrNoLeader = fmt.Errorf("%w: leader is unknown", repository.ErrUnavailable)
+
+type operation string
+
+const (
+	opCreate operation = "create"
+	opUpdate operation = "update"
+	opDelete operation = "delete"
+	opUpsert operation = "upsert"
+
+	opAddHoliday    operation = "add_holiday"
+	opDeleteHoliday operation = "delete_holiday"
+)
+
+// command is a write replicated through Raft log, it is applied on behalf of tenant of context of the write
+type command struct {
+	Op       operation    `json:"op"`
+	TenantID uint64       `json:"tenant_id,omitempty"`
+	Event    *model.Event `json:"event,omitempty"`
+	UserID   uint64       `json:"user_id,omitempty"`
+	ID       uint64       `json:"id,omitempty"`
+	Date     time.Time    `json:"date"`
+	Name     string       `json:"name,omitempty"`
+}
+
+// result is an outcome of applying command, ID and Created describe the Event written by upsert
+type result struct {
+	Error   string `json:"error,omitempty"`
+	ID      uint64 `json:"id,omitempty"`
+	Created bool   `json:"created,omitempty"`
+}
+
+// errors which can be returned by applying command, they are transferred between nodes by message
+var knownErrors = []error{repository.ErrUserNotFound, repository.ErrEventNotFound, repository.ErrDuplicateID,
+	repository.ErrNoICalUID, repository.ErrHolidayNotFound}
+
+// Repository is a storage of Events replicated with Raft.
+// Writes are proposed to leader and return after they are committed by majority of cluster and applied locally.
+// Reads are served by local memory.Repository of every node, so reads on followers may be stale:
+// if maxStale is positive, followers which haven't heard from leader for longer than maxStale return ErrStale.
+type Repository struct {
+	local    *memory.Repository
+	node     *raft.Node
+	maxStale time.Duration
+
+	m          sync.Mutex
+	randomizer *rand.Rand
+}
+
+// New creates a node of cluster with given Config applying committed writes to local repository and returns pointer to it.
+// Local repository must be empty: a restarted node fills it from its saved snapshot and by applying saved log after it.
+// Snapshots of local repository are taken every cfg.SnapshotEntries writes to bound the log.
+// The node must be started with Start.
+func New(cfg raft.Config, local *memory.Repository, maxStale time.Duration) (*Repository, error) {
+	r := &Repository{
+		local:      local,
+		maxStale:   maxStale,
+		randomizer: rand.New(rand.NewSource(time.Now().UnixNano() + int64(cfg.ID))),
+	}
+	cfg.Snapshot, cfg.Restore = local.Snapshot, local.Restore
+	node, err := raft.New(cfg, r.apply)
+	if err != nil {
+		return nil, err
+	}
+	r.node = node
+	return r, nil
+}
+
+// Node returns Raft node of repository, its Handler must be served for other nodes of cluster
+func (r *Repository) Node() *raft.Node {
+	return r.node
+}
+
+// Leader reports whether node of repository is leader of cluster
+func (r *Repository) Leader() bool {
+	state, _, _ := r.node.Status()
+	return state == raft.Leader
+}
+
+// Start starts Raft node of repository
+func (r *Repository) Start() {
+	r.node.Start()
+}
+
+// Stop stops Raft node of repository
+func (r *Repository) Stop() {
+	r.node.Stop()
+}
+
+// Create adds an Event to repository through leader
+func (r *Repository) Create(ctx context.Context, e *model.Event) (uint64, error) {
+	r.m.Lock()
+	e.ID = r.randomizer.Uint64()
+	r.m.Unlock()
+	e.TenantID = tenant.FromContext(ctx)
+	if _, err := r.propose(ctx, command{Op: opCreate, TenantID: e.TenantID, Event: e}); err != nil {
+		return 0, err
+	}
+	return e.ID, nil
+}
+
+// Update changes an Event in repository through leader
+func (r *Repository) Update(ctx context.Context, e *model.Event) error {
+	e.TenantID = tenant.FromContext(ctx)
+	_, err := r.propose(ctx, command{Op: opUpdate, TenantID: e.TenantID, Event: e})
+	return err
+}
+
+// Delete removes an Event from repository through leader
+func (r *Repository) Delete(ctx context.Context, userID, id uint64) error {
+	_, err := r.propose(ctx, command{Op: opDelete, TenantID: tenant.FromContext(ctx), UserID: userID, ID: id})
+	return err
+}
+
+// Upsert adds an Event or replaces the Event of the same user with the same ICalUID through leader,
+// it reports whether the Event was added
+func (r *Repository) Upsert(ctx context.Context, e *model.Event) (bool, error) {
+	r.m.Lock()
+	e.ID = r.randomizer.Uint64()
+	r.m.Unlock()
+	e.TenantID = tenant.FromContext(ctx)
+	res, err := r.propose(ctx, command{Op: opUpsert, TenantID: e.TenantID, Event: e})
+	if err != nil {
+		return false, err
+	}
+	e.ID = res.ID
+	return res.Created, nil
+}
+
+// AddHoliday adds a day off defined by user through leader
+func (r *Repository) AddHoliday(ctx context.Context, userID uint64, date time.Time, name string) error {
+	_, err := r.propose(ctx, command{Op: opAddHoliday, TenantID: tenant.FromContext(ctx), UserID: userID, Date: date, Name: name})
+	return err
+}
+
+// DeleteHoliday removes a day off defined by user through leader
+func (r *Repository) DeleteHoliday(ctx context.Context, userID uint64, date time.Time) error {
+	_, err := r.propose(ctx, command{Op: opDeleteHoliday, TenantID: tenant.FromContext(ctx), UserID: userID, Date: date})
+	return err
+}
+
+// Holidays returns days off defined by user from local replica
+func (r *Repository) Holidays(ctx context.Context, userID uint64) (map[time.Time]string, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.Holidays(ctx, userID)
+}
+
+// Changes returns changes of events of user from journal of local replica
+func (r *Repository) Changes(ctx context.Context, userID, since uint64) ([]repository.Change, uint64, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, 0, err
+	}
+	return r.local.Changes(ctx, userID, since)
+}
+
+// Seq returns sequence number of the latest change applied to local replica,
+// it's the same on every node which applied the same part of log
+func (r *Repository) Seq() uint64 {
+	return r.local.Seq()
+}
+
+// LastChange returns sequence number of the latest change of events of user applied to local replica
+func (r *Repository) LastChange(ctx context.Context, userID uint64) uint64 {
+	return r.local.LastChange(ctx, userID)
+}
+
+// Get returns an Event by its id from local replica
+func (r *Repository) Get(ctx context.Context, userID, id uint64) (*model.Event, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.Get(ctx, userID, id)
+}
+
+// GetAll returns a list of all events of user from local replica
+func (r *Repository) GetAll(ctx context.Context, userID uint64) ([]*model.Event, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.GetAll(ctx, userID)
+}
+
+// GetBefore returns a list of events of all users which date is before t from local replica
+func (r *Repository) GetBefore(ctx context.Context, t time.Time) ([]*model.Event, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.GetBefore(ctx, t)
+}
+
+// GetForDay returns a list of events for given day from local replica
+func (r *Repository) GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.GetForDay(ctx, userID, t)
+}
+
+// GetForWeek returns a list of events for a week starting from given day from local replica
+func (r *Repository) GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.GetForWeek(ctx, userID, t)
+}
+
+// GetForMonth returns a list of events for a month starting from given day from local replica
+func (r *Repository) GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.GetForMonth(ctx, userID, t)
+}
+
+// Search returns events matching query from local replica
+func (r *Repository) Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error) {
+	if err := r.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return r.local.Search(ctx, userID, query)
+}
+
+// Health returns error if node can't serve queries: leader of cluster is unknown or local replica is too stale
+func (r *Repository) Health(ctx context.Context) error {
+	if _, _, leaderID := r.node.Status(); leaderID == 0 {
+		return ErrNoLeader
+	}
+	if err := r.checkStaleness(); err != nil {
+		return err
+	}
+	return r.local.Health(ctx)
+}
+
+func (r *Repository) checkStaleness() error {
+	if r.maxStale > 0 && time.Since(r.node.LastContact()) > r.maxStale {
+		return ErrStale
+	}
+	return nil
+}
+
+func (r *Repository) propose(ctx context.Context, cmd command) (result, error) {
+	var res result
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return res, err
+	}
+	data, err = r.node.Propose(ctx, data)
+	if err != nil {
+		return res, err
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return res, err
+	}
+	if res.Error == "" {
+		return res, nil
+	}
+	for _, known := range knownErrors {
+		if res.Error == known.Error() {
+			return res, known
+		}
+	}
+	return res, errors.New(res.Error)
+}
+
+// apply executes committed command on local repository
+func (r *Repository) apply(data []byte) []byte {
+	var cmd command
+	res := result{}
+	err := json.Unmarshal(data, &cmd)
+	if err == nil {
+		ctx := tenant.NewContext(context.Background(), cmd.TenantID)
+		switch cmd.Op {
+		case opCreate:
+			err = r.local.Insert(ctx, cmd.Event)
+		case opUpdate:
+			err = r.local.Update(ctx, cmd.Event)
+		case opDelete:
+			err = r.local.Delete(ctx, cmd.UserID, cmd.ID)
+		case opUpsert:
+			res.Created, err = r.local.Upsert(ctx, cmd.Event)
+			res.ID = cmd.Event.ID
+		case opAddHoliday:
+			err = r.local.AddHoliday(ctx, cmd.UserID, cmd.Date, cmd.Name)
+		case opDeleteHoliday:
+			err = r.local.DeleteHoliday(ctx, cmd.UserID, cmd.Date)
+		default:
+			err = errors.New("unknown operation " + string(cmd.Op))
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	data, _ = json.Marshal(res)
+	return data
+}
@@ -0,0 +1,265 @@
+package replicated
+
+import (
+	"context"
+	"dev11/internal/raft"
+	"dev11/internal/repository"
+	"dev11/internal/repository/memory"
+	"dev11/internal/repository/repotest"
+	"dev11/pkg/model"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var date = time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+// cluster is a set of nodes listening on loopback interface
+type cluster struct {
+	t       *testing.T
+	repos   map[uint64]*Repository
+	servers map[uint64]*http.Server
+}
+
+func newCluster(t *testing.T, size int, maxStale time.Duration) *cluster {
+	c := &cluster{t: t, repos: map[uint64]*Repository{}, servers: map[uint64]*http.Server{}}
+	listeners := map[uint64]net.Listener{}
+	addrs := map[uint64]string{}
+	for id := uint64(1); id <= uint64(size); id++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[id] = l
+		addrs[id] = "http://" + l.Addr().String()
+	}
+	for id, l := range listeners {
+		peers := map[uint64]string{}
+		for peer, addr := range addrs {
+			if peer != id {
+				peers[peer] = addr
+			}
+		}
+		cfg := raft.Config{ID: id, Peers: peers, HeartbeatInterval: 20 * time.Millisecond, ElectionTimeout: 100 * time.Millisecond,
+			Dir: t.TempDir(), Secret: "secret"}
+		repo, err := New(cfg, memory.New(), maxStale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &http.Server{Handler: repo.Node().Handler()}
+		go s.Serve(l)
+		repo.Start()
+		c.repos[id] = repo
+		c.servers[id] = s
+	}
+	t.Cleanup(func() {
+		for id := range c.repos {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+// stop shuts down node as if its process was killed
+func (c *cluster) stop(id uint64) {
+	c.servers[id].Close()
+	c.repos[id].Stop()
+	delete(c.repos, id)
+	delete(c.servers, id)
+}
+
+// leader waits until running nodes agree on a single leader and returns its id
+func (c *cluster) leader() uint64 {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		leaders := map[uint64]bool{}
+		for id, repo := range c.repos {
+			if state, _, leaderID := repo.Node().Status(); state == raft.Leader {
+				leaders[id] = true
+			} else {
+				leaders[leaderID] = true
+			}
+		}
+		if len(leaders) == 1 {
+			for id := range leaders {
+				if _, ok := c.repos[id]; ok && id != 0 {
+					return id
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatal("leader is not elected")
+	return 0
+}
+
+func (c *cluster) follower() uint64 {
+	leader := c.leader()
+	for id := range c.repos {
+		if id != leader {
+			return id
+		}
+	}
+	c.t.Fatal("cluster has no followers")
+	return 0
+}
+
+// waitReplicated waits until every running node has exactly given events of user at date
+func (c *cluster) waitReplicated(userID uint64, ids ...uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for id, repo := range c.repos {
+		for {
+			events, _ := repo.GetForDay(context.Background(), userID, date)
+			if fmt.Sprint(sortedIDs(events)) == fmt.Sprint(sorted(ids)) {
+				break
+			}
+			if time.Now().After(deadline) {
+				c.t.Fatalf("node %d: expected events %v, got %v", id, sorted(ids), sortedIDs(events))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func sortedIDs(events []*model.Event) []uint64 {
+	ids := []uint64{}
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return sorted(ids)
+}
+
+func sorted(ids []uint64) []uint64 {
+	s := append([]uint64{}, ids...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s
+}
+
+func TestClusterContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository {
+		c := newCluster(t, 3, 0)
+		// followers may lag behind, so the contract is checked on leader
+		return c.repos[c.leader()]
+	})
+}
+
+func TestClusterReplicatesWrites(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// writes to a follower are forwarded to leader
+	follower := c.repos[c.follower()]
+	id, err := follower.Create(ctx, &model.Event{UserID: 1, Title: "standup", Date: date})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.waitReplicated(1, id)
+
+	if err := follower.Update(ctx, &model.Event{ID: id, UserID: 1, Title: "retro", Date: date}); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.Delete(ctx, 1, id+1); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	if err := follower.Update(ctx, &model.Event{ID: id, UserID: 2, Title: "retro", Date: date}); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	c.waitReplicated(1, id)
+	for nodeID, repo := range c.repos {
+		events, _ := repo.GetForDay(ctx, 1, date)
+		if events[0].Title != "retro" {
+			t.Errorf("node %d: expected updated title, got: %s", nodeID, events[0].Title)
+		}
+	}
+
+	if err := c.repos[c.leader()].Delete(ctx, 1, id); err != nil {
+		t.Fatal(err)
+	}
+	c.waitReplicated(1)
+}
+
+func TestClusterJournal(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// changes made through any node are recorded by every node with the same sequence numbers
+	follower := c.follower()
+	first, err := c.repos[follower].Create(ctx, &model.Event{UserID: 1, Title: "standup", Date: date})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := &model.Event{UserID: 1, ICalUID: "retro@example.com", Title: "retro", Date: date}
+	if _, err := c.repos[c.leader()].Upsert(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	c.waitReplicated(1, first, second.ID)
+	expected, seq, err := c.repos[c.leader()].Changes(ctx, 1, 0)
+	if err != nil || len(expected) != 2 {
+		t.Fatalf("expected: 2 changes, got: %+v, %v", expected, err)
+	}
+	for id, repo := range c.repos {
+		changes, got, err := repo.Changes(ctx, 1, 0)
+		if err != nil || got != seq || !reflect.DeepEqual(changes, expected) {
+			t.Errorf("node %d: expected: %+v at %d, got: %+v at %d, %v", id, expected, seq, changes, got, err)
+		}
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	c := newCluster(t, 3, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := c.repos[c.leader()].Create(ctx, &model.Event{UserID: 1, Title: "before", Date: date})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.waitReplicated(1, first)
+
+	old := c.leader()
+	_, term, _ := c.repos[old].Node().Status()
+	c.stop(old)
+
+	leader := c.leader()
+	if _, newTerm, _ := c.repos[leader].Node().Status(); newTerm <= term {
+		t.Errorf("expected term greater than %d, got: %d", term, newTerm)
+	}
+	second, err := c.repos[c.follower()].Create(ctx, &model.Event{UserID: 1, Title: "after", Date: date})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.waitReplicated(1, first, second)
+}
+
+func TestClusterWithoutMajority(t *testing.T) {
+	c := newCluster(t, 3, 100*time.Millisecond)
+	c.leader()
+	survivor := c.follower()
+	if err := c.repos[survivor].Health(context.Background()); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	for id := range c.repos {
+		if id != survivor {
+			c.stop(id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := c.repos[survivor].Create(ctx, &model.Event{UserID: 1, Title: "lost", Date: date}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if _, err := c.repos[survivor].GetForDay(context.Background(), 1, date); !errors.Is(err, ErrStale) {
+		t.Errorf("expected: %v, got: %v", ErrStale, err)
+	}
+	if err := c.repos[survivor].Health(context.Background()); !errors.Is(err, repository.ErrUnavailable) {
+		t.Errorf("expected: %v, got: %v", repository.ErrUnavailable, err)
+	}
+}
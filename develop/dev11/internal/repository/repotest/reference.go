@@ -0,0 +1,106 @@
+package repotest
+
+import (
+	"dev11/internal/repository"
+	"dev11/pkg/model"
+	"time"
+)
+
+// reference is the simplest possible implementation of the contract used as a model in randomized test.
+// It keeps events in a flat list and scans it on every query.
+type reference struct {
+	users  map[uint64]bool
+	events []*model.Event
+}
+
+func newReference() *reference {
+	return &reference{users: map[uint64]bool{}}
+}
+
+func (r *reference) find(userID, id uint64) int {
+	for i, e := range r.events {
+		if e.UserID == userID && e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *reference) insert(e *model.Event) error {
+	r.users[e.UserID] = true
+	if r.find(e.UserID, e.ID) >= 0 {
+		return repository.ErrDuplicateID
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *reference) update(e *model.Event) error {
+	if !r.users[e.UserID] {
+		return repository.ErrUserNotFound
+	}
+	i := r.find(e.UserID, e.ID)
+	if i < 0 {
+		return repository.ErrEventNotFound
+	}
+	r.events[i] = e
+	return nil
+}
+
+func (r *reference) delete(userID, id uint64) error {
+	if !r.users[userID] {
+		return repository.ErrUserNotFound
+	}
+	i := r.find(userID, id)
+	if i < 0 {
+		return repository.ErrEventNotFound
+	}
+	r.events = append(r.events[:i], r.events[i+1:]...)
+	return nil
+}
+
+func (r *reference) getOne(userID, id uint64) (*model.Event, error) {
+	if !r.users[userID] {
+		return nil, repository.ErrUserNotFound
+	}
+	i := r.find(userID, id)
+	if i < 0 {
+		return nil, repository.ErrEventNotFound
+	}
+	return r.events[i], nil
+}
+
+// get returns events of user in window of query with given name starting from t,
+// GetAll query has unbounded window
+func (r *reference) get(query string, userID uint64, t time.Time) ([]*model.Event, error) {
+	if !r.users[userID] {
+		return nil, repository.ErrUserNotFound
+	}
+	end := t.AddDate(0, 0, 1)
+	switch query {
+	case "GetAll":
+		t, end = time.Time{}, time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	case "GetForWeek":
+		end = t.AddDate(0, 0, 7)
+	case "GetForMonth":
+		end = t.AddDate(0, 1, 0)
+	}
+	events := []*model.Event{}
+	for _, e := range r.events {
+		if e.UserID == userID && !e.Date.Before(t) && e.Date.Before(end) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// before returns events of all users which date is before t
+func (r *reference) before(t time.Time) []*model.Event {
+	events := []*model.Event{}
+	for _, e := range r.events {
+		if e.Date.Before(t) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
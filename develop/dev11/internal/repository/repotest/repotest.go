@@ -0,0 +1,669 @@
+// Package repotest provides a conformance test suite for implementations of event repository.
+// Every storage backend must behave exactly like memory.Repository:
+//
+//	func TestRepository(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repotest.Repository { return memory.New() })
+//	}
+//
+// The suite starts concurrent goroutines, so it should be run with the race detector.
+package repotest
+
+import (
+	"context"
+	"dev11/internal/repository"
+	"dev11/internal/tenant"
+	"dev11/pkg/model"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Repository is a storage of events checked by the suite
+type Repository interface {
+	Create(ctx context.Context, e *model.Event) (uint64, error)
+	Update(ctx context.Context, e *model.Event) error
+	Delete(ctx context.Context, userID, id uint64) error
+	Get(ctx context.Context, userID, id uint64) (*model.Event, error)
+	GetAll(ctx context.Context, userID uint64) ([]*model.Event, error)
+	GetBefore(ctx context.Context, t time.Time) ([]*model.Event, error)
+	GetForDay(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForWeek(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	GetForMonth(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+	Search(ctx context.Context, userID uint64, query string) ([]*model.SearchResult, error)
+	Upsert(ctx context.Context, e *model.Event) (bool, error)
+	Changes(ctx context.Context, userID, since uint64) ([]repository.Change, uint64, error)
+	Seq() uint64
+	LastChange(ctx context.Context, userID uint64) uint64
+	AddHoliday(ctx context.Context, userID uint64, date time.Time, name string) error
+	DeleteHoliday(ctx context.Context, userID uint64, date time.Time) error
+	Holidays(ctx context.Context, userID uint64) (map[time.Time]string, error)
+}
+
+// Factory creates a new empty Repository for every test of the suite,
+// it may register cleanup of the Repository with t.Cleanup
+type Factory func(t *testing.T) Repository
+
+// Operations is a number of random operations in model-based test
+var Operations = 500
+
+var base = time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+// Run runs the whole contract of event repository against repositories created by factory
+func Run(t *testing.T, factory Factory) {
+	t.Run("Errors", func(t *testing.T) { testErrors(t, factory(t)) })
+	t.Run("Windows", func(t *testing.T) { testWindows(t, factory(t)) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, factory(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, factory(t)) })
+	t.Run("Model", func(t *testing.T) { testModel(t, factory(t)) })
+	t.Run("Tenants", func(t *testing.T) { testTenants(t, factory(t)) })
+	t.Run("Upsert", func(t *testing.T) { testUpsert(t, factory(t)) })
+	t.Run("Journal", func(t *testing.T) { testJournal(t, factory(t)) })
+	t.Run("Holidays", func(t *testing.T) { testHolidays(t, factory(t)) })
+}
+
+func testErrors(t *testing.T, r Repository) {
+	ctx := context.Background()
+	if _, err := r.GetForDay(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetForDay of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if _, err := r.GetForWeek(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetForWeek of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if _, err := r.GetForMonth(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetForMonth of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if _, err := r.Get(ctx, 1, 1); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Get of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if _, err := r.GetAll(ctx, 1); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetAll of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if _, err := r.Search(ctx, 1, "title"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Search of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if err := r.Update(ctx, &model.Event{ID: 1, UserID: 1, Title: "title", Date: base}); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Update of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+	if err := r.Delete(ctx, 1, 1); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Delete of unknown user: expected: %v, got: %v", repository.ErrUserNotFound, err)
+	}
+
+	id, err := r.Create(ctx, &model.Event{UserID: 1, Title: "title", Date: base})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := r.Update(ctx, &model.Event{ID: id + 1, UserID: 1, Title: "title", Date: base}); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("Update of unknown event: expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	if e, err := r.Get(ctx, 1, id); err != nil || e.ID != id || e.Title != "title" {
+		t.Errorf("Get: expected: event %d, got: %v, %v", id, e, err)
+	}
+	if _, err := r.Get(ctx, 1, id+1); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("Get of unknown event: expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	if err := r.Delete(ctx, 1, id+1); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("Delete of unknown event: expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	if err := r.Delete(ctx, 1, id); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if err := r.Delete(ctx, 1, id); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("Delete of deleted event: expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	// user stays known after his last event is deleted
+	events, err := r.GetForDay(ctx, 1, base)
+	if err != nil || events == nil || len(events) != 0 {
+		t.Errorf("GetForDay of user without events: expected: empty list, got: %v, %v", events, err)
+	}
+	if events, err := r.GetAll(ctx, 1); err != nil || events == nil || len(events) != 0 {
+		t.Errorf("GetAll of user without events: expected: empty list, got: %v, %v", events, err)
+	}
+	if events, err := r.GetBefore(ctx, base.AddDate(1, 0, 0)); err != nil || events == nil || len(events) != 0 {
+		t.Errorf("GetBefore without events: expected: empty list, got: %v, %v", events, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := r.GetForDay(cancelled, 1, base); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetForDay with cancelled context: expected: %v, got: %v", context.Canceled, err)
+	}
+	if _, err := r.Create(cancelled, &model.Event{UserID: 1, Title: "title", Date: base}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Create with cancelled context: expected: %v, got: %v", context.Canceled, err)
+	}
+}
+
+func testWindows(t *testing.T, r Repository) {
+	ctx := context.Background()
+	ids := map[string]uint64{}
+	// base is 31 January, so month window ends at 2 March because of normalization of 31 February
+	for name, date := range map[string]time.Time{
+		"day before":        base.AddDate(0, 0, -1),
+		"first day":         base,
+		"first day evening": base.Add(20 * time.Hour),
+		"second day":        base.AddDate(0, 0, 1),
+		"last week day":     base.AddDate(0, 0, 6),
+		"last week night":   base.AddDate(0, 0, 6).Add(23*time.Hour + 59*time.Minute),
+		"after week":        base.AddDate(0, 0, 7),
+		"last month day":    time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		"after month":       time.Date(2024, time.March, 2, 0, 0, 0, 0, time.UTC),
+		"other user's day":  base,
+	} {
+		userID := uint64(1)
+		if name == "other user's day" {
+			userID = 2
+		}
+		id, err := r.Create(ctx, &model.Event{UserID: userID, Title: name, Date: date})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		ids[name] = id
+	}
+
+	tests := map[string]struct {
+		get      func(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error)
+		expected []string
+	}{
+		"day":   {get: r.GetForDay, expected: []string{"first day", "first day evening"}},
+		"week":  {get: r.GetForWeek, expected: []string{"first day", "first day evening", "second day", "last week day", "last week night"}},
+		"month": {get: r.GetForMonth, expected: []string{"first day", "first day evening", "second day", "last week day", "last week night", "after week", "last month day"}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			events, err := v.get(ctx, 1, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []uint64{}
+			for _, name := range v.expected {
+				expected = append(expected, ids[name])
+			}
+			if got := idsOf(events); !equalIDs(got, expected) {
+				t.Errorf("expected: %v, got: %v", v.expected, titlesOf(events))
+			}
+		})
+	}
+}
+
+func testSearch(t *testing.T, r Repository) {
+	ctx := context.Background()
+	id, err := r.Create(ctx, &model.Event{UserID: 1, Title: "Quarterly planning", Description: "Discuss roadmap", Date: base})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := r.Create(ctx, &model.Event{UserID: 1, Title: "Lunch", Date: base}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	results, err := r.Search(ctx, 1, "roadmap")
+	if err != nil || len(results) != 1 || results[0].Event.ID != id {
+		t.Errorf("Search by description: expected: [%d], got: %v, %v", id, results, err)
+	}
+	if err := r.Update(ctx, &model.Event{ID: id, UserID: 1, Title: "Retro", Date: base}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if results, err := r.Search(ctx, 1, "roadmap"); err != nil || len(results) != 0 {
+		t.Errorf("Search after update: expected: [], got: %v, %v", results, err)
+	}
+	if err := r.Delete(ctx, 1, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if results, err := r.Search(ctx, 1, "retro"); err != nil || len(results) != 0 {
+		t.Errorf("Search after delete: expected: [], got: %v, %v", results, err)
+	}
+}
+
+// testTenants checks that users with equal ids in different tenants never see events of each other
+func testTenants(t *testing.T, r Repository) {
+	first := tenant.NewContext(context.Background(), 1)
+	second := tenant.NewContext(context.Background(), 2)
+	secret, err := r.Create(first, &model.Event{UserID: 1, Title: "Board meeting", Description: "Acquisition", Date: base})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// fill caches of the first tenant before queries of the second one
+	if events, err := r.GetForDay(first, 1, base); err != nil || len(events) != 1 {
+		t.Fatalf("GetForDay of the first tenant: expected: 1 event, got: %v, %v", titlesOf(events), err)
+	}
+
+	for name, ctx := range map[string]context.Context{"other tenant": second, "default tenant": context.Background()} {
+		if _, err := r.GetForDay(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("GetForDay of %s: expected: %v, got: %v", name, repository.ErrUserNotFound, err)
+		}
+		if _, err := r.GetForWeek(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("GetForWeek of %s: expected: %v, got: %v", name, repository.ErrUserNotFound, err)
+		}
+		if _, err := r.GetForMonth(ctx, 1, base); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("GetForMonth of %s: expected: %v, got: %v", name, repository.ErrUserNotFound, err)
+		}
+		if _, err := r.Get(ctx, 1, secret); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("Get of %s: expected: %v, got: %v", name, repository.ErrUserNotFound, err)
+		}
+		if _, err := r.GetAll(ctx, 1); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("GetAll of %s: expected: %v, got: %v", name, repository.ErrUserNotFound, err)
+		}
+		if _, err := r.Search(ctx, 1, "board"); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("Search of %s: expected: %v, got: %v", name, repository.ErrUserNotFound, err)
+		}
+	}
+
+	own, err := r.Create(second, &model.Event{UserID: 1, Title: "Standup", Description: "Board games", Date: base})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := r.Get(second, 1, secret); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("Get of event of other tenant: expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	if err := r.Update(second, &model.Event{ID: secret, UserID: 1, Title: "Hijacked", Date: base}); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("Update of event of other tenant: expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	if err := r.Delete(second, 1, secret); !errors.Is(err, repository.ErrEventNotFound) {
+		t.Errorf("Delete of event of other tenant: expected: %v, got: %v", repository.ErrEventNotFound, err)
+	}
+	queries := map[string]func(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error){
+		"GetForDay":   r.GetForDay,
+		"GetForWeek":  r.GetForWeek,
+		"GetForMonth": r.GetForMonth,
+		"GetAll": func(ctx context.Context, userID uint64, _ time.Time) ([]*model.Event, error) {
+			return r.GetAll(ctx, userID)
+		},
+	}
+	for k, get := range queries {
+		for ctx, expected := range map[context.Context]uint64{first: secret, second: own} {
+			events, err := get(ctx, 1, base)
+			if err != nil || !equalIDs(idsOf(events), []uint64{expected}) {
+				t.Errorf("%s of tenant %d: expected: [%d], got: %v, %v", k, tenant.FromContext(ctx), expected, idsOf(events), err)
+			}
+			for _, e := range events {
+				if e.TenantID != tenant.FromContext(ctx) {
+					t.Errorf("%s of tenant %d: expected tenant of event: %d, got: %d", k, tenant.FromContext(ctx), tenant.FromContext(ctx), e.TenantID)
+				}
+			}
+		}
+	}
+	results, err := r.Search(second, 1, "board")
+	if err != nil || len(results) != 1 || results[0].Event.ID != own {
+		t.Errorf("Search of other tenant: expected: [%d], got: %v, %v", own, results, err)
+	}
+	e, err := r.Get(first, 1, secret)
+	if err != nil || e.Title != "Board meeting" {
+		t.Errorf("Get after writes of other tenant: expected: %q, got: %v, %v", "Board meeting", e, err)
+	}
+	events, err := r.GetBefore(context.Background(), base.AddDate(0, 0, 1))
+	if err != nil || len(events) != 2 {
+		t.Fatalf("GetBefore: expected: 2 events of all tenants, got: %v, %v", titlesOf(events), err)
+	}
+	for _, e := range events {
+		if expected := map[uint64]uint64{secret: 1, own: 2}[e.ID]; e.TenantID != expected {
+			t.Errorf("GetBefore: expected tenant of event %q: %d, got: %d", e.Title, expected, e.TenantID)
+		}
+	}
+}
+
+func testUpsert(t *testing.T, r Repository) {
+	ctx := context.Background()
+	if _, err := r.Upsert(ctx, &model.Event{UserID: 1, Title: "no uid", Date: base}); !errors.Is(err, repository.ErrNoICalUID) {
+		t.Errorf("Upsert without ICalUID: expected: %v, got: %v", repository.ErrNoICalUID, err)
+	}
+	e := &model.Event{UserID: 1, ICalUID: "planning@example.com", Title: "Planning", Date: base}
+	created, err := r.Upsert(ctx, e)
+	if err != nil || !created || e.ID == 0 {
+		t.Fatalf("Upsert of new event: expected: created, got: %v, %v, id %d", created, err, e.ID)
+	}
+	id := e.ID
+	created, err = r.Upsert(ctx, &model.Event{UserID: 1, ICalUID: "planning@example.com", Title: "Planning Q3", Date: base})
+	if err != nil || created {
+		t.Fatalf("Upsert of existing event: expected: replaced, got: %v, %v", created, err)
+	}
+	events, err := r.GetAll(ctx, 1)
+	if err != nil || len(events) != 1 || events[0].ID != id || events[0].Title != "Planning Q3" {
+		t.Errorf("GetAll: expected: replaced event %d, got: %v, %v", id, titlesOf(events), err)
+	}
+	if created, err := r.Upsert(ctx, &model.Event{UserID: 2, ICalUID: "planning@example.com", Title: "Planning", Date: base}); err != nil || !created {
+		t.Errorf("Upsert of other user: expected: created, got: %v, %v", created, err)
+	}
+
+	// concurrent upserts of the same object add it once
+	const workers = 8
+	var wg sync.WaitGroup
+	results := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			created, err := r.Upsert(ctx, &model.Event{UserID: 3, ICalUID: "race@example.com", Title: fmt.Sprint(i), Date: base})
+			if err != nil {
+				t.Errorf("Upsert: %v", err)
+			}
+			results <- created
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	added := 0
+	for created := range results {
+		if created {
+			added++
+		}
+	}
+	events, err = r.GetAll(ctx, 3)
+	if added != 1 || err != nil || len(events) != 1 {
+		t.Errorf("concurrent Upsert: expected: 1 event added once, got: %d added, %v, %v", added, titlesOf(events), err)
+	}
+}
+
+func testJournal(t *testing.T, r Repository) {
+	ctx := tenant.NewContext(context.Background(), 1)
+	start := r.Seq()
+	first, err := r.Create(ctx, &model.Event{UserID: 1, ICalUID: "first@example.com", Title: "first", Date: base})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := r.Update(ctx, &model.Event{ID: first, UserID: 1, ICalUID: "first@example.com", Title: "updated", Date: base}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	second, err := r.Create(ctx, &model.Event{UserID: 1, ICalUID: "second@example.com", Title: "second", Date: base})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := r.Delete(ctx, 1, second); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := r.Create(tenant.NewContext(ctx, 2), &model.Event{UserID: 1, Title: "other tenant", Date: base}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	changes, seq, err := r.Changes(ctx, 1, start)
+	expected := []repository.Change{
+		{Seq: start + 2, EventID: first, ICalUID: "first@example.com"},
+		{Seq: start + 4, EventID: second, ICalUID: "second@example.com", Deleted: true},
+	}
+	if err != nil || seq != start+5 || !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Changes: expected: %+v at %d, got: %+v at %d, %v", expected, start+5, changes, seq, err)
+	}
+	if got := r.Seq(); got != start+5 {
+		t.Errorf("Seq: expected: %d, got: %d", start+5, got)
+	}
+	if got := r.LastChange(ctx, 1); got != start+4 {
+		t.Errorf("LastChange: expected: %d, got: %d", start+4, got)
+	}
+	if changes, _, err := r.Changes(ctx, 1, seq); err != nil || len(changes) != 0 {
+		t.Errorf("Changes since current: expected: none, got: %+v, %v", changes, err)
+	}
+	if _, _, err := r.Changes(ctx, 1, seq+1); !errors.Is(err, repository.ErrInvalidSyncToken) {
+		t.Errorf("Changes since future: expected: %v, got: %v", repository.ErrInvalidSyncToken, err)
+	}
+	if changes, _, err := r.Changes(tenant.NewContext(ctx, 3), 1, start); err != nil || len(changes) != 0 {
+		t.Errorf("Changes of tenant without events: expected: none, got: %+v, %v", changes, err)
+	}
+}
+
+func testHolidays(t *testing.T, r Repository) {
+	ctx := context.Background()
+	other := tenant.NewContext(ctx, 2)
+	if err := r.DeleteHoliday(ctx, 1, base); !errors.Is(err, repository.ErrHolidayNotFound) {
+		t.Errorf("DeleteHoliday of unknown user: expected: %v, got: %v", repository.ErrHolidayNotFound, err)
+	}
+	for _, day := range []struct {
+		ctx  context.Context
+		user uint64
+		date time.Time
+		name string
+	}{
+		{ctx, 1, base, "Birthday"},
+		{ctx, 1, base.AddDate(0, 0, 1), "Vacation"},
+		{ctx, 1, base, "Birthday party"},
+		{ctx, 2, base, "Vacation"},
+		{other, 1, base.AddDate(0, 0, 2), "Day off"},
+	} {
+		if err := r.AddHoliday(day.ctx, day.user, day.date, day.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.DeleteHoliday(ctx, 1, base.AddDate(0, 0, 1)); err != nil {
+		t.Errorf("DeleteHoliday: expected: %v, got: %v", nil, err)
+	}
+	if err := r.DeleteHoliday(ctx, 1, base.AddDate(0, 0, 2)); !errors.Is(err, repository.ErrHolidayNotFound) {
+		t.Errorf("DeleteHoliday of other tenant: expected: %v, got: %v", repository.ErrHolidayNotFound, err)
+	}
+	tests := map[string]struct {
+		ctx      context.Context
+		user     uint64
+		expected map[time.Time]string
+	}{
+		"renamed and deleted": {ctx: ctx, user: 1, expected: map[time.Time]string{base: "Birthday party"}},
+		"other user":          {ctx: ctx, user: 2, expected: map[time.Time]string{base: "Vacation"}},
+		"other tenant":        {ctx: other, user: 1, expected: map[time.Time]string{base.AddDate(0, 0, 2): "Day off"}},
+		"unknown user":        {ctx: ctx, user: 3, expected: map[time.Time]string{}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			got, err := r.Holidays(v.ctx, v.user)
+			if err != nil || !reflect.DeepEqual(got, v.expected) {
+				t.Errorf("expected: %v, got: %v %v", v.expected, got, err)
+			}
+		})
+	}
+}
+
+func testConcurrent(t *testing.T, r Repository) {
+	ctx := context.Background()
+	const workers, iterations = 8, 50
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	alive := map[uint64]uint64{}
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(userID uint64) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				date := base.AddDate(0, 0, j%7)
+				id, err := r.Create(ctx, &model.Event{UserID: userID, Title: "created", Date: date})
+				if err != nil {
+					errs <- fmt.Errorf("Create: %w", err)
+					return
+				}
+				if err := r.Update(ctx, &model.Event{ID: id, UserID: userID, Title: "updated", Date: date}); err != nil {
+					errs <- fmt.Errorf("Update: %w", err)
+					return
+				}
+				if j%2 == 0 {
+					if err := r.Delete(ctx, userID, id); err != nil {
+						errs <- fmt.Errorf("Delete: %w", err)
+						return
+					}
+					continue
+				}
+				m.Lock()
+				alive[id] = userID
+				m.Unlock()
+			}
+		}(uint64(i%3 + 1))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	got := 0
+	for userID := uint64(1); userID <= 3; userID++ {
+		events, err := r.GetForWeek(ctx, userID, base)
+		if err != nil {
+			t.Fatalf("GetForWeek: %v", err)
+		}
+		for _, e := range events {
+			if alive[e.ID] != userID || e.Title != "updated" {
+				t.Errorf("unexpected event %+v", e)
+			}
+		}
+		got += len(events)
+	}
+	if got != len(alive) {
+		t.Errorf("expected: %d events, got: %d", len(alive), got)
+	}
+}
+
+// testModel runs random sequence of operations against repository and reference implementation
+// and compares their results after every operation
+func testModel(t *testing.T, r Repository) {
+	ctx := context.Background()
+	seed := time.Now().UnixNano()
+	rnd := rand.New(rand.NewSource(seed))
+	ref := newReference()
+	ids := []uint64{}
+	randomID := func() uint64 {
+		if len(ids) == 0 || rnd.Intn(5) == 0 {
+			return rnd.Uint64()
+		}
+		return ids[rnd.Intn(len(ids))]
+	}
+	randomEvent := func() *model.Event {
+		return &model.Event{
+			UserID: uint64(rnd.Intn(3) + 1),
+			Title:  fmt.Sprintf("event %d", rnd.Intn(1000)),
+			Date:   base.AddDate(0, 0, rnd.Intn(70)-20),
+		}
+	}
+
+	for i := 0; i < Operations; i++ {
+		var op string
+		var got, expected error
+		switch rnd.Intn(8) {
+		case 0, 1:
+			e := randomEvent()
+			op = fmt.Sprintf("Create(%+v)", *e)
+			var id uint64
+			id, got = r.Create(ctx, e)
+			if got == nil {
+				ids = append(ids, id)
+				expected = ref.insert(&model.Event{ID: id, UserID: e.UserID, Title: e.Title, Date: e.Date})
+			}
+		case 2:
+			e := randomEvent()
+			e.ID = randomID()
+			op = fmt.Sprintf("Update(%+v)", *e)
+			got = r.Update(ctx, e)
+			expected = ref.update(&model.Event{ID: e.ID, UserID: e.UserID, Title: e.Title, Date: e.Date})
+		case 3:
+			userID, id := uint64(rnd.Intn(3)+1), randomID()
+			op = fmt.Sprintf("Delete(%d, %d)", userID, id)
+			got = r.Delete(ctx, userID, id)
+			expected = ref.delete(userID, id)
+		case 4:
+			userID, id := uint64(rnd.Intn(4)+1), randomID()
+			op = fmt.Sprintf("Get(%d, %d)", userID, id)
+			e, err := r.Get(ctx, userID, id)
+			refEvent, refErr := ref.getOne(userID, id)
+			if !sameError(err, refErr) {
+				t.Fatalf("seed %d, operation %d: %s: expected error: %v, got: %v", seed, i, op, refErr, err)
+			}
+			if err == nil && !equalEvents([]*model.Event{e}, []*model.Event{refEvent}) {
+				t.Fatalf("seed %d, operation %d: %s: expected: %+v, got: %+v", seed, i, op, *refEvent, *e)
+			}
+			userID = uint64(rnd.Intn(4) + 1)
+			op = fmt.Sprintf("GetAll(%d)", userID)
+			events, err := r.GetAll(ctx, userID)
+			refEvents, refErr := ref.get("GetAll", userID, time.Time{})
+			if !sameError(err, refErr) {
+				t.Fatalf("seed %d, operation %d: %s: expected error: %v, got: %v", seed, i, op, refErr, err)
+			}
+			if !equalEvents(events, refEvents) {
+				t.Fatalf("seed %d, operation %d: %s: expected: %v, got: %v", seed, i, op, titlesOf(refEvents), titlesOf(events))
+			}
+			continue
+		case 5:
+			date := base.AddDate(0, 0, rnd.Intn(70)-20)
+			op = fmt.Sprintf("GetBefore(%s)", date.Format("2006-01-02"))
+			events, err := r.GetBefore(ctx, date)
+			refEvents := ref.before(date)
+			if err != nil {
+				t.Fatalf("seed %d, operation %d: %s: %v", seed, i, op, err)
+			}
+			if !equalEvents(events, refEvents) {
+				t.Fatalf("seed %d, operation %d: %s: expected: %v, got: %v", seed, i, op, titlesOf(refEvents), titlesOf(events))
+			}
+			continue
+		default:
+			userID, date := uint64(rnd.Intn(4)+1), base.AddDate(0, 0, rnd.Intn(60)-20)
+			for name, get := range map[string]func(ctx context.Context, userID uint64, t time.Time) ([]*model.Event, error){
+				"GetForDay":   r.GetForDay,
+				"GetForWeek":  r.GetForWeek,
+				"GetForMonth": r.GetForMonth,
+			} {
+				op = fmt.Sprintf("%s(%d, %s)", name, userID, date.Format("2006-01-02"))
+				events, err := get(ctx, userID, date)
+				refEvents, refErr := ref.get(name, userID, date)
+				if !sameError(err, refErr) {
+					t.Fatalf("seed %d, operation %d: %s: expected error: %v, got: %v", seed, i, op, refErr, err)
+				}
+				if !equalEvents(events, refEvents) {
+					t.Fatalf("seed %d, operation %d: %s: expected: %v, got: %v", seed, i, op, titlesOf(refEvents), titlesOf(events))
+				}
+			}
+			continue
+		}
+		if !sameError(got, expected) {
+			t.Fatalf("seed %d, operation %d: %s: expected error: %v, got: %v", seed, i, op, expected, got)
+		}
+	}
+}
+
+func sameError(got, expected error) bool {
+	if expected == nil {
+		return got == nil
+	}
+	return errors.Is(got, expected)
+}
+
+func idsOf(events []*model.Event) []uint64 {
+	ids := make([]uint64, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func titlesOf(events []*model.Event) []string {
+	titles := []string{}
+	for _, e := range events {
+		titles = append(titles, e.Title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func equalIDs(a, b []uint64) bool {
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalEvents(a, b []*model.Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byID := map[uint64]*model.Event{}
+	for _, e := range b {
+		byID[e.ID] = e
+	}
+	for _, e := range a {
+		other, ok := byID[e.ID]
+		if !ok || other.UserID != e.UserID || other.Title != e.Title || !other.Date.Equal(e.Date) {
+			return false
+		}
+	}
+	return true
+}
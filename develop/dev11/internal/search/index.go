@@ -0,0 +1,168 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Weights of matches in ranking
+const (
+	TitleWeight       = 2.0
+	DescriptionWeight = 1.0
+	PrefixWeight      = 0.5
+)
+
+// Hit is a document found by Index with its relevance score
+type Hit struct {
+	ID    uint64
+	Score float64
+}
+
+// posting contains frequencies of a word in fields of a document
+type posting struct {
+	title, description int
+}
+
+func (p *posting) weight() float64 {
+	return TitleWeight*float64(p.title) + DescriptionWeight*float64(p.description)
+}
+
+// userIndex is an inverted index of documents of a single user
+type userIndex struct {
+	docs  map[uint64][]string
+	stems map[string]map[uint64]*posting
+	words map[string]map[uint64]*posting
+}
+
+// Index is an inverted index of titles and descriptions of documents grouped by user.
+// Index is not safe for concurrent use, its owner must synchronize access to it.
+type Index struct {
+	users map[uint64]*userIndex
+}
+
+// NewIndex creates an empty Index and returns pointer to it
+func NewIndex() *Index {
+	return &Index{users: map[uint64]*userIndex{}}
+}
+
+// Add indexes a document of user replacing a previously indexed document with the same id
+func (idx *Index) Add(userID, id uint64, title, description string) {
+	idx.Remove(userID, id)
+	u, ok := idx.users[userID]
+	if !ok {
+		u = &userIndex{docs: map[uint64][]string{}, stems: map[string]map[uint64]*posting{}, words: map[string]map[uint64]*posting{}}
+		idx.users[userID] = u
+	}
+	words := []string{}
+	add := func(text string, title bool) {
+		for _, t := range Tokenize(text) {
+			for _, p := range []*posting{getPosting(u.stems, Stem(t.Word), id), getPosting(u.words, t.Word, id)} {
+				if title {
+					p.title++
+				} else {
+					p.description++
+				}
+			}
+			words = append(words, t.Word)
+		}
+	}
+	add(title, true)
+	add(description, false)
+	u.docs[id] = words
+}
+
+// Remove drops a document of user from index
+func (idx *Index) Remove(userID, id uint64) {
+	u, ok := idx.users[userID]
+	if !ok {
+		return
+	}
+	for _, word := range u.docs[id] {
+		removePosting(u.stems, Stem(word), id)
+		removePosting(u.words, word, id)
+	}
+	delete(u.docs, id)
+	if len(u.docs) == 0 {
+		delete(idx.users, userID)
+	}
+}
+
+// Search returns documents of user matching every term of query ordered by relevance.
+// A term matches a word with the same stem or a word starting with the term.
+func (idx *Index) Search(userID uint64, q Query) []Hit {
+	u, ok := idx.users[userID]
+	if !ok || len(q) == 0 {
+		return []Hit{}
+	}
+	var scores map[uint64]float64
+	for _, t := range q {
+		termScores := u.match(t)
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id := range scores {
+			if s, ok := termScores[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// match returns scores of documents matching a term
+func (u *userIndex) match(t Term) map[uint64]float64 {
+	scores := map[uint64]float64{}
+	n := float64(len(u.docs))
+	score := func(postings map[uint64]*posting, weight float64) {
+		idf := math.Log(1 + n/float64(len(postings)))
+		for id, p := range postings {
+			if s := weight * idf * p.weight(); s > scores[id] {
+				scores[id] = s
+			}
+		}
+	}
+	if postings, ok := u.stems[t.Stem]; ok {
+		score(postings, 1)
+	}
+	if !isShortWord(t.Word) {
+		for word, postings := range u.words {
+			if word != t.Word && strings.HasPrefix(word, t.Word) {
+				score(postings, PrefixWeight)
+			}
+		}
+	}
+	return scores
+}
+
+func getPosting(postings map[string]map[uint64]*posting, key string, id uint64) *posting {
+	if _, ok := postings[key]; !ok {
+		postings[key] = map[uint64]*posting{}
+	}
+	p, ok := postings[key][id]
+	if !ok {
+		p = &posting{}
+		postings[key][id] = p
+	}
+	return p
+}
+
+func removePosting(postings map[string]map[uint64]*posting, key string, id uint64) {
+	delete(postings[key], id)
+	if len(postings[key]) == 0 {
+		delete(postings, key)
+	}
+}
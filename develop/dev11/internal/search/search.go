@@ -0,0 +1,134 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Markers which surround matched words in highlighted text
+const (
+	MarkStart = "<mark>"
+	MarkEnd   = "</mark>"
+)
+
+// Token is a folded word of text with its byte offsets in the text
+type Token struct {
+	Word       string
+	Start, End int
+}
+
+// Fold converts word to the form used in index: lower case with ё replaced by е
+func Fold(word string) string {
+	return strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+}
+
+// Tokenize splits text into folded words consisting of letters and digits
+func Tokenize(text string) []Token {
+	tokens := []Token{}
+	start := -1
+	for i, r := range text {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWordRune && start < 0:
+			start = i
+		case !isWordRune && start >= 0:
+			tokens = append(tokens, Token{Word: Fold(text[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, Token{Word: Fold(text[start:]), Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+// Term is a word of search query
+type Term struct {
+	Word string
+	Stem string
+}
+
+// Query is a parsed search query
+type Query []Term
+
+// ParseQuery splits query into terms
+func ParseQuery(q string) Query {
+	query := Query{}
+	for _, t := range Tokenize(q) {
+		query = append(query, Term{Word: t.Word, Stem: Stem(t.Word)})
+	}
+	return query
+}
+
+// Matches reports whether folded word matches any term of query either by stem or by prefix
+func (q Query) Matches(word string) bool {
+	stem := Stem(word)
+	for _, t := range q {
+		if stem == t.Stem || (!isShortWord(t.Word) && strings.HasPrefix(word, t.Word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Highlight escapes text for HTML and surrounds words matching query with MarkStart and MarkEnd
+func Highlight(text string, q Query) string {
+	return highlight(text, Tokenize(text), q)
+}
+
+// Snippet returns highlighted fragment of text around the first word matching query
+// with at most width words, or empty string if text has no matching words
+func Snippet(text string, q Query, width int) string {
+	tokens := Tokenize(text)
+	first := -1
+	for i, t := range tokens {
+		if q.Matches(t.Word) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+	from := first - width/2
+	if from < 0 {
+		from = 0
+	}
+	to := from + width
+	if to > len(tokens) {
+		to = len(tokens)
+	}
+	start, end := tokens[from].Start, tokens[to-1].End
+	snippet := highlight(text[start:end], Tokenize(text[start:end]), q)
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(tokens) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+func highlight(text string, tokens []Token, q Query) string {
+	b := strings.Builder{}
+	last := 0
+	for _, t := range tokens {
+		if !q.Matches(t.Word) {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:t.Start]))
+		b.WriteString(MarkStart)
+		b.WriteString(html.EscapeString(text[t.Start:t.End]))
+		b.WriteString(MarkEnd)
+		last = t.End
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// isShortWord reports whether word is too short to be used as a prefix
+func isShortWord(word string) bool {
+	return utf8.RuneCountInString(word) < 2
+}
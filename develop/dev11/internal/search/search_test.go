@@ -0,0 +1,82 @@
+package search
+
+import "testing"
+
+func TestStem(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		output string
+	}{
+		"russian noun":            {input: "встречи", output: "встреч"},
+		"russian noun plural":     {input: "встречами", output: "встреч"},
+		"russian adjective":       {input: "ежедневная", output: "ежедневн"},
+		"russian verb":            {input: "обсуждали", output: "обсужда"},
+		"russian reflexive verb":  {input: "встречаемся", output: "встреча"},
+		"russian perfective":      {input: "прочитав", output: "прочита"},
+		"english plural":          {input: "meetings", output: "meet"},
+		"english past":            {input: "planned", output: "plan"},
+		"english derivational":    {input: "relational", output: "relat"},
+		"english ies":             {input: "cries", output: "cri"},
+		"english short":           {input: "on", output: "on"},
+		"digits are left as is":   {input: "2024", output: "2024"},
+		"english generalization":  {input: "generalizations", output: "general"},
+		"english ing with vowel":  {input: "hopping", output: "hop"},
+		"english ing needs vowel": {input: "sing", output: "sing"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if stem := Stem(v.input); stem != v.output {
+				t.Errorf("expected: %s, got: %s", v.output, stem)
+			}
+		})
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(1, 1, "Стендап команды", "Ежедневная встреча")
+	idx.Add(1, 2, "Встреча с заказчиком", "Обсуждаем встречи и планы")
+	idx.Add(1, 3, "Planning meeting", "Quarterly plans")
+	idx.Add(2, 4, "Встреча", "")
+
+	tests := map[string]struct {
+		query string
+		ids   []uint64
+	}{
+		"stemmed russian ranked by frequency": {query: "встречи", ids: []uint64{2, 1}},
+		"case folding":                        {query: "СТЕНДАП", ids: []uint64{1}},
+		"prefix":                              {query: "заказ", ids: []uint64{2}},
+		"english stem":                        {query: "plan", ids: []uint64{3}},
+		"every term must match":               {query: "встреча заказчиком", ids: []uint64{2}},
+		"no match":                            {query: "отпуск", ids: []uint64{}},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			hits := idx.Search(1, ParseQuery(v.query))
+			if len(hits) != len(v.ids) {
+				t.Fatalf("expected: %v, got: %v", v.ids, hits)
+			}
+			for i, id := range v.ids {
+				if hits[i].ID != id {
+					t.Errorf("expected: %v, got: %v", v.ids, hits)
+				}
+			}
+		})
+	}
+
+	idx.Remove(1, 2)
+	if hits := idx.Search(1, ParseQuery("заказчик")); len(hits) != 0 {
+		t.Errorf("expected: no hits after remove, got: %v", hits)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	q := ParseQuery("встреча")
+	if s := Highlight("Встречи <команды>", q); s != "<mark>Встречи</mark> &lt;команды&gt;" {
+		t.Errorf("unexpected highlight: %s", s)
+	}
+	s := Snippet("один два три четыре встреча пять шесть семь восемь", q, 4)
+	if s != "…три четыре <mark>встреча</mark> пять…" {
+		t.Errorf("unexpected snippet: %s", s)
+	}
+}
@@ -0,0 +1,301 @@
+package search
+
+import "strings"
+
+// Stem reduces a folded word to its stem.
+// Russian words are stemmed with Snowball russian algorithm, words in latin script with Porter2 english algorithm,
+// other words are returned as is.
+func Stem(word string) string {
+	for _, r := range word {
+		switch {
+		case r >= 'а' && r <= 'я':
+			return stemRussian(word)
+		case r >= 'a' && r <= 'z':
+			return stemEnglish(word)
+		}
+	}
+	return word
+}
+
+// longestSuffix returns the longest suffix from list which word ends with and which starts not before position from
+func longestSuffix(word []rune, from int, suffixes []string) string {
+	best, bestLen := "", 0
+	for _, s := range suffixes {
+		n := len([]rune(s))
+		if n > bestLen && len(word)-n >= from && strings.HasSuffix(string(word), s) {
+			best, bestLen = s, n
+		}
+	}
+	return best
+}
+
+func trim(word []rune, suffix string) []rune {
+	return word[:len(word)-len([]rune(suffix))]
+}
+
+// Russian stemmer (https://snowballstem.org/algorithms/russian/stemmer.html)
+
+var (
+	ruPerfectiveGerund1 = []string{"в", "вши", "вшись"}
+	ruPerfectiveGerund2 = []string{"ив", "ивши", "ившись", "ыв", "ывши", "ывшись"}
+	ruAdjective         = []string{"ее", "ие", "ые", "ое", "ими", "ыми", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом",
+		"его", "ого", "ему", "ому", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею"}
+	ruParticiple1 = []string{"ем", "нн", "вш", "ющ", "щ"}
+	ruParticiple2 = []string{"ивш", "ывш", "ующ"}
+	ruReflexive   = []string{"ся", "сь"}
+	ruVerb1       = []string{"ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно"}
+	ruVerb2       = []string{"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен",
+		"ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю"}
+	ruNoun = []string{"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й",
+		"иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я"}
+	ruSuperlative   = []string{"ейш", "ейше"}
+	ruDerivational  = []string{"ост", "ость"}
+	ruVowels        = "аеиоуыэюя"
+	ruGroup1Prefix  = "ая"
+	enVowels        = "aeiouy"
+	enDoubles       = []string{"bb", "dd", "ff", "gg", "mm", "nn", "pp", "rr", "tt"}
+	enLiEndings     = "cdeghkmnrt"
+	enR1Exceptions  = []string{"gener", "commun", "arsen"}
+	enStep2Suffixes = map[string]string{
+		"tional": "tion", "enci": "ence", "anci": "ance", "abli": "able", "entli": "ent", "izer": "ize",
+		"ization": "ize", "ational": "ate", "ation": "ate", "ator": "ate", "alism": "al", "aliti": "al",
+		"alli": "al", "fulness": "ful", "ousli": "ous", "ousness": "ous", "iveness": "ive", "iviti": "ive",
+		"biliti": "ble", "bli": "ble", "fulli": "ful", "lessli": "less",
+	}
+	enStep3Suffixes = map[string]string{
+		"tional": "tion", "ational": "ate", "alize": "al", "icate": "ic", "iciti": "ic", "ical": "ic",
+		"ful": "", "ness": "",
+	}
+	enStep4Suffixes = []string{"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment", "ent",
+		"ism", "ate", "iti", "ous", "ive", "ize", "ion"}
+)
+
+// regions returns start positions of RV, R1 and R2 regions of word
+func regions(word []rune, vowels string) (rv, r1, r2 int) {
+	rv, r1, r2 = len(word), len(word), len(word)
+	for i, r := range word {
+		if strings.ContainsRune(vowels, r) {
+			rv = i + 1
+			break
+		}
+	}
+	r1 = nextRegion(word, 0, vowels)
+	r2 = nextRegion(word, r1, vowels)
+	return
+}
+
+// nextRegion returns position after the first non-vowel following a vowel starting from position from
+func nextRegion(word []rune, from int, vowels string) int {
+	for i := from + 1; i < len(word); i++ {
+		if !strings.ContainsRune(vowels, word[i]) && strings.ContainsRune(vowels, word[i-1]) {
+			return i + 1
+		}
+	}
+	return len(word)
+}
+
+// removeGrouped removes the longest ending from group1 preceded by а or я, or from group2 if it's longer
+func removeGrouped(word []rune, from int, group1, group2 []string) ([]rune, bool) {
+	s1 := longestSuffix(word, from, group1)
+	if s1 != "" {
+		pos := len(word) - len([]rune(s1)) - 1
+		if pos < from || !strings.ContainsRune(ruGroup1Prefix, word[pos]) {
+			s1 = ""
+		}
+	}
+	s2 := longestSuffix(word, from, group2)
+	switch {
+	case s1 == "" && s2 == "":
+		return word, false
+	case len([]rune(s2)) >= len([]rune(s1)):
+		return trim(word, s2), true
+	default:
+		return trim(word, s1), true
+	}
+}
+
+func stemRussian(s string) string {
+	word := []rune(strings.ReplaceAll(s, "ё", "е"))
+	rv, _, r2 := regions(word, ruVowels)
+
+	// Step 1
+	if w, ok := removeGrouped(word, rv, ruPerfectiveGerund1, ruPerfectiveGerund2); ok {
+		word = w
+	} else {
+		if suffix := longestSuffix(word, rv, ruReflexive); suffix != "" {
+			word = trim(word, suffix)
+		}
+		if suffix := longestSuffix(word, rv, ruAdjective); suffix != "" {
+			word = trim(word, suffix)
+			word, _ = removeGrouped(word, rv, ruParticiple1, ruParticiple2)
+		} else if w, ok := removeGrouped(word, rv, ruVerb1, ruVerb2); ok {
+			word = w
+		} else if suffix := longestSuffix(word, rv, ruNoun); suffix != "" {
+			word = trim(word, suffix)
+		}
+	}
+
+	// Step 2
+	if len(word) > rv && word[len(word)-1] == 'и' {
+		word = word[:len(word)-1]
+	}
+
+	// Step 3
+	if suffix := longestSuffix(word, r2, ruDerivational); suffix != "" {
+		word = trim(word, suffix)
+	}
+
+	// Step 4
+	switch {
+	case strings.HasSuffix(string(word), "нн") && len(word)-2 >= rv:
+		word = word[:len(word)-1]
+	case longestSuffix(word, rv, ruSuperlative) != "":
+		word = trim(word, longestSuffix(word, rv, ruSuperlative))
+		if strings.HasSuffix(string(word), "нн") && len(word)-2 >= rv {
+			word = word[:len(word)-1]
+		}
+	case len(word) > rv && word[len(word)-1] == 'ь':
+		word = word[:len(word)-1]
+	}
+	return string(word)
+}
+
+// English stemmer (https://snowballstem.org/algorithms/english/stemmer.html)
+
+func isEnVowel(r rune) bool {
+	return strings.ContainsRune(enVowels, r)
+}
+
+// isShortSyllable reports whether word ends with a short syllable
+func isShortSyllable(word []rune) bool {
+	n := len(word)
+	if n == 2 {
+		return isEnVowel(word[0]) && !isEnVowel(word[1])
+	}
+	if n < 3 {
+		return false
+	}
+	a, b, c := word[n-3], word[n-2], word[n-1]
+	return !isEnVowel(a) && isEnVowel(b) && !isEnVowel(c) && c != 'w' && c != 'x' && c != 'Y'
+}
+
+func containsVowel(word []rune) bool {
+	for _, r := range word {
+		if isEnVowel(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func stemEnglish(s string) string {
+	if len(s) <= 2 {
+		return s
+	}
+	word := []rune(strings.TrimPrefix(s, "'"))
+	if len(word) > 0 && word[0] == 'y' {
+		word[0] = 'Y'
+	}
+	for i := 1; i < len(word); i++ {
+		if word[i] == 'y' && isEnVowel(word[i-1]) {
+			word[i] = 'Y'
+		}
+	}
+
+	_, r1, r2 := regions(word, enVowels)
+	for _, prefix := range enR1Exceptions {
+		if strings.HasPrefix(string(word), prefix) {
+			r1 = len(prefix)
+			r2 = nextRegion(word, r1, enVowels)
+		}
+	}
+
+	// Step 0
+	if suffix := longestSuffix(word, 0, []string{"'", "'s", "'s'"}); suffix != "" {
+		word = trim(word, suffix)
+	}
+
+	// Step 1a
+	switch suffix := longestSuffix(word, 0, []string{"sses", "ied", "ies", "us", "ss", "s"}); suffix {
+	case "sses":
+		word = trim(word, "es")
+	case "ied", "ies":
+		if len(word) > 4 {
+			word = append(trim(word, suffix), 'i')
+		} else {
+			word = append(trim(word, suffix), 'i', 'e')
+		}
+	case "s":
+		if len(word) > 2 && containsVowel(word[:len(word)-2]) {
+			word = word[:len(word)-1]
+		}
+	}
+
+	// Step 1b
+	switch suffix := longestSuffix(word, 0, []string{"eed", "eedly", "ed", "edly", "ing", "ingly"}); suffix {
+	case "eed", "eedly":
+		if len(word)-len(suffix) >= r1 {
+			word = append(trim(word, suffix), 'e', 'e')
+		}
+	case "ed", "edly", "ing", "ingly":
+		stem := trim(word, suffix)
+		if containsVowel(stem) {
+			word = stem
+			switch {
+			case longestSuffix(word, 0, []string{"at", "bl", "iz"}) != "":
+				word = append(word, 'e')
+			case longestSuffix(word, 0, enDoubles) != "":
+				word = word[:len(word)-1]
+			case r1 >= len(word) && isShortSyllable(word):
+				word = append(word, 'e')
+			}
+		}
+	}
+
+	// Step 1c
+	if n := len(word); n > 2 && (word[n-1] == 'y' || word[n-1] == 'Y') && !isEnVowel(word[n-2]) {
+		word[n-1] = 'i'
+	}
+
+	// Step 2
+	if suffix := longestSuffix(word, r1, mapKeys(enStep2Suffixes)); suffix != "" {
+		word = append(trim(word, suffix), []rune(enStep2Suffixes[suffix])...)
+	} else if suffix := longestSuffix(word, r1, []string{"ogi", "li"}); suffix == "ogi" && len(word) > 3 && word[len(word)-4] == 'l' {
+		word = trim(word, "i")
+	} else if suffix == "li" && len(word) > 2 && strings.ContainsRune(enLiEndings, word[len(word)-3]) {
+		word = trim(word, "li")
+	}
+
+	// Step 3
+	if suffix := longestSuffix(word, r1, mapKeys(enStep3Suffixes)); suffix != "" {
+		word = append(trim(word, suffix), []rune(enStep3Suffixes[suffix])...)
+	} else if longestSuffix(word, r2, []string{"ative"}) != "" {
+		word = trim(word, "ative")
+	}
+
+	// Step 4
+	if suffix := longestSuffix(word, r2, enStep4Suffixes); suffix != "" {
+		stem := trim(word, suffix)
+		if suffix != "ion" || (len(stem) > 0 && (stem[len(stem)-1] == 's' || stem[len(stem)-1] == 't')) {
+			word = stem
+		}
+	}
+
+	// Step 5
+	if n := len(word); n > 0 && word[n-1] == 'e' {
+		if n-1 >= r2 || (n-1 >= r1 && !isShortSyllable(word[:n-1])) {
+			word = word[:n-1]
+		}
+	} else if n > 1 && word[n-1] == 'l' && word[n-2] == 'l' && n-1 >= r2 {
+		word = word[:n-1]
+	}
+	return strings.ToLower(string(word))
+}
+
+func mapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,155 @@
+// Package tenant separates data of organisations sharing the calendar.
+// Every request is served on behalf of a tenant carried by its context and storages key data by tenant and user,
+// so ids of users are scoped by tenant and no query can reach data of other tenants.
+// Requests without tenant belong to the default tenant with zero id, so deployments of a single organisation
+// work without configuration.
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+type contextKey struct{}
+
+// NewContext returns copy of ctx served on behalf of tenant
+func NewContext(ctx context.Context, tenantID uint64) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// FromContext returns id of tenant of ctx, zero for the default tenant
+func FromContext(ctx context.Context) uint64 {
+	tenantID, _ := ctx.Value(contextKey{}).(uint64)
+	return tenantID
+}
+
+// Key identifies data of a user, users of different tenants may have equal ids
+type Key struct {
+	TenantID uint64
+	UserID   uint64
+}
+
+// UserKey returns Key of user of tenant of ctx
+func UserKey(ctx context.Context, userID uint64) Key {
+	return Key{TenantID: FromContext(ctx), UserID: userID}
+}
+
+// Role of user in Organisation
+type Role string
+
+// Roles of users: admins may view calendars of members of their Organisation
+const (
+	RoleMember Role = "member"
+	RoleAdmin  Role = "admin"
+)
+
+// Organisation is a tenant owning users, Admins are members too
+type Organisation struct {
+	ID      uint64   `json:"id"`
+	Name    string   `json:"name"`
+	Admins  []uint64 `json:"admins"`
+	Members []uint64 `json:"members"`
+}
+
+// Directory contains organisations loaded from JSON array in file
+type Directory struct {
+	path string
+
+	m             sync.RWMutex
+	organisations map[uint64]*Organisation
+	roles         map[Key]Role
+}
+
+// Load reads organisations from file and returns pointer to Directory
+func Load(path string) (*Directory, error) {
+	d := &Directory{path: path}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload reads file of Directory again, previous organisations are kept if file is invalid
+func (d *Directory) Reload() error {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	var list []*Organisation
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", d.path, err)
+	}
+	organisations := map[uint64]*Organisation{}
+	roles := map[Key]Role{}
+	for _, o := range list {
+		if o.ID == 0 {
+			return fmt.Errorf("%s: invalid id of organisation %q", d.path, o.Name)
+		}
+		if _, ok := organisations[o.ID]; ok {
+			return fmt.Errorf("%s: duplicate organisation %d", d.path, o.ID)
+		}
+		organisations[o.ID] = o
+		for _, userID := range o.Members {
+			roles[Key{TenantID: o.ID, UserID: userID}] = RoleMember
+		}
+		for _, userID := range o.Admins {
+			roles[Key{TenantID: o.ID, UserID: userID}] = RoleAdmin
+		}
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.organisations = organisations
+	d.roles = roles
+	return nil
+}
+
+// Organisation returns copy of Organisation by its id
+func (d *Directory) Organisation(tenantID uint64) (Organisation, bool) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	o, ok := d.organisations[tenantID]
+	if !ok {
+		return Organisation{}, false
+	}
+	return *o, true
+}
+
+// Role returns Role of user in Organisation, false is returned for users who are not its members
+func (d *Directory) Role(tenantID, userID uint64) (Role, bool) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	role, ok := d.roles[Key{TenantID: tenantID, UserID: userID}]
+	return role, ok
+}
+
+// Members returns ids of all members of Organisation including its admins
+func (d *Directory) Members(tenantID uint64) []uint64 {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	members := []uint64{}
+	for key := range d.roles {
+		if key.TenantID == tenantID {
+			members = append(members, key.UserID)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+	return members
+}
+
+// CanView reports whether user of tenant may view calendar of other user of the same tenant:
+// users view their own calendars and admins view calendars of members of their Organisation
+func (d *Directory) CanView(tenantID, userID, otherID uint64) bool {
+	if userID == otherID {
+		return true
+	}
+	if d == nil {
+		return false
+	}
+	role, _ := d.Role(tenantID, userID)
+	_, member := d.Role(tenantID, otherID)
+	return role == RoleAdmin && member
+}
@@ -0,0 +1,69 @@
+package tenant
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	data := `[{"id": 1, "name": "Acme", "admins": [1], "members": [2, 3]}, {"id": 2, "name": "Globex", "members": [1]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		tenantID, userID, otherID uint64
+		expected                  bool
+	}{
+		"own calendar":              {tenantID: 5, userID: 4, otherID: 4, expected: true},
+		"admin views member":        {tenantID: 1, userID: 1, otherID: 2, expected: true},
+		"member views admin":        {tenantID: 1, userID: 2, otherID: 1},
+		"member views member":       {tenantID: 1, userID: 2, otherID: 3},
+		"admin views non member":    {tenantID: 1, userID: 1, otherID: 4},
+		"admin of other tenant":     {tenantID: 2, userID: 1, otherID: 2},
+		"unknown tenant":            {tenantID: 3, userID: 1, otherID: 2},
+		"default tenant has no org": {tenantID: 0, userID: 1, otherID: 2},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if got := d.CanView(v.tenantID, v.userID, v.otherID); got != v.expected {
+				t.Errorf("expected: %v, got: %v", v.expected, got)
+			}
+		})
+	}
+	if members := d.Members(1); !reflect.DeepEqual(members, []uint64{1, 2, 3}) {
+		t.Errorf("expected: %v, got: %v", []uint64{1, 2, 3}, members)
+	}
+	if role, ok := d.Role(2, 1); !ok || role != RoleMember {
+		t.Errorf("expected: %v, got: %v", RoleMember, role)
+	}
+
+	invalid := map[string]string{
+		"syntax":    `[{"id": 1`,
+		"zero id":   `[{"name": "Nameless"}]`,
+		"duplicate": `[{"id": 1, "name": "Acme"}, {"id": 1, "name": "Acme"}]`,
+	}
+	for k, v := range invalid {
+		t.Run(k, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(v), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Reload(); err == nil {
+				t.Errorf("expected: error, got: %v", err)
+			}
+			if o, ok := d.Organisation(1); !ok || o.Name != "Acme" {
+				t.Errorf("expected: %v, got: %v", "Acme", o.Name)
+			}
+		})
+	}
+	var nilDirectory *Directory
+	if nilDirectory.CanView(1, 1, 2) {
+		t.Errorf("expected: %v, got: %v", false, true)
+	}
+}
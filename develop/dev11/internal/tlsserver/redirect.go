@@ -0,0 +1,28 @@
+package tlsserver
+
+import (
+	"net"
+	"net/http"
+)
+
+// Redirect returns http.Handler redirecting requests to the same host and path on HTTPS port httpsPort.
+// Port is omitted from URL if it is the default 443. Status 308 keeps method and body of redirected requests.
+func Redirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			// IPv6 address needs brackets without port too
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
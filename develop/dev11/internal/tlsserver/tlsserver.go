@@ -0,0 +1,108 @@
+// Package tlsserver provides TLS configuration of the server whose certificate, key and
+// certificate authorities of clients can be reloaded from files without restart.
+package tlsserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrNoClientCA is returned when client certificates are verified without certificate authorities
+var ErrNoClientCA = errors.New("client certificate authorities are not provided")
+
+// ParseClientAuth returns policy of client certificates by its name:
+// "none" doesn't request certificates, "optional" verifies certificates sent by clients
+// and "require" rejects clients without valid certificate
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown client auth %q", s)
+}
+
+// Reloader keeps certificate of the server and pool of client certificate authorities loaded from files
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	m         sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewReloader loads certificate and key of the server and optional PEM bundle of client certificate authorities
+// and returns pointer to Reloader
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads files again, previous certificates are kept if any of files is invalid.
+// Connections established before are not affected.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+		data, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s: no certificates found", r.clientCAFile)
+		}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.cert, r.clientCAs = &cert, pool
+	return nil
+}
+
+// Certificate returns current certificate of the server
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.cert
+}
+
+// Config returns TLS configuration using current certificates of Reloader on every handshake
+func (r *Reloader) Config(clientAuth tls.ClientAuthType) (*tls.Config, error) {
+	if clientAuth >= tls.VerifyClientCertIfGiven && r.clientCAFile == "" {
+		return nil, ErrNoClientCA
+	}
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		// pool of client certificate authorities is a field of Config, so it is replaced with the whole Config
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.m.RLock()
+			defer r.m.RUnlock()
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+				ClientAuth:     clientAuth,
+				ClientCAs:      r.clientCAs,
+				NextProtos:     []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}, nil
+}
@@ -0,0 +1,321 @@
+package tlsserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// authority is a certificate authority generated for a test
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newAuthority(t *testing.T, name string) *authority {
+	t.Helper()
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	certPEM, key := issue(t, template, nil, nil)
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authority{cert: cert, key: key, pem: certPEM}
+}
+
+// server returns PEM encoded certificate and key of server for 127.0.0.1 with common name
+func (a *authority) server(t *testing.T, name string) ([]byte, []byte) {
+	t.Helper()
+	certPEM, key := issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, a.cert, a.key)
+	return certPEM, encodeKey(t, key)
+}
+
+// client returns certificate of client with subject
+func (a *authority) client(t *testing.T, subject pkix.Name) tls.Certificate {
+	t.Helper()
+	certPEM, key := issue(t, &x509.Certificate{
+		Subject:     subject,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, a.cert, a.key)
+	cert, err := tls.X509KeyPair(certPEM, encodeKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// issue signs template with parent and its key, template is self-signed if parent is nil
+func issue(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key
+}
+
+func encodeKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// files are paths of certificate, key and client authorities of server in temporary directory
+type files struct {
+	cert, key, clientCA string
+}
+
+func newFiles(t *testing.T, ca *authority, name string, clientCA *authority) files {
+	t.Helper()
+	dir := t.TempDir()
+	f := files{cert: filepath.Join(dir, "cert.pem"), key: filepath.Join(dir, "key.pem")}
+	certPEM, keyPEM := ca.server(t, name)
+	writeFile(t, f.cert, certPEM)
+	writeFile(t, f.key, keyPEM)
+	if clientCA != nil {
+		f.clientCA = filepath.Join(dir, "client-ca.pem")
+		writeFile(t, f.clientCA, clientCA.pem)
+	}
+	return f
+}
+
+// serve starts HTTPS server with Reloader responding with subject of verified client certificate or "anonymous"
+func serve(t *testing.T, r *Reloader, clientAuth tls.ClientAuthType) *httptest.Server {
+	t.Helper()
+	config, err := r.Config(clientAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) == 0 {
+			io.WriteString(w, "anonymous")
+			return
+		}
+		io.WriteString(w, req.TLS.VerifiedChains[0][0].Subject.String())
+	}))
+	s.TLS = config
+	// rejected handshakes are expected
+	s.Config.ErrorLog = log.New(io.Discard, "", 0)
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// get requests server trusting ca with client certificates and returns common name of server and body of response
+func get(s *httptest.Server, ca *authority, certs ...tls.Certificate) (string, string, error) {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: certs},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.TLS.PeerCertificates[0].Subject.CommonName, string(body), err
+}
+
+func TestReload(t *testing.T) {
+	ca := newAuthority(t, "ca")
+	f := newFiles(t, ca, "first", nil)
+	r, err := NewReloader(f.cert, f.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := serve(t, r, tls.NoClientCert)
+	if name, _, err := get(s, ca); err != nil || name != "first" {
+		t.Fatalf("expected: first, got: %s, %v", name, err)
+	}
+
+	certPEM, keyPEM := ca.server(t, "second")
+	writeFile(t, f.cert, certPEM)
+	writeFile(t, f.key, keyPEM)
+	if name, _, err := get(s, ca); err != nil || name != "first" {
+		t.Errorf("expected: first until reload, got: %s, %v", name, err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name, _, err := get(s, ca); err != nil || name != "second" {
+		t.Errorf("expected: second, got: %s, %v", name, err)
+	}
+
+	// key doesn't match certificate, previous certificate is kept
+	otherPEM, _ := ca.server(t, "third")
+	writeFile(t, f.cert, otherPEM)
+	if err := r.Reload(); err == nil {
+		t.Error("expected: error for mismatched key, got: nil")
+	}
+	if name, _, err := get(s, ca); err != nil || name != "second" {
+		t.Errorf("expected: second, got: %s, %v", name, err)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	ca := newAuthority(t, "ca")
+	clients := newAuthority(t, "clients")
+	untrusted := newAuthority(t, "untrusted")
+	alice := pkix.Name{CommonName: "alice", Organization: []string{"Example"}}
+	tests := map[string]struct {
+		clientAuth tls.ClientAuthType
+		certs      []tls.Certificate
+		body       string
+		fails      bool
+	}{
+		"none ignores certificate":     {clientAuth: tls.NoClientCert, certs: []tls.Certificate{clients.client(t, alice)}, body: "anonymous"},
+		"optional without certificate": {clientAuth: tls.VerifyClientCertIfGiven, body: "anonymous"},
+		"optional with certificate":    {clientAuth: tls.VerifyClientCertIfGiven, certs: []tls.Certificate{clients.client(t, alice)}, body: "CN=alice,O=Example"},
+		// client doesn't offer certificate of authority not accepted by server
+		"optional untrusted":            {clientAuth: tls.VerifyClientCertIfGiven, certs: []tls.Certificate{untrusted.client(t, alice)}, body: "anonymous"},
+		"require without certificate":   {clientAuth: tls.RequireAndVerifyClientCert, fails: true},
+		"require with certificate":      {clientAuth: tls.RequireAndVerifyClientCert, certs: []tls.Certificate{clients.client(t, alice)}, body: "CN=alice,O=Example"},
+		"require untrusted certificate": {clientAuth: tls.RequireAndVerifyClientCert, certs: []tls.Certificate{untrusted.client(t, alice)}, fails: true},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			f := newFiles(t, ca, "server", clients)
+			r, err := NewReloader(f.cert, f.key, f.clientCA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := serve(t, r, v.clientAuth)
+			_, body, err := get(s, ca, v.certs...)
+			if v.fails && err == nil {
+				t.Errorf("expected: handshake error, got: %q", body)
+			}
+			if !v.fails && (err != nil || body != v.body) {
+				t.Errorf("expected: %q, got: %q, %v", v.body, body, err)
+			}
+		})
+	}
+}
+
+func TestClientCAReload(t *testing.T) {
+	ca := newAuthority(t, "ca")
+	oldClients := newAuthority(t, "old clients")
+	newClients := newAuthority(t, "new clients")
+	f := newFiles(t, ca, "server", oldClients)
+	r, err := NewReloader(f.cert, f.key, f.clientCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := serve(t, r, tls.RequireAndVerifyClientCert)
+	bob := pkix.Name{CommonName: "bob"}
+	if _, _, err := get(s, ca, newClients.client(t, bob)); err == nil {
+		t.Error("expected: client of new authority is rejected before reload, got: nil")
+	}
+	writeFile(t, f.clientCA, newClients.pem)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, body, err := get(s, ca, newClients.client(t, bob)); err != nil || body != "CN=bob" {
+		t.Errorf("expected: CN=bob, got: %q, %v", body, err)
+	}
+	if _, _, err := get(s, ca, oldClients.client(t, bob)); err == nil {
+		t.Error("expected: client of old authority is rejected after reload, got: nil")
+	}
+
+	writeFile(t, f.clientCA, []byte("not a certificate"))
+	if err := r.Reload(); err == nil {
+		t.Error("expected: error for invalid authorities, got: nil")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	ca := newAuthority(t, "ca")
+	f := newFiles(t, ca, "server", nil)
+	r, err := NewReloader(f.cert, f.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"optional", "require"} {
+		clientAuth, err := ParseClientAuth(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Config(clientAuth); err != ErrNoClientCA {
+			t.Errorf("%s: expected: %v, got: %v", s, ErrNoClientCA, err)
+		}
+	}
+	if _, err := ParseClientAuth("always"); err == nil {
+		t.Error("expected: error for unknown policy, got: nil")
+	}
+	if _, err := NewReloader(f.cert, filepath.Join(t.TempDir(), "missing.pem"), ""); err == nil {
+		t.Error("expected: error for missing key, got: nil")
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	tests := map[string]struct {
+		port     string
+		host     string
+		uri      string
+		location string
+	}{
+		"custom port":       {port: "8443", host: "calendar.example:8080", uri: "/events_for_day?user_id=1&date=2024-05-15", location: "https://calendar.example:8443/events_for_day?user_id=1&date=2024-05-15"},
+		"default port":      {port: "443", host: "calendar.example", uri: "/ui/", location: "https://calendar.example/ui/"},
+		"ipv6 custom port":  {port: "8443", host: "[::1]:8080", uri: "/", location: "https://[::1]:8443/"},
+		"ipv6 default port": {port: "443", host: "[::1]:80", uri: "/", location: "https://[::1]/"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://"+v.host+v.uri, nil)
+			w := httptest.NewRecorder()
+			Redirect(v.port).ServeHTTP(w, req)
+			if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != v.location {
+				t.Errorf("expected: %d %s, got: %d %s", http.StatusPermanentRedirect, v.location, w.Code, w.Header().Get("Location"))
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Attachment is a file attached to an Event, files with equal content share the same SHA256 hash
+type Attachment struct {
+	ID          uint64    `json:"uuid"`
+	EventID     uint64    `json:"event_id"`
+	TenantID    uint64    `json:"tenant_id,omitempty"`
+	UserID      uint64    `json:"user_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Created     time.Time `json:"created"`
+}
@@ -2,10 +2,56 @@ package model
 
 import "time"
 
-// Event is a model for events in calendar with fields id, user_id, title and date
+// Priority of an Event
+type Priority int
+
+// Priorities of events from the lowest to the highest
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityUrgent
+)
+
+// Event is a model for events in calendar with fields id, user_id, title, description, location,
+// category, color, tags, priority and date.
+// ICalUID is UID of event in iCalendar format set by CalDAV clients.
+// TenantID is set by repositories from context of request, it is zero for the default tenant.
+// Date may have time of day, then Duration is a length of event in minutes, zero Duration means no end time.
+// Event with WorkingDay repeats every month from month of Date on this working day by production calendar of Country,
+// Date is its first occurrence.
 type Event struct {
-	ID     uint64    `json:"uuid"`
-	UserID uint64    `json:"user_id"`
-	Title  string    `json:"title"`
-	Date   time.Time `json:"date"`
+	ID          uint64    `json:"uuid"`
+	ICalUID     string    `json:"ical_uid,omitempty"`
+	TenantID    uint64    `json:"tenant_id,omitempty"`
+	UserID      uint64    `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Color       string    `json:"color,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Priority    Priority  `json:"priority"`
+	Date        time.Time `json:"date"`
+	Duration    int       `json:"duration,omitempty"`
+	WorkingDay  int       `json:"working_day,omitempty"`
+	Country     string    `json:"country,omitempty"`
+}
+
+// HasTag reports whether Event is marked with given tag
+func (e *Event) HasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchResult is an Event found by full-text search with its relevance score and highlighted title and description
+type SearchResult struct {
+	Event   *Event  `json:"event"`
+	Score   float64 `json:"score"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet,omitempty"`
 }
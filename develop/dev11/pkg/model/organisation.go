@@ -0,0 +1,10 @@
+package model
+
+// Organisation is a tenant of the calendar as seen by its member with given Role.
+// Members are listed only for admins.
+type Organisation struct {
+	ID      uint64   `json:"id"`
+	Name    string   `json:"name"`
+	Role    string   `json:"role"`
+	Members []uint64 `json:"members,omitempty"`
+}
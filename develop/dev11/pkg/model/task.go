@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// Recurrence of a Task
+type Recurrence string
+
+// Recurrences of tasks, empty Recurrence means the Task is done once
+const (
+	RecurrenceNone    Recurrence = ""
+	RecurrenceDaily   Recurrence = "daily"
+	RecurrenceWeekly  Recurrence = "weekly"
+	RecurrenceMonthly Recurrence = "monthly"
+)
+
+// Next returns the date of the next occurrence after t, t is returned for RecurrenceNone
+func (r Recurrence) Next(t time.Time) time.Time {
+	switch r {
+	case RecurrenceDaily:
+		return t.AddDate(0, 0, 1)
+	case RecurrenceWeekly:
+		return t.AddDate(0, 0, 7)
+	case RecurrenceMonthly:
+		return t.AddDate(0, 1, 0)
+	}
+	return t
+}
+
+// Task is a model for to-do items in calendar with fields id, user_id, title, description, due date,
+// completion status and recurrence.
+// NextID is id of the next occurrence of recurring Task created when the Task was completed.
+type Task struct {
+	ID          uint64     `json:"uuid"`
+	UserID      uint64     `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	Due         time.Time  `json:"due"`
+	Done        bool       `json:"done"`
+	DoneAt      *time.Time `json:"done_at,omitempty"`
+	Recurrence  Recurrence `json:"recurrence,omitempty"`
+	NextID      uint64     `json:"next_id,omitempty"`
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook is a subscription of user to notifications about changes of his events sent to URL.
+// Events lists types of notifications, empty Events means all types.
+// Secret signs notifications, it is shown only when the Webhook is created.
+type Webhook struct {
+	ID       uint64    `json:"uuid"`
+	TenantID uint64    `json:"tenant_id,omitempty"`
+	UserID   uint64    `json:"user_id"`
+	URL      string    `json:"url"`
+	Events   []string  `json:"events,omitempty"`
+	Secret   string    `json:"secret,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// DeliveryStatus is a state of Delivery
+type DeliveryStatus string
+
+// Statuses of deliveries: pending deliveries wait in queue of their Webhook,
+// dead deliveries failed too many times and won't be retried
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// Delivery is a notification sent to Webhook with Payload as its body.
+// LastStatus and LastError describe the latest failed or successful attempt.
+type Delivery struct {
+	ID          uint64          `json:"uuid"`
+	WebhookID   uint64          `json:"webhook_id"`
+	Type        string          `json:"type"`
+	EventID     uint64          `json:"event_id"`
+	Status      DeliveryStatus  `json:"status"`
+	Attempts    int             `json:"attempts"`
+	Created     time.Time       `json:"created"`
+	NextAttempt *time.Time      `json:"next_attempt,omitempty"`
+	Finished    *time.Time      `json:"finished,omitempty"`
+	LastStatus  int             `json:"last_status,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+}
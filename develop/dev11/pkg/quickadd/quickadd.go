@@ -0,0 +1,685 @@
+// Package quickadd parses short phrases in Russian and English like "стендап завтра в 10:00 на 30 минут"
+// or "lunch next friday 13:00" into title, date, time of day and duration of an event.
+//
+// Supported expressions:
+//   - days: сегодня, завтра, послезавтра, вчера, today, tomorrow, (the) day after tomorrow, yesterday;
+//   - weekdays: пятница, в пятницу, в следующую пятницу, friday, on friday, next friday, this friday,
+//     where plain weekday is the nearest one starting from today and "next" weekday is the one of the next week;
+//   - dates: 2024-05-15, 15.05, 15.05.2024, 15 мая, 15 may, may 15, with optional year,
+//     dates without year are never in the past;
+//   - relative dates and times: через 3 дня, через неделю, через 2 часа, in 3 days, in a week, in 2 hours;
+//   - times: в 10, в 10:30, в 7 вечера, в 3 дня, 13:00, at 1pm, at 10, noon, полдень;
+//   - durations: на 30 минут, на час, на полтора часа, на 1 час 30 минут, for an hour, for 90 min, for 1h30m;
+//   - ranges: с 10 до 11:30, from 10am to 11am, 10:00-11:30.
+//
+// Everything else is a title of the event. Dates and times are wall clock of the location of now
+// represented in UTC like all dates of calendar.
+package quickadd
+
+import (
+	"dev11/pkg/model"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Errors of parsing
+var (
+	ErrEmpty        = errors.New("empty phrase")
+	ErrNoTitle      = errors.New("phrase has no title")
+	ErrInvalidRange = errors.New("end of time range is not after its start")
+)
+
+// Result is a parsed phrase. Date is a day of event with time of day if HasTime is true.
+type Result struct {
+	Title    string
+	Date     time.Time
+	HasTime  bool
+	Duration time.Duration
+}
+
+// Event returns Event of user described by Result
+func (r Result) Event(userID uint64) *model.Event {
+	return &model.Event{
+		UserID:   userID,
+		Title:    r.Title,
+		Date:     r.Date,
+		Duration: int(r.Duration / time.Minute),
+		Priority: model.PriorityNormal,
+	}
+}
+
+// Parse parses phrase relative to moment now.
+// Phrase without date is for today, phrase without time has no time of day.
+func Parse(phrase string, now time.Time) (Result, error) {
+	p := newParser(phrase, now)
+	if len(p.words) == 0 {
+		return Result{}, ErrEmpty
+	}
+	for p.pos < len(p.words) {
+		if p.apply() {
+			continue
+		}
+		p.title = append(p.title, p.orig[p.pos])
+		p.pos++
+	}
+	if p.err != nil {
+		return Result{}, p.err
+	}
+	title := strings.TrimFunc(strings.Join(p.title, " "), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) && r != ')' && r != '"' && r != '»'
+	})
+	if title == "" {
+		return Result{}, ErrNoTitle
+	}
+
+	r := Result{Title: title, Date: p.today}
+	if p.s.date != nil {
+		r.Date = *p.s.date
+	}
+	switch {
+	case p.s.at != nil:
+		r.Date, r.HasTime = *p.s.at, true
+	case p.s.clock != nil:
+		r.Date, r.HasTime = r.Date.Add(*p.s.clock), true
+	}
+	if p.s.duration != nil {
+		r.Duration = *p.s.duration
+	}
+	return r, nil
+}
+
+// settings are parts of Result found by a rule
+type settings struct {
+	date     *time.Time
+	clock    *time.Duration
+	at       *time.Time
+	duration *time.Duration
+}
+
+// rule matches words starting from position i and returns number of matched words and found settings
+type rule func(p *parser, i int) (int, settings)
+
+type parser struct {
+	now   time.Time
+	today time.Time
+	words []string
+	orig  []string
+	pos   int
+	title []string
+	s     settings
+	err   error
+}
+
+func newParser(phrase string, now time.Time) *parser {
+	wall := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.UTC)
+	p := &parser{now: wall, today: truncate(wall)}
+	for _, word := range strings.Fields(phrase) {
+		p.orig = append(p.orig, word)
+		p.words = append(p.words, normalize(word))
+	}
+	return p
+}
+
+// normalize lowercases word, replaces ё with е and trims punctuation around it
+func normalize(word string) string {
+	word = strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(word, "ё", "е"), "Ё", "Е"))
+	return strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) && r != '@' && r != '-' || unicode.IsSymbol(r)
+	})
+}
+
+// rules are tried in order, longer expressions go first
+var rules = []rule{matchRange, matchPrepositionTime, matchRelative, matchDuration, matchWeek, matchWeekday, matchDayWord, matchDate, matchClock}
+
+// apply applies the first rule matching at current position which doesn't set already found parts of Result
+func (p *parser) apply() bool {
+	for _, r := range rules {
+		n, s := r(p, p.pos)
+		if n == 0 || s.date != nil && (p.s.date != nil || p.s.at != nil) ||
+			(s.clock != nil || s.at != nil) && (p.s.clock != nil || p.s.at != nil) ||
+			s.duration != nil && p.s.duration != nil {
+			continue
+		}
+		if s.at != nil && p.s.date != nil {
+			continue
+		}
+		if s.date != nil {
+			p.s.date = s.date
+		}
+		if s.clock != nil {
+			p.s.clock = s.clock
+		}
+		if s.at != nil {
+			p.s.at = s.at
+		}
+		if s.duration != nil {
+			if *s.duration <= 0 {
+				p.err = ErrInvalidRange
+			}
+			p.s.duration = s.duration
+		}
+		p.pos += n
+		return true
+	}
+	return false
+}
+
+func (p *parser) word(i int) string {
+	if i < len(p.words) {
+		return p.words[i]
+	}
+	return ""
+}
+
+func (p *parser) oneOf(i int, words ...string) bool {
+	w := p.word(i)
+	for _, x := range words {
+		if w == x {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRange matches "с 10 до 11:30", "from 10am to 11am", "10:00-11:30" and "10:00 - 11:30"
+func matchRange(p *parser, i int) (int, settings) {
+	n := 0
+	if p.oneOf(i, "с", "со", "from") {
+		n = 1
+	}
+	if w := p.word(i + n); strings.Count(w, "-") == 1 && n == 0 {
+		left, right, _ := strings.Cut(w, "-")
+		start, ok1 := parseClockWord(left, false)
+		end, ok2 := parseClockWord(right, false)
+		if ok1 && ok2 {
+			return 1, rangeSettings(start, end)
+		}
+	}
+	k, start, ok := p.clock(i+n, n > 0)
+	if !ok {
+		return 0, settings{}
+	}
+	n += k
+	if !(n > 1 && p.oneOf(i+n, "до", "по", "to", "till", "until", "-") || p.oneOf(i+n, "-")) {
+		return 0, settings{}
+	}
+	n++
+	k, end, ok := p.clock(i+n, true)
+	if !ok {
+		return 0, settings{}
+	}
+	return n + k, rangeSettings(start, end)
+}
+
+func rangeSettings(start, end time.Duration) settings {
+	duration := end - start
+	return settings{clock: &start, duration: &duration}
+}
+
+// matchPrepositionTime matches time after preposition: "в 10", "в 7 вечера", "at 1pm", "@ 10:30"
+func matchPrepositionTime(p *parser, i int) (int, settings) {
+	if !p.oneOf(i, "в", "во", "at", "@", "к", "by") {
+		return 0, settings{}
+	}
+	n, clock, ok := p.clock(i+1, true)
+	if !ok {
+		return 0, settings{}
+	}
+	return n + 1, settings{clock: &clock}
+}
+
+// matchClock matches time without preposition: "13:00", "1pm", "10 утра", "noon"
+func matchClock(p *parser, i int) (int, settings) {
+	n, clock, ok := p.clock(i, false)
+	if !ok {
+		return 0, settings{}
+	}
+	return n, settings{clock: &clock}
+}
+
+// clock parses time of day at position i, bare numbers are accepted only if bare is true or with a modifier
+func (p *parser) clock(i int, bare bool) (int, time.Duration, bool) {
+	w := p.word(i)
+	switch w {
+	case "noon", "полдень", "полудня":
+		return 1, 12 * time.Hour, true
+	case "midnight", "полночь", "полуночи":
+		return 1, 0, true
+	}
+	h, m, suffix, ok := splitClock(w)
+	if !ok {
+		return 0, 0, false
+	}
+	n := 1
+	explicit := strings.Contains(w, ":") || suffix != ""
+	if suffix == "" && p.oneOf(i+n, "am", "pm", "a.m", "p.m") {
+		suffix = strings.ReplaceAll(p.word(i+n), ".", "")
+		n++
+	}
+	if suffix == "" && p.oneOf(i+n, "o'clock", "час", "часа", "часов", "ч") {
+		// "в 10 часов", but not "на 2 часа" which is matched by duration rules first
+		n++
+		explicit = explicit || bare
+	}
+	if suffix == "" && p.oneOf(i+n, "утра", "дня", "вечера", "ночи") {
+		suffix = p.word(i + n)
+		n++
+	}
+	if !explicit && suffix == "" && !bare {
+		return 0, 0, false
+	}
+	h, ok = applySuffix(h, suffix)
+	if !ok || m > 59 {
+		return 0, 0, false
+	}
+	return n, time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, true
+}
+
+// parseClockWord parses time of day written as one word like "10:30", "1pm" or "10" if bare is true
+func parseClockWord(w string, bare bool) (time.Duration, bool) {
+	h, m, suffix, ok := splitClock(w)
+	if !ok || !bare && suffix == "" && !strings.Contains(w, ":") {
+		return 0, false
+	}
+	h, ok = applySuffix(h, suffix)
+	if !ok || m > 59 {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, true
+}
+
+// splitClock splits "10:30pm" into hours, minutes and suffix
+func splitClock(w string) (h, m int, suffix string, ok bool) {
+	for _, s := range []string{"a.m", "p.m", "am", "pm"} {
+		if strings.HasSuffix(w, s) && len(w) > len(s) {
+			w, suffix = w[:len(w)-len(s)], strings.ReplaceAll(s, ".", "")
+			break
+		}
+	}
+	hours, minutes, hasMinutes := strings.Cut(w, ":")
+	if hours == "" || len(hours) > 2 || hasMinutes && len(minutes) != 2 {
+		return 0, 0, "", false
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	if hasMinutes {
+		if m, err = strconv.Atoi(minutes); err != nil {
+			return 0, 0, "", false
+		}
+	}
+	return h, m, suffix, true
+}
+
+// applySuffix converts hours with suffix like pm or вечера to 24-hour clock
+func applySuffix(h int, suffix string) (int, bool) {
+	switch suffix {
+	case "am", "утра":
+		if h < 1 || h > 12 {
+			return 0, false
+		}
+		if h == 12 {
+			h = 0
+		}
+	case "pm", "вечера":
+		if h < 1 || h > 12 {
+			return 0, false
+		}
+		if h < 12 {
+			h += 12
+		}
+	case "дня":
+		if h < 1 || h > 12 {
+			return 0, false
+		}
+		if h <= 6 {
+			h += 12
+		}
+	case "ночи":
+		if h == 12 {
+			h = 0
+		}
+		if h > 5 {
+			return 0, false
+		}
+	}
+	return h, h >= 0 && h < 24
+}
+
+// matchRelative matches "через 3 дня", "через неделю", "через 2 часа", "in 3 days", "in a week", "in half an hour"
+func matchRelative(p *parser, i int) (int, settings) {
+	if !p.oneOf(i, "через", "in") {
+		return 0, settings{}
+	}
+	n, d, ok := p.duration(i + 1)
+	if ok {
+		at := p.now.Add(d)
+		return n + 1, settings{at: &at}
+	}
+	n, count, ok := p.number(i+1, true)
+	if !ok {
+		return 0, settings{}
+	}
+	date := p.today
+	switch unit := p.word(i + 1 + n); {
+	case isUnit(unit, dayUnits):
+		date = date.AddDate(0, 0, count)
+	case isUnit(unit, weekUnits):
+		date = date.AddDate(0, 0, 7*count)
+	case isUnit(unit, monthUnits):
+		date = date.AddDate(0, count, 0)
+	default:
+		return 0, settings{}
+	}
+	return n + 2, settings{date: &date}
+}
+
+// matchDuration matches "на 30 минут", "на час", "for 2 hours", "for 1h30m"
+func matchDuration(p *parser, i int) (int, settings) {
+	if !p.oneOf(i, "на", "for") {
+		return 0, settings{}
+	}
+	n, d, ok := p.duration(i + 1)
+	if !ok {
+		return 0, settings{}
+	}
+	return n + 1, settings{duration: &d}
+}
+
+var (
+	minuteUnits = []string{"минута", "минуту", "минуты", "минут", "мин", "minute", "minutes", "min", "mins", "m", "м"}
+	hourUnits   = []string{"час", "часа", "часов", "ч", "hour", "hours", "hr", "hrs", "h"}
+	dayUnits    = []string{"день", "дня", "дней", "сутки", "суток", "day", "days"}
+	weekUnits   = []string{"неделя", "неделю", "недели", "недель", "week", "weeks"}
+	monthUnits  = []string{"месяц", "месяца", "месяцев", "month", "months"}
+)
+
+func isUnit(w string, units []string) bool {
+	for _, u := range units {
+		if w == u {
+			return true
+		}
+	}
+	return false
+}
+
+// duration parses duration in minutes and hours at position i
+func (p *parser) duration(i int) (int, time.Duration, bool) {
+	switch {
+	case p.oneOf(i, "полчаса"):
+		return 1, 30 * time.Minute, true
+	case p.oneOf(i, "полтора") && p.oneOf(i+1, "часа"):
+		return 2, 90 * time.Minute, true
+	case p.oneOf(i, "half") && p.oneOf(i+1, "an", "a") && p.oneOf(i+2, "hour"):
+		return 3, 30 * time.Minute, true
+	case p.oneOf(i, "half") && p.oneOf(i+1, "hour"):
+		return 2, 30 * time.Minute, true
+	case p.oneOf(i, "час", "hour"):
+		return 1, time.Hour, true
+	case p.oneOf(i, "an", "a") && p.oneOf(i+1, "hour"):
+		return 2, time.Hour, true
+	case p.oneOf(i, "минуту", "minute"):
+		return 1, time.Minute, true
+	}
+	if d, ok := parseCompactDuration(p.word(i)); ok {
+		return 1, d, true
+	}
+
+	var total time.Duration
+	n := 0
+	for {
+		k, value, ok := p.decimal(i + n)
+		if !ok {
+			break
+		}
+		unit := p.word(i + n + k)
+		var d time.Duration
+		switch {
+		case isUnit(unit, hourUnits):
+			d = time.Duration(value * float64(time.Hour))
+		case isUnit(unit, minuteUnits):
+			d = time.Duration(value * float64(time.Minute))
+		default:
+			return n, total, n > 0
+		}
+		total += d.Round(time.Minute)
+		n += k + 1
+		if !p.oneOf(i+n, "и", "and") {
+			continue
+		}
+		if k, _, ok := p.decimal(i + n + 1); !ok || !isUnit(p.word(i+n+1+k), minuteUnits) {
+			break
+		}
+		n++
+	}
+	return n, total, n > 0
+}
+
+// parseCompactDuration parses durations like "30m", "1h", "1h30m", "1.5h", "2ч", "30мин"
+func parseCompactDuration(w string) (time.Duration, bool) {
+	w = strings.NewReplacer("мин", "m", "ч", "h", "м", "m", ",", ".").Replace(w)
+	if w == "" || !unicode.IsDigit(rune(w[0])) || !strings.ContainsAny(w, "hm") {
+		return 0, false
+	}
+	d, err := time.ParseDuration(w)
+	if err != nil || d < time.Minute || d%time.Minute != 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+var numbers = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+	"один": 1, "одна": 1, "одну": 1, "два": 2, "две": 2, "три": 3, "четыре": 4, "пять": 5, "шесть": 6, "семь": 7,
+	"восемь": 8, "девять": 9, "десять": 10,
+}
+
+// number parses positive integer at position i, if optional is true missing number is 1
+func (p *parser) number(i int, optional bool) (int, int, bool) {
+	w := p.word(i)
+	if n, ok := numbers[w]; ok {
+		return 1, n, true
+	}
+	if n, err := strconv.Atoi(w); err == nil && n > 0 {
+		return 1, n, true
+	}
+	return 0, 1, optional
+}
+
+// decimal parses positive number like 2, 1.5 or 1,5 at position i
+func (p *parser) decimal(i int) (int, float64, bool) {
+	w := p.word(i)
+	if n, ok := numbers[w]; ok && w != "a" && w != "an" {
+		return 1, float64(n), true
+	}
+	f, err := strconv.ParseFloat(strings.Replace(w, ",", ".", 1), 64)
+	if err != nil || f <= 0 {
+		return 0, 0, false
+	}
+	return 1, f, true
+}
+
+// matchWeek matches "на следующей неделе" and "next week" as Monday of the next week
+func matchWeek(p *parser, i int) (int, settings) {
+	n := 0
+	switch {
+	case p.oneOf(i, "на") && p.oneOf(i+1, "следующей") && p.oneOf(i+2, "неделе"):
+		n = 3
+	case p.oneOf(i, "next") && p.oneOf(i+1, "week"):
+		n = 2
+	default:
+		return 0, settings{}
+	}
+	date := p.today.AddDate(0, 0, 7-weekday(p.today))
+	return n, settings{date: &date}
+}
+
+var weekdays = map[string]int{
+	"понедельник": 0, "вторник": 1, "среда": 2, "среду": 2, "четверг": 3, "пятница": 4, "пятницу": 4,
+	"суббота": 5, "субботу": 5, "воскресенье": 6,
+	"monday": 0, "tuesday": 1, "wednesday": 2, "thursday": 3, "friday": 4, "saturday": 5, "sunday": 6,
+}
+
+// matchWeekday matches "пятница", "в пятницу", "в следующую пятницу", "friday", "on friday", "next friday", "this friday"
+func matchWeekday(p *parser, i int) (int, settings) {
+	n := 0
+	if p.oneOf(i, "в", "во", "on", "на") {
+		n++
+	}
+	next, this := false, false
+	switch {
+	case p.oneOf(i+n, "следующий", "следующую", "следующее", "next"):
+		next = true
+		n++
+	case p.oneOf(i+n, "этот", "эту", "это", "this"):
+		this = true
+		n++
+	}
+	w, ok := weekdays[p.word(i+n)]
+	if !ok {
+		return 0, settings{}
+	}
+	today := weekday(p.today)
+	delta := (w - today + 7) % 7
+	switch {
+	case next:
+		delta = 7 - today + w
+	case this:
+		delta = w - today
+	}
+	date := p.today.AddDate(0, 0, delta)
+	return n + 1, settings{date: &date}
+}
+
+// matchDayWord matches "сегодня", "завтра", "послезавтра", "today", "tomorrow", "the day after tomorrow"
+func matchDayWord(p *parser, i int) (int, settings) {
+	n := 0
+	if p.oneOf(i, "на", "on") {
+		n++
+	}
+	days, k := 0, 1
+	switch {
+	case p.oneOf(i+n, "сегодня", "today"):
+	case p.oneOf(i+n, "завтра", "tomorrow"):
+		days = 1
+	case p.oneOf(i+n, "послезавтра"):
+		days = 2
+	case p.oneOf(i+n, "вчера", "yesterday"):
+		days = -1
+	case p.oneOf(i+n, "the") && p.oneOf(i+n+1, "day") && p.oneOf(i+n+2, "after") && p.oneOf(i+n+3, "tomorrow"):
+		days, k = 2, 4
+	case p.oneOf(i+n, "day") && p.oneOf(i+n+1, "after") && p.oneOf(i+n+2, "tomorrow"):
+		days, k = 2, 3
+	default:
+		return 0, settings{}
+	}
+	date := p.today.AddDate(0, 0, days)
+	return n + k, settings{date: &date}
+}
+
+var months = map[string]time.Month{
+	"января": 1, "февраля": 2, "марта": 3, "апреля": 4, "мая": 5, "июня": 6, "июля": 7, "августа": 8,
+	"сентября": 9, "октября": 10, "ноября": 11, "декабря": 12,
+	"january": 1, "february": 2, "march": 3, "april": 4, "may": 5, "june": 6, "july": 7, "august": 8,
+	"september": 9, "october": 10, "november": 11, "december": 12,
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "sept": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// matchDate matches "2024-05-15", "15.05", "15.05.2024", "15 мая", "15 may 2024", "may 15", "on may 15th"
+func matchDate(p *parser, i int) (int, settings) {
+	n := 0
+	if p.oneOf(i, "on", "на") {
+		n++
+	}
+	w := p.word(i + n)
+	if t, err := time.Parse("2006-01-02", w); err == nil {
+		return n + 1, settings{date: &t}
+	}
+	if parts := strings.Split(w, "."); len(parts) == 2 || len(parts) == 3 {
+		day, err1 := strconv.Atoi(parts[0])
+		month, err2 := strconv.Atoi(parts[1])
+		year := 0
+		var err3 error
+		if len(parts) == 3 {
+			year, err3 = strconv.Atoi(parts[2])
+		}
+		if err1 == nil && err2 == nil && err3 == nil && len(parts[0]) <= 2 && len(parts[1]) <= 2 {
+			if date, ok := p.date(year, time.Month(month), day); ok {
+				return n + 1, settings{date: &date}
+			}
+		}
+	}
+
+	var day, k int
+	var month time.Month
+	if d, ok := parseDay(w); ok {
+		if month, ok = months[p.word(i+n+1)]; !ok {
+			return 0, settings{}
+		}
+		day, k = d, 2
+	} else if m, ok := months[w]; ok {
+		if day, ok = parseDay(p.word(i + n + 1)); !ok {
+			return 0, settings{}
+		}
+		month, k = m, 2
+	} else {
+		return 0, settings{}
+	}
+	year := 0
+	if y, err := strconv.Atoi(p.word(i + n + k)); err == nil && y >= 1000 && y <= 9999 {
+		year = y
+		k++
+		if p.oneOf(i+n+k, "г", "года") {
+			k++
+		}
+	}
+	date, ok := p.date(year, month, day)
+	if !ok {
+		return 0, settings{}
+	}
+	return n + k, settings{date: &date}
+}
+
+// parseDay parses day of month like 15, 15th, 1st
+func parseDay(w string) (int, bool) {
+	for _, suffix := range []string{"st", "nd", "rd", "th", "-го", "го"} {
+		w = strings.TrimSuffix(w, suffix)
+	}
+	d, err := strconv.Atoi(w)
+	return d, err == nil && d >= 1 && d <= 31
+}
+
+// date returns date with given fields, date without year is the nearest one starting from today
+func (p *parser) date(year int, month time.Month, day int) (time.Time, bool) {
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+	explicit := year != 0
+	if year < 100 && explicit {
+		year += 2000
+	}
+	if !explicit {
+		year = p.today.Year()
+	}
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day {
+		return time.Time{}, false
+	}
+	if !explicit && date.Before(p.today) {
+		date = date.AddDate(1, 0, 0)
+	}
+	return date, true
+}
+
+// weekday returns number of day in week starting from Monday
+func weekday(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+func truncate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
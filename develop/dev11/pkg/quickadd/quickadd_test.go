@@ -0,0 +1,194 @@
+package quickadd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// now is Wednesday
+var now = time.Date(2024, time.May, 15, 9, 30, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		phrase   string
+		title    string
+		date     string
+		duration time.Duration
+		err      error
+	}{
+		// Russian
+		"ru example":                  {phrase: "стендап завтра в 10:00 на 30 минут", title: "стендап", date: "2024-05-16 10:00", duration: 30 * time.Minute},
+		"ru today":                    {phrase: "Созвон сегодня", title: "Созвон", date: "2024-05-15"},
+		"ru day after tomorrow":       {phrase: "послезавтра ревью кода", title: "ревью кода", date: "2024-05-17"},
+		"ru yesterday":                {phrase: "вчера отчёт", title: "отчёт", date: "2024-05-14"},
+		"ru for tomorrow":             {phrase: "встреча на завтра", title: "встреча", date: "2024-05-16"},
+		"ru weekday":                  {phrase: "обед в пятницу", title: "обед", date: "2024-05-17"},
+		"ru weekday vo":               {phrase: "ретро во вторник в 16", title: "ретро", date: "2024-05-21 16:00"},
+		"ru weekday today":            {phrase: "планёрка в среду", title: "планёрка", date: "2024-05-15"},
+		"ru weekday nominative":       {phrase: "суббота футбол", title: "футбол", date: "2024-05-18"},
+		"ru next weekday":             {phrase: "демо в следующую пятницу в 15:00", title: "демо", date: "2024-05-24 15:00"},
+		"ru next monday":              {phrase: "в следующий понедельник планирование", title: "планирование", date: "2024-05-20"},
+		"ru next sunday":              {phrase: "дача в следующее воскресенье", title: "дача", date: "2024-05-26"},
+		"ru this weekday":             {phrase: "в этот понедельник отчёт", title: "отчёт", date: "2024-05-13"},
+		"ru next week":                {phrase: "отпуск на следующей неделе", title: "отпуск", date: "2024-05-20"},
+		"ru in days":                  {phrase: "звонок маме через 3 дня", title: "звонок маме", date: "2024-05-18"},
+		"ru in a day word":            {phrase: "через два дня сдать отчёт", title: "сдать отчёт", date: "2024-05-17"},
+		"ru in a week":                {phrase: "через неделю отпуск", title: "отпуск", date: "2024-05-22"},
+		"ru in weeks":                 {phrase: "через 2 недели релиз", title: "релиз", date: "2024-05-29"},
+		"ru in a month":               {phrase: "через месяц ТО машины", title: "ТО машины", date: "2024-06-15"},
+		"ru in hours":                 {phrase: "через 2 часа позвонить", title: "позвонить", date: "2024-05-15 11:30"},
+		"ru in an hour":               {phrase: "через час кофе", title: "кофе", date: "2024-05-15 10:30"},
+		"ru in half an hour":          {phrase: "через полчаса созвон", title: "созвон", date: "2024-05-15 10:00"},
+		"ru in minutes":               {phrase: "через 15 минут перерыв", title: "перерыв", date: "2024-05-15 09:45"},
+		"ru bare hour":                {phrase: "встреча в 10", title: "встреча", date: "2024-05-15 10:00"},
+		"ru hour with word":           {phrase: "встреча в 10 часов", title: "встреча", date: "2024-05-15 10:00"},
+		"ru morning":                  {phrase: "пробежка завтра в 7 утра", title: "пробежка", date: "2024-05-16 07:00"},
+		"ru evening":                  {phrase: "кино в 7 вечера", title: "кино", date: "2024-05-15 19:00"},
+		"ru afternoon":                {phrase: "врач в 3 дня", title: "врач", date: "2024-05-15 15:00"},
+		"ru noon day":                 {phrase: "обед в 12 дня", title: "обед", date: "2024-05-15 12:00"},
+		"ru night":                    {phrase: "деплой в 2 ночи", title: "деплой", date: "2024-05-15 02:00"},
+		"ru midnight":                 {phrase: "новый год в полночь", title: "новый год", date: "2024-05-15 00:00"},
+		"ru noon":                     {phrase: "обед в полдень", title: "обед", date: "2024-05-15 12:00"},
+		"ru bare clock":               {phrase: "обед 13:30", title: "обед", date: "2024-05-15 13:30"},
+		"ru hour duration":            {phrase: "лекция завтра в 18:00 на час", title: "лекция", date: "2024-05-16 18:00", duration: time.Hour},
+		"ru hours duration":           {phrase: "воркшоп на 2 часа в 11", title: "воркшоп", date: "2024-05-15 11:00", duration: 2 * time.Hour},
+		"ru half an hour duration":    {phrase: "звонок на полчаса", title: "звонок", date: "2024-05-15", duration: 30 * time.Minute},
+		"ru hour and a half":          {phrase: "тренировка на полтора часа", title: "тренировка", date: "2024-05-15", duration: 90 * time.Minute},
+		"ru compound duration":        {phrase: "семинар на 1 час 30 минут", title: "семинар", date: "2024-05-15", duration: 90 * time.Minute},
+		"ru decimal duration":         {phrase: "интервью на 1,5 часа", title: "интервью", date: "2024-05-15", duration: 90 * time.Minute},
+		"ru compact duration":         {phrase: "созвон на 45мин", title: "созвон", date: "2024-05-15", duration: 45 * time.Minute},
+		"ru range":                    {phrase: "встреча с 10 до 11:30", title: "встреча", date: "2024-05-15 10:00", duration: 90 * time.Minute},
+		"ru range with clock":         {phrase: "совещание завтра с 14:00 до 15:00", title: "совещание", date: "2024-05-16 14:00", duration: time.Hour},
+		"ru date":                     {phrase: "день рождения 20 мая", title: "день рождения", date: "2024-05-20"},
+		"ru date with year":           {phrase: "конференция 3 июня 2025 г", title: "конференция", date: "2025-06-03"},
+		"ru past date is next year":   {phrase: "годовщина 1 января", title: "годовщина", date: "2025-01-01"},
+		"ru numeric date":             {phrase: "сдача проекта 01.06 в 12:00", title: "сдача проекта", date: "2024-06-01 12:00"},
+		"ru numeric date with year":   {phrase: "экзамен 15.05.2024", title: "экзамен", date: "2024-05-15"},
+		"ru short year":               {phrase: "экзамен 15.06.25", title: "экзамен", date: "2025-06-15"},
+		"ru with person":              {phrase: "обед с Петей завтра в 13:00", title: "обед с Петей", date: "2024-05-16 13:00"},
+		"ru yo":                       {phrase: "ещё созвон в четверг", title: "ещё созвон", date: "2024-05-16"},
+		"ru capitalized":              {phrase: "Стендап Завтра В 10:00", title: "Стендап", date: "2024-05-16 10:00"},
+		"ru punctuation":              {phrase: "Стендап, завтра, в 10:00!", title: "Стендап", date: "2024-05-16 10:00"},
+		"ru preposition without time": {phrase: "поход в кино", title: "поход в кино", date: "2024-05-15"},
+		"ru na without duration":      {phrase: "поездка на дачу", title: "поездка на дачу", date: "2024-05-15"},
+		"ru s without time":           {phrase: "чай с лимоном", title: "чай с лимоном", date: "2024-05-15"},
+
+		// English
+		"en example":                  {phrase: "lunch next friday 13:00", title: "lunch", date: "2024-05-24 13:00"},
+		"en today":                    {phrase: "call today", title: "call", date: "2024-05-15"},
+		"en tomorrow":                 {phrase: "Dentist tomorrow at 9am", title: "Dentist", date: "2024-05-16 09:00"},
+		"en day after tomorrow":       {phrase: "pay rent the day after tomorrow", title: "pay rent", date: "2024-05-17"},
+		"en day after tomorrow short": {phrase: "gym day after tomorrow", title: "gym", date: "2024-05-17"},
+		"en yesterday":                {phrase: "report yesterday", title: "report", date: "2024-05-14"},
+		"en weekday":                  {phrase: "yoga on thursday", title: "yoga", date: "2024-05-16"},
+		"en weekday today":            {phrase: "sync wednesday", title: "sync", date: "2024-05-15"},
+		"en weekday past":             {phrase: "brunch monday", title: "brunch", date: "2024-05-20"},
+		"en this weekday":             {phrase: "party this saturday at 8pm", title: "party", date: "2024-05-18 20:00"},
+		"en next monday":              {phrase: "planning next monday", title: "planning", date: "2024-05-20"},
+		"en next sunday":              {phrase: "hike next sunday", title: "hike", date: "2024-05-26"},
+		"en next week":                {phrase: "vacation next week", title: "vacation", date: "2024-05-20"},
+		"en in days":                  {phrase: "follow up in 3 days", title: "follow up", date: "2024-05-18"},
+		"en in a week":                {phrase: "review in a week", title: "review", date: "2024-05-22"},
+		"en in two weeks":             {phrase: "release in two weeks", title: "release", date: "2024-05-29"},
+		"en in months":                {phrase: "checkup in 6 months", title: "checkup", date: "2024-11-15"},
+		"en in an hour":               {phrase: "coffee in an hour", title: "coffee", date: "2024-05-15 10:30"},
+		"en in hours":                 {phrase: "call back in 2 hours", title: "call back", date: "2024-05-15 11:30"},
+		"en in half an hour":          {phrase: "standup in half an hour", title: "standup", date: "2024-05-15 10:00"},
+		"en in minutes":               {phrase: "break in 20 minutes", title: "break", date: "2024-05-15 09:50"},
+		"en at hour":                  {phrase: "meeting at 10", title: "meeting", date: "2024-05-15 10:00"},
+		"en pm":                       {phrase: "dinner at 7pm", title: "dinner", date: "2024-05-15 19:00"},
+		"en pm with minutes":          {phrase: "dinner at 7:30pm", title: "dinner", date: "2024-05-15 19:30"},
+		"en separate pm":              {phrase: "dinner 7 pm", title: "dinner", date: "2024-05-15 19:00"},
+		"en dotted pm":                {phrase: "dinner at 7 p.m.", title: "dinner", date: "2024-05-15 19:00"},
+		"en 12am":                     {phrase: "release at 12am", title: "release", date: "2024-05-15 00:00"},
+		"en 12pm":                     {phrase: "lunch at 12pm", title: "lunch", date: "2024-05-15 12:00"},
+		"en o'clock":                  {phrase: "tea at 5 o'clock", title: "tea", date: "2024-05-15 05:00"},
+		"en noon":                     {phrase: "lunch at noon tomorrow", title: "lunch", date: "2024-05-16 12:00"},
+		"en midnight":                 {phrase: "deploy at midnight", title: "deploy", date: "2024-05-15 00:00"},
+		"en at sign":                  {phrase: "sync @ 11:15", title: "sync", date: "2024-05-15 11:15"},
+		"en for an hour":              {phrase: "1:1 with Anna tomorrow 15:00 for an hour", title: "1:1 with Anna", date: "2024-05-16 15:00", duration: time.Hour},
+		"en for minutes":              {phrase: "standup at 10:00 for 15 minutes", title: "standup", date: "2024-05-15 10:00", duration: 15 * time.Minute},
+		"en for hours":                {phrase: "hackathon saturday at 9am for 8 hours", title: "hackathon", date: "2024-05-18 09:00", duration: 8 * time.Hour},
+		"en for half an hour":         {phrase: "call mom for half an hour", title: "call mom", date: "2024-05-15", duration: 30 * time.Minute},
+		"en for compound":             {phrase: "workshop for 1 hour and 30 minutes", title: "workshop", date: "2024-05-15", duration: 90 * time.Minute},
+		"en for compact":              {phrase: "focus time for 1h30m", title: "focus time", date: "2024-05-15", duration: 90 * time.Minute},
+		"en for decimal":              {phrase: "training for 1.5 hours", title: "training", date: "2024-05-15", duration: 90 * time.Minute},
+		"en for min":                  {phrase: "review for 90 min", title: "review", date: "2024-05-15", duration: 90 * time.Minute},
+		"en range":                    {phrase: "offsite from 10am to 4pm", title: "offsite", date: "2024-05-15 10:00", duration: 6 * time.Hour},
+		"en dash range":               {phrase: "interview 14:00-15:30 friday", title: "interview", date: "2024-05-17 14:00", duration: 90 * time.Minute},
+		"en spaced dash range":        {phrase: "interview 14:00 - 15:00", title: "interview", date: "2024-05-15 14:00", duration: time.Hour},
+		"en month day":                {phrase: "conference may 20", title: "conference", date: "2024-05-20"},
+		"en month day ordinal":        {phrase: "anniversary on june 3rd", title: "anniversary", date: "2024-06-03"},
+		"en day month year":           {phrase: "wedding 12 september 2025", title: "wedding", date: "2025-09-12"},
+		"en short month":              {phrase: "taxes apr 15", title: "taxes", date: "2025-04-15"},
+		"en iso date":                 {phrase: "launch 2024-07-01 at 10:00", title: "launch", date: "2024-07-01 10:00"},
+		"en may as a word":            {phrase: "may the force be with you", title: "may the force be with you", date: "2024-05-15"},
+		"en in as a word":             {phrase: "check in hotel", title: "check in hotel", date: "2024-05-15"},
+		"en for as a word":            {phrase: "shopping for groceries", title: "shopping for groceries", date: "2024-05-15"},
+		"en number in title":          {phrase: "buy 2 tickets", title: "buy 2 tickets", date: "2024-05-15"},
+		"en first date wins":          {phrase: "monday report due friday", title: "report due friday", date: "2024-05-20"},
+		"en first time wins":          {phrase: "sync at 10 at 11", title: "sync at 11", date: "2024-05-15 10:00"},
+
+		// errors
+		"empty":            {phrase: "   ", err: ErrEmpty},
+		"no title":         {phrase: "завтра в 10:00", err: ErrNoTitle},
+		"only punctuation": {phrase: "tomorrow, at 10!", err: ErrNoTitle},
+		"reversed range":   {phrase: "meeting from 11 to 10", err: ErrInvalidRange},
+		"empty range":      {phrase: "встреча с 10:00 до 10:00", err: ErrInvalidRange},
+		"invalid clock":    {phrase: "meeting at 25:00", title: "meeting at 25:00", date: "2024-05-15"},
+		"invalid date":     {phrase: "party 31.02", title: "party 31.02", date: "2024-05-15"},
+		"invalid pm":       {phrase: "dinner at 15pm", title: "dinner at 15pm", date: "2024-05-15"},
+		"invalid minutes":  {phrase: "call 10:75", title: "call 10:75", date: "2024-05-15"},
+	}
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			r, err := Parse(v.phrase, now)
+			if v.err != nil {
+				if !errors.Is(err, v.err) {
+					t.Errorf("expected: %v, got: %v", v.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			layout := "2006-01-02"
+			if r.HasTime {
+				layout = "2006-01-02 15:04"
+			}
+			if date := r.Date.Format(layout); date != v.date {
+				t.Errorf("expected: %s, got: %s", v.date, date)
+			}
+			if r.Title != v.title {
+				t.Errorf("expected: %q, got: %q", v.title, r.Title)
+			}
+			if r.Duration != v.duration {
+				t.Errorf("expected: %v, got: %v", v.duration, r.Duration)
+			}
+		})
+	}
+}
+
+func TestParseInLocation(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	// it is already Thursday in Moscow
+	r, err := Parse("стендап завтра в 10:00", time.Date(2024, time.May, 15, 22, 0, 0, 0, time.UTC).In(moscow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, time.May, 17, 10, 0, 0, 0, time.UTC); !r.Date.Equal(expected) {
+		t.Errorf("expected: %v, got: %v", expected, r.Date)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	r, err := Parse("lunch tomorrow 13:00 for 45 min", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := r.Event(7)
+	if e.UserID != 7 || e.Title != "lunch" || e.Duration != 45 || !e.Date.Equal(time.Date(2024, time.May, 16, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected event: %+v", *e)
+	}
+}